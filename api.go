@@ -1,67 +1,269 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	stdjson "encoding/json"
 	"fmt"
 	"net"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/gin-contrib/sse"
 	"github.com/gin-gonic/gin"
 )
 
+const (
+	ndjsonContentType = "application/x-ndjson"
+	maxImportLineSize = 1024 * 1024
+
+	eventStreamContentType        = sse.ContentType
+	defaultEventHeartbeatInterval = 15 * time.Second
+	eventStreamRetry              = 3 * time.Second
+	eventStreamBufferSize         = 64
+)
+
 type apiServerConfig struct {
 	host             string
 	port             string
+	grpcPort         string
 	connectionString string
+	trashRetention   time.Duration
+	requireIfMatch   bool
+	idempotencyTTL   time.Duration
+	webhookAttempts  int
+	eventSinks       string
+	eventFile        string
+	devMode          bool
+	logLevel         string
+	traceExporter    string
+	otlpEndpoint     string
+	liveOrigins      string
+	v1Deprecation    time.Time
+	v1Sunset         time.Time
+	errorReportURL   string
 }
 
 func (c *apiServerConfig) load(cfg *applicationConfig) {
 	c.host = cfg.host
 	c.port = cfg.port
+	c.grpcPort = cfg.grpcPort
 	c.connectionString = cfg.dsn
+	c.trashRetention = cfg.trashRetention
+	c.requireIfMatch = cfg.requireIfMatch
+	c.idempotencyTTL = cfg.idempotencyTTL
+	c.webhookAttempts = cfg.webhookAttempts
+	c.eventSinks = cfg.eventSinks
+	c.eventFile = cfg.eventFile
+	c.devMode = cfg.devMode
+	c.logLevel = cfg.logLevel
+	c.traceExporter = cfg.traceExporter
+	c.otlpEndpoint = cfg.otlpEndpoint
+	c.liveOrigins = cfg.liveOrigins
+	c.v1Deprecation = cfg.v1Deprecation
+	c.v1Sunset = cfg.v1Sunset
+	c.errorReportURL = cfg.errorReportURL
 }
 
 type apiServer struct {
-	httpServer *ginHTTPServer
-	address    string
-	datastore  datastore
+	httpServer     *ginHTTPServer
+	address        string
+	grpcServer     *grpcServer
+	grpcAddress    string
+	datastore      datastore
+	scheduler      *recipeScheduler
+	purger         *retentionPurger
+	webhooks       *webhookDispatcher
+	events         *eventBus
+	eventHeartbeat time.Duration
+	relay          *recipeEventRelay
+	live           *liveHub
+	requireIfMatch bool
+	idempotencyTTL time.Duration
+	devMode        bool
+	contract       *apiContract
+	// deprecations and sunsets are when the versions of the API were
+	// deprecated and are removed. v1 is deprecated in favor of v2, whose
+	// recipes carry their rating as an object.
+	deprecations map[int]time.Time
+	sunsets      map[int]time.Time
+	tracer       *tracer
+	startedAt    time.Time
+	shuttingDown int32
 }
 
 func newAPIServer(cfg apiServerConfig) *apiServer {
+	logger.setLevel(parseLogLevel(cfg.logLevel))
+	tracer := newTracer(newSpanExporter(cfg.traceExporter, cfg.otlpEndpoint), defaultTraceExportInterval)
 	httpServer := newGinHTTPServer()
+	httpServer.tracer = tracer
+	if cfg.errorReportURL != "" {
+		httpServer.errorReporter = newHTTPErrorReporter(cfg.errorReportURL)
+	}
+	db := newSqlxPostgreSQL(cfg.connectionString)
+	metrics.register(&dbStatsCollector{db.sqlxDB.DB})
+	datastore := &instrumentedDatastore{datastore: db}
+	webhooks := newWebhookDispatcher(datastore, cfg.webhookAttempts, defaultWebhookPollInterval)
+	webhooks.tracer = tracer
+	events := newEventBus(recipeEventReplaySize)
 	apiServer := &apiServer{
-		httpServer: httpServer,
-		address:    net.JoinHostPort(cfg.host, cfg.port),
-		datastore:  newSqlxPostgreSQL(cfg.connectionString),
+		httpServer:     httpServer,
+		address:        net.JoinHostPort(cfg.host, cfg.port),
+		grpcAddress:    net.JoinHostPort(cfg.host, cfg.grpcPort),
+		datastore:      datastore,
+		scheduler:      newRecipeScheduler(datastore, defaultSchedulePollInterval),
+		purger:         newRetentionPurger(datastore, cfg.trashRetention, cfg.idempotencyTTL, defaultRetentionPurgeInterval),
+		webhooks:       webhooks,
+		events:         events,
+		eventHeartbeat: defaultEventHeartbeatInterval,
+		live:           newLiveHub(defaultLiveRoomIdleTimeout),
+		relay:          newRecipeEventRelay(datastore, newEventSinks(cfg.eventSinks, cfg.eventFile, webhooks, events), defaultRelayPollInterval),
+		requireIfMatch: cfg.requireIfMatch,
+		idempotencyTTL: cfg.idempotencyTTL,
+		devMode:        cfg.devMode,
+		tracer:         tracer,
+		startedAt:      time.Now(),
+		deprecations:   map[int]time.Time{1: cfg.v1Deprecation},
+		sunsets:        map[int]time.Time{1: cfg.v1Sunset},
+	}
+	for _, origin := range strings.Split(cfg.liveOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			apiServer.live.allowedOrigins = append(apiServer.live.allowedOrigins, origin)
+		}
 	}
+	apiServer.grpcServer = newGRPCServer(apiServer)
 	apiServer.routes()
 	return apiServer
 }
 
 func (s *apiServer) run() {
-	fmt.Println("starting http service on", s.address)
+	go s.scheduler.run()
+	go s.purger.run()
+	go s.webhooks.run()
+	go s.relay.run()
+	go s.live.run()
+	go s.tracer.run()
+	logger.info("starting grpc service", logFields{"address": s.grpcAddress})
+	go s.grpcServer.run(s.grpcAddress)
+	logger.info("starting http service", logFields{"address": s.address})
 	s.httpServer.run(s.address)
 }
 
 func (s *apiServer) shutdown() {
+	s.beginShutdown()
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
+	s.events.close()
 	if err := s.httpServer.Shutdown(ctx); err != nil {
 		panic(err)
 	}
+	s.grpcServer.shutdown(ctx)
+	s.live.stop(ctx)
+	s.scheduler.stop(ctx)
+	s.purger.stop(ctx)
+	s.relay.stop(ctx)
+	s.webhooks.stop(ctx)
+	s.tracer.stop(ctx)
 	s.datastore.close()
-	fmt.Println("service stopped")
+	logger.info("service stopped")
 }
 
 func (s *apiServer) routes() {
-	s.httpServer.router.GET("/recipes", s.getRecipes)
-	s.httpServer.router.POST("/recipes", s.postRecipe)
-	s.httpServer.router.GET("/recipes/:id", s.getRecipe)
-	s.httpServer.router.PUT("/recipes/:id", s.putRecipe)
-	s.httpServer.router.DELETE("/recipes/:id", s.deleteRecipe)
-	s.httpServer.router.POST("/recipes/:id/rating", s.postRateRecipe)
+	s.httpServer.router.Use(s.identifyUser, s.validateContract, s.idempotency)
+	s.versionRoutes("", s.negotiateAPIVersion)
+	for version := 1; version <= latestAPIVersion; version++ {
+		s.versionRoutes("/v"+strconv.Itoa(version), s.fixedAPIVersion(version))
+	}
+	s.httpServer.router.GET("/events", negotiateAmong(eventStreamContentType), s.getEvents)
+	s.httpServer.router.POST("/graphql", negotiateAmong(gin.MIMEJSON), s.postGraphQL)
+	s.httpServer.router.GET("/openapi.json", negotiateAmong(gin.MIMEJSON), s.getOpenAPIDocument)
+	s.httpServer.router.GET("/docs", negotiateAmong(htmlContentType), s.getAPIDocs)
+	s.httpServer.router.GET("/metrics", negotiateAmong(metricsContentType), s.getMetrics)
+	s.httpServer.router.GET("/healthz", negotiateAmong(gin.MIMEJSON), s.getHealth)
+	s.httpServer.router.GET("/readyz", negotiateAmong(gin.MIMEJSON), s.getReadiness)
+	s.httpServer.router.GET("/status", negotiateAmong(gin.MIMEJSON), s.getStatus)
+	s.contract = newAPIContract(newOpenAPIDocument(s.httpServer.routes()))
+}
+
+// datastoreOf is the datastore to handle a request with, which traces its
+// calls under the span of the request.
+func (s *apiServer) datastoreOf(c *gin.Context) datastore {
+	return s.datastore.withContext(c.Request.Context())
+}
+
+// identifyUser keeps the user that the datastore looked up the credential of
+// a request as, once it has been handled, for the access log.
+func (s *apiServer) identifyUser(c *gin.Context) {
+	ctx, user := contextWithRequestUser(c.Request.Context())
+	c.Request = c.Request.WithContext(ctx)
+	c.Next()
+	if id, ok := user.get(); ok {
+		c.Set(userIDKey, id)
+	}
+}
+
+// requestUserKey is the key of the requestUser of a request in its context.
+type requestUserKey struct{}
+
+// requestUser is the user of the credential of a request, which the
+// datastore records when it looks the credential up.
+type requestUser struct {
+	id int64
+}
+
+func contextWithRequestUser(ctx context.Context) (context.Context, *requestUser) {
+	u := &requestUser{}
+	return context.WithValue(ctx, requestUserKey{}, u), u
+}
+
+// recordRequestUser records the user of the credential of the request of
+// the context, if it is the context of a request.
+func recordRequestUser(ctx context.Context, id int) {
+	if ctx == nil {
+		return
+	}
+	if u, ok := ctx.Value(requestUserKey{}).(*requestUser); ok {
+		atomic.StoreInt64(&u.id, int64(id))
+	}
+}
+
+func (u *requestUser) get() (int64, bool) {
+	id := atomic.LoadInt64(&u.id)
+	return id, id != 0
+}
+
+// versionRoutes registers the API under a version prefix like "/v1", or at
+// the root, where the version is negotiated.
+func (s *apiServer) versionRoutes(prefix string, version gin.HandlerFunc) {
+	group := s.httpServer.router.Group(prefix, version)
+	group.GET("/recipes", negotiate(csvContentType), s.getRecipes)
+	group.POST("/recipes", negotiate(), s.postRecipe)
+	s.httpServer.handleStaticRoute("POST", prefix+"/recipes:batch", version, negotiate(), s.postRecipeBatch)
+	s.httpServer.handleStaticRoute("POST", prefix+"/recipes/import", version, negotiate(), s.postImportSchemaOrgRecipe)
+	s.httpServer.handleStaticRoute("POST", prefix+"/recipes/import.csv", version, negotiate(), s.postImportRecipesCSV)
+	group.GET("/recipes/:id", negotiate(jsonLDContentType), s.getRecipe)
+	group.PUT("/recipes/:id", negotiate(), s.putRecipe)
+	group.PATCH("/recipes/:id", negotiate(), s.patchRecipe)
+	group.DELETE("/recipes/:id", negotiate(), s.deleteRecipe)
+	group.POST("/recipes/:id/rating", negotiate(), s.postRateRecipe)
+	group.POST("/recipes/:id/restore", negotiate(), s.postRestoreRecipe)
+	group.GET("/trash", negotiate(), s.getTrash)
+	group.GET("/recipes/:id/revisions", negotiate(), s.getRecipeRevisions)
+	group.GET("/recipes/:id/revisions/:rev", negotiate(), s.getRecipeRevision)
+	group.POST("/recipes/:id/revisions/:rev/restore", negotiate(), s.postRestoreRecipeRevision)
+	group.GET("/recipes/:id/diff", negotiate(), s.getRecipeRevisionDiff)
+	group.GET("/recipes/:id/live", s.getRecipeLive)
+	group.GET("/export/recipes", negotiateAmong(ndjsonContentType), s.getExportRecipes)
+	group.POST("/import/recipes", negotiate(), s.postImportRecipes)
+	group.GET("/webhooks", negotiate(), s.getWebhooks)
+	group.POST("/webhooks", negotiate(), s.postWebhook)
+	group.DELETE("/webhooks/:id", negotiate(), s.deleteWebhook)
+	group.GET("/webhooks/:id/deliveries", negotiate(), s.getWebhookDeliveries)
+	group.POST("/webhooks/:id/deliveries/:delivery/redeliver", negotiate(), s.postRedeliverWebhookDelivery)
 }
 
 func (s *apiServer) getRecipes(c *gin.Context) {
@@ -73,27 +275,162 @@ func (s *apiServer) getRecipes(c *gin.Context) {
 
 	paging := newPaging()
 	bindPagiing(c, paging)
-	res := s.datastore.listRecipes(filter, paging)
+	res := s.datastoreOf(c).listRecipes(filter, paging)
 	if res == nil {
 		panic("got nil in method listRecipes")
 	}
-	c.JSON(http.StatusOK, res)
+	if responseFormat(c) == csvContentType {
+		c.Header("Content-Type", csvContentType+"; charset=utf-8")
+		c.Status(http.StatusOK)
+		if err := writeRecipesCSV(c.Writer, res); err != nil {
+			panic(err)
+		}
+		return
+	}
+	respond(c, http.StatusOK, res)
+}
+
+func (s *apiServer) postImportRecipesCSV(c *gin.Context) {
+	args, rowErrors, err := readRecipesCSV(c.Request.Body)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	report := &RecipeCSVImportReport{
+		Errors:  rowErrors,
+		Recipes: make([]*Recipe, 0),
+	}
+	if len(rowErrors) > 0 {
+		respond(c, http.StatusUnprocessableEntity, report)
+		return
+	}
+
+	ops := make([]*RecipeBatchOperation, len(args))
+	for i, arg := range args {
+		ops[i] = &RecipeBatchOperation{Op: recipeBatchCreate, Recipe: arg}
+	}
+	token := c.GetHeader("Authorization")
+	for _, recipe := range s.datastoreOf(c).executeRecipeBatchByCredential(ops, true, token) {
+		if recipe == nil {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		report.Recipes = append(report.Recipes, recipe)
+	}
+	report.Imported = len(report.Recipes)
+	respond(c, http.StatusOK, report)
 }
 
 func (s *apiServer) postRecipe(c *gin.Context) {
 	arg := &PostRecipeArg{}
-	if err := c.ShouldBindJSON(arg); err != nil {
+	if err := bindBody(c, arg); err != nil {
+		c.AbortWithStatus(bindErrorStatus(err))
+		return
+	}
+
+	if err := validate.Struct(arg); err != nil {
+		abortWithValidationErrors(c, "body", arg, err)
+		return
+	}
+
+	token := c.GetHeader("Authorization")
+	if res := s.datastoreOf(c).addRecipeByCredential(arg, token); res != nil {
+		respond(c, http.StatusOK, res)
+		return
+	}
+	c.AbortWithStatus(http.StatusNotFound)
+}
+
+func (s *apiServer) postRecipeBatch(c *gin.Context) {
+	arg := &RecipeBatchArg{}
+	if err := bindBody(c, arg); err != nil {
+		c.AbortWithStatus(bindErrorStatus(err))
+		return
+	}
+
+	if err := validate.Struct(arg); err != nil {
+		abortWithValidationErrors(c, "body", arg, err)
+		return
+	}
+	if arg.Mode == "" {
+		arg.Mode = recipeBatchAllOrNothing
+	}
+	atomic := arg.Mode == recipeBatchAllOrNothing
+
+	results := make([]*RecipeBatchResult, len(arg.Operations))
+	ops := make([]*RecipeBatchOperation, 0, len(arg.Operations))
+	indexes := make([]int, 0, len(arg.Operations))
+	for i, op := range arg.Operations {
+		if err := op.validate(); err != nil {
+			results[i] = &RecipeBatchResult{Status: http.StatusBadRequest, Error: err.Error()}
+			continue
+		}
+		ops = append(ops, op)
+		indexes = append(indexes, i)
+	}
+
+	token := c.GetHeader("Authorization")
+	committed := !atomic || len(ops) == len(arg.Operations)
+	if committed {
+		for j, recipe := range s.datastoreOf(c).executeRecipeBatchByCredential(ops, atomic, token) {
+			i := indexes[j]
+			if recipe != nil {
+				results[i] = &RecipeBatchResult{Status: http.StatusOK, Recipe: recipe}
+				continue
+			}
+			results[i] = &RecipeBatchResult{Status: s.failedBatchOperationStatus(s.datastoreOf(c), ops[j], token)}
+			if atomic {
+				committed = false
+				break
+			}
+		}
+	}
+
+	status := http.StatusOK
+	if !committed {
+		status = http.StatusUnprocessableEntity
+		for i, res := range results {
+			if res == nil || res.Status == http.StatusOK {
+				results[i] = &RecipeBatchResult{Status: http.StatusFailedDependency, Error: "rolled back"}
+			}
+		}
+	}
+	respond(c, status, &RecipeBatchResponse{
+		Mode:      arg.Mode,
+		Committed: committed,
+		Results:   results,
+	})
+}
+
+func (s *apiServer) failedBatchOperationStatus(ds datastore, op *RecipeBatchOperation, token string) int {
+	if op.Op != recipeBatchCreate && op.Version != 0 && ds.getRecipeVersionByCredential(op.ID, token).Valid {
+		return http.StatusPreconditionFailed
+	}
+	return http.StatusNotFound
+}
+
+func (s *apiServer) postImportSchemaOrgRecipe(c *gin.Context) {
+	doc, err := c.GetRawData()
+	if err != nil || !stdjson.Valid(doc) {
 		c.AbortWithStatus(http.StatusBadRequest)
 		return
 	}
 
+	arg, err := parseSchemaOrgRecipe(doc)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnprocessableEntity)
+		return
+	}
+
 	if err := validate.Struct(arg); err != nil {
-		panic(err)
+		abortWithValidationErrors(c, "body", arg, err)
+		return
 	}
 
 	token := c.GetHeader("Authorization")
-	if res := s.datastore.addRecipeByCredential(arg, token); res != nil {
-		c.JSON(http.StatusOK, res)
+	if res := s.datastoreOf(c).addRecipeByCredential(arg, token); res != nil {
+		respond(c, http.StatusOK, res)
 		return
 	}
 	c.AbortWithStatus(http.StatusNotFound)
@@ -106,8 +443,20 @@ func (s *apiServer) getRecipe(c *gin.Context) {
 		return
 	}
 
-	if res := s.datastore.getRecipeByID(recipeID); res != nil {
-		c.JSON(http.StatusOK, res)
+	if res := s.datastoreOf(c).getRecipeByID(recipeID); res != nil {
+		etag := recipeETag(res)
+		c.Header("ETag", etag)
+		c.Writer.Header().Add("Vary", "Accept")
+		if matchesETagWeakly(c.GetHeader("If-None-Match"), etag) {
+			c.AbortWithStatus(http.StatusNotModified)
+			return
+		}
+		if responseFormat(c) == jsonLDContentType {
+			c.Header("Content-Type", jsonLDContentType)
+			c.JSON(http.StatusOK, newSchemaOrgRecipe(res))
+			return
+		}
+		respond(c, http.StatusOK, res)
 		return
 	}
 
@@ -121,23 +470,92 @@ func (s *apiServer) putRecipe(c *gin.Context) {
 		return
 	}
 
+	version, ok := s.bindIfMatch(c, recipeID)
+	if !ok {
+		return
+	}
+
 	arg := &PutRecipeArg{}
-	if err := c.ShouldBindJSON(arg); err != nil {
-		c.AbortWithStatus(http.StatusBadRequest)
+	if err := bindBody(c, arg); err != nil {
+		c.AbortWithStatus(bindErrorStatus(err))
 		return
 	}
 
 	if err := validate.Struct(arg); err != nil {
-		panic(err)
+		abortWithValidationErrors(c, "body", arg, err)
+		return
 	}
 
 	token := c.GetHeader("Authorization")
-	if recipe := s.datastore.updateAndGetRecipeByCredential(arg, recipeID, token); recipe != nil {
-		c.JSON(http.StatusOK, recipe)
+	if recipe := s.datastoreOf(c).updateAndGetRecipeByCredential(arg, recipeID, version, token); recipe != nil {
+		c.Header("ETag", recipeETag(recipe))
+		respond(c, http.StatusOK, recipe)
 		return
 	}
 
-	c.AbortWithStatus(http.StatusNotFound)
+	s.abortWithFailedWrite(c, recipeID, version, token)
+}
+
+func (s *apiServer) patchRecipe(c *gin.Context) {
+	recipeID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	version, ok := s.bindIfMatch(c, recipeID)
+	if !ok {
+		return
+	}
+
+	apply := patchFuncByContentType(c.ContentType())
+	if apply == nil {
+		c.AbortWithStatus(http.StatusUnsupportedMediaType)
+		return
+	}
+	patch, err := c.GetRawData()
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	token := c.GetHeader("Authorization")
+	recipe := s.datastoreOf(c).getRecipeByCredential(recipeID, token)
+	if recipe == nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if version != 0 && version != recipe.Version {
+		c.AbortWithStatus(http.StatusPreconditionFailed)
+		return
+	}
+
+	arg, err := patchRecipeArg(newPutRecipeArg(recipe), patch, apply)
+	switch err {
+	case nil:
+	case errMalformedPatch:
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	case errPatchTestFailed:
+		c.AbortWithStatus(http.StatusConflict)
+		return
+	default:
+		c.AbortWithStatus(http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err := validate.Struct(arg); err != nil {
+		abortWithValidationErrors(c, "body", arg, err)
+		return
+	}
+
+	if recipe := s.datastoreOf(c).updateAndGetRecipeByCredential(arg, recipeID, recipe.Version, token); recipe != nil {
+		c.Header("ETag", recipeETag(recipe))
+		respond(c, http.StatusOK, recipe)
+		return
+	}
+
+	s.abortWithFailedWrite(c, recipeID, recipe.Version, token)
 }
 
 func (s *apiServer) deleteRecipe(c *gin.Context) {
@@ -147,15 +565,45 @@ func (s *apiServer) deleteRecipe(c *gin.Context) {
 		return
 	}
 
+	version, ok := s.bindIfMatch(c, recipeID)
+	if !ok {
+		return
+	}
+
 	token := c.GetHeader("Authorization")
-	if recipe := s.datastore.deleteAndGetRecipeByCredential(recipeID, token); recipe != nil {
-		c.JSON(http.StatusOK, recipe)
+	if recipe := s.datastoreOf(c).deleteAndGetRecipeByCredential(recipeID, version, token); recipe != nil {
+		c.Header("ETag", recipeETag(recipe))
+		respond(c, http.StatusOK, recipe)
 		return
 	}
 
+	s.abortWithFailedWrite(c, recipeID, version, token)
+}
+
+func (s *apiServer) bindIfMatch(c *gin.Context, recipeID int) (int, bool) {
+	ifMatch := c.GetHeader("If-Match")
+	if ifMatch == "" && s.requireIfMatch {
+		c.AbortWithStatus(http.StatusPreconditionRequired)
+		return 0, false
+	}
+	return expectedRecipeVersion(ifMatch, recipeID), true
+}
+
+func (s *apiServer) abortWithFailedWrite(c *gin.Context, recipeID int, version int, token string) {
+	if s.isVersionMismatched(s.datastoreOf(c), recipeID, version, token) {
+		c.AbortWithStatus(http.StatusPreconditionFailed)
+		return
+	}
 	c.AbortWithStatus(http.StatusNotFound)
 }
 
+// isVersionMismatched tells whether a write expecting a version failed
+// because the recipe of the user has another version, rather than because
+// there is no such recipe.
+func (s *apiServer) isVersionMismatched(ds datastore, recipeID int, version int, token string) bool {
+	return version != 0 && ds.getRecipeVersionByCredential(recipeID, token).Valid
+}
+
 func (s *apiServer) postRateRecipe(c *gin.Context) {
 	recipeID, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
@@ -164,19 +612,434 @@ func (s *apiServer) postRateRecipe(c *gin.Context) {
 	}
 
 	arg := &PostRateRecipeArg{}
-	if err := c.ShouldBindJSON(arg); err != nil {
+	if err := bindBody(c, arg); err != nil {
+		c.AbortWithStatus(bindErrorStatus(err))
+		return
+	}
+
+	if err := validate.Struct(arg); err != nil {
+		abortWithValidationErrors(c, "body", arg, err)
+		return
+	}
+
+	if recipe := s.datastoreOf(c).rateAndGetRecipe(arg, recipeID, c.GetHeader("Authorization")); recipe != nil {
+		respond(c, http.StatusOK, recipe)
+		return
+	}
+
+	c.AbortWithStatus(http.StatusNotFound)
+}
+
+func (s *apiServer) postRestoreRecipe(c *gin.Context) {
+	recipeID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	token := c.GetHeader("Authorization")
+	if recipe := s.datastoreOf(c).restoreAndGetRecipeByCredential(recipeID, token); recipe != nil {
+		respond(c, http.StatusOK, recipe)
+		return
+	}
+
+	c.AbortWithStatus(http.StatusNotFound)
+}
+
+func (s *apiServer) getTrash(c *gin.Context) {
+	paging := newPaging()
+	bindPagiing(c, paging)
+	token := c.GetHeader("Authorization")
+	if res := s.datastoreOf(c).listDeletedRecipesByCredential(token, paging); res != nil {
+		respond(c, http.StatusOK, res)
+		return
+	}
+
+	c.AbortWithStatus(http.StatusNotFound)
+}
+
+func (s *apiServer) getRecipeRevisions(c *gin.Context) {
+	recipeID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	token := c.GetHeader("Authorization")
+	if res := s.datastoreOf(c).listRecipeRevisionsByCredential(recipeID, token); res != nil {
+		respond(c, http.StatusOK, res)
+		return
+	}
+
+	c.AbortWithStatus(http.StatusNotFound)
+}
+
+func (s *apiServer) getRecipeRevision(c *gin.Context) {
+	recipeID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	revision, err := strconv.Atoi(c.Param("rev"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	token := c.GetHeader("Authorization")
+	if res := s.datastoreOf(c).getRecipeRevisionByCredential(recipeID, revision, token); res != nil {
+		respond(c, http.StatusOK, res)
+		return
+	}
+
+	c.AbortWithStatus(http.StatusNotFound)
+}
+
+func (s *apiServer) postRestoreRecipeRevision(c *gin.Context) {
+	recipeID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	revision, err := strconv.Atoi(c.Param("rev"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	token := c.GetHeader("Authorization")
+	if recipe := s.datastoreOf(c).restoreRecipeRevisionByCredential(recipeID, revision, token); recipe != nil {
+		respond(c, http.StatusOK, recipe)
+		return
+	}
+
+	c.AbortWithStatus(http.StatusNotFound)
+}
+
+func (s *apiServer) getRecipeRevisionDiff(c *gin.Context) {
+	recipeID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	arg := &RevisionDiffArg{}
+	if err := c.ShouldBindQuery(arg); err != nil {
 		c.AbortWithStatus(http.StatusBadRequest)
 		return
 	}
 
 	if err := validate.Struct(arg); err != nil {
-		panic(err)
+		abortWithValidationErrors(c, "query", arg, err)
+		return
+	}
+
+	token := c.GetHeader("Authorization")
+	from := s.datastoreOf(c).getRecipeRevisionByCredential(recipeID, arg.From, token)
+	to := s.datastoreOf(c).getRecipeRevisionByCredential(recipeID, arg.To, token)
+	if from == nil || to == nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	respond(c, http.StatusOK, newRecipeRevisionDiff(from, to))
+}
+
+// getRecipeLive upgrades the request to a WebSocket, and puts the user of
+// its credential into the live cooking session of the recipe, under their
+// account, until they leave.
+func (s *apiServer) getRecipeLive(c *gin.Context) {
+	recipeID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	ds := s.datastoreOf(c)
+	account := ds.getAccountByCredential(c.GetHeader("Authorization"))
+	if !account.Valid {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if !s.live.allowsOrigin(c.Request) {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	if ds.getRecipeByID(recipeID) == nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if !isWebSocketUpgrade(c.Request) {
+		c.Header("Upgrade", "websocket")
+		c.Header("Sec-WebSocket-Version", "13")
+		c.AbortWithStatus(http.StatusUpgradeRequired)
+		return
+	}
+
+	c.Status(http.StatusSwitchingProtocols)
+	conn, err := upgradeWebSocket(c.Writer, c.Request, liveMaxMessageSize)
+	if err != nil {
+		requestLogger(c).warn("error upgrading to websocket", logFields{"error": err})
+		return
+	}
+	s.live.join(recipeID, account.String, conn)
+}
+
+func (s *apiServer) getExportRecipes(c *gin.Context) {
+	encoder := stdjson.NewEncoder(c.Writer)
+	token := c.GetHeader("Authorization")
+	if ok := s.datastoreOf(c).exportRecipesByCredential(token, func(r *RecipeExport) {
+		if !c.Writer.Written() {
+			c.Header("Content-Type", ndjsonContentType)
+		}
+		if err := encoder.Encode(r); err != nil {
+			panic(err)
+		}
+		c.Writer.Flush()
+	}); ok {
+		c.Header("Content-Type", ndjsonContentType)
+		c.Status(http.StatusOK)
+		return
+	}
+
+	c.AbortWithStatus(http.StatusNotFound)
+}
+
+func (s *apiServer) postImportRecipes(c *gin.Context) {
+	arg := &ImportRecipesArg{}
+	if err := c.ShouldBindQuery(arg); err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	if err := validate.Struct(arg); err != nil {
+		abortWithValidationErrors(c, "query", arg, err)
+		return
+	}
+	if arg.Strategy == "" {
+		arg.Strategy = recipeImportSkip
+	}
+
+	results := make([]*RecipeImportResult, 0)
+	recipes := make([]*RecipeExport, 0)
+	pending := make([]*RecipeImportResult, 0)
+	scanner := bufio.NewScanner(c.Request.Body)
+	scanner.Buffer(make([]byte, 64*1024), maxImportLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		result := &RecipeImportResult{Line: line}
+		results = append(results, result)
+		r := &RecipeExport{}
+		if err := stdjson.Unmarshal(text, r); err != nil {
+			result.Action, result.Error = recipeImportFailed, err.Error()
+			continue
+		}
+		if err := r.validate(); err != nil {
+			result.SourceID, result.Action, result.Error = r.ID, recipeImportFailed, err.Error()
+			continue
+		}
+		recipes = append(recipes, r)
+		pending = append(pending, result)
+	}
+	if err := scanner.Err(); err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	token := c.GetHeader("Authorization")
+	imported := s.datastoreOf(c).importRecipesByCredential(recipes, arg, token)
+	if imported == nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	for i, r := range imported {
+		r.Line = pending[i].Line
+		*pending[i] = *r
+	}
+	respond(c, http.StatusOK, newRecipeImportReport(arg, results))
+}
+
+func (s *apiServer) postWebhook(c *gin.Context) {
+	arg := &PostWebhookArg{}
+	if err := bindBody(c, arg); err != nil {
+		c.AbortWithStatus(bindErrorStatus(err))
+		return
+	}
+
+	if err := validate.Struct(arg); err != nil {
+		abortWithValidationErrors(c, "body", arg, err)
+		return
+	}
+	if err := s.webhooks.checkURL(arg.URL); err != nil {
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, &ContractViolationReport{
+			Errors:    []*ContractViolation{{In: "body", Name: "url", Error: err.Error()}},
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	token := c.GetHeader("Authorization")
+	if res := s.datastoreOf(c).addWebhookByCredential(arg, newWebhookSecret(), token); res != nil {
+		respond(c, http.StatusOK, res)
+		return
+	}
+	c.AbortWithStatus(http.StatusNotFound)
+}
+
+func (s *apiServer) getWebhooks(c *gin.Context) {
+	token := c.GetHeader("Authorization")
+	if res := s.datastoreOf(c).listWebhooksByCredential(token); res != nil {
+		respond(c, http.StatusOK, res)
+		return
+	}
+
+	c.AbortWithStatus(http.StatusNotFound)
+}
+
+func (s *apiServer) deleteWebhook(c *gin.Context) {
+	webhookID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	token := c.GetHeader("Authorization")
+	if res := s.datastoreOf(c).deleteWebhookByCredential(webhookID, token); res != nil {
+		respond(c, http.StatusOK, res)
+		return
+	}
+
+	c.AbortWithStatus(http.StatusNotFound)
+}
+
+func (s *apiServer) getWebhookDeliveries(c *gin.Context) {
+	webhookID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	filter := &WebhookDeliveryFilter{}
+	if err := c.ShouldBindQuery(filter); err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	if err := validate.Struct(filter); err != nil {
+		abortWithValidationErrors(c, "query", filter, err)
+		return
+	}
+
+	paging := newPaging()
+	bindPagiing(c, paging)
+	token := c.GetHeader("Authorization")
+	if res := s.datastoreOf(c).listWebhookDeliveriesByCredential(webhookID, filter, token, paging); res != nil {
+		respond(c, http.StatusOK, res)
+		return
+	}
+
+	c.AbortWithStatus(http.StatusNotFound)
+}
+
+func (s *apiServer) postRedeliverWebhookDelivery(c *gin.Context) {
+	webhookID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	deliveryID, err := strconv.Atoi(c.Param("delivery"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
 	}
 
-	if recipe := s.datastore.rateAndGetRecipe(arg, recipeID); recipe != nil {
-		c.JSON(http.StatusOK, recipe)
+	token := c.GetHeader("Authorization")
+	if res := s.datastoreOf(c).redeliverWebhookDeliveryByCredential(webhookID, deliveryID, token); res != nil {
+		s.webhooks.notify()
+		respond(c, http.StatusAccepted, res)
 		return
 	}
 
 	c.AbortWithStatus(http.StatusNotFound)
 }
+
+// getEvents streams the recipe events as server-sent events. A client that
+// reconnects with a Last-Event-ID header first gets the kept events it
+// missed, and a comment is sent as a heartbeat while there are no events.
+func (s *apiServer) getEvents(c *gin.Context) {
+	filter := &EventFilter{}
+	if err := c.ShouldBindQuery(filter); err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	if err := validate.Struct(filter); err != nil {
+		abortWithValidationErrors(c, "query", filter, err)
+		return
+	}
+
+	var lastSeq int64
+	if header := c.GetHeader("Last-Event-ID"); header != "" {
+		seq, err := strconv.ParseInt(header, 10, 64)
+		if err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		lastSeq = seq
+	}
+
+	missed, events := s.events.subscribe(lastSeq, eventStreamBufferSize)
+	defer s.events.unsubscribe(events)
+	c.Header("Content-Type", eventStreamContentType)
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	fmt.Fprintf(c.Writer, "retry:%d\n\n", eventStreamRetry/time.Millisecond)
+	for _, e := range missed {
+		writeEvent(c.Writer, filter, e)
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(s.eventHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			writeEvent(c.Writer, filter, e)
+		case <-heartbeat.C:
+			fmt.Fprint(c.Writer, ":heartbeat\n\n")
+		}
+		c.Writer.Flush()
+	}
+}
+
+// writeEvent sends an event with the sequence number of the bus as its ID,
+// which the client resumes the stream after.
+func writeEvent(w gin.ResponseWriter, filter *EventFilter, e *busEvent) {
+	if !filter.matches(e.recipeEvent) {
+		return
+	}
+	if err := sse.Encode(w, sse.Event{Id: strconv.FormatInt(e.seq, 10), Event: e.Type, Data: e.recipeEvent}); err != nil {
+		panic(err)
+	}
+}
+
+func (s *apiServer) getOpenAPIDocument(c *gin.Context) {
+	c.JSON(http.StatusOK, s.contract.doc)
+}
+
+func (s *apiServer) getAPIDocs(c *gin.Context) {
+	c.Data(http.StatusOK, htmlContentType+"; charset=utf-8", []byte(apiDocsPage))
+}
+
+func (s *apiServer) getMetrics(c *gin.Context) {
+	c.Data(http.StatusOK, metricsContentType+"; version="+metricsExpositionVersion+"; charset=utf-8", renderMetrics(metrics))
+}
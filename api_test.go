@@ -2,16 +2,23 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"database/sql"
 	"net/http"
 	"net/http/httptest"
+	"time"
 
+	"github.com/lib/pq"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	null "gopkg.in/guregu/null.v3"
 )
 
 type mockDatastore struct {
-	dataFunc func() interface{}
+	dataFunc           func() interface{}
+	idempotencyRecords map[string]*idempotencyRecord
+	ownerLookups       int
+	pingErr            error
 }
 
 func (md *mockDatastore) listRecipes(f *ListFilter, p *paging) []*Recipe {
@@ -32,27 +39,264 @@ func (md *mockDatastore) getRecipeByID(id int) *Recipe {
 	return nil
 }
 
-func (md *mockDatastore) updateAndGetRecipeByCredential(arg *PutRecipeArg, id int, token string) *Recipe {
+func (md *mockDatastore) listRecipeOwners(ids []int) map[int]string {
+	md.ownerLookups++
+	res := make(map[int]string)
+	for _, id := range ids {
+		res[id] = "foo"
+	}
+	return res
+}
+
+func (md *mockDatastore) updateAndGetRecipeByCredential(arg *PutRecipeArg, id int, version int, token string) *Recipe {
+	if d := md.dataFunc(); d != nil {
+		if r := md.dataFunc().(*Recipe); version == 0 || version == r.Version {
+			return r
+		}
+	}
+	return nil
+}
+
+func (md *mockDatastore) deleteAndGetRecipeByCredential(id int, version int, token string) *Recipe {
+	if d := md.dataFunc(); d != nil {
+		if r := md.dataFunc().(*Recipe); version == 0 || version == r.Version {
+			return r
+		}
+	}
+	return nil
+}
+
+func (md *mockDatastore) getRecipeByCredential(id int, token string) *Recipe {
+	if d := md.dataFunc(); d != nil {
+		return md.dataFunc().(*Recipe)
+	}
+	return nil
+}
+
+func (md *mockDatastore) getAccountByCredential(token string) null.String {
+	if token == "faketoken" {
+		return null.StringFrom("foo")
+	}
+	return null.String{}
+}
+
+func (md *mockDatastore) isAdminByCredential(token string) bool {
+	return token == "faketoken"
+}
+
+func (md *mockDatastore) getRecipeVersionByCredential(id int, token string) null.Int {
+	if d := md.dataFunc(); d != nil {
+		return null.IntFrom(int64(md.dataFunc().(*Recipe).Version))
+	}
+	return null.Int{}
+}
+
+func (md *mockDatastore) rateAndGetRecipe(arg *PostRateRecipeArg, id int, token string) *Recipe {
 	if d := md.dataFunc(); d != nil {
 		return md.dataFunc().(*Recipe)
 	}
 	return nil
 }
 
-func (md *mockDatastore) deleteAndGetRecipeByCredential(id int, token string) *Recipe {
+func (md *mockDatastore) publishRecipeScheduleEvents(to time.Time) int {
+	return 0
+}
+
+func (md *mockDatastore) nextRecipeScheduleTime(after time.Time) null.Time {
+	if d := md.dataFunc(); d != nil {
+		if t, ok := d.(null.Time); ok {
+			return t
+		}
+	}
+	return null.Time{}
+}
+
+func (md *mockDatastore) listDeletedRecipesByCredential(token string, p *paging) []*Recipe {
+	if d := md.dataFunc(); d != nil {
+		return md.dataFunc().([]*Recipe)
+	}
+	return nil
+}
+
+func (md *mockDatastore) restoreAndGetRecipeByCredential(id int, token string) *Recipe {
 	if d := md.dataFunc(); d != nil {
 		return md.dataFunc().(*Recipe)
 	}
 	return nil
 }
 
-func (md *mockDatastore) rateAndGetRecipe(arg *PostRateRecipeArg, id int) *Recipe {
+func (md *mockDatastore) purgeDeletedRecipes(deletedBefore time.Time) int64 {
+	return 0
+}
+
+func (md *mockDatastore) listRecipeRevisionsByCredential(id int, token string) []*RecipeRevision {
+	if d := md.dataFunc(); d != nil {
+		return md.dataFunc().([]*RecipeRevision)
+	}
+	return nil
+}
+
+func (md *mockDatastore) getRecipeRevisionByCredential(id int, revision int, token string) *RecipeRevision {
+	if d := md.dataFunc(); d != nil {
+		return md.dataFunc().(*RecipeRevision)
+	}
+	return nil
+}
+
+func (md *mockDatastore) restoreRecipeRevisionByCredential(id int, revision int, token string) *Recipe {
 	if d := md.dataFunc(); d != nil {
 		return md.dataFunc().(*Recipe)
 	}
 	return nil
 }
 
+func (md *mockDatastore) executeRecipeBatchByCredential(ops []*RecipeBatchOperation, atomic bool, token string) []*Recipe {
+	res := make([]*Recipe, len(ops))
+	if d := md.dataFunc(); d != nil {
+		copy(res, md.dataFunc().([]*Recipe))
+	}
+	return res
+}
+
+func (md *mockDatastore) reserveIdempotencyRecord(r *idempotencyRecord, expiredBefore time.Time) bool {
+	if md.idempotencyRecords == nil {
+		md.idempotencyRecords = make(map[string]*idempotencyRecord)
+	}
+	if stored, ok := md.idempotencyRecords[r.Scope+r.Key]; ok && stored.CreatedAt.After(expiredBefore) {
+		return false
+	}
+	stored := *r
+	stored.CreatedAt = time.Now()
+	md.idempotencyRecords[r.Scope+r.Key] = &stored
+	return true
+}
+
+func (md *mockDatastore) getIdempotencyRecord(scope string, key string, expiredBefore time.Time) *idempotencyRecord {
+	if stored, ok := md.idempotencyRecords[scope+key]; ok && stored.CreatedAt.After(expiredBefore) {
+		return stored
+	}
+	return nil
+}
+
+func (md *mockDatastore) completeIdempotencyRecord(r *idempotencyRecord) {
+	stored := *r
+	stored.CreatedAt = md.idempotencyRecords[r.Scope+r.Key].CreatedAt
+	md.idempotencyRecords[r.Scope+r.Key] = &stored
+}
+
+func (md *mockDatastore) releaseIdempotencyRecord(r *idempotencyRecord) {
+	delete(md.idempotencyRecords, r.Scope+r.Key)
+}
+
+func (md *mockDatastore) purgeIdempotencyRecords(createdBefore time.Time) int64 {
+	return 0
+}
+
+func (md *mockDatastore) exportRecipesByCredential(token string, emit func(*RecipeExport)) bool {
+	if d := md.dataFunc(); d != nil {
+		for _, r := range md.dataFunc().([]*RecipeExport) {
+			emit(r)
+		}
+		return true
+	}
+	return false
+}
+
+func (md *mockDatastore) importRecipesByCredential(recipes []*RecipeExport, arg *ImportRecipesArg, token string) []*RecipeImportResult {
+	if d := md.dataFunc(); d != nil {
+		res := make([]*RecipeImportResult, len(recipes))
+		for i, r := range recipes {
+			res[i] = &RecipeImportResult{SourceID: r.ID, ID: r.ID, Action: recipeImportCreated}
+		}
+		return res
+	}
+	return nil
+}
+
+func (md *mockDatastore) addWebhookByCredential(arg *PostWebhookArg, secret string, token string) *Webhook {
+	if d := md.dataFunc(); d != nil {
+		res := *md.dataFunc().(*Webhook)
+		res.Secret = secret
+		return &res
+	}
+	return nil
+}
+
+func (md *mockDatastore) listWebhooksByCredential(token string) []*Webhook {
+	if d := md.dataFunc(); d != nil {
+		return md.dataFunc().([]*Webhook)
+	}
+	return nil
+}
+
+func (md *mockDatastore) deleteWebhookByCredential(id int, token string) *Webhook {
+	if d := md.dataFunc(); d != nil {
+		return md.dataFunc().(*Webhook)
+	}
+	return nil
+}
+
+func (md *mockDatastore) listWebhookDeliveriesByCredential(id int, f *WebhookDeliveryFilter, token string, p *paging) []*WebhookDelivery {
+	if d := md.dataFunc(); d != nil {
+		res := make([]*WebhookDelivery, 0)
+		for _, w := range md.dataFunc().([]*WebhookDelivery) {
+			if f.Status == "" || f.Status == w.Status {
+				res = append(res, w)
+			}
+		}
+		return res
+	}
+	return nil
+}
+
+func (md *mockDatastore) redeliverWebhookDeliveryByCredential(id int, deliveryID int, token string) *WebhookDelivery {
+	if d := md.dataFunc(); d != nil {
+		res := *md.dataFunc().(*WebhookDelivery)
+		res.Status = webhookDeliveryPending
+		res.Attempts = 0
+		return &res
+	}
+	return nil
+}
+
+func (md *mockDatastore) enqueueWebhookDeliveries(eventType string, recipeID int, payload []byte, traceparent string) int64 {
+	return 0
+}
+
+func (md *mockDatastore) claimWebhookDeliveries(heldUntil time.Time, limit int) []*webhookAttempt {
+	return nil
+}
+
+func (md *mockDatastore) recordWebhookDeliveryAttempt(w *WebhookDelivery) {}
+
+func (md *mockDatastore) relayRecipeEvents(limit int, publish func(*recipeEvent) bool) int {
+	cnt := 0
+	if d := md.dataFunc(); d != nil {
+		for _, e := range d.([]*recipeEvent) {
+			if publish(e) {
+				cnt++
+			}
+		}
+	}
+	return cnt
+}
+
+func (md *mockDatastore) purgeRecipeEvents(publishedBefore time.Time) int64 {
+	return 0
+}
+
+func (md *mockDatastore) ping(timeout time.Duration) error {
+	return md.pingErr
+}
+
+func (md *mockDatastore) poolStats() sql.DBStats {
+	return sql.DBStats{MaxOpenConnections: 10, OpenConnections: 3, InUse: 2, Idle: 1}
+}
+
+func (md *mockDatastore) withContext(ctx context.Context) datastore {
+	return md
+}
+
 func (md *mockDatastore) close() {}
 
 func newTestAPIServer(data interface{}) *apiServer {
@@ -64,7 +308,14 @@ func newTestAPIServer(data interface{}) *apiServer {
 	s := &apiServer{
 		httpServer: newGinHTTPServer(),
 		datastore:  md,
+		webhooks:   newWebhookDispatcher(md, defaultWebhookAttempts, defaultWebhookPollInterval),
+		events:     newEventBus(recipeEventReplaySize),
+		live:       newLiveHub(defaultLiveRoomIdleTimeout),
+		startedAt:  time.Now(),
 	}
+	s.eventHeartbeat = defaultEventHeartbeatInterval
+	s.deprecations = map[int]time.Time{1: defaultV1Deprecation}
+	s.sunsets = map[int]time.Time{1: defaultV1Sunset}
 	s.routes()
 	return s
 }
@@ -72,8 +323,8 @@ func newTestAPIServer(data interface{}) *apiServer {
 var _ = Describe("Listing recipes", func() {
 	It("lists non-empty results", func() {
 		server := newTestAPIServer([]*Recipe{
-			{1, "name1", null.IntFromPtr(nil), null.IntFromPtr(nil), false, null.FloatFrom(0.0), null.IntFrom(0)},
-			{11, "name11", null.IntFrom(1), null.IntFrom(2), true, null.FloatFrom(0.0), null.IntFrom(0)},
+			{ID: 1, Name: "name1", PrepareTime: null.IntFromPtr(nil), Difficulty: null.IntFromPtr(nil), IsVegetarian: false, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0)},
+			{ID: 11, Name: "name11", PrepareTime: null.IntFrom(1), Difficulty: null.IntFrom(2), IsVegetarian: true, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0)},
 		})
 		rr := httptest.NewRecorder()
 		req, _ := http.NewRequest("GET", "/recipes", nil)
@@ -92,7 +343,10 @@ var _ = Describe("Listing recipes", func() {
 			   "difficulty":null,
 			   "is_vegetarian":false,
 			   "rating": 0,
-			   "rated_num": 0
+			   "rated_num": 0,
+			   "publish_at": null,
+			   "unpublish_at": null,
+			   "deleted_at": null
 			},
 			{
 			   "id":11,
@@ -101,7 +355,10 @@ var _ = Describe("Listing recipes", func() {
 			   "difficulty":2,
 			   "is_vegetarian":true,
 			   "rating": 0,
-			   "rated_num": 0
+			   "rated_num": 0,
+			   "publish_at": null,
+			   "unpublish_at": null,
+			   "deleted_at": null
 			}
 		]
 		`))
@@ -123,9 +380,83 @@ var _ = Describe("Listing recipes", func() {
 	})
 })
 
+var _ = Describe("Listing recipes as CSV", func() {
+	It("lists the recipes as CSV when asked for", func() {
+		server := newTestAPIServer([]*Recipe{
+			{ID: 1, Name: "name1", PrepareTime: null.IntFromPtr(nil), Difficulty: null.IntFromPtr(nil), IsVegetarian: false, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0)},
+			{ID: 11, Name: "name11", PrepareTime: null.IntFrom(1), Difficulty: null.IntFrom(2), IsVegetarian: true, Rating: null.FloatFrom(4.5), RatedNum: null.IntFrom(2)},
+		})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/recipes?name=name", nil)
+		req.Header.Set("Accept", "text/csv")
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(rr.Header().Get("Content-Type")).To(Equal("text/csv; charset=utf-8"))
+		Expect(rr.Body.String()).To(Equal("id,name,prepare_time,difficulty,is_vegetarian,rating,rated_num,publish_at,unpublish_at\n" +
+			"1,name1,,,false,0,0,,\n" +
+			"11,name11,1,2,true,4.5,2,,\n"))
+	})
+})
+
+var _ = Describe("Importing recipes from CSV", func() {
+	It("adds a recipe for every row", func() {
+		server := newTestAPIServer([]*Recipe{
+			{ID: 32, Name: "name3", IsVegetarian: false, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0)},
+			{ID: 33, Name: "name4", IsVegetarian: true, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0)},
+		})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/recipes/import.csv", bytes.NewBufferString("name,is_vegetarian\nname3,false\nname4,true\n"))
+		req.Header.Set("Content-Type", "text/csv")
+		req.Header.Set("Authorization", "faketoken")
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		jsonObj := newJSON(rr.Body.Bytes())
+		GinkgoT().Logf("[Import Recipes From CSV] JSON Result: %s", jsonObj.pretty())
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(jsonObj.Get("imported").MustInt()).To(Equal(2))
+		Expect(jsonObj.Get("errors").MustArray()).To(BeEmpty())
+		Expect(jsonObj.Get("recipes").GetIndex(1).Get("id").MustInt()).To(Equal(33))
+	})
+	It("responses with [422 Unprocessable Entity] and the row errors when a row is invalid", func() {
+		server := newTestAPIServer([]*Recipe{})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/recipes/import.csv", bytes.NewBufferString("name,is_vegetarian\nname3,false\nname4,\n"))
+		req.Header.Set("Authorization", "faketoken")
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		Expect(rr.Code).To(Equal(http.StatusUnprocessableEntity))
+		Expect(rr.Body.String()).To(MatchJSON(`
+		{
+			"imported":0,
+			"errors":[
+				{"row":3,"column":"is_vegetarian","error":"failed on the 'required' validation"}
+			],
+			"recipes":[]
+		}
+		`))
+	})
+	It("responses with [404 Not Found] when the user's credential is not valid", func() {
+		server := newTestAPIServer([]*Recipe{nil})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/recipes/import.csv", bytes.NewBufferString("name,is_vegetarian\nname3,false\n"))
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		Expect(rr.Code).To(Equal(http.StatusNotFound))
+	})
+	It("responses with [400 Bad Request] when getting a malformed CSV document", func() {
+		server := newTestAPIServer([]*Recipe{})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/recipes/import.csv", bytes.NewBufferString(""))
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		Expect(rr.Code).To(Equal(http.StatusBadRequest))
+	})
+})
+
 var _ = Describe("Adding a recipe", func() {
 	It("adds a recipe and returns the resulting JSON object", func() {
-		server := newTestAPIServer(&Recipe{32, "name3", null.IntFrom(5), null.IntFromPtr(nil), false, null.FloatFrom(0.0), null.IntFrom(0)})
+		server := newTestAPIServer(&Recipe{ID: 32, Name: "name3", PrepareTime: null.IntFrom(5), Difficulty: null.IntFromPtr(nil), IsVegetarian: false, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0)})
 		rr := httptest.NewRecorder()
 		req, _ := http.NewRequest("POST", "/recipes", newJSON([]byte(`
 		{
@@ -167,7 +498,7 @@ var _ = Describe("Adding a recipe", func() {
 		Expect(rr.Code).To(Equal(http.StatusNotFound))
 	})
 	It("responses with [400 Bad Request] when getting an invalid JSON argument", func() {
-		server := newTestAPIServer(&Recipe{32, "name3", null.IntFrom(5), null.IntFromPtr(nil), false, null.FloatFrom(0.0), null.IntFrom(0)})
+		server := newTestAPIServer(&Recipe{ID: 32, Name: "name3", PrepareTime: null.IntFrom(5), Difficulty: null.IntFromPtr(nil), IsVegetarian: false, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0)})
 		rr := httptest.NewRecorder()
 		req, _ := http.NewRequest("POST", "/recipes", bytes.NewBuffer([]byte(`
 		{
@@ -185,49 +516,51 @@ var _ = Describe("Adding a recipe", func() {
 	})
 })
 
-var _ = Describe("Getting a recipe by ID", func() {
-	It("gets a recipe and returns the corresponding JSON object", func() {
-		server := newTestAPIServer(&Recipe{32, "name3", null.IntFrom(5), null.IntFromPtr(nil), false, null.FloatFrom(0.0), null.IntFrom(0)})
+var _ = Describe("Executing a batch of recipe operations", func() {
+	It("commits all operations in all-or-nothing mode", func() {
+		server := newTestAPIServer([]*Recipe{
+			{ID: 32, Name: "name3", IsVegetarian: false, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0), Version: 1},
+			{ID: 5, Name: "name5", IsVegetarian: true, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0), Version: 2},
+		})
 		rr := httptest.NewRecorder()
-		req, _ := http.NewRequest("GET", "/recipes/32", nil)
+		req, _ := http.NewRequest("POST", "/recipes:batch", bytes.NewBuffer([]byte(`
+		{
+			"operations":[
+				{"op":"create","recipe":{"name":"name3","is_vegetarian":false}},
+				{"op":"delete","id":5}
+			]
+		}
+		`)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "faketoken")
 
 		server.httpServer.router.ServeHTTP(rr, req)
 
 		jsonObj := newJSON(rr.Body.Bytes())
-		GinkgoT().Logf("[Get A Recipe By ID] JSON Result: %s", jsonObj.pretty())
+		GinkgoT().Logf("[Batch] JSON Result: %s", jsonObj.pretty())
 		Expect(rr.Code).To(Equal(http.StatusOK))
-		Expect(jsonObj.Get("id").MustInt()).To(Equal(32))
-		Expect(jsonObj.Get("name").MustString()).To(Equal("name3"))
-		Expect(jsonObj.Get("prepare_time").MustInt()).To(Equal(5))
-		Expect(jsonObj.Get("difficulty").Interface()).To(BeNil())
-		Expect(jsonObj.Get("is_vegetarian").MustBool()).To(BeFalse())
-	})
-	It("responses with [404 Not Found] when getting an invalid parameter", func() {
-		server := newTestAPIServer(&Recipe{32, "name3", null.IntFrom(5), null.IntFromPtr(nil), false, null.FloatFrom(0.0), null.IntFrom(0)})
-		rr := httptest.NewRecorder()
-		req, _ := http.NewRequest("GET", "/recipes/ff", nil)
-
-		server.httpServer.router.ServeHTTP(rr, req)
-		Expect(rr.Code).To(Equal(http.StatusNotFound))
-	})
-	It("responses with [404 Not Found] when the recipe doesn't exist", func() {
-		server := newTestAPIServer(nil)
-		rr := httptest.NewRecorder()
-		req, _ := http.NewRequest("GET", "/recipes/32", nil)
-
-		server.httpServer.router.ServeHTTP(rr, req)
-		Expect(rr.Code).To(Equal(http.StatusNotFound))
+		Expect(jsonObj.Get("mode").MustString()).To(Equal("all_or_nothing"))
+		Expect(jsonObj.Get("committed").MustBool()).To(BeTrue())
+		Expect(jsonObj.Get("results").MustArray()).To(HaveLen(2))
+		Expect(jsonObj.Get("results").GetIndex(0).Get("status").MustInt()).To(Equal(http.StatusOK))
+		Expect(jsonObj.Get("results").GetIndex(0).Get("recipe").Get("id").MustInt()).To(Equal(32))
+		Expect(jsonObj.Get("results").GetIndex(1).Get("status").MustInt()).To(Equal(http.StatusOK))
+		Expect(jsonObj.Get("results").GetIndex(1).Get("recipe").Get("id").MustInt()).To(Equal(5))
 	})
-})
-
-var _ = Describe("Updating a recipe by ID", func() {
-	It("updates a recipe and gets the updated JSON object", func() {
-		server := newTestAPIServer(&Recipe{32, "name3", null.IntFrom(5), null.IntFrom(3), false, null.FloatFrom(0.0), null.IntFrom(0)})
+	It("rolls back every operation in all-or-nothing mode when one fails", func() {
+		server := newTestAPIServer([]*Recipe{
+			{ID: 32, Name: "name3", IsVegetarian: false, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0), Version: 1},
+			nil,
+		})
 		rr := httptest.NewRecorder()
-		req, _ := http.NewRequest("PUT", "/recipes/32", bytes.NewBuffer([]byte(`
+		req, _ := http.NewRequest("POST", "/recipes:batch", bytes.NewBuffer([]byte(`
 		{
-			"prepare_time":5,
-			"difficulty":3
+			"mode":"all_or_nothing",
+			"operations":[
+				{"op":"create","recipe":{"name":"name3","is_vegetarian":false}},
+				{"op":"update","id":7,"recipe":{"name":"name7","is_vegetarian":true}},
+				{"op":"delete","id":5}
+			]
 		}
 		`)))
 		req.Header.Set("Content-Type", "application/json")
@@ -236,69 +569,199 @@ var _ = Describe("Updating a recipe by ID", func() {
 		server.httpServer.router.ServeHTTP(rr, req)
 
 		jsonObj := newJSON(rr.Body.Bytes())
-		GinkgoT().Logf("[Update A Recipe By ID] JSON Result: %s", jsonObj.pretty())
-		Expect(rr.Code).To(Equal(http.StatusOK))
-		Expect(jsonObj.Get("prepare_time").MustInt()).To(Equal(5))
-		Expect(jsonObj.Get("difficulty").MustInt()).To(Equal(3))
+		Expect(rr.Code).To(Equal(http.StatusUnprocessableEntity))
+		Expect(jsonObj.Get("committed").MustBool()).To(BeFalse())
+		Expect(jsonObj.Get("results").GetIndex(0).Get("status").MustInt()).To(Equal(http.StatusFailedDependency))
+		Expect(jsonObj.Get("results").GetIndex(0).Get("recipe").Interface()).To(BeNil())
+		Expect(jsonObj.Get("results").GetIndex(1).Get("status").MustInt()).To(Equal(http.StatusNotFound))
+		Expect(jsonObj.Get("results").GetIndex(2).Get("status").MustInt()).To(Equal(http.StatusFailedDependency))
 	})
-	It("responses with [404 Not Found] when getting an invalid parameter", func() {
-		server := newTestAPIServer(&Recipe{32, "name3", null.IntFrom(5), null.IntFromPtr(nil), false, null.FloatFrom(0.0), null.IntFrom(0)})
+	It("executes nothing in all-or-nothing mode when an operation is invalid", func() {
+		server := newTestAPIServer(nil)
 		rr := httptest.NewRecorder()
-		req, _ := http.NewRequest("PUT", "/recipes/ff", bytes.NewBuffer([]byte(`
+		req, _ := http.NewRequest("POST", "/recipes:batch", bytes.NewBuffer([]byte(`
 		{
-			"prepare_time":5,
-			"difficulty":3
+			"operations":[
+				{"op":"upsert","id":5},
+				{"op":"delete","id":5}
+			]
 		}
 		`)))
+		req.Header.Set("Content-Type", "application/json")
 		req.Header.Set("Authorization", "faketoken")
 
 		server.httpServer.router.ServeHTTP(rr, req)
-		Expect(rr.Code).To(Equal(http.StatusNotFound))
+
+		jsonObj := newJSON(rr.Body.Bytes())
+		Expect(rr.Code).To(Equal(http.StatusUnprocessableEntity))
+		Expect(jsonObj.Get("committed").MustBool()).To(BeFalse())
+		Expect(jsonObj.Get("results").GetIndex(0).Get("status").MustInt()).To(Equal(http.StatusBadRequest))
+		Expect(jsonObj.Get("results").GetIndex(0).Get("error").MustString()).NotTo(BeEmpty())
+		Expect(jsonObj.Get("results").GetIndex(1).Get("status").MustInt()).To(Equal(http.StatusFailedDependency))
 	})
-	It("responses with [404 Not Found] when the recipe is not authorized or not found", func() {
-		server := newTestAPIServer(nil)
+	It("returns a status for every operation in best-effort mode", func() {
+		server := newTestAPIServer([]*Recipe{
+			{ID: 32, Name: "name3", IsVegetarian: false, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0), Version: 1},
+			nil,
+		})
 		rr := httptest.NewRecorder()
-		req, _ := http.NewRequest("PUT", "/recipes/32", bytes.NewBuffer([]byte(`
+		req, _ := http.NewRequest("POST", "/recipes:batch", bytes.NewBuffer([]byte(`
 		{
-			"prepare_time":5,
-			"difficulty":3
+			"mode":"best_effort",
+			"operations":[
+				{"op":"create","recipe":{"name":"name3","is_vegetarian":false}},
+				{"op":"update","id":7,"recipe":{"is_vegetarian":true}},
+				{"op":"delete","id":5}
+			]
 		}
 		`)))
+		req.Header.Set("Content-Type", "application/json")
 		req.Header.Set("Authorization", "faketoken")
 
 		server.httpServer.router.ServeHTTP(rr, req)
-		Expect(rr.Code).To(Equal(http.StatusNotFound))
+
+		jsonObj := newJSON(rr.Body.Bytes())
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(jsonObj.Get("mode").MustString()).To(Equal("best_effort"))
+		Expect(jsonObj.Get("committed").MustBool()).To(BeTrue())
+		Expect(jsonObj.Get("results").GetIndex(0).Get("status").MustInt()).To(Equal(http.StatusOK))
+		Expect(jsonObj.Get("results").GetIndex(1).Get("status").MustInt()).To(Equal(http.StatusBadRequest))
+		Expect(jsonObj.Get("results").GetIndex(2).Get("status").MustInt()).To(Equal(http.StatusNotFound))
 	})
-	It("responses with [400 Bad Request] if the JSON argument is invalid", func() {
-		server := newTestAPIServer(&Recipe{32, "name3", null.IntFrom(5), null.IntFromPtr(nil), false, null.FloatFrom(0.0), null.IntFrom(0)})
+	It("responses with [400 Bad Request] when getting an invalid JSON argument", func() {
+		server := newTestAPIServer(nil)
 		rr := httptest.NewRecorder()
-		req, _ := http.NewRequest("PUT", "/recipes/32", bytes.NewBuffer([]byte(`
-		{
-			"name":"name3",
-			"prepare_time":5,
-			"difficulty":null,
-			"is_vegetarian":false,
-		}
-		`)))
+		req, _ := http.NewRequest("POST", "/recipes:batch", bytes.NewBuffer([]byte(`{"operations":[}`)))
 		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("Authorization", "faketoken")
 
 		server.httpServer.router.ServeHTTP(rr, req)
 		Expect(rr.Code).To(Equal(http.StatusBadRequest))
 	})
-})
-
-var _ = Describe("Deleting a recipe by ID", func() {
-	It("deletes a recipe and gets the deleted JSON object", func() {
-		server := newTestAPIServer(&Recipe{32, "name3", null.IntFrom(5), null.IntFromPtr(nil), false, null.FloatFrom(0.0), null.IntFrom(0)})
+	It("responses with [404 Not Found] for other methods", func() {
+		server := newTestAPIServer(nil)
 		rr := httptest.NewRecorder()
-		req, _ := http.NewRequest("DELETE", "/recipes/32", nil)
-		req.Header.Set("Authorization", "faketoken")
+		req, _ := http.NewRequest("GET", "/recipes:batch", nil)
 
 		server.httpServer.router.ServeHTTP(rr, req)
-
-		jsonObj := newJSON(rr.Body.Bytes())
-		GinkgoT().Logf("[Delete A Recipe By ID] JSON Result: %s", jsonObj.pretty())
+		Expect(rr.Code).To(Equal(http.StatusNotFound))
+	})
+})
+
+var _ = Describe("Retrying a request with an idempotency key", func() {
+	newIdempotentRequest := func(method, url, key, body string) *http.Request {
+		req, _ := http.NewRequest(method, url, bytes.NewBuffer([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "faketoken")
+		req.Header.Set("Idempotency-Key", key)
+		return req
+	}
+	var recipe *Recipe
+	var server *apiServer
+	BeforeEach(func() {
+		recipe = &Recipe{ID: 32, Name: "name3", IsVegetarian: false, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0), Version: 1}
+		server = newTestAPIServer(recipe)
+		server.idempotencyTTL = time.Hour
+	})
+	It("replays the stored response for the same key and body", func() {
+		rr := httptest.NewRecorder()
+		server.httpServer.router.ServeHTTP(rr, newIdempotentRequest("POST", "/recipes", "key1", `{"name":"name3","is_vegetarian":false}`))
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(rr.Header().Get("Idempotent-Replayed")).To(BeEmpty())
+
+		recipe.Name = "changed"
+		rr = httptest.NewRecorder()
+		server.httpServer.router.ServeHTTP(rr, newIdempotentRequest("POST", "/recipes", "key1", `{"name":"name3","is_vegetarian":false}`))
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(rr.Header().Get("Idempotent-Replayed")).To(Equal("true"))
+		Expect(rr.Header().Get("Content-Type")).To(HavePrefix("application/json"))
+		Expect(newJSON(rr.Body.Bytes()).Get("name").MustString()).To(Equal("name3"))
+	})
+	It("replays a stored response without a body", func() {
+		server = newTestAPIServer(nil)
+		server.idempotencyTTL = time.Hour
+		rr := httptest.NewRecorder()
+		server.httpServer.router.ServeHTTP(rr, newIdempotentRequest("DELETE", "/recipes/32", "key2", ``))
+		Expect(rr.Code).To(Equal(http.StatusNotFound))
+		rr = httptest.NewRecorder()
+		server.httpServer.router.ServeHTTP(rr, newIdempotentRequest("DELETE", "/recipes/32", "key2", ``))
+		Expect(rr.Code).To(Equal(http.StatusNotFound))
+		Expect(rr.Header().Get("Idempotent-Replayed")).To(Equal("true"))
+	})
+	It("executes the request again after a server error", func() {
+		server.datastore.(*mockDatastore).dataFunc = func() interface{} {
+			panic("connection refused")
+		}
+		rr := httptest.NewRecorder()
+		server.httpServer.router.ServeHTTP(rr, newIdempotentRequest("POST", "/recipes/32/rating", "key1", `{"rating":5}`))
+		Expect(rr.Code).To(Equal(http.StatusInternalServerError))
+
+		rr = httptest.NewRecorder()
+		server.httpServer.router.ServeHTTP(rr, newIdempotentRequest("POST", "/recipes/32/rating", "key1", `{"rating":5}`))
+		Expect(rr.Code).To(Equal(http.StatusInternalServerError))
+		Expect(rr.Header().Get("Idempotent-Replayed")).To(BeEmpty())
+	})
+	It("responses with [422 Unprocessable Entity] when the key is reused with a different request", func() {
+		rr := httptest.NewRecorder()
+		server.httpServer.router.ServeHTTP(rr, newIdempotentRequest("POST", "/recipes/32/rating", "key1", `{"rating":5}`))
+		Expect(rr.Code).To(Equal(http.StatusOK))
+
+		rr = httptest.NewRecorder()
+		server.httpServer.router.ServeHTTP(rr, newIdempotentRequest("POST", "/recipes/32/rating", "key1", `{"rating":4}`))
+		Expect(rr.Code).To(Equal(http.StatusUnprocessableEntity))
+		rr = httptest.NewRecorder()
+		server.httpServer.router.ServeHTTP(rr, newIdempotentRequest("POST", "/recipes", "key1", `{"name":"name3","is_vegetarian":false}`))
+		Expect(rr.Code).To(Equal(http.StatusUnprocessableEntity))
+	})
+	It("responses with [409 Conflict] while the first request is in progress", func() {
+		req := newIdempotentRequest("POST", "/recipes/32/rating", "key1", `{"rating":5}`)
+		server.datastore.reserveIdempotencyRecord(&idempotencyRecord{
+			Scope:       idempotencyScope("faketoken"),
+			Key:         "key1",
+			Fingerprint: requestFingerprint(req, []byte(`{"rating":5}`)),
+		}, time.Now())
+
+		rr := httptest.NewRecorder()
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusConflict))
+	})
+	It("scopes the keys to the credential", func() {
+		rr := httptest.NewRecorder()
+		server.httpServer.router.ServeHTTP(rr, newIdempotentRequest("POST", "/recipes/32/rating", "key1", `{"rating":5}`))
+		Expect(rr.Code).To(Equal(http.StatusOK))
+
+		req := newIdempotentRequest("POST", "/recipes/32/rating", "key1", `{"rating":4}`)
+		req.Header.Set("Authorization", "otherfaketoken")
+		rr = httptest.NewRecorder()
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(rr.Header().Get("Idempotent-Replayed")).To(BeEmpty())
+	})
+	It("ignores the key on GET requests and when it is disabled", func() {
+		rr := httptest.NewRecorder()
+		server.httpServer.router.ServeHTTP(rr, newIdempotentRequest("GET", "/recipes/32", "key1", ``))
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		rr = httptest.NewRecorder()
+		server.httpServer.router.ServeHTTP(rr, newIdempotentRequest("POST", "/recipes/32/rating", "key1", `{"rating":5}`))
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(rr.Header().Get("Idempotent-Replayed")).To(BeEmpty())
+
+		server.idempotencyTTL = 0
+		rr = httptest.NewRecorder()
+		server.httpServer.router.ServeHTTP(rr, newIdempotentRequest("POST", "/recipes/32/rating", "key1", `{"rating":4}`))
+		Expect(rr.Code).To(Equal(http.StatusOK))
+	})
+})
+
+var _ = Describe("Getting a recipe by ID", func() {
+	It("gets a recipe and returns the corresponding JSON object", func() {
+		server := newTestAPIServer(&Recipe{ID: 32, Name: "name3", PrepareTime: null.IntFrom(5), Difficulty: null.IntFromPtr(nil), IsVegetarian: false, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0)})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/recipes/32", nil)
+
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		jsonObj := newJSON(rr.Body.Bytes())
+		GinkgoT().Logf("[Get A Recipe By ID] JSON Result: %s", jsonObj.pretty())
 		Expect(rr.Code).To(Equal(http.StatusOK))
 		Expect(jsonObj.Get("id").MustInt()).To(Equal(32))
 		Expect(jsonObj.Get("name").MustString()).To(Equal("name3"))
@@ -306,80 +769,1468 @@ var _ = Describe("Deleting a recipe by ID", func() {
 		Expect(jsonObj.Get("difficulty").Interface()).To(BeNil())
 		Expect(jsonObj.Get("is_vegetarian").MustBool()).To(BeFalse())
 	})
-	It("responses with [404 Not Found] when getting an invalid parameter", func() {
-		server := newTestAPIServer(&Recipe{32, "name3", null.IntFrom(5), null.IntFromPtr(nil), false, null.FloatFrom(0.0), null.IntFrom(0)})
+	It("gets a recipe as schema.org JSON-LD when asked for", func() {
+		server := newTestAPIServer(&Recipe{ID: 32, Name: "name3", PrepareTime: null.IntFrom(75), Difficulty: null.IntFrom(2), IsVegetarian: true, Rating: null.FloatFrom(4.5), RatedNum: null.IntFrom(2)})
 		rr := httptest.NewRecorder()
-		req, _ := http.NewRequest("DELETE", "/recipes/ff", nil)
-		req.Header.Set("Authorization", "faketoken")
+		req, _ := http.NewRequest("GET", "/recipes/32", nil)
+		req.Header.Set("Accept", "application/ld+json")
 
 		server.httpServer.router.ServeHTTP(rr, req)
-		Expect(rr.Code).To(Equal(http.StatusNotFound))
+
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(rr.Header().Get("Content-Type")).To(Equal("application/ld+json"))
+		Expect(rr.Header()["Vary"]).To(ContainElement("Accept"))
+		Expect(rr.Body.String()).To(MatchJSON(`
+		{
+			"@context":"https://schema.org",
+			"@type":"Recipe",
+			"identifier":32,
+			"name":"name3",
+			"prepTime":"PT1H15M",
+			"suitableForDiet":"https://schema.org/VegetarianDiet",
+			"aggregateRating":{
+				"@type":"AggregateRating",
+				"ratingValue":4.5,
+				"ratingCount":2
+			}
+		}
+		`))
 	})
-	It("responses with [404 Not Found] when the recipe is not authorized or not found", func() {
+	It("responses with [400 Bad Request] when getting an invalid parameter", func() {
+		server := newTestAPIServer(&Recipe{ID: 32, Name: "name3", PrepareTime: null.IntFrom(5), Difficulty: null.IntFromPtr(nil), IsVegetarian: false, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0)})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/recipes/ff", nil)
+
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusBadRequest))
+	})
+	It("responses with [404 Not Found] when the recipe doesn't exist", func() {
 		server := newTestAPIServer(nil)
 		rr := httptest.NewRecorder()
-		req, _ := http.NewRequest("DELETE", "/recipes/32", nil)
+		req, _ := http.NewRequest("GET", "/recipes/32", nil)
+
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusNotFound))
+	})
+})
+
+var _ = Describe("Importing a schema.org recipe", func() {
+	It("adds a recipe from a JSON-LD document", func() {
+		server := newTestAPIServer(&Recipe{ID: 32, Name: "name3", PrepareTime: null.IntFrom(20), IsVegetarian: true, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0)})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/recipes/import", bytes.NewBuffer([]byte(`
+		{
+			"@context":"https://schema.org",
+			"@type":"Recipe",
+			"name":"name3",
+			"prepTime":"PT20M",
+			"suitableForDiet":"https://schema.org/VegetarianDiet"
+		}
+		`)))
+		req.Header.Set("Content-Type", "application/ld+json")
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		jsonObj := newJSON(rr.Body.Bytes())
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(jsonObj.Get("id").MustInt()).To(Equal(32))
+	})
+	It("responses with [422 Unprocessable Entity] when the document has no recipe", func() {
+		server := newTestAPIServer(&Recipe{ID: 32, Name: "name3", Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0)})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/recipes/import", bytes.NewBuffer([]byte(`{"@type":"Person","name":"foo"}`)))
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusUnprocessableEntity))
+	})
+	It("responses with [400 Bad Request] when getting an invalid JSON document", func() {
+		server := newTestAPIServer(&Recipe{ID: 32, Name: "name3", Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0)})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/recipes/import", bytes.NewBuffer([]byte(`{"@type":"Recipe",`)))
 		req.Header.Set("Authorization", "faketoken")
 
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusBadRequest))
+	})
+	It("responses with [404 Not Found] when the user's credential is not valid", func() {
+		server := newTestAPIServer(nil)
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/recipes/import", bytes.NewBuffer([]byte(`{"@type":"Recipe","name":"name3"}`)))
+
 		server.httpServer.router.ServeHTTP(rr, req)
 		Expect(rr.Code).To(Equal(http.StatusNotFound))
 	})
 })
 
-var _ = Describe("Rating a recipe by ID", func() {
-	It("Rates a recipe and gets the updated JSON object", func() {
-		server := newTestAPIServer(&Recipe{3, "name3", null.IntFrom(5), null.IntFrom(3), false, null.FloatFrom(3.0), null.IntFrom(1)})
+var _ = Describe("Updating a recipe by ID", func() {
+	It("updates a recipe and gets the updated JSON object", func() {
+		server := newTestAPIServer(&Recipe{ID: 32, Name: "name3", PrepareTime: null.IntFrom(5), Difficulty: null.IntFrom(3), IsVegetarian: false, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0)})
 		rr := httptest.NewRecorder()
-		req, _ := http.NewRequest("POST", "/recipes/3/rating", bytes.NewBuffer([]byte(`
+		req, _ := http.NewRequest("PUT", "/recipes/32", bytes.NewBuffer([]byte(`
 		{
-			"rating":3
+			"name":"name3",
+			"prepare_time":5,
+			"difficulty":3,
+			"is_vegetarian":false
 		}
 		`)))
 		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "faketoken")
 
 		server.httpServer.router.ServeHTTP(rr, req)
 
 		jsonObj := newJSON(rr.Body.Bytes())
-		GinkgoT().Logf("[Rate A Recipe By ID] JSON Result: %s", jsonObj.pretty())
+		GinkgoT().Logf("[Update A Recipe By ID] JSON Result: %s", jsonObj.pretty())
 		Expect(rr.Code).To(Equal(http.StatusOK))
-		Expect(jsonObj.Get("rating").MustFloat64()).To(Equal(3.0))
-		Expect(jsonObj.Get("rated_num").MustInt()).To(Equal(1))
+		Expect(jsonObj.Get("prepare_time").MustInt()).To(Equal(5))
+		Expect(jsonObj.Get("difficulty").MustInt()).To(Equal(3))
 	})
-	It("responses with [404 Not Found] when getting an invalid parameter", func() {
-		server := newTestAPIServer(&Recipe{3, "name3", null.IntFrom(5), null.IntFromPtr(nil), false, null.FloatFrom(3.0), null.IntFrom(0)})
+	It("responses with [400 Bad Request] when getting an invalid parameter", func() {
+		server := newTestAPIServer(&Recipe{ID: 32, Name: "name3", PrepareTime: null.IntFrom(5), Difficulty: null.IntFromPtr(nil), IsVegetarian: false, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0)})
 		rr := httptest.NewRecorder()
-		req, _ := http.NewRequest("POST", "/recipes/ff/rating", bytes.NewBuffer([]byte(`
+		req, _ := http.NewRequest("PUT", "/recipes/ff", bytes.NewBuffer([]byte(`
 		{
-			"rating":3
+			"prepare_time":5,
+			"difficulty":3
 		}
 		`)))
+		req.Header.Set("Authorization", "faketoken")
 
 		server.httpServer.router.ServeHTTP(rr, req)
-		Expect(rr.Code).To(Equal(http.StatusNotFound))
+		Expect(rr.Code).To(Equal(http.StatusBadRequest))
 	})
-	It("responses with [404 Not Found] when the recipe is not found", func() {
+	It("responses with [404 Not Found] when the recipe is not authorized or not found", func() {
 		server := newTestAPIServer(nil)
 		rr := httptest.NewRecorder()
-		req, _ := http.NewRequest("POST", "/recipes/3/rating", bytes.NewBuffer([]byte(`
+		req, _ := http.NewRequest("PUT", "/recipes/32", bytes.NewBuffer([]byte(`
 		{
-			"rating":3
+			"name":"name3",
+			"prepare_time":5,
+			"difficulty":3,
+			"is_vegetarian":false
 		}
 		`)))
+		req.Header.Set("Authorization", "faketoken")
 
 		server.httpServer.router.ServeHTTP(rr, req)
 		Expect(rr.Code).To(Equal(http.StatusNotFound))
 	})
 	It("responses with [400 Bad Request] if the JSON argument is invalid", func() {
-		server := newTestAPIServer(&Recipe{3, "name3", null.IntFrom(5), null.IntFromPtr(nil), false, null.FloatFrom(3.0), null.IntFrom(0)})
+		server := newTestAPIServer(&Recipe{ID: 32, Name: "name3", PrepareTime: null.IntFrom(5), Difficulty: null.IntFromPtr(nil), IsVegetarian: false, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0)})
 		rr := httptest.NewRecorder()
-		req, _ := http.NewRequest("POST", "/recipes/3/rating", bytes.NewBuffer([]byte(`
+		req, _ := http.NewRequest("PUT", "/recipes/32", bytes.NewBuffer([]byte(`
 		{
-			"rating":3,
+			"name":"name3",
+			"prepare_time":5,
+			"difficulty":null,
+			"is_vegetarian":false,
+		}
+		`)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusBadRequest))
+	})
+	It("responses with [400 Bad Request] if a mandatory field is missing", func() {
+		server := newTestAPIServer(&Recipe{ID: 32, Name: "name3", PrepareTime: null.IntFrom(5), Difficulty: null.IntFromPtr(nil), IsVegetarian: false, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0)})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/recipes/32", bytes.NewBuffer([]byte(`
+		{
+			"prepare_time":5,
+			"difficulty":3
 		}
 		`)))
 		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		jsonObj := newJSON(rr.Body.Bytes())
+		Expect(rr.Code).To(Equal(http.StatusBadRequest))
+		Expect(jsonObj.Get("errors").MustArray()).To(HaveLen(2))
+		Expect(jsonObj.Get("errors").GetIndex(0).Get("name").MustString()).To(Equal("name"))
+		Expect(jsonObj.Get("errors").GetIndex(0).Get("error").MustString()).To(Equal("is required"))
+	})
+})
+
+var _ = Describe("Patching a recipe by ID", func() {
+	It("patches a recipe with a JSON merge patch", func() {
+		server := newTestAPIServer(&Recipe{ID: 32, Name: "name3", PrepareTime: null.IntFrom(5), Difficulty: null.IntFrom(3), IsVegetarian: false, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0), Version: 3})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("PATCH", "/recipes/32", bytes.NewBuffer([]byte(`
+		{
+			"prepare_time":null
+		}
+		`)))
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		jsonObj := newJSON(rr.Body.Bytes())
+		GinkgoT().Logf("[Patch A Recipe By ID] JSON Result: %s", jsonObj.pretty())
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(rr.Header().Get("ETag")).To(Equal(`"32-3"`))
+		Expect(jsonObj.Get("id").MustInt()).To(Equal(32))
+	})
+	It("patches a recipe with a JSON patch", func() {
+		server := newTestAPIServer(&Recipe{ID: 32, Name: "name3", PrepareTime: null.IntFrom(5), Difficulty: null.IntFrom(3), IsVegetarian: false, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0), Version: 3})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("PATCH", "/recipes/32", bytes.NewBuffer([]byte(`
+		[
+			{"op":"test","path":"/name","value":"name3"},
+			{"op":"replace","path":"/difficulty","value":null}
+		]
+		`)))
+		req.Header.Set("Content-Type", "application/json-patch+json")
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusOK))
+	})
+	It("responses with [409 Conflict] when a test operation fails", func() {
+		server := newTestAPIServer(&Recipe{ID: 32, Name: "name3", PrepareTime: null.IntFrom(5), Difficulty: null.IntFrom(3), IsVegetarian: false, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0), Version: 3})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("PATCH", "/recipes/32", bytes.NewBuffer([]byte(`
+		[
+			{"op":"test","path":"/name","value":"name4"},
+			{"op":"replace","path":"/difficulty","value":null}
+		]
+		`)))
+		req.Header.Set("Content-Type", "application/json-patch+json")
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusConflict))
+	})
+	It("responses with [400 Bad Request] if the patch document is invalid", func() {
+		server := newTestAPIServer(&Recipe{ID: 32, Name: "name3", PrepareTime: null.IntFrom(5), Difficulty: null.IntFrom(3), IsVegetarian: false, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0), Version: 3})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("PATCH", "/recipes/32", bytes.NewBuffer([]byte(`
+		{
+			"prepare_time":null,
+		}
+		`)))
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+		req.Header.Set("Authorization", "faketoken")
 
 		server.httpServer.router.ServeHTTP(rr, req)
 		Expect(rr.Code).To(Equal(http.StatusBadRequest))
 	})
+	It("responses with [422 Unprocessable Entity] if the patched recipe has unknown fields", func() {
+		server := newTestAPIServer(&Recipe{ID: 32, Name: "name3", PrepareTime: null.IntFrom(5), Difficulty: null.IntFrom(3), IsVegetarian: false, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0), Version: 3})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("PATCH", "/recipes/32", bytes.NewBuffer([]byte(`
+		{
+			"rating":5
+		}
+		`)))
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusUnprocessableEntity))
+	})
+	It("responses with [422 Unprocessable Entity] if the patched recipe is not valid", func() {
+		server := newTestAPIServer(&Recipe{ID: 32, Name: "name3", PrepareTime: null.IntFrom(5), Difficulty: null.IntFrom(3), IsVegetarian: false, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0), Version: 3})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("PATCH", "/recipes/32", bytes.NewBuffer([]byte(`
+		{
+			"name":null
+		}
+		`)))
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+		req.Header.Set("Authorization", "faketoken")
+		req.Header.Set("X-Request-ID", "req-1")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusUnprocessableEntity))
+		Expect(rr.Body.String()).To(MatchJSON(`{"errors":[{"in":"body","name":"name","error":"failed on the 'required' validation"}],"request_id":"req-1"}`))
+	})
+	It("responses with [415 Unsupported Media Type] if the patch format is not supported", func() {
+		server := newTestAPIServer(&Recipe{ID: 32, Name: "name3", PrepareTime: null.IntFrom(5), Difficulty: null.IntFrom(3), IsVegetarian: false, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0), Version: 3})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("PATCH", "/recipes/32", bytes.NewBuffer([]byte(`
+		{
+			"prepare_time":null
+		}
+		`)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusUnsupportedMediaType))
+	})
+	It("responses with [412 Precondition Failed] when the ETag doesn't match If-Match", func() {
+		server := newTestAPIServer(&Recipe{ID: 32, Name: "name3", PrepareTime: null.IntFrom(5), Difficulty: null.IntFrom(3), IsVegetarian: false, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0), Version: 3})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("PATCH", "/recipes/32", bytes.NewBuffer([]byte(`
+		{
+			"prepare_time":null
+		}
+		`)))
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+		req.Header.Set("Authorization", "faketoken")
+		req.Header.Set("If-Match", `"32-2"`)
+
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusPreconditionFailed))
+	})
+	It("responses with [404 Not Found] when the recipe is not authorized or not found", func() {
+		server := newTestAPIServer(nil)
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("PATCH", "/recipes/32", bytes.NewBuffer([]byte(`
+		{
+			"prepare_time":null
+		}
+		`)))
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusNotFound))
+	})
+})
+
+var _ = Describe("Deleting a recipe by ID", func() {
+	It("deletes a recipe and gets the deleted JSON object", func() {
+		server := newTestAPIServer(&Recipe{ID: 32, Name: "name3", PrepareTime: null.IntFrom(5), Difficulty: null.IntFromPtr(nil), IsVegetarian: false, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0)})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("DELETE", "/recipes/32", nil)
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		jsonObj := newJSON(rr.Body.Bytes())
+		GinkgoT().Logf("[Delete A Recipe By ID] JSON Result: %s", jsonObj.pretty())
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(jsonObj.Get("id").MustInt()).To(Equal(32))
+		Expect(jsonObj.Get("name").MustString()).To(Equal("name3"))
+		Expect(jsonObj.Get("prepare_time").MustInt()).To(Equal(5))
+		Expect(jsonObj.Get("difficulty").Interface()).To(BeNil())
+		Expect(jsonObj.Get("is_vegetarian").MustBool()).To(BeFalse())
+	})
+	It("responses with [400 Bad Request] when getting an invalid parameter", func() {
+		server := newTestAPIServer(&Recipe{ID: 32, Name: "name3", PrepareTime: null.IntFrom(5), Difficulty: null.IntFromPtr(nil), IsVegetarian: false, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0)})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("DELETE", "/recipes/ff", nil)
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusBadRequest))
+	})
+	It("responses with [404 Not Found] when the recipe is not authorized or not found", func() {
+		server := newTestAPIServer(nil)
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("DELETE", "/recipes/32", nil)
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusNotFound))
+	})
+})
+
+var _ = Describe("Rating a recipe by ID", func() {
+	It("Rates a recipe and gets the updated JSON object", func() {
+		server := newTestAPIServer(&Recipe{ID: 3, Name: "name3", PrepareTime: null.IntFrom(5), Difficulty: null.IntFrom(3), IsVegetarian: false, Rating: null.FloatFrom(3.0), RatedNum: null.IntFrom(1)})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/recipes/3/rating", bytes.NewBuffer([]byte(`
+		{
+			"rating":3
+		}
+		`)))
+		req.Header.Set("Content-Type", "application/json")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		jsonObj := newJSON(rr.Body.Bytes())
+		GinkgoT().Logf("[Rate A Recipe By ID] JSON Result: %s", jsonObj.pretty())
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(jsonObj.Get("rating").MustFloat64()).To(Equal(3.0))
+		Expect(jsonObj.Get("rated_num").MustInt()).To(Equal(1))
+	})
+	It("responses with [400 Bad Request] when getting an invalid parameter", func() {
+		server := newTestAPIServer(&Recipe{ID: 3, Name: "name3", PrepareTime: null.IntFrom(5), Difficulty: null.IntFromPtr(nil), IsVegetarian: false, Rating: null.FloatFrom(3.0), RatedNum: null.IntFrom(0)})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/recipes/ff/rating", bytes.NewBuffer([]byte(`
+		{
+			"rating":3
+		}
+		`)))
+
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusBadRequest))
+	})
+	It("responses with [404 Not Found] when the recipe is not found", func() {
+		server := newTestAPIServer(nil)
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/recipes/3/rating", bytes.NewBuffer([]byte(`
+		{
+			"rating":3
+		}
+		`)))
+
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusNotFound))
+	})
+	It("responses with [400 Bad Request] if the JSON argument is invalid", func() {
+		server := newTestAPIServer(&Recipe{ID: 3, Name: "name3", PrepareTime: null.IntFrom(5), Difficulty: null.IntFromPtr(nil), IsVegetarian: false, Rating: null.FloatFrom(3.0), RatedNum: null.IntFrom(0)})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/recipes/3/rating", bytes.NewBuffer([]byte(`
+		{
+			"rating":3,
+		}
+		`)))
+		req.Header.Set("Content-Type", "application/json")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusBadRequest))
+	})
+})
+
+var _ = Describe("Listing deleted recipes", func() {
+	It("lists the deleted recipes of the user", func() {
+		deletedAt := time.Date(2018, 9, 1, 12, 0, 0, 0, time.UTC)
+		server := newTestAPIServer([]*Recipe{
+			{ID: 3, Name: "name3", PrepareTime: null.IntFrom(5), Difficulty: null.IntFromPtr(nil), IsVegetarian: false, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0), DeletedAt: null.TimeFrom(deletedAt)},
+		})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/trash", nil)
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		jsonObj := newJSON(rr.Body.Bytes())
+		GinkgoT().Logf("[List Deleted Recipes] JSON Result: %s", jsonObj.pretty())
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(jsonObj.MustArray()).To(HaveLen(1))
+		Expect(jsonObj.GetIndex(0).Get("id").MustInt()).To(Equal(3))
+		Expect(jsonObj.GetIndex(0).Get("deleted_at").MustString()).To(Equal("2018-09-01T12:00:00Z"))
+	})
+	It("responses with [404 Not Found] when the user's credential is not valid", func() {
+		server := newTestAPIServer(nil)
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/trash", nil)
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusNotFound))
+	})
+})
+
+var _ = Describe("Restoring a recipe by ID", func() {
+	It("restores a recipe and gets the restored JSON object", func() {
+		server := newTestAPIServer(&Recipe{ID: 32, Name: "name3", PrepareTime: null.IntFrom(5), Difficulty: null.IntFromPtr(nil), IsVegetarian: false, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0)})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/recipes/32/restore", nil)
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		jsonObj := newJSON(rr.Body.Bytes())
+		GinkgoT().Logf("[Restore A Recipe By ID] JSON Result: %s", jsonObj.pretty())
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(jsonObj.Get("id").MustInt()).To(Equal(32))
+		Expect(jsonObj.Get("deleted_at").Interface()).To(BeNil())
+	})
+	It("responses with [400 Bad Request] when getting an invalid parameter", func() {
+		server := newTestAPIServer(&Recipe{ID: 32, Name: "name3", PrepareTime: null.IntFrom(5), Difficulty: null.IntFromPtr(nil), IsVegetarian: false, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0)})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/recipes/ff/restore", nil)
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusBadRequest))
+	})
+	It("responses with [404 Not Found] when the recipe is not deleted, not authorized or not found", func() {
+		server := newTestAPIServer(nil)
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/recipes/32/restore", nil)
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusNotFound))
+	})
+})
+
+var _ = Describe("Listing revisions of a recipe", func() {
+	It("lists the revisions of the recipe", func() {
+		createdAt := time.Date(2018, 9, 1, 12, 0, 0, 0, time.UTC)
+		server := newTestAPIServer([]*RecipeRevision{
+			{RecipeID: 32, Revision: 1, Author: null.StringFrom("foo"), CreatedAt: createdAt, Name: "name3", PrepareTime: null.IntFrom(5)},
+			{RecipeID: 32, Revision: 2, Author: null.StringFrom("foo"), CreatedAt: createdAt, Name: "name3", PrepareTime: null.IntFrom(10)},
+		})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/recipes/32/revisions", nil)
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		jsonObj := newJSON(rr.Body.Bytes())
+		GinkgoT().Logf("[List Revisions Of A Recipe] JSON Result: %s", jsonObj.pretty())
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(jsonObj.MustArray()).To(HaveLen(2))
+		Expect(jsonObj.GetIndex(1).Get("revision").MustInt()).To(Equal(2))
+		Expect(jsonObj.GetIndex(1).Get("author").MustString()).To(Equal("foo"))
+		Expect(jsonObj.GetIndex(1).Get("prepare_time").MustInt()).To(Equal(10))
+	})
+	It("responses with [404 Not Found] when the recipe is not authorized or not found", func() {
+		server := newTestAPIServer(nil)
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/recipes/32/revisions", nil)
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusNotFound))
+	})
+})
+
+var _ = Describe("Getting a revision of a recipe", func() {
+	It("gets the revision and returns the corresponding JSON object", func() {
+		server := newTestAPIServer(&RecipeRevision{RecipeID: 32, Revision: 2, Author: null.StringFrom("foo"), Name: "name3", PrepareTime: null.IntFrom(10)})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/recipes/32/revisions/2", nil)
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		jsonObj := newJSON(rr.Body.Bytes())
+		GinkgoT().Logf("[Get A Revision Of A Recipe] JSON Result: %s", jsonObj.pretty())
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(jsonObj.Get("recipe_id").MustInt()).To(Equal(32))
+		Expect(jsonObj.Get("revision").MustInt()).To(Equal(2))
+		Expect(jsonObj.Get("name").MustString()).To(Equal("name3"))
+	})
+	It("responses with [400 Bad Request] when getting an invalid parameter", func() {
+		server := newTestAPIServer(&RecipeRevision{RecipeID: 32, Revision: 2})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/recipes/32/revisions/ff", nil)
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusBadRequest))
+	})
+	It("responses with [404 Not Found] when the revision is not authorized or not found", func() {
+		server := newTestAPIServer(nil)
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/recipes/32/revisions/2", nil)
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusNotFound))
+	})
+})
+
+var _ = Describe("Comparing revisions of a recipe", func() {
+	It("returns the field-level differences between the revisions", func() {
+		server := newTestAPIServer(&RecipeRevision{RecipeID: 32, Revision: 2, Name: "name3"})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/recipes/32/diff?from=1&to=2", nil)
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		jsonObj := newJSON(rr.Body.Bytes())
+		GinkgoT().Logf("[Compare Revisions Of A Recipe] JSON Result: %s", jsonObj.pretty())
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(jsonObj.Get("recipe_id").MustInt()).To(Equal(32))
+		Expect(jsonObj.Get("changes").MustArray()).To(HaveLen(0))
+	})
+	It("responses with [400 Bad Request] when getting an invalid query", func() {
+		server := newTestAPIServer(&RecipeRevision{RecipeID: 32, Revision: 2})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/recipes/32/diff?from=ff&to=2", nil)
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusBadRequest))
+	})
+	It("responses with [404 Not Found] when the revisions are not authorized or not found", func() {
+		server := newTestAPIServer(nil)
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/recipes/32/diff?from=1&to=2", nil)
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusNotFound))
+	})
+})
+
+var _ = Describe("Restoring a revision of a recipe", func() {
+	It("restores the recipe and gets the restored JSON object", func() {
+		server := newTestAPIServer(&Recipe{ID: 32, Name: "name3", PrepareTime: null.IntFrom(5), Difficulty: null.IntFromPtr(nil), IsVegetarian: false, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0)})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/recipes/32/revisions/1/restore", nil)
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		jsonObj := newJSON(rr.Body.Bytes())
+		GinkgoT().Logf("[Restore A Revision Of A Recipe] JSON Result: %s", jsonObj.pretty())
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(jsonObj.Get("id").MustInt()).To(Equal(32))
+		Expect(jsonObj.Get("name").MustString()).To(Equal("name3"))
+	})
+	It("responses with [404 Not Found] when the revision is not authorized or not found", func() {
+		server := newTestAPIServer(nil)
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/recipes/32/revisions/1/restore", nil)
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusNotFound))
+	})
+})
+
+var _ = Describe("Checking preconditions of a recipe", func() {
+	It("returns the ETag of the recipe", func() {
+		server := newTestAPIServer(&Recipe{ID: 32, Name: "name3", PrepareTime: null.IntFrom(5), Difficulty: null.IntFromPtr(nil), IsVegetarian: false, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0), Version: 3})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/recipes/32", nil)
+
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(rr.Header().Get("ETag")).To(Equal(`"32-3"`))
+	})
+	It("responses with [304 Not Modified] when the ETag matches If-None-Match", func() {
+		server := newTestAPIServer(&Recipe{ID: 32, Name: "name3", PrepareTime: null.IntFrom(5), Difficulty: null.IntFromPtr(nil), IsVegetarian: false, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0), Version: 3})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/recipes/32", nil)
+		req.Header.Set("If-None-Match", `"32-3"`)
+
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusNotModified))
+		Expect(rr.Header().Get("ETag")).To(Equal(`"32-3"`))
+		Expect(rr.Body.Len()).To(Equal(0))
+	})
+	It("updates a recipe when the ETag matches If-Match", func() {
+		server := newTestAPIServer(&Recipe{ID: 32, Name: "name3", PrepareTime: null.IntFrom(5), Difficulty: null.IntFrom(3), IsVegetarian: false, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0), Version: 3})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/recipes/32", bytes.NewBuffer([]byte(`
+		{
+			"name":"name3",
+			"prepare_time":5,
+			"is_vegetarian":false
+		}
+		`)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "faketoken")
+		req.Header.Set("If-Match", `"32-3"`)
+
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(rr.Header().Get("ETag")).To(Equal(`"32-3"`))
+	})
+	It("responses with [412 Precondition Failed] when the ETag doesn't match If-Match", func() {
+		server := newTestAPIServer(&Recipe{ID: 32, Name: "name3", PrepareTime: null.IntFrom(5), Difficulty: null.IntFrom(3), IsVegetarian: false, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0), Version: 3})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/recipes/32", bytes.NewBuffer([]byte(`
+		{
+			"name":"name3",
+			"prepare_time":5,
+			"is_vegetarian":false
+		}
+		`)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "faketoken")
+		req.Header.Set("If-Match", `"32-2"`)
+
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusPreconditionFailed))
+
+		rr = httptest.NewRecorder()
+		req, _ = http.NewRequest("DELETE", "/recipes/32", nil)
+		req.Header.Set("Authorization", "faketoken")
+		req.Header.Set("If-Match", `"32-2"`)
+
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusPreconditionFailed))
+	})
+	It("responses with [428 Precondition Required] when If-Match is required but not set", func() {
+		server := newTestAPIServer(&Recipe{ID: 32, Name: "name3", PrepareTime: null.IntFrom(5), Difficulty: null.IntFrom(3), IsVegetarian: false, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0), Version: 3})
+		server.requireIfMatch = true
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("DELETE", "/recipes/32", nil)
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusPreconditionRequired))
+	})
+})
+
+var _ = Describe("Exporting recipes", func() {
+	It("streams every recipe as a line of JSON", func() {
+		server := newTestAPIServer([]*RecipeExport{
+			{Recipe: Recipe{ID: 1, Name: "name1", Rating: null.FloatFrom(4.5), RatedNum: null.IntFrom(2)}, Owner: null.StringFrom("foo")},
+			{Recipe: Recipe{ID: 11, Name: "name11", IsVegetarian: true, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0)}, Owner: null.StringFrom("bar")},
+		})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/export/recipes", nil)
+		req.Header.Set("Authorization", "faketoken")
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(rr.Header().Get("Content-Type")).To(Equal("application/x-ndjson"))
+		lines := bytes.Split(bytes.TrimSpace(rr.Body.Bytes()), []byte("\n"))
+		Expect(lines).To(HaveLen(2))
+		Expect(string(lines[0])).To(MatchJSON(`
+		{
+			"id":1,
+			"name":"name1",
+			"prepare_time":null,
+			"difficulty":null,
+			"is_vegetarian":false,
+			"rating":4.5,
+			"rated_num":2,
+			"publish_at":null,
+			"unpublish_at":null,
+			"deleted_at":null,
+			"owner":"foo"
+		}
+		`))
+		Expect(newJSON(lines[1]).Get("owner").MustString()).To(Equal("bar"))
+	})
+	It("responses with an empty body when there is no recipe", func() {
+		server := newTestAPIServer([]*RecipeExport{})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/export/recipes", nil)
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(rr.Header().Get("Content-Type")).To(Equal("application/x-ndjson"))
+		Expect(rr.Body.Len()).To(BeZero())
+	})
+	It("responses with [404 Not Found] when the user's credential is not valid", func() {
+		server := newTestAPIServer(nil)
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/export/recipes", nil)
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		Expect(rr.Code).To(Equal(http.StatusNotFound))
+	})
+})
+
+var _ = Describe("Importing recipes", func() {
+	body := `{"id":1,"name":"name1","is_vegetarian":false,"rating":4.5,"rated_num":2,"owner":"foo"}
+
+{"id":2,"name":"","is_vegetarian":false,"owner":"foo"}
+{"id":3,
+{"id":4,"name":"name4","is_vegetarian":true,"owner":"bar"}
+`
+	It("reports the outcome of every line", func() {
+		server := newTestAPIServer([]*RecipeImportResult{})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/import/recipes?strategy=rename&dry_run=true", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		req.Header.Set("Authorization", "faketoken")
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		jsonObj := newJSON(rr.Body.Bytes())
+		GinkgoT().Logf("[Import Recipes] JSON Result: %s", jsonObj.pretty())
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(jsonObj.Get("strategy").MustString()).To(Equal("rename"))
+		Expect(jsonObj.Get("dry_run").MustBool()).To(BeTrue())
+		Expect(jsonObj.Get("created").MustInt()).To(Equal(2))
+		Expect(jsonObj.Get("failed").MustInt()).To(Equal(2))
+		results := jsonObj.Get("results")
+		Expect(results.MustArray()).To(HaveLen(4))
+		Expect(results.GetIndex(0).Get("line").MustInt()).To(Equal(1))
+		Expect(results.GetIndex(0).Get("action").MustString()).To(Equal("created"))
+		Expect(results.GetIndex(1).Get("line").MustInt()).To(Equal(3))
+		Expect(results.GetIndex(1).Get("source_id").MustInt()).To(Equal(2))
+		Expect(results.GetIndex(1).Get("action").MustString()).To(Equal("failed"))
+		Expect(results.GetIndex(2).Get("line").MustInt()).To(Equal(4))
+		Expect(results.GetIndex(2).Get("action").MustString()).To(Equal("failed"))
+		Expect(results.GetIndex(3).Get("line").MustInt()).To(Equal(5))
+		Expect(results.GetIndex(3).Get("id").MustInt()).To(Equal(4))
+	})
+	It("skips the conflicting recipes by default", func() {
+		server := newTestAPIServer([]*RecipeImportResult{})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/import/recipes", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "faketoken")
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		jsonObj := newJSON(rr.Body.Bytes())
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(jsonObj.Get("strategy").MustString()).To(Equal("skip"))
+		Expect(jsonObj.Get("dry_run").MustBool()).To(BeFalse())
+	})
+	It("responses with [404 Not Found] when the user's credential is not valid", func() {
+		server := newTestAPIServer(nil)
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/import/recipes", bytes.NewBufferString(body))
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		Expect(rr.Code).To(Equal(http.StatusNotFound))
+	})
+	It("responses with [400 Bad Request] when getting an invalid query argument", func() {
+		server := newTestAPIServer([]*RecipeImportResult{})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/import/recipes?dry_run=maybe", bytes.NewBufferString(body))
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		Expect(rr.Code).To(Equal(http.StatusBadRequest))
+	})
+})
+
+var _ = Describe("Negotiating formats", func() {
+	It("gets a recipe as XML when asked for", func() {
+		server := newTestAPIServer(&Recipe{ID: 32, Name: "name3", PrepareTime: null.IntFrom(5), Difficulty: null.IntFromPtr(nil), IsVegetarian: false, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0)})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/recipes/32", nil)
+		req.Header.Set("Accept", "application/xml")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(rr.Header().Get("Content-Type")).To(Equal("application/xml; charset=utf-8"))
+		Expect(rr.Body.String()).To(ContainSubstring("<response><id>32</id><name>name3</name><prepare_time>5</prepare_time><difficulty></difficulty>"))
+	})
+	It("lists recipes as YAML when asked for", func() {
+		server := newTestAPIServer([]*Recipe{
+			{ID: 1, Name: "name1", PrepareTime: null.IntFrom(5), IsVegetarian: true, Rating: null.FloatFrom(4.5), RatedNum: null.IntFrom(2)},
+		})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/recipes", nil)
+		req.Header.Set("Accept", "application/json;q=0.5, application/x-yaml")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(rr.Header().Get("Content-Type")).To(Equal("application/x-yaml; charset=utf-8"))
+		Expect(rr.Body.String()).To(HavePrefix("- id: 1\n  name: name1\n  prepare_time: 5\n"))
+	})
+	It("adds a recipe from an XML document", func() {
+		server := newTestAPIServer(&Recipe{ID: 32, Name: "name3", PrepareTime: null.IntFrom(5), Difficulty: null.IntFromPtr(nil), IsVegetarian: false, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0)})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/recipes", bytes.NewBuffer([]byte(`
+		<recipe>
+			<name>name3</name>
+			<prepare_time>5</prepare_time>
+			<difficulty/>
+			<is_vegetarian>false</is_vegetarian>
+		</recipe>
+		`)))
+		req.Header.Set("Content-Type", "application/xml")
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		jsonObj := newJSON(rr.Body.Bytes())
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(jsonObj.Get("id").MustInt()).To(Equal(32))
+	})
+	It("adds a recipe from a YAML document", func() {
+		server := newTestAPIServer(&Recipe{ID: 32, Name: "name3", PrepareTime: null.IntFrom(5), Difficulty: null.IntFromPtr(nil), IsVegetarian: false, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0)})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/recipes", bytes.NewBuffer([]byte("name: name3\nprepare_time: 5\nis_vegetarian: false\n")))
+		req.Header.Set("Content-Type", "application/x-yaml")
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusOK))
+	})
+	It("responses with [406 Not Acceptable] when no format is acceptable", func() {
+		server := newTestAPIServer(&Recipe{ID: 32, Name: "name3", Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0)})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/recipes/32", nil)
+		req.Header.Set("Accept", "text/html")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusNotAcceptable))
+	})
+	It("responses with [415 Unsupported Media Type] when the request body has an unknown type", func() {
+		server := newTestAPIServer(&Recipe{ID: 32, Name: "name3", Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0)})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/recipes", bytes.NewBuffer([]byte(`name=name3`)))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusUnsupportedMediaType))
+	})
+})
+
+var _ = Describe("Describing the API", func() {
+	It("documents every route", func() {
+		server := newTestAPIServer(nil)
+		for _, route := range server.httpServer.routes() {
+			path, _ := unversionedPath(route.Path)
+			Expect(apiOperations).To(HaveKey(route.Method+" "+path), "the route %s %s is not documented in apiOperations", route.Method, route.Path)
+		}
+	})
+	It("serves the OpenAPI document", func() {
+		server := newTestAPIServer(nil)
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/openapi.json", nil)
+
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		jsonObj := newJSON(rr.Body.Bytes())
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(jsonObj.Get("openapi").MustString()).To(Equal("3.0.3"))
+		Expect(jsonObj.GetPath("paths", "/recipes/{id}", "get", "operationId").MustString()).To(Equal("getRecipesId"))
+		Expect(jsonObj.GetPath("paths", "/recipes:batch", "post", "security").MustArray()).To(HaveLen(1))
+		Expect(jsonObj.GetPath("components", "schemas", "PostRecipeArg", "required").MustStringArray()).To(Equal([]string{"name", "is_vegetarian"}))
+	})
+	It("serves the documentation page", func() {
+		server := newTestAPIServer(nil)
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/docs", nil)
+
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(rr.Header().Get("Content-Type")).To(Equal("text/html; charset=utf-8"))
+		Expect(rr.Body.String()).To(ContainSubstring(`url: "/openapi.json"`))
+	})
+})
+
+var _ = Describe("Validating requests against the API contract", func() {
+	It("responses with [400 Bad Request] and the violations when the request doesn't conform", func() {
+		server := newTestAPIServer(&Recipe{ID: 32, Name: "name3", Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0)})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/recipes", bytes.NewBuffer([]byte(`{"name":"name3","prepare_time":"5","is_vegetarian":false}`)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "faketoken")
+		req.Header.Set("X-Request-ID", "req-1")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		Expect(rr.Code).To(Equal(http.StatusBadRequest))
+		Expect(rr.Body.String()).To(MatchJSON(`{"errors":[{"in":"body","name":"prepare_time","error":"must be a number"}],"request_id":"req-1"}`))
+	})
+	It("responses with [400 Bad Request] when a paging header is out of range", func() {
+		server := newTestAPIServer([]*Recipe{})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/recipes", nil)
+		req.Header.Set("page-number", "-1")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		Expect(rr.Code).To(Equal(http.StatusBadRequest))
+		Expect(rr.Body.String()).To(MatchJSON(`{"errors":[{"in":"header","name":"page-number","error":"must be at least 1"}],"request_id":"` + rr.Header().Get("X-Request-ID") + `"}`))
+	})
+})
+
+var _ = Describe("Versioning the API", func() {
+	var server *apiServer
+	BeforeEach(func() {
+		server = newTestAPIServer(&Recipe{ID: 32, Name: "name3", PrepareTime: null.IntFrom(5), IsVegetarian: true, Rating: null.FloatFrom(4.5), RatedNum: null.IntFrom(2)})
+	})
+	It("gets a recipe in the shape of v2 under /v2", func() {
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/v2/recipes/32", nil)
+
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(rr.Header().Get("API-Version")).To(Equal("2"))
+		Expect(rr.Header().Get("Deprecation")).To(BeEmpty())
+		Expect(rr.Body.String()).To(MatchJSON(`
+		{
+			"id":32,
+			"name":"name3",
+			"prepare_time":5,
+			"difficulty":null,
+			"is_vegetarian":true,
+			"rating":{"average":4.5,"count":2},
+			"publish_at":null,
+			"unpublish_at":null,
+			"deleted_at":null
+		}
+		`))
+	})
+	It("marks v1 as deprecated", func() {
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/v1/recipes/32", nil)
+
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		jsonObj := newJSON(rr.Body.Bytes())
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(jsonObj.Get("rated_num").MustInt()).To(Equal(2))
+		Expect(rr.Header().Get("API-Version")).To(Equal("1"))
+		Expect(rr.Header().Get("Deprecation")).To(Equal("@1792368000"))
+		Expect(rr.Header().Get("Sunset")).To(Equal("Mon, 19 Apr 2027 00:00:00 GMT"))
+		Expect(rr.Header().Get("Link")).To(Equal(`</v2/recipes/32>; rel="successor-version"`))
+	})
+	It("negotiates the version by the API-Version header without a prefix", func() {
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/recipes/32", nil)
+		req.Header.Set("API-Version", "2")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		jsonObj := newJSON(rr.Body.Bytes())
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(rr.Header().Get("API-Version")).To(Equal("2"))
+		Expect(rr.Header()["Vary"]).To(ContainElement("API-Version"))
+		Expect(jsonObj.GetPath("rating", "count").MustInt()).To(Equal(2))
+	})
+	It("serves v1 without a prefix by default", func() {
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/recipes/32", nil)
+
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(rr.Header().Get("API-Version")).To(Equal("1"))
+		Expect(rr.Header().Get("Link")).To(Equal(`</v2/recipes/32>; rel="successor-version"`))
+	})
+	It("serves the static routes under a version prefix", func() {
+		server = newTestAPIServer([]*Recipe{
+			{ID: 32, Name: "name3", IsVegetarian: true, Rating: null.FloatFrom(4.5), RatedNum: null.IntFrom(2)},
+		})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/v2/recipes:batch", bytes.NewBuffer([]byte(`{"operations":[{"op":"create","recipe":{"name":"name3","is_vegetarian":true}}]}`)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		jsonObj := newJSON(rr.Body.Bytes())
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(jsonObj.Get("results").GetIndex(0).GetPath("recipe", "rating", "average").MustFloat64()).To(Equal(4.5))
+	})
+	It("responses with [400 Bad Request] when asking for an unknown version", func() {
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/recipes/32", nil)
+		req.Header.Set("API-Version", "3")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusBadRequest))
+	})
+})
+
+var _ = Describe("Querying recipes with GraphQL", func() {
+	It("resolves the selected fields with the owners looked up at once", func() {
+		server := newTestAPIServer([]*Recipe{
+			{ID: 1, Name: "name1", IsVegetarian: true, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0)},
+			{ID: 11, Name: "name11", PrepareTime: null.IntFrom(1), IsVegetarian: true, Rating: null.FloatFrom(4.5), RatedNum: null.IntFrom(2)},
+		})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/graphql", bytes.NewBuffer([]byte(`{"query":"{ recipes(filter: {isVegetarian: true}, pageSize: 2) { id name prepareTime rating { average count } owner { account } } }"}`)))
+		req.Header.Set("Content-Type", "application/json")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(rr.Body.String()).To(Equal(`{"data":{"recipes":[` +
+			`{"id":1,"name":"name1","prepareTime":null,"rating":{"average":0,"count":0},"owner":{"account":"foo"}},` +
+			`{"id":11,"name":"name11","prepareTime":1,"rating":{"average":4.5,"count":2},"owner":{"account":"foo"}}]}}`))
+		Expect(server.datastore.(*mockDatastore).ownerLookups).To(Equal(1))
+	})
+	It("resolves variables, aliases and fragments", func() {
+		server := newTestAPIServer(&Recipe{ID: 32, Name: "name3", IsVegetarian: false, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0), Version: 2})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/graphql", bytes.NewBuffer([]byte(`{
+			"query": "query Get($id: Int!, $withOwner: Boolean = false) { first: recipe(id: $id) { ...Fields } second: recipe(id: 33) { __typename id owner @include(if: $withOwner) { account } } } fragment Fields on Recipe { name version }",
+			"operationName": "Get",
+			"variables": {"id": 32}
+		}`)))
+		req.Header.Set("Content-Type", "application/json")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(rr.Body.String()).To(MatchJSON(`{"data":{"first":{"name":"name3","version":2},"second":{"__typename":"Recipe","id":32}}}`))
+		Expect(server.datastore.(*mockDatastore).ownerLookups).To(Equal(0))
+	})
+	It("responses with [400 Bad Request] and the errors when the query is not valid", func() {
+		server := newTestAPIServer(nil)
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/graphql", bytes.NewBuffer([]byte(`{"query":"{ recipe(id: 1) { id secret } }"}`)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Request-ID", "req-1")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		Expect(rr.Code).To(Equal(http.StatusBadRequest))
+		Expect(rr.Body.String()).To(MatchJSON(`{"errors":[{"message":"Cannot query field \"secret\" on type \"Recipe\".","locations":[{"line":1,"column":22}]}],"extensions":{"request_id":"req-1"}}`))
+	})
+	It("responses with [400 Bad Request] and the error when the query is malformed", func() {
+		server := newTestAPIServer(nil)
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/graphql", bytes.NewBuffer([]byte(`{"query":"{ recipe(id: 1) { id }"}`)))
+		req.Header.Set("Content-Type", "application/json")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		jsonObj := newJSON(rr.Body.Bytes())
+		Expect(rr.Code).To(Equal(http.StatusBadRequest))
+		Expect(jsonObj.Get("errors").GetIndex(0).Get("message").MustString()).To(Equal(`Syntax Error: Expected Name, found <EOF>.`))
+	})
+})
+
+var _ = Describe("Changing recipes with GraphQL", func() {
+	It("adds a recipe", func() {
+		server := newTestAPIServer(&Recipe{ID: 32, Name: "name3", IsVegetarian: true, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0)})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/graphql", bytes.NewBuffer([]byte(`{
+			"query": "mutation Add($input: RecipeInput!) { addRecipe(input: $input) { id name isVegetarian } }",
+			"variables": {"input": {"name": "name3", "isVegetarian": true}}
+		}`)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(rr.Body.String()).To(MatchJSON(`{"data":{"addRecipe":{"id":32,"name":"name3","isVegetarian":true}}}`))
+	})
+	It("reports the invalid input of a mutation", func() {
+		server := newTestAPIServer(&Recipe{ID: 32, Name: "name3", IsVegetarian: true, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0)})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/graphql", bytes.NewBuffer([]byte(`{"query":"mutation { rateRecipe(id: 32, rating: 6) { id } }"}`)))
+		req.Header.Set("Content-Type", "application/json")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		jsonObj := newJSON(rr.Body.Bytes())
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(jsonObj.GetPath("data", "rateRecipe").Interface()).To(BeNil())
+		Expect(jsonObj.Get("errors").GetIndex(0).Get("path").MustArray()).To(Equal([]interface{}{"rateRecipe"}))
+		Expect(jsonObj.Get("errors").GetIndex(0).GetPath("extensions", "code").MustString()).To(Equal("BAD_USER_INPUT"))
+	})
+	It("reports a recipe that doesn't exist or isn't of the user", func() {
+		server := newTestAPIServer(nil)
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/graphql", bytes.NewBuffer([]byte(`{"query":"mutation { deleteRecipe(id: 32) { id } }"}`)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(rr.Body.String()).To(MatchJSON(`{"data":{"deleteRecipe":null},"errors":[{"message":"not found","locations":[{"line":1,"column":12}],"path":["deleteRecipe"],"extensions":{"code":"NOT_FOUND"}}]}`))
+	})
+	It("reports a version that doesn't match", func() {
+		server := newTestAPIServer(&Recipe{ID: 32, Name: "name3", IsVegetarian: true, Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0), Version: 2})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/graphql", bytes.NewBuffer([]byte(`{"query":"mutation { updateRecipe(id: 32, version: 1, input: {name: \"name4\", isVegetarian: false}) { id } }"}`)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		jsonObj := newJSON(rr.Body.Bytes())
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(jsonObj.Get("errors").GetIndex(0).GetPath("extensions", "code").MustString()).To(Equal("PRECONDITION_FAILED"))
+	})
+})
+
+var _ = Describe("Registering a webhook", func() {
+	It("registers a webhook and gets its secret", func() {
+		server := newTestAPIServer(&Webhook{ID: 7, URL: "https://hooks.example.com/recipes", Events: pq.StringArray{"recipe.created"}})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/webhooks", bytes.NewBuffer([]byte(`{"url":"https://hooks.example.com/recipes","events":["recipe.created"]}`)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		jsonObj := newJSON(rr.Body.Bytes())
+		GinkgoT().Logf("[Register A Webhook] JSON Result: %s", jsonObj.pretty())
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(jsonObj.Get("id").MustInt()).To(Equal(7))
+		Expect(jsonObj.Get("events").MustArray()).To(Equal([]interface{}{"recipe.created"}))
+		Expect(jsonObj.Get("secret").MustString()).To(MatchRegexp("^[0-9a-f]{64}$"))
+	})
+	It("responses with [400 Bad Request] when the URL is not absolute", func() {
+		server := newTestAPIServer(&Webhook{ID: 7})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/webhooks", bytes.NewBuffer([]byte(`{"url":"/hook"}`)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "faketoken")
+		req.Header.Set("X-Request-ID", "req-1")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		Expect(rr.Code).To(Equal(http.StatusBadRequest))
+		Expect(rr.Body.String()).To(MatchJSON(`{"errors":[{"in":"body","name":"url","error":"must be an absolute URL"}],"request_id":"req-1"}`))
+	})
+	It("responses with [422 Unprocessable Entity] when the URL points to the network of the service", func() {
+		server := newTestAPIServer(&Webhook{ID: 7})
+		for _, hook := range []string{"http://127.0.0.1:9000/hook", "http://localhost:9000/hook", "http://169.254.169.254/latest/meta-data", "http://[fd00::1]/hook", "http://10.0.0.1/hook"} {
+			rr := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/webhooks", bytes.NewBuffer([]byte(`{"url":"`+hook+`"}`)))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "faketoken")
+			req.Header.Set("X-Request-ID", "req-1")
+
+			server.httpServer.router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusUnprocessableEntity), hook)
+			Expect(rr.Body.String()).To(MatchJSON(`{"errors":[{"in":"body","name":"url","error":"must not point to a loopback, private or link-local address"}],"request_id":"req-1"}`))
+		}
+
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/webhooks", bytes.NewBuffer([]byte(`{"url":"ftp://hooks.example.com/recipes"}`)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "faketoken")
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusUnprocessableEntity))
+		Expect(rr.Body.String()).To(ContainSubstring("must be an http or https URL"))
+	})
+	It("responses with [400 Bad Request] when subscribing to an unknown event", func() {
+		server := newTestAPIServer(&Webhook{ID: 7})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/webhooks", bytes.NewBuffer([]byte(`{"url":"https://hooks.example.com/recipes","events":["recipe.cooked"]}`)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		Expect(rr.Code).To(Equal(http.StatusBadRequest))
+	})
+	It("responses with [404 Not Found] when the user's credential is not valid", func() {
+		server := newTestAPIServer(nil)
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/webhooks", bytes.NewBuffer([]byte(`{"url":"https://hooks.example.com/recipes"}`)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusNotFound))
+	})
+})
+
+var _ = Describe("Listing webhooks", func() {
+	It("lists the webhooks of the user without their secrets", func() {
+		server := newTestAPIServer([]*Webhook{
+			{ID: 7, URL: "https://hooks.example.com/recipes", Events: pq.StringArray{}},
+		})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/webhooks", nil)
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		jsonObj := newJSON(rr.Body.Bytes())
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(jsonObj.MustArray()).To(HaveLen(1))
+		Expect(jsonObj.GetIndex(0).Get("url").MustString()).To(Equal("https://hooks.example.com/recipes"))
+		_, ok := jsonObj.GetIndex(0).CheckGet("secret")
+		Expect(ok).To(BeFalse())
+	})
+})
+
+var _ = Describe("Removing a webhook", func() {
+	It("removes a webhook of the user", func() {
+		server := newTestAPIServer(&Webhook{ID: 7, URL: "https://hooks.example.com/recipes", Events: pq.StringArray{}})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("DELETE", "/webhooks/7", nil)
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(newJSON(rr.Body.Bytes()).Get("id").MustInt()).To(Equal(7))
+	})
+	It("responses with [404 Not Found] when the webhook is not of the user", func() {
+		server := newTestAPIServer(nil)
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("DELETE", "/webhooks/7", nil)
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusNotFound))
+	})
+})
+
+var _ = Describe("Listing deliveries of a webhook", func() {
+	deliveries := []*WebhookDelivery{
+		{ID: 2, WebhookID: 7, Event: "recipe.updated", Payload: []byte(`{"type":"recipe.updated","recipe_id":32}`), Status: webhookDeliveryDead, Attempts: 8, ResponseStatus: null.IntFrom(500), Error: null.StringFrom("unexpected response status 500 Internal Server Error")},
+		{ID: 1, WebhookID: 7, Event: "recipe.created", Payload: []byte(`{"type":"recipe.created","recipe_id":32}`), Status: webhookDeliveryDelivered, Attempts: 1, ResponseStatus: null.IntFrom(200)},
+	}
+	It("lists the delivery log of the webhook", func() {
+		server := newTestAPIServer(deliveries)
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/webhooks/7/deliveries", nil)
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		jsonObj := newJSON(rr.Body.Bytes())
+		GinkgoT().Logf("[List Webhook Deliveries] JSON Result: %s", jsonObj.pretty())
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(jsonObj.MustArray()).To(HaveLen(2))
+		Expect(jsonObj.GetIndex(1).Get("payload").Get("type").MustString()).To(Equal("recipe.created"))
+	})
+	It("lists the dead letters of the webhook", func() {
+		server := newTestAPIServer(deliveries)
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/webhooks/7/deliveries?status=dead", nil)
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		jsonObj := newJSON(rr.Body.Bytes())
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(jsonObj.MustArray()).To(HaveLen(1))
+		Expect(jsonObj.GetIndex(0).Get("id").MustInt()).To(Equal(2))
+		Expect(jsonObj.GetIndex(0).Get("response_status").MustInt()).To(Equal(500))
+	})
+	It("responses with [400 Bad Request] when filtering by an unknown status", func() {
+		server := newTestAPIServer(deliveries)
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/webhooks/7/deliveries?status=lost", nil)
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusBadRequest))
+	})
+	It("responses with [404 Not Found] when the webhook is not of the user", func() {
+		server := newTestAPIServer(nil)
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/webhooks/7/deliveries", nil)
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusNotFound))
+	})
+})
+
+var _ = Describe("Redelivering a webhook delivery", func() {
+	It("queues the delivery again", func() {
+		server := newTestAPIServer(&WebhookDelivery{ID: 2, WebhookID: 7, Event: "recipe.updated", Payload: []byte(`{}`), Status: webhookDeliveryDead, Attempts: 8})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/webhooks/7/deliveries/2/redeliver", nil)
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		jsonObj := newJSON(rr.Body.Bytes())
+		Expect(rr.Code).To(Equal(http.StatusAccepted))
+		Expect(jsonObj.Get("status").MustString()).To(Equal(webhookDeliveryPending))
+		Expect(jsonObj.Get("attempts").MustInt()).To(Equal(0))
+		Expect(server.webhooks.wake).To(HaveLen(1))
+	})
+	It("responses with [404 Not Found] when the delivery is not of the user", func() {
+		server := newTestAPIServer(nil)
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/webhooks/7/deliveries/2/redeliver", nil)
+		req.Header.Set("Authorization", "faketoken")
+
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusNotFound))
+	})
+})
+
+func subscribersOf(bus *eventBus) int {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	return len(bus.subscribers)
+}
+
+var _ = Describe("Streaming recipe events", func() {
+	It("replays the missed events and streams the new ones of a recipe", func() {
+		server := newTestAPIServer(nil)
+		server.events.seq = 0
+		server.events.publish(&recipeEvent{ID: 1, Type: recipeCreatedEvent, RecipeID: 1})
+		server.events.publish(&recipeEvent{ID: 2, Type: recipeCreatedEvent, RecipeID: 2})
+		server.events.publish(&recipeEvent{ID: 3, Type: recipeRatedEvent, RecipeID: 1})
+
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/events?recipe_id=1", nil)
+		req.Header.Set("Last-Event-ID", "1")
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			server.httpServer.router.ServeHTTP(rr, req)
+		}()
+		Eventually(func() int { return subscribersOf(server.events) }).Should(Equal(1))
+		server.events.publish(&recipeEvent{ID: 4, Type: recipeDeletedEvent, RecipeID: 1})
+		server.events.publish(&recipeEvent{ID: 5, Type: recipeDeletedEvent, RecipeID: 2})
+		server.events.close()
+		Eventually(done).Should(BeClosed())
+
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(rr.Header().Get("Content-Type")).To(Equal(eventStreamContentType))
+		Expect(rr.Header().Get("Cache-Control")).To(Equal("no-cache"))
+		body := rr.Body.String()
+		Expect(body).To(HavePrefix("retry:3000\n\nid:3\nevent:recipe.rated\ndata:{\"id\":3,\"type\":\"recipe.rated\",\"recipe_id\":1,"))
+		Expect(body).To(ContainSubstring("\n\nid:4\nevent:recipe.deleted\ndata:{\"id\":4,"))
+		Expect(body).NotTo(ContainSubstring("id:2\n"))
+		Expect(body).NotTo(ContainSubstring("id:5\n"))
+	})
+	It("resumes after the last event the client got, even if an event with a smaller ID was published later", func() {
+		server := newTestAPIServer(nil)
+		server.events.seq = 0
+		server.events.publish(&recipeEvent{ID: 1, Type: recipeCreatedEvent, RecipeID: 1})
+		server.events.publish(&recipeEvent{ID: 3, Type: recipeCreatedEvent, RecipeID: 3})
+		// The event 2 was held back by the relay, and published after the event 3.
+		server.events.publish(&recipeEvent{ID: 2, Type: recipeCreatedEvent, RecipeID: 2})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/events", nil)
+		req.Header.Set("Last-Event-ID", "2")
+		req = req.WithContext(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			server.httpServer.router.ServeHTTP(rr, req)
+		}()
+		Eventually(func() int { return subscribersOf(server.events) }).Should(Equal(1))
+		cancel()
+		Eventually(done).Should(BeClosed())
+
+		Expect(rr.Body.String()).To(Equal("retry:3000\n\nid:3\nevent:recipe.created\ndata:{\"id\":2,\"type\":\"recipe.created\",\"recipe_id\":2,\"time\":\"0001-01-01T00:00:00Z\"}\n\n"))
+	})
+	It("sends heartbeats and leaves out the unpublished recipes and other owners", func() {
+		server := newTestAPIServer(nil)
+		server.events.seq = 0
+		server.eventHeartbeat = 10 * time.Millisecond
+		ctx, cancel := context.WithCancel(context.Background())
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/events?owner=foo", nil)
+		req = req.WithContext(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			server.httpServer.router.ServeHTTP(rr, req)
+		}()
+		Eventually(func() int { return subscribersOf(server.events) }).Should(Equal(1))
+		now := time.Now()
+		server.events.publish(&recipeEvent{ID: 1, Type: recipeCreatedEvent, RecipeID: 1, Time: now, Owner: "bar"})
+		server.events.publish(&recipeEvent{ID: 2, Type: recipeCreatedEvent, RecipeID: 2, Time: now, Owner: "foo",
+			Recipe: &Recipe{ID: 2, PublishAt: null.TimeFrom(now.Add(time.Hour))}})
+		server.events.publish(&recipeEvent{ID: 3, Type: recipeCreatedEvent, RecipeID: 3, Time: now, Owner: "foo",
+			Recipe: &Recipe{ID: 3, UnpublishAt: null.TimeFrom(now.Add(time.Hour))}})
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+		Eventually(done).Should(BeClosed())
+		Expect(subscribersOf(server.events)).To(Equal(0))
+
+		body := rr.Body.String()
+		Expect(body).To(ContainSubstring(":heartbeat\n\n"))
+		Expect(body).To(ContainSubstring("id:3\n"))
+		Expect(body).NotTo(ContainSubstring("id:1\n"))
+		Expect(body).NotTo(ContainSubstring("id:2\n"))
+	})
+	It("rejects a malformed Last-Event-ID or filter", func() {
+		server := newTestAPIServer(nil)
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/events", nil)
+		req.Header.Set("Last-Event-ID", "abc")
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusBadRequest))
+
+		rr = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/events?recipe_id=0", nil)
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusBadRequest))
+
+		rr = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/events", nil)
+		req.Header.Set("Accept", "application/json")
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusNotAcceptable))
+	})
 })
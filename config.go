@@ -1,14 +1,27 @@
 package main
 
 import (
+	"time"
+
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
 var (
-	defaultHost = ""
-	defaultPort = "8080"
-	defaultDSN  = ""
+	defaultHost     = ""
+	defaultPort     = "8080"
+	defaultGRPCPort = "9090"
+	defaultDSN      = ""
+
+	defaultTrashRetention  = 30 * 24 * time.Hour
+	defaultIdempotencyTTL  = 24 * time.Hour
+	defaultWebhookAttempts = 8
+	defaultEventSinks      = eventSinkWebhook + "," + eventSinkBus
+	defaultEventFile       = "events.ndjson"
+	defaultLogLevel        = logLevelInfo.String()
+	defaultTraceExporter   = traceExporterNone
+	defaultV1Deprecation   = time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	defaultV1Sunset        = time.Date(2027, 4, 19, 0, 0, 0, 0, time.UTC)
 )
 
 const noDefaultValue = ""
@@ -26,7 +39,22 @@ func newCommandLineConfig() *viper.Viper {
 func defineFlags() {
 	pflag.String("host", noDefaultValue, "host that the http service binds to")
 	pflag.String("port", noDefaultValue, "port that the http service listens to")
+	pflag.String("grpc-port", noDefaultValue, "port that the grpc service listens to")
 	pflag.String("dsn", noDefaultValue, "postgreSQL database connection string")
+	pflag.String("error-report-url", noDefaultValue, "URL that the panics of the requests are POSTed to as JSON")
+	pflag.String("trash-retention", noDefaultValue, "how long deleted recipes are kept before being purged")
+	pflag.Bool("require-if-match", false, "reject writes without an If-Match header")
+	pflag.String("idempotency-ttl", noDefaultValue, "how long responses to requests with an Idempotency-Key header are replayed")
+	pflag.String("webhook-attempts", noDefaultValue, "how many times a webhook delivery is attempted before it is dead")
+	pflag.String("event-sinks", noDefaultValue, "comma-separated sinks that the recipe events are published to: stdout, file, webhook and bus")
+	pflag.String("event-file", noDefaultValue, "file that the file sink appends the recipe events to")
+	pflag.Bool("dev-mode", false, "log responses that don't conform to the OpenAPI document")
+	pflag.String("log-level", noDefaultValue, "lowest level of the messages that are logged: debug, info, warn or error")
+	pflag.String("trace-exporter", noDefaultValue, "where the traces are exported to: none, stdout or otlp")
+	pflag.String("otlp-endpoint", noDefaultValue, "URL of the OTLP/HTTP collector that the otlp trace exporter POSTs to")
+	pflag.String("live-allowed-origins", noDefaultValue, "comma-separated origins that the live cooking sessions accept besides the service's own")
+	pflag.String("v1-deprecation", noDefaultValue, "date that v1 of the api was deprecated at, like 2026-10-19")
+	pflag.String("v1-sunset", noDefaultValue, "date that v1 of the api is removed at, like 2027-04-19")
 }
 
 func loadCommandLineFlag(v *viper.Viper, flagSet *pflag.FlagSet) {
@@ -53,19 +81,90 @@ func loadEnvironmentVariables(v *viper.Viper) {
 			panic(err)
 		}
 	}
+	if err := v.BindEnv("error-report-url", "ERROR_REPORT_URL"); err != nil {
+		panic(err)
+	}
+	if err := v.BindEnv("grpc-port", "GRPC_PORT"); err != nil {
+		panic(err)
+	}
+	if err := v.BindEnv("trash-retention", "TRASH_RETENTION"); err != nil {
+		panic(err)
+	}
+	if err := v.BindEnv("require-if-match", "REQUIRE_IF_MATCH"); err != nil {
+		panic(err)
+	}
+	if err := v.BindEnv("idempotency-ttl", "IDEMPOTENCY_TTL"); err != nil {
+		panic(err)
+	}
+	if err := v.BindEnv("webhook-attempts", "WEBHOOK_ATTEMPTS"); err != nil {
+		panic(err)
+	}
+	if err := v.BindEnv("event-sinks", "EVENT_SINKS"); err != nil {
+		panic(err)
+	}
+	if err := v.BindEnv("event-file", "EVENT_FILE"); err != nil {
+		panic(err)
+	}
+	if err := v.BindEnv("dev-mode", "DEV_MODE"); err != nil {
+		panic(err)
+	}
+	if err := v.BindEnv("log-level", "LOG_LEVEL"); err != nil {
+		panic(err)
+	}
+	if err := v.BindEnv("trace-exporter", "TRACE_EXPORTER"); err != nil {
+		panic(err)
+	}
+	if err := v.BindEnv("otlp-endpoint", "OTLP_ENDPOINT"); err != nil {
+		panic(err)
+	}
+	if err := v.BindEnv("live-allowed-origins", "LIVE_ALLOWED_ORIGINS"); err != nil {
+		panic(err)
+	}
+	if err := v.BindEnv("v1-deprecation", "V1_DEPRECATION"); err != nil {
+		panic(err)
+	}
+	if err := v.BindEnv("v1-sunset", "V1_SUNSET"); err != nil {
+		panic(err)
+	}
 }
 
 type applicationConfig struct {
-	host string
-	port string
-	dsn  string
+	host            string
+	port            string
+	grpcPort        string
+	dsn             string
+	trashRetention  time.Duration
+	requireIfMatch  bool
+	idempotencyTTL  time.Duration
+	webhookAttempts int
+	eventSinks      string
+	eventFile       string
+	devMode         bool
+	logLevel        string
+	traceExporter   string
+	otlpEndpoint    string
+	liveOrigins     string
+	v1Deprecation   time.Time
+	v1Sunset        time.Time
+	errorReportURL  string
 }
 
 func newApplicationConfig() *applicationConfig {
 	return &applicationConfig{
-		host: defaultHost,
-		port: defaultPort,
-		dsn:  defaultDSN,
+		host:            defaultHost,
+		port:            defaultPort,
+		grpcPort:        defaultGRPCPort,
+		dsn:             defaultDSN,
+		trashRetention:  defaultTrashRetention,
+		idempotencyTTL:  defaultIdempotencyTTL,
+		webhookAttempts: defaultWebhookAttempts,
+		eventSinks:      defaultEventSinks,
+		eventFile:       defaultEventFile,
+		logLevel:        defaultLogLevel,
+		traceExporter:   defaultTraceExporter,
+		otlpEndpoint:    defaultOTLPEndpoint,
+		v1Deprecation:   defaultV1Deprecation,
+		v1Sunset:        defaultV1Sunset,
 	}
 }
 
@@ -76,7 +175,52 @@ func (c *applicationConfig) bind(v *viper.Viper) {
 	if v.IsSet("port") {
 		c.port = v.GetString("port")
 	}
+	if v.IsSet("grpc-port") {
+		c.grpcPort = v.GetString("grpc-port")
+	}
 	if v.IsSet("dsn") {
 		c.dsn = v.GetString("dsn")
 	}
+	if v.IsSet("trash-retention") {
+		c.trashRetention = v.GetDuration("trash-retention")
+	}
+	if v.IsSet("require-if-match") {
+		c.requireIfMatch = v.GetBool("require-if-match")
+	}
+	if v.IsSet("idempotency-ttl") {
+		c.idempotencyTTL = v.GetDuration("idempotency-ttl")
+	}
+	if v.IsSet("webhook-attempts") {
+		c.webhookAttempts = v.GetInt("webhook-attempts")
+	}
+	if v.IsSet("event-sinks") {
+		c.eventSinks = v.GetString("event-sinks")
+	}
+	if v.IsSet("event-file") {
+		c.eventFile = v.GetString("event-file")
+	}
+	if v.IsSet("dev-mode") {
+		c.devMode = v.GetBool("dev-mode")
+	}
+	if v.IsSet("log-level") {
+		c.logLevel = v.GetString("log-level")
+	}
+	if v.IsSet("trace-exporter") {
+		c.traceExporter = v.GetString("trace-exporter")
+	}
+	if v.IsSet("otlp-endpoint") {
+		c.otlpEndpoint = v.GetString("otlp-endpoint")
+	}
+	if v.IsSet("live-allowed-origins") {
+		c.liveOrigins = v.GetString("live-allowed-origins")
+	}
+	if v.IsSet("v1-deprecation") {
+		c.v1Deprecation = v.GetTime("v1-deprecation")
+	}
+	if v.IsSet("v1-sunset") {
+		c.v1Sunset = v.GetTime("v1-sunset")
+	}
+	if v.IsSet("error-report-url") {
+		c.errorReportURL = v.GetString("error-report-url")
+	}
 }
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bytes"
+	stdjson "encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxCheckedResponseSize is how long a response can be to be checked
+// against the contract in development mode.
+const maxCheckedResponseSize = 1 << 20
+
+type ContractViolation struct {
+	In    string `json:"in"`
+	Name  string `json:"name,omitempty"`
+	Error string `json:"error"`
+}
+
+type ContractViolationReport struct {
+	Errors    []*ContractViolation `json:"errors"`
+	RequestID string               `json:"request_id,omitempty"`
+}
+
+type contractRoute struct {
+	method    string
+	pattern   *regexp.Regexp
+	params    []string
+	operation *openAPIOperation
+}
+
+// apiContract checks requests and responses against an OpenAPI document.
+type apiContract struct {
+	doc    *openAPIDocument
+	routes []*contractRoute
+}
+
+func newAPIContract(doc *openAPIDocument) *apiContract {
+	a := &apiContract{doc: doc}
+	for path, operations := range doc.Paths {
+		params := make([]string, 0)
+		pattern := regexp.MustCompile(`\\\{(\w+)\\\}`).ReplaceAllStringFunc(regexp.QuoteMeta(path), func(m string) string {
+			params = append(params, strings.Trim(m, `\{}`))
+			return "([^/]+)"
+		})
+		for method, op := range operations {
+			a.routes = append(a.routes, &contractRoute{
+				method:    strings.ToUpper(method),
+				pattern:   regexp.MustCompile("^" + pattern + "$"),
+				params:    params,
+				operation: op,
+			})
+		}
+	}
+	// A static path like "/recipes/import" wins over "/recipes/{id}".
+	sort.Slice(a.routes, func(i, j int) bool {
+		return len(a.routes[i].params) < len(a.routes[j].params)
+	})
+	return a
+}
+
+func (a *apiContract) find(method, path string) (*openAPIOperation, map[string]string) {
+	for _, route := range a.routes {
+		if route.method != method {
+			continue
+		}
+		m := route.pattern.FindStringSubmatch(path)
+		if m == nil {
+			continue
+		}
+		params := make(map[string]string)
+		for i, name := range route.params {
+			params[name] = m[i+1]
+		}
+		return route.operation, params
+	}
+	return nil, nil
+}
+
+func (a *apiContract) schema(s *openAPISchema) *openAPISchema {
+	for s != nil && s.Ref != "" {
+		s = a.doc.Components.Schemas[strings.TrimPrefix(s.Ref, openAPISchemaRef)]
+	}
+	return s
+}
+
+func (a *apiContract) validateRequest(op *openAPIOperation, pathParams map[string]string, r *http.Request, body []byte) []*ContractViolation {
+	res := make([]*ContractViolation, 0)
+	query := r.URL.Query()
+	for _, p := range op.Parameters {
+		var value string
+		var present bool
+		switch p.In {
+		case "path":
+			value, present = pathParams[p.Name]
+		case "query":
+			value, present = query.Get(p.Name), len(query[p.Name]) > 0
+		case "header":
+			value = r.Header.Get(p.Name)
+			present = value != ""
+		}
+		if !present {
+			if p.Required {
+				res = append(res, &ContractViolation{In: p.In, Name: p.Name, Error: "is required"})
+			}
+			continue
+		}
+		v, err := parseParameter(a.schema(p.Schema), value)
+		if err != nil {
+			res = append(res, &ContractViolation{In: p.In, Name: p.Name, Error: err.Error()})
+			continue
+		}
+		res = append(res, a.validateValue(p.In, p.Name, p.Schema, v)...)
+	}
+
+	if op.RequestBody == nil || !isJSONMediaType(r.Header.Get("Content-Type")) {
+		return res
+	}
+	media, ok := op.RequestBody.Content[gin.MIMEJSON]
+	if !ok {
+		return res
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		if op.RequestBody.Required {
+			res = append(res, &ContractViolation{In: "body", Error: "is required"})
+		}
+		return res
+	}
+	v, err := decodeJSONValue(body)
+	if err != nil {
+		return append(res, &ContractViolation{In: "body", Error: "is not valid JSON"})
+	}
+	return append(res, a.validateValue("body", "", media.Schema, v)...)
+}
+
+func (a *apiContract) validateResponse(op *openAPIOperation, status int, contentType string, body []byte) []*ContractViolation {
+	res := make([]*ContractViolation, 0)
+	response, ok := op.Responses[strconv.Itoa(status)]
+	if !ok {
+		response, ok = op.Responses["default"]
+	}
+	if !ok {
+		return append(res, &ContractViolation{In: "status", Error: fmt.Sprintf("%d is not documented", status)})
+	}
+	if len(response.Content) == 0 || len(body) == 0 {
+		return res
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	media, ok := response.Content[mediaType]
+	if !ok {
+		return append(res, &ContractViolation{In: "header", Name: "Content-Type", Error: fmt.Sprintf("%q is not documented", mediaType)})
+	}
+	if mediaType != gin.MIMEJSON {
+		return res
+	}
+	v, err := decodeJSONValue(body)
+	if err != nil {
+		return append(res, &ContractViolation{In: "body", Error: "is not valid JSON"})
+	}
+	return a.validateValue("body", "", media.Schema, v)
+}
+
+// validateValue checks a value decoded from JSON with numbers as
+// json.Number against a schema, naming the violations after the path to the
+// offending value, like "operations[0].recipe.name".
+func (a *apiContract) validateValue(in, name string, schema *openAPISchema, v interface{}) []*ContractViolation {
+	schema = a.schema(schema)
+	res := make([]*ContractViolation, 0)
+	if schema == nil || schema.SkipValidation {
+		return res
+	}
+	violate := func(format string, args ...interface{}) []*ContractViolation {
+		return append(res, &ContractViolation{In: in, Name: name, Error: fmt.Sprintf(format, args...)})
+	}
+	for _, s := range schema.AllOf {
+		res = append(res, a.validateValue(in, name, s, v)...)
+	}
+	if v == nil {
+		if schema.Type != "" && !schema.Nullable {
+			return violate("must not be null")
+		}
+		return res
+	}
+
+	switch schema.Type {
+	case "string":
+		s, ok := v.(string)
+		if !ok {
+			return violate("must be a string")
+		}
+		switch schema.Format {
+		case "date-time":
+			if _, err := time.Parse(time.RFC3339, s); err != nil {
+				return violate("must be an RFC 3339 date-time")
+			}
+		case "uri":
+			if u, err := url.Parse(s); err != nil || !u.IsAbs() || u.Host == "" {
+				return violate("must be an absolute URL")
+			}
+		}
+		if n := utf8.RuneCountInString(s); schema.MinLength != nil && n < *schema.MinLength {
+			return violate("must be at least %d characters long", *schema.MinLength)
+		} else if schema.MaxLength != nil && n > *schema.MaxLength {
+			return violate("must be at most %d characters long", *schema.MaxLength)
+		}
+		if len(schema.Enum) > 0 && !containsString(schema.Enum, s) {
+			return violate("must be one of %s", strings.Join(schema.Enum, ", "))
+		}
+	case "integer", "number":
+		n, ok := v.(stdjson.Number)
+		if !ok {
+			return violate("must be a number")
+		}
+		f, err := n.Float64()
+		if err != nil {
+			return violate("must be a number")
+		}
+		if _, err := n.Int64(); err != nil && schema.Type == "integer" {
+			return violate("must be an integer")
+		}
+		if msg := boundViolation(f, schema); msg != "" {
+			return violate("%s", msg)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return violate("must be a boolean")
+		}
+	case "array":
+		items, ok := v.([]interface{})
+		if !ok {
+			return violate("must be an array")
+		}
+		if schema.MinItems != nil && len(items) < *schema.MinItems {
+			return violate("must have at least %d items", *schema.MinItems)
+		}
+		if schema.MaxItems != nil && len(items) > *schema.MaxItems {
+			return violate("must have at most %d items", *schema.MaxItems)
+		}
+		for i, item := range items {
+			res = append(res, a.validateValue(in, fmt.Sprintf("%s[%d]", name, i), schema.Items, item)...)
+		}
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return violate("must be an object")
+		}
+		for _, key := range schema.Required {
+			if _, ok := obj[key]; !ok {
+				res = append(res, &ContractViolation{In: in, Name: joinContractName(name, key), Error: "is required"})
+			}
+		}
+		keys := make([]string, 0, len(schema.Properties))
+		for key := range schema.Properties {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if value, ok := obj[key]; ok {
+				res = append(res, a.validateValue(in, joinContractName(name, key), schema.Properties[key], value)...)
+			}
+		}
+	}
+	return res
+}
+
+func boundViolation(f float64, schema *openAPISchema) string {
+	if m := schema.Minimum; m != nil {
+		if schema.ExclusiveMinimum && f <= *m {
+			return fmt.Sprintf("must be greater than %v", *m)
+		}
+		if f < *m {
+			return fmt.Sprintf("must be at least %v", *m)
+		}
+	}
+	if m := schema.Maximum; m != nil {
+		if schema.ExclusiveMaximum && f >= *m {
+			return fmt.Sprintf("must be less than %v", *m)
+		}
+		if f > *m {
+			return fmt.Sprintf("must be at most %v", *m)
+		}
+	}
+	return ""
+}
+
+// parseParameter converts the text of a path, query or header parameter to
+// the value JSON would have, so that it can be checked like a body.
+func parseParameter(schema *openAPISchema, s string) (interface{}, error) {
+	if schema == nil {
+		return s, nil
+	}
+	switch schema.Type {
+	case "integer":
+		if _, err := strconv.ParseInt(s, 10, 64); err != nil {
+			return nil, fmt.Errorf("must be an integer")
+		}
+		return stdjson.Number(s), nil
+	case "number":
+		if _, err := strconv.ParseFloat(s, 64); err != nil {
+			return nil, fmt.Errorf("must be a number")
+		}
+		return stdjson.Number(s), nil
+	case "boolean":
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("must be a boolean")
+		}
+		return b, nil
+	}
+	return s, nil
+}
+
+func decodeJSONValue(doc []byte) (interface{}, error) {
+	var v interface{}
+	decoder := stdjson.NewDecoder(bytes.NewReader(doc))
+	decoder.UseNumber()
+	err := decoder.Decode(&v)
+	return v, err
+}
+
+func isJSONMediaType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == gin.MIMEJSON
+}
+
+func joinContractName(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// validateContract rejects requests that don't conform to the OpenAPI
+// document with 400 and the violations, and JSON bodies longer than
+// maxRequestBodySize with 413. In development mode, it also logs the
+// responses up to maxCheckedResponseSize that don't conform to it.
+func (s *apiServer) validateContract(c *gin.Context) {
+	if s.contract == nil {
+		c.Next()
+		return
+	}
+	op, pathParams := s.contract.find(c.Request.Method, c.Request.URL.Path)
+	if op == nil {
+		c.Next()
+		return
+	}
+
+	var body []byte
+	if op.RequestBody != nil && c.Request.Body != nil && isJSONMediaType(c.GetHeader("Content-Type")) {
+		limitRequestBody(c)
+		var err error
+		if body, err = ioutil.ReadAll(c.Request.Body); err != nil {
+			c.AbortWithStatus(bindErrorStatus(err))
+			return
+		}
+		c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	if violations := s.contract.validateRequest(op, pathParams, c.Request, body); len(violations) > 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ContractViolationReport{Errors: violations, RequestID: requestID(c)})
+		return
+	}
+	if !s.devMode || streamsEvents(op) {
+		c.Next()
+		return
+	}
+
+	w := &bodyRecordingWriter{ResponseWriter: c.Writer, limit: maxCheckedResponseSize}
+	c.Writer = w
+	c.Next()
+	if w.truncated {
+		requestLogger(c).debug("response too large to check against the contract", logFields{"method": c.Request.Method, "path": c.Request.URL.Path})
+		return
+	}
+	for _, v := range s.contract.validateResponse(op, w.Status(), w.Header().Get("Content-Type"), w.body.Bytes()) {
+		requestLogger(c).warn("response contract violation", logFields{"method": c.Request.Method, "path": c.Request.URL.Path, "in": v.In, "name": v.Name, "error": v.Error})
+	}
+}
+
+// streamsEvents tells if an operation responds with a stream of events,
+// which never ends and so cannot be recorded to check its response.
+func streamsEvents(op *openAPIOperation) bool {
+	for _, response := range op.Responses {
+		if _, ok := response.Content[eventStreamContentType]; ok {
+			return true
+		}
+	}
+	return false
+}
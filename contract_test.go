@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestAPIContract() *apiContract {
+	return newAPIContract(newOpenAPIDocument(gin.RoutesInfo{
+		{Method: "GET", Path: "/recipes"},
+		{Method: "POST", Path: "/recipes:batch"},
+		{Method: "POST", Path: "/recipes/import"},
+		{Method: "PUT", Path: "/recipes/:id"},
+		{Method: "GET", Path: "/recipes/:id/diff"},
+	}))
+}
+
+func TestAPIContractFind(t *testing.T) {
+	a := newTestAPIContract()
+	op, params := a.find("PUT", "/recipes/32")
+	assert.Equal(t, "putRecipesId", op.OperationID)
+	assert.Equal(t, map[string]string{"id": "32"}, params)
+
+	op, _ = a.find("POST", "/recipes/import")
+	assert.Equal(t, "postRecipesImport", op.OperationID)
+
+	op, _ = a.find("GET", "/recipes/32/revisions")
+	assert.Nil(t, op)
+}
+
+func TestAPIContractValidateRequest(t *testing.T) {
+	a := newTestAPIContract()
+	op, params := a.find("PUT", "/recipes/ff")
+	r, _ := http.NewRequest("PUT", "/recipes/ff", nil)
+	r.Header.Set("Content-Type", "application/json")
+	assert.Equal(t, []*ContractViolation{
+		{In: "path", Name: "id", Error: "must be an integer"},
+		{In: "body", Error: "is required"},
+	}, a.validateRequest(op, params, r, nil))
+
+	op, params = a.find("PUT", "/recipes/32")
+	assert.Equal(t, []*ContractViolation{
+		{In: "body", Name: "is_vegetarian", Error: "is required"},
+		{In: "body", Name: "difficulty", Error: "must be at most 3"},
+		{In: "body", Name: "name", Error: "must be at least 1 characters long"},
+		{In: "body", Name: "prepare_time", Error: "must be greater than 0"},
+		{In: "body", Name: "publish_at", Error: "must be an RFC 3339 date-time"},
+	}, a.validateRequest(op, params, r, []byte(`{"name":"","prepare_time":0,"difficulty":4,"publish_at":"today","unpublish_at":null}`)))
+	assert.Empty(t, a.validateRequest(op, params, r, []byte(`{"name":"name1","is_vegetarian":false,"difficulty":null}`)))
+
+	r.Header.Set("Content-Type", "application/xml")
+	assert.Empty(t, a.validateRequest(op, params, r, []byte(`<recipe/>`)))
+
+	op, params = a.find("GET", "/recipes/32/diff")
+	r, _ = http.NewRequest("GET", "/recipes/32/diff?from=0", nil)
+	assert.Equal(t, []*ContractViolation{
+		{In: "query", Name: "from", Error: "must be at least 1"},
+		{In: "query", Name: "to", Error: "is required"},
+	}, a.validateRequest(op, params, r, nil))
+
+	op, params = a.find("GET", "/recipes")
+	r, _ = http.NewRequest("GET", "/recipes?prepare_time_from=ten", nil)
+	r.Header.Set("page-size", "0")
+	assert.Equal(t, []*ContractViolation{
+		{In: "header", Name: "page-size", Error: "must be at least 1"},
+		{In: "query", Name: "prepare_time_from", Error: "must be an integer"},
+	}, a.validateRequest(op, params, r, nil))
+}
+
+func TestAPIContractValidateBatchRequest(t *testing.T) {
+	a := newTestAPIContract()
+	op, params := a.find("POST", "/recipes:batch")
+	r, _ := http.NewRequest("POST", "/recipes:batch", nil)
+	assert.Empty(t, a.validateRequest(op, params, r, []byte(`{"operations":[{"op":"create","recipe":{"name":""}}]}`)))
+	assert.Equal(t, []*ContractViolation{
+		{In: "body", Name: "mode", Error: "must be one of all_or_nothing, best_effort"},
+		{In: "body", Name: "operations[0].id", Error: "must be an integer"},
+	}, a.validateRequest(op, params, r, []byte(`{"mode":"some","operations":[{"op":"delete","id":1.5}]}`)))
+	assert.Equal(t, []*ContractViolation{
+		{In: "body", Name: "operations", Error: "must have at least 1 items"},
+	}, a.validateRequest(op, params, r, []byte(`{"operations":[]}`)))
+	assert.Equal(t, []*ContractViolation{
+		{In: "body", Error: "is not valid JSON"},
+	}, a.validateRequest(op, params, r, []byte(`{"operations":[}`)))
+}
+
+func TestAPIContractValidateResponse(t *testing.T) {
+	a := newTestAPIContract()
+	op, _ := a.find("PUT", "/recipes/32")
+	assert.Empty(t, a.validateResponse(op, http.StatusOK, "application/json; charset=utf-8", []byte(`{"id":32,"name":"name3","prepare_time":null}`)))
+	assert.Empty(t, a.validateResponse(op, http.StatusNotFound, "", nil))
+	assert.Equal(t, []*ContractViolation{
+		{In: "body", Name: "id", Error: "must be a number"},
+		{In: "body", Name: "is_vegetarian", Error: "must be a boolean"},
+	}, a.validateResponse(op, http.StatusOK, "application/json", []byte(`{"id":"32","is_vegetarian":1}`)))
+	assert.Equal(t, []*ContractViolation{
+		{In: "status", Error: "202 is not documented"},
+	}, a.validateResponse(op, http.StatusAccepted, "", nil))
+	assert.Equal(t, []*ContractViolation{
+		{In: "header", Name: "Content-Type", Error: `"text/plain" is not documented`},
+	}, a.validateResponse(op, http.StatusOK, "text/plain", []byte(strings.Repeat("x", 3))))
+}
+
+func TestValidateContractLimitsBodies(t *testing.T) {
+	server := newTestAPIServer(nil)
+	body := `{"name":"` + strings.Repeat("a", maxRequestBodySize) + `","is_vegetarian":false}`
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/recipes", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "faketoken")
+	server.httpServer.router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	w := &bodyRecordingWriter{ResponseWriter: c.Writer, limit: 4}
+	w.WriteString("abc")
+	assert.Equal(t, "abc", w.body.String())
+	assert.False(t, w.truncated)
+	w.Write([]byte("de"))
+	w.WriteString("f")
+	assert.Empty(t, w.body.String())
+	assert.True(t, w.truncated)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	validator "gopkg.in/go-playground/validator.v9"
+	null "gopkg.in/guregu/null.v3"
+)
+
+const csvContentType = "text/csv"
+
+var recipeCSVColumns = []string{
+	"id", "name", "prepare_time", "difficulty", "is_vegetarian", "rating", "rated_num", "publish_at", "unpublish_at",
+}
+
+var recipeCSVSetters = map[string]func(*PostRecipeArg, string) error{
+	"name": func(arg *PostRecipeArg, v string) error {
+		arg.Name = null.NewString(v, v != "")
+		return nil
+	},
+	"prepare_time": func(arg *PostRecipeArg, v string) (err error) {
+		arg.PrepareTime, err = parseCSVInt(v)
+		return
+	},
+	"difficulty": func(arg *PostRecipeArg, v string) (err error) {
+		arg.Difficulty, err = parseCSVInt(v)
+		return
+	},
+	"is_vegetarian": func(arg *PostRecipeArg, v string) error {
+		if v == "" {
+			arg.IsVegetarian = null.Bool{}
+			return nil
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", v)
+		}
+		arg.IsVegetarian = null.BoolFrom(b)
+		return nil
+	},
+	"publish_at": func(arg *PostRecipeArg, v string) (err error) {
+		arg.PublishAt, err = parseCSVTime(v)
+		return
+	},
+	"unpublish_at": func(arg *PostRecipeArg, v string) (err error) {
+		arg.UnpublishAt, err = parseCSVTime(v)
+		return
+	},
+}
+
+type RecipeCSVRowError struct {
+	Row    int    `json:"row"`
+	Column string `json:"column,omitempty"`
+	Error  string `json:"error"`
+}
+
+type RecipeCSVImportReport struct {
+	Imported int                  `json:"imported"`
+	Errors   []*RecipeCSVRowError `json:"errors"`
+	Recipes  []*Recipe            `json:"recipes"`
+}
+
+func writeRecipesCSV(w io.Writer, recipes []*Recipe) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(recipeCSVColumns); err != nil {
+		return err
+	}
+	for _, r := range recipes {
+		if err := cw.Write([]string{
+			strconv.Itoa(r.ID),
+			r.Name,
+			formatCSVInt(r.PrepareTime),
+			formatCSVInt(r.Difficulty),
+			strconv.FormatBool(r.IsVegetarian),
+			formatCSVFloat(r.Rating),
+			formatCSVInt(r.RatedNum),
+			formatCSVTime(r.PublishAt),
+			formatCSVTime(r.UnpublishAt),
+		}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// readRecipesCSV maps the columns of a CSV document with a header row to the
+// fields of PostRecipeArg. Columns it doesn't know, like the "id" and
+// "rating" ones of an exported document, are ignored. A row that can't be
+// parsed or validated is reported instead of being returned.
+func readRecipesCSV(r io.Reader) ([]*PostRecipeArg, []*RecipeCSVRowError, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(header[i], "\ufeff")))
+	}
+
+	args := make([]*PostRecipeArg, 0)
+	rowErrors := make([]*RecipeCSVRowError, 0)
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err, ok := err.(*csv.ParseError); ok && err.Err == csv.ErrFieldCount {
+			rowErrors = append(rowErrors, &RecipeCSVRowError{Row: err.StartLine, Error: err.Err.Error()})
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		row, _ := cr.FieldPos(0)
+
+		arg := &PostRecipeArg{}
+		errs := make([]*RecipeCSVRowError, 0)
+		for i, cell := range record {
+			set, ok := recipeCSVSetters[header[i]]
+			if !ok {
+				continue
+			}
+			if err := set(arg, strings.TrimSpace(cell)); err != nil {
+				errs = append(errs, &RecipeCSVRowError{Row: row, Column: header[i], Error: err.Error()})
+			}
+		}
+		if len(errs) == 0 {
+			errs = recipeCSVValidationErrors(row, validate.Struct(arg))
+		}
+		if len(errs) > 0 {
+			rowErrors = append(rowErrors, errs...)
+			continue
+		}
+		args = append(args, arg)
+	}
+	return args, rowErrors, nil
+}
+
+func recipeCSVValidationErrors(row int, err error) []*RecipeCSVRowError {
+	res := make([]*RecipeCSVRowError, 0)
+	if err == nil {
+		return res
+	}
+	fieldErrors, ok := err.(validator.ValidationErrors)
+	if !ok {
+		return append(res, &RecipeCSVRowError{Row: row, Error: err.Error()})
+	}
+	argType := reflect.TypeOf(PostRecipeArg{})
+	for _, fe := range fieldErrors {
+		column := fe.Field()
+		if f, ok := argType.FieldByName(fe.StructField()); ok {
+			column = strings.Split(f.Tag.Get("json"), ",")[0]
+		}
+		res = append(res, &RecipeCSVRowError{
+			Row:    row,
+			Column: column,
+			Error:  fmt.Sprintf("failed on the '%s' validation", fe.Tag()),
+		})
+	}
+	return res
+}
+
+func parseCSVInt(v string) (null.Int, error) {
+	if v == "" {
+		return null.Int{}, nil
+	}
+	i, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return null.Int{}, fmt.Errorf("invalid integer %q", v)
+	}
+	return null.IntFrom(i), nil
+}
+
+func parseCSVTime(v string) (null.Time, error) {
+	if v == "" {
+		return null.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return null.Time{}, fmt.Errorf("invalid RFC 3339 time %q", v)
+	}
+	return null.TimeFrom(t), nil
+}
+
+func formatCSVInt(v null.Int) string {
+	if !v.Valid {
+		return ""
+	}
+	return strconv.FormatInt(v.Int64, 10)
+}
+
+func formatCSVFloat(v null.Float) string {
+	if !v.Valid {
+		return ""
+	}
+	return strconv.FormatFloat(v.Float64, 'f', -1, 64)
+}
+
+func formatCSVTime(v null.Time) string {
+	if !v.Valid {
+		return ""
+	}
+	return v.Time.Format(time.RFC3339)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	null "gopkg.in/guregu/null.v3"
+)
+
+func TestWriteRecipesCSV(t *testing.T) {
+	publishAt := time.Date(2018, 5, 1, 8, 0, 0, 0, time.UTC)
+	buf := &bytes.Buffer{}
+	assert.NoError(t, writeRecipesCSV(buf, []*Recipe{
+		{ID: 1, Name: "name1", IsVegetarian: false, Rating: null.FloatFrom(4.5), RatedNum: null.IntFrom(2)},
+		{ID: 11, Name: "name, \"11\"", PrepareTime: null.IntFrom(1), Difficulty: null.IntFrom(2), IsVegetarian: true, Rating: null.FloatFrom(0), RatedNum: null.IntFrom(0), PublishAt: null.TimeFrom(publishAt)},
+	}))
+	assert.Equal(t, "id,name,prepare_time,difficulty,is_vegetarian,rating,rated_num,publish_at,unpublish_at\n"+
+		"1,name1,,,false,4.5,2,,\n"+
+		"11,\"name, \"\"11\"\"\",1,2,true,0,0,2018-05-01T08:00:00Z,\n", buf.String())
+}
+
+func TestReadRecipesCSV(t *testing.T) {
+	args, rowErrors, err := readRecipesCSV(strings.NewReader("\ufeffName,prepare_time,Is_Vegetarian,rating\n" +
+		"name1,,false,4.5\n" +
+		"name2,20,T,\n"))
+	assert.NoError(t, err)
+	assert.Empty(t, rowErrors)
+	assert.Equal(t, []*PostRecipeArg{
+		{Name: null.StringFrom("name1"), IsVegetarian: null.BoolFrom(false)},
+		{Name: null.StringFrom("name2"), PrepareTime: null.IntFrom(20), IsVegetarian: null.BoolFrom(true)},
+	}, args)
+
+	args, rowErrors, err = readRecipesCSV(strings.NewReader("name,prepare_time,difficulty,is_vegetarian,publish_at\n" +
+		"name1,ten,2,false,\n" +
+		",5,4,false,\n" +
+		"name3,5,2\n" +
+		"name4,5,2,maybe,yesterday\n" +
+		"name5,5,2,true,2018-05-01T08:00:00Z\n"))
+	assert.NoError(t, err)
+	assert.Len(t, args, 1)
+	assert.Equal(t, []*RecipeCSVRowError{
+		{Row: 2, Column: "prepare_time", Error: `invalid integer "ten"`},
+		{Row: 3, Column: "name", Error: "failed on the 'required' validation"},
+		{Row: 3, Column: "difficulty", Error: "failed on the 'max' validation"},
+		{Row: 4, Error: "wrong number of fields"},
+		{Row: 5, Column: "is_vegetarian", Error: `invalid boolean "maybe"`},
+		{Row: 5, Column: "publish_at", Error: `invalid RFC 3339 time "yesterday"`},
+	}, rowErrors)
+
+	for i, v := range []string{"", "name\n\"name1\n"} {
+		_, _, err := readRecipesCSV(strings.NewReader(v))
+		assert.Error(t, err, "Case [%d]: %#v", i, v)
+	}
+}
@@ -1,22 +1,105 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	stdjson "encoding/json"
+	"time"
+
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+	null "gopkg.in/guregu/null.v3"
 )
 
+const recipeColumns = `
+	r_id, r_name, r_prep_time, r_difficulty, r_vegetarian, r_rating, r_rated_num,
+	r_publish_at, r_unpublish_at, r_deleted_at, r_version
+	`
+
+const recipeRevisionColumns = `
+	rr_r_id, rr_number, hu_account, rr_created_at,
+	rr_name, rr_prep_time, rr_difficulty, rr_vegetarian, rr_rating, rr_rated_num,
+	rr_publish_at, rr_unpublish_at, rr_deleted_at, rr_version
+	`
+
+const idempotencyRecordColumns = `
+	ik_scope, ik_key, ik_fingerprint, ik_status, ik_content_type, ik_etag, ik_body, ik_created_at
+	`
+
+const webhookColumns = `
+	wh_id, wh_url, wh_events, wh_created_at
+	`
+
+const webhookDeliveryColumns = `
+	wd_id, wd_wh_id, wd_event, wd_payload, wd_status, wd_attempts, wd_next_attempt_at,
+	wd_response_status, wd_error, wd_created_at, wd_delivered_at
+	`
+
+const recipeOutboxColumns = `
+	ro_id, ro_type AS e_type, ro_r_id AS r_id, ro_created_at AS e_time, ro_payload,
+	ro_traceparent, COALESCE(hu_account, '') AS hu_account
+	`
+
+// recipeOutboxLock is the key of the advisory lock that lets only one relay
+// publish the events at a time, which keeps the events of a recipe in order.
+const recipeOutboxLock = 20181001
+
+const recipeIsNotDeleted = `
+	r_deleted_at IS NULL
+	`
+
+const recipeIsPublished = `
+	(r_publish_at IS NULL OR r_publish_at <= now()) AND
+	(r_unpublish_at IS NULL OR r_unpublish_at > now())
+	`
+
 type datastore interface {
 	listRecipes(*ListFilter, *paging) []*Recipe
 	addRecipeByCredential(*PostRecipeArg, string) *Recipe
 	getRecipeByID(int) *Recipe
-	updateAndGetRecipeByCredential(*PutRecipeArg, int, string) *Recipe
-	deleteAndGetRecipeByCredential(int, string) *Recipe
-	rateAndGetRecipe(*PostRateRecipeArg, int) *Recipe
+	listRecipeOwners([]int) map[int]string
+	updateAndGetRecipeByCredential(*PutRecipeArg, int, int, string) *Recipe
+	deleteAndGetRecipeByCredential(int, int, string) *Recipe
+	getRecipeVersionByCredential(int, string) null.Int
+	getAccountByCredential(string) null.String
+	isAdminByCredential(string) bool
+	getRecipeByCredential(int, string) *Recipe
+	rateAndGetRecipe(*PostRateRecipeArg, int, string) *Recipe
+	publishRecipeScheduleEvents(time.Time) int
+	nextRecipeScheduleTime(time.Time) null.Time
+	listDeletedRecipesByCredential(string, *paging) []*Recipe
+	restoreAndGetRecipeByCredential(int, string) *Recipe
+	purgeDeletedRecipes(time.Time) int64
+	listRecipeRevisionsByCredential(int, string) []*RecipeRevision
+	getRecipeRevisionByCredential(int, int, string) *RecipeRevision
+	restoreRecipeRevisionByCredential(int, int, string) *Recipe
+	executeRecipeBatchByCredential([]*RecipeBatchOperation, bool, string) []*Recipe
+	reserveIdempotencyRecord(*idempotencyRecord, time.Time) bool
+	getIdempotencyRecord(string, string, time.Time) *idempotencyRecord
+	completeIdempotencyRecord(*idempotencyRecord)
+	releaseIdempotencyRecord(*idempotencyRecord)
+	purgeIdempotencyRecords(time.Time) int64
+	exportRecipesByCredential(string, func(*RecipeExport)) bool
+	importRecipesByCredential([]*RecipeExport, *ImportRecipesArg, string) []*RecipeImportResult
+	addWebhookByCredential(*PostWebhookArg, string, string) *Webhook
+	listWebhooksByCredential(string) []*Webhook
+	deleteWebhookByCredential(int, string) *Webhook
+	listWebhookDeliveriesByCredential(int, *WebhookDeliveryFilter, string, *paging) []*WebhookDelivery
+	redeliverWebhookDeliveryByCredential(int, int, string) *WebhookDelivery
+	enqueueWebhookDeliveries(string, int, []byte, string) int64
+	claimWebhookDeliveries(time.Time, int) []*webhookAttempt
+	recordWebhookDeliveryAttempt(*WebhookDelivery)
+	relayRecipeEvents(int, func(*recipeEvent) bool) int
+	purgeRecipeEvents(time.Time) int64
+	ping(time.Duration) error
+	poolStats() sql.DBStats
+	withContext(context.Context) datastore
 	close()
 }
 
 type sqlxPostgreSQL struct {
 	sqlxDB *sqlx.DB
+	ctx    context.Context
 }
 
 func newSqlxPostgreSQL(connectionString string) *sqlxPostgreSQL {
@@ -25,6 +108,28 @@ func newSqlxPostgreSQL(connectionString string) *sqlxPostgreSQL {
 	}
 }
 
+// withContext returns the datastore that runs its statements in spans under
+// the span of the context.
+func (d *sqlxPostgreSQL) withContext(ctx context.Context) datastore {
+	return &sqlxPostgreSQL{sqlxDB: d.sqlxDB, ctx: ctx}
+}
+
+func (d *sqlxPostgreSQL) db() *tracedDB {
+	return &tracedDB{DB: d.sqlxDB, ctx: d.ctx}
+}
+
+// ping checks that the database can be reached within the timeout. Unlike
+// the other methods, it returns the error for the health checks to report.
+func (d *sqlxPostgreSQL) ping(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return d.sqlxDB.PingContext(ctx)
+}
+
+func (d *sqlxPostgreSQL) poolStats() sql.DBStats {
+	return d.sqlxDB.Stats()
+}
+
 func (d *sqlxPostgreSQL) close() {
 	if err := d.sqlxDB.Close(); err != nil {
 		panic(err)
@@ -36,35 +141,41 @@ func (d *sqlxPostgreSQL) listRecipes(f *ListFilter, p *paging) []*Recipe {
 		panic("nil *ListFilter or *paging variable not allowed")
 	}
 	res := make([]*Recipe, 0)
-	if err := d.sqlxDB.Select(&res, `
-	SELECT r_id, r_name, r_prep_time, r_difficulty, r_vegetarian, r_rating, r_rated_num FROM recipe
-	`+f.whereClause()+`ORDER BY r_id`+p.limitClause()+p.offsetClause()); err != nil {
+	if err := d.db().Select(&res, `
+	SELECT `+recipeColumns+` FROM recipe
+	`+f.whereClause()+` AND `+recipeIsNotDeleted+` AND `+recipeIsPublished+`ORDER BY r_id`+p.limitClause()+p.offsetClause()); err != nil {
 		panic(err)
 	}
 	return res
 }
 
 func (d *sqlxPostgreSQL) addRecipeByCredential(arg *PostRecipeArg, token string) *Recipe {
-	var res Recipe
-	var userID int
-	tx := d.sqlxDB.MustBegin()
-	if err := d.sqlxDB.Get(&userID, `
-	SELECT hu_id FROM hellofresh_user
-	WHERE hu_access_token = $1
-	`, token); err != nil {
+	tx := d.db().MustBegin()
+	res := d.addRecipe(tx, arg, token)
+	if res == nil {
 		if err := tx.Rollback(); err != nil {
 			panic(err)
 		}
 		return nil
 	}
+	tx.Commit()
+	return res
+}
+
+func (d *sqlxPostgreSQL) addRecipe(tx *tracedTx, arg *PostRecipeArg, token string) *Recipe {
+	var res Recipe
+	user := d.getUserByCredential(tx, token)
+	if user == nil {
+		return nil
+	}
 	if _, err := tx.NamedExec(`
-	INSERT INTO recipe(r_name, r_prep_time, r_difficulty, r_vegetarian)
-	VALUES (:r_name, :r_prep_time, :r_difficulty, :r_vegetarian)
+	INSERT INTO recipe(r_name, r_prep_time, r_difficulty, r_vegetarian, r_publish_at, r_unpublish_at)
+	VALUES (:r_name, :r_prep_time, :r_difficulty, :r_vegetarian, :r_publish_at, :r_unpublish_at)
 	`, arg); err != nil {
 		panic(err)
 	}
 	if err := tx.Get(&res, `
-	SELECT r_id, r_name, r_prep_time, r_difficulty, r_vegetarian, r_rating, r_rated_num FROM recipe
+	SELECT `+recipeColumns+` FROM recipe
 	WHERE r_id = (
 		SELECT currval(pg_get_serial_sequence('recipe','r_id'))
 	)
@@ -74,128 +185,991 @@ func (d *sqlxPostgreSQL) addRecipeByCredential(arg *PostRecipeArg, token string)
 	tx.MustExec(`
 	INSERT INTO hellofresh_user_recipe(hur_hu_id, hur_r_id)
 	VALUES ($1, $2)
-	`, userID, res.ID)
-	tx.Commit()
+	`, user.ID, res.ID)
+	d.addRecipeRevision(tx, res.ID, token)
+	d.addRecipeEvent(tx, recipeCreatedEvent, res.ID)
 	return &res
 }
 
 func (d *sqlxPostgreSQL) getRecipeByID(id int) *Recipe {
 	var res Recipe
-	if err := d.sqlxDB.Get(&res, `
-	SELECT r_id, r_name, r_prep_time, r_difficulty, r_vegetarian, r_rating, r_rated_num FROM recipe
-	WHERE r_id = $1
-	`, id); err != nil {
+	if err := d.db().Get(&res, `
+	SELECT `+recipeColumns+` FROM recipe
+	WHERE r_id = $1 AND `+recipeIsNotDeleted+` AND `+recipeIsPublished, id); err != nil {
 		return nil
 	}
 	return &res
 }
 
-func (d *sqlxPostgreSQL) updateAndGetRecipeByCredential(arg *PutRecipeArg, id int, token string) *Recipe {
-	var res Recipe
-	tx := d.sqlxDB.MustBegin()
-	if err := d.sqlxDB.Get(&res, `
-	SELECT r_id, r_name, r_prep_time, r_difficulty, r_vegetarian, r_rating, r_rated_num FROM recipe
-	WHERE r_id = $1
-	`, id); err != nil {
+type recipeOwner struct {
+	RecipeID int    `db:"hur_r_id"`
+	Account  string `db:"hu_account"`
+}
+
+func (d *sqlxPostgreSQL) listRecipeOwners(ids []int) map[int]string {
+	rows := make([]*recipeOwner, 0)
+	if err := d.db().Select(&rows, `
+	SELECT hur_r_id, hu_account FROM hellofresh_user_recipe
+	INNER JOIN hellofresh_user
+	ON hellofresh_user_recipe.hur_hu_id = hellofresh_user.hu_id
+	WHERE hur_r_id = ANY($1)
+	`, pq.Array(ids)); err != nil {
+		panic(err)
+	}
+	res := make(map[int]string)
+	for _, row := range rows {
+		res[row.RecipeID] = row.Account
+	}
+	return res
+}
+
+func (d *sqlxPostgreSQL) updateAndGetRecipeByCredential(arg *PutRecipeArg, id int, version int, token string) *Recipe {
+	tx := d.db().MustBegin()
+	res := d.updateRecipe(tx, arg, id, version, token)
+	if res == nil {
 		if err := tx.Rollback(); err != nil {
 			panic(err)
 		}
 		return nil
 	}
+	tx.Commit()
+	return res
+}
+
+func (d *sqlxPostgreSQL) updateRecipe(tx *tracedTx, arg *PutRecipeArg, id int, version int, token string) *Recipe {
+	var res Recipe
+	if err := tx.Get(&res, `
+	SELECT `+recipeColumns+` FROM recipe
+	WHERE r_id = $1 AND `+recipeIsNotDeleted+`
+	FOR UPDATE
+	`, id); err != nil {
+		return nil
+	}
 	arg.overwriteRecipe(&res)
-	slqResult := tx.MustExec(`
+	if err := tx.Get(&res, `
 	UPDATE recipe
 	SET	r_name = $1,
 		r_prep_time = $2,
 		r_difficulty = $3,
-		r_vegetarian = $4
+		r_vegetarian = $4,
+		r_publish_at = $5,
+		r_unpublish_at = $6,
+		r_version = r_version + 1
 	WHERE r_id = (
 		SELECT recipe.r_id
 		FROM recipe
 		INNER JOIN hellofresh_user_recipe
 		ON recipe.r_id = hellofresh_user_recipe.hur_r_id
-		WHERE recipe.r_id = $5 AND hellofresh_user_recipe.hur_hu_id= (
+		WHERE recipe.r_id = $7 AND recipe.r_deleted_at IS NULL AND hellofresh_user_recipe.hur_hu_id= (
 			SELECT hu_id FROM hellofresh_user
-			WHERE hu_access_token = $6
+			WHERE hu_access_token = $8
 		)
-	)
-	`, res.Name, res.PrepareTime, res.Difficulty, res.IsVegetarian, id, token)
-	if cnt, err := slqResult.RowsAffected(); err != nil {
+	) AND ($9 = 0 OR r_version = $9)
+	RETURNING `+recipeColumns, res.Name, res.PrepareTime, res.Difficulty, res.IsVegetarian, res.PublishAt, res.UnpublishAt, id, token, version); err != nil {
+		return nil
+	}
+	d.addRecipeRevision(tx, id, token)
+	d.addRecipeEvent(tx, recipeUpdatedEvent, id)
+	return &res
+}
+
+func (d *sqlxPostgreSQL) deleteAndGetRecipeByCredential(id int, version int, token string) *Recipe {
+	tx := d.db().MustBegin()
+	res := d.deleteRecipe(tx, id, version, token)
+	if res == nil {
+		if err := tx.Rollback(); err != nil {
+			panic(err)
+		}
+		return nil
+	}
+	tx.Commit()
+	return res
+}
+
+func (d *sqlxPostgreSQL) deleteRecipe(tx *tracedTx, id int, version int, token string) *Recipe {
+	var res Recipe
+	if err := tx.Get(&res, `
+	UPDATE recipe
+	SET	r_deleted_at = now(),
+		r_version = r_version + 1
+	WHERE r_id = (
+		SELECT recipe.r_id
+		FROM recipe
+		INNER JOIN hellofresh_user_recipe
+		ON recipe.r_id = hellofresh_user_recipe.hur_r_id
+		WHERE recipe.r_id = $1 AND recipe.r_deleted_at IS NULL AND hellofresh_user_recipe.hur_hu_id= (
+			SELECT hu_id FROM hellofresh_user
+			WHERE hu_access_token = $2
+		)
+	) AND ($3 = 0 OR r_version = $3)
+	RETURNING `+recipeColumns, id, token, version); err != nil {
+		return nil
+	}
+	d.addRecipeRevision(tx, id, token)
+	d.addRecipeEvent(tx, recipeDeletedEvent, id)
+	return &res
+}
+
+func (d *sqlxPostgreSQL) executeRecipeBatchByCredential(ops []*RecipeBatchOperation, atomic bool, token string) []*Recipe {
+	res := make([]*Recipe, len(ops))
+	tx := d.db().MustBegin()
+	for i, op := range ops {
+		if !atomic {
+			tx.MustExec(`SAVEPOINT recipe_batch_operation`)
+		}
+		switch op.Op {
+		case recipeBatchCreate:
+			res[i] = d.addRecipe(tx, op.Recipe, token)
+		case recipeBatchUpdate:
+			arg := PutRecipeArg(*op.Recipe)
+			res[i] = d.updateRecipe(tx, &arg, op.ID, op.Version, token)
+		case recipeBatchDelete:
+			res[i] = d.deleteRecipe(tx, op.ID, op.Version, token)
+		}
+		switch {
+		case res[i] == nil && atomic:
+			if err := tx.Rollback(); err != nil {
+				panic(err)
+			}
+			return res
+		case res[i] == nil:
+			tx.MustExec(`ROLLBACK TO SAVEPOINT recipe_batch_operation`)
+		case !atomic:
+			tx.MustExec(`RELEASE SAVEPOINT recipe_batch_operation`)
+		}
+	}
+	tx.Commit()
+	return res
+}
+
+func (d *sqlxPostgreSQL) exportRecipesByCredential(token string, emit func(*RecipeExport)) bool {
+	user := d.getUserByCredential(d.db(), token)
+	if user == nil {
+		return false
+	}
+	rows, err := d.db().Queryx(`
+	SELECT `+recipeColumns+`, hu_account FROM recipe
+	LEFT JOIN hellofresh_user_recipe
+	ON recipe.r_id = hellofresh_user_recipe.hur_r_id
+	LEFT JOIN hellofresh_user
+	ON hellofresh_user_recipe.hur_hu_id = hellofresh_user.hu_id
+	WHERE $1 OR hellofresh_user_recipe.hur_hu_id = $2
+	ORDER BY r_id
+	`, user.Admin, user.ID)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r RecipeExport
+		if err := rows.StructScan(&r); err != nil {
+			panic(err)
+		}
+		emit(&r)
+	}
+	if err := rows.Err(); err != nil {
 		panic(err)
-	} else if cnt == 0 {
+	}
+	return true
+}
+
+func (d *sqlxPostgreSQL) importRecipesByCredential(recipes []*RecipeExport, arg *ImportRecipesArg, token string) []*RecipeImportResult {
+	res := make([]*RecipeImportResult, len(recipes))
+	tx := d.db().MustBegin()
+	user := d.getUserByCredential(tx, token)
+	if user == nil {
 		if err := tx.Rollback(); err != nil {
 			panic(err)
 		}
 		return nil
 	}
+	for i, r := range recipes {
+		tx.MustExec(`SAVEPOINT recipe_import`)
+		res[i] = d.importRecipe(tx, r, arg.Strategy, user, token)
+		if res[i].Action == recipeImportFailed {
+			tx.MustExec(`ROLLBACK TO SAVEPOINT recipe_import`)
+			continue
+		}
+		tx.MustExec(`RELEASE SAVEPOINT recipe_import`)
+	}
+	if arg.DryRun {
+		if err := tx.Rollback(); err != nil {
+			panic(err)
+		}
+		return res
+	}
+	tx.MustExec(`
+	SELECT setval(pg_get_serial_sequence('recipe','r_id'), MAX(r_id)) FROM recipe
+	HAVING MAX(r_id) IS NOT NULL
+	`)
 	tx.Commit()
+	return res
+}
+
+func (d *sqlxPostgreSQL) importRecipe(tx *tracedTx, r *RecipeExport, strategy string, user *credentialUser, token string) *RecipeImportResult {
+	res := &RecipeImportResult{SourceID: r.ID}
+	if !user.Admin && r.Owner.String != user.Account {
+		res.Action, res.Error = recipeImportFailed, "only an admin can import recipes of other users"
+		return res
+	}
+	var ownerID int
+	if err := tx.Get(&ownerID, `
+	SELECT hu_id FROM hellofresh_user
+	WHERE hu_account = $1
+	`, r.Owner); err != nil {
+		res.Action, res.Error = recipeImportFailed, "unknown owner"
+		return res
+	}
+	var exists bool
+	if err := tx.Get(&exists, `
+	SELECT EXISTS(SELECT 1 FROM recipe WHERE r_id = $1)
+	`, r.ID); err != nil {
+		panic(err)
+	}
+	// Only an admin carries the ratings over. The recipes anyone else
+	// imports start unrated, or keep their ratings when they are overwritten.
+	var rating null.Float
+	var ratedNum null.Int
+	if user.Admin {
+		rating, ratedNum = null.FloatFrom(r.Rating.Float64), null.IntFrom(r.RatedNum.Int64)
+	}
+
+	id := r.ID
+	switch {
+	case exists && strategy == recipeImportSkip:
+		res.ID, res.Action = r.ID, recipeImportSkipped
+		return res
+	case exists && strategy == recipeImportOverwrite:
+		if !user.Admin {
+			var owned bool
+			if err := tx.Get(&owned, `
+			SELECT EXISTS(
+				SELECT 1 FROM hellofresh_user_recipe
+				WHERE hur_r_id = $1 AND hur_hu_id = $2
+			)
+			`, r.ID, user.ID); err != nil {
+				panic(err)
+			}
+			if !owned {
+				res.Action, res.Error = recipeImportFailed, "only an admin can overwrite recipes of other users"
+				return res
+			}
+		}
+		res.ID, res.Action = r.ID, recipeImportOverwritten
+		if _, err := tx.Exec(`
+		UPDATE recipe
+		SET	r_name = $2,
+			r_prep_time = $3,
+			r_difficulty = $4,
+			r_vegetarian = $5,
+			r_rating = COALESCE($6, r_rating),
+			r_rated_num = COALESCE($7, r_rated_num),
+			r_publish_at = $8,
+			r_unpublish_at = $9,
+			r_deleted_at = $10,
+			r_version = r_version + 1
+		WHERE r_id = $1
+		`, r.ID, r.Name, r.PrepareTime, r.Difficulty, r.IsVegetarian, rating, ratedNum,
+			r.PublishAt, r.UnpublishAt, r.DeletedAt); err != nil {
+			res.ID, res.Action, res.Error = 0, recipeImportFailed, err.Error()
+			return res
+		}
+		tx.MustExec(`
+		DELETE FROM hellofresh_user_recipe
+		WHERE hur_r_id = $1
+		`, r.ID)
+	default:
+		res.Action = recipeImportCreated
+		if exists {
+			id, res.Action = 0, recipeImportRenamed
+		}
+		if err := tx.Get(&res.ID, `
+		INSERT INTO recipe(
+			r_id, r_name, r_prep_time, r_difficulty, r_vegetarian, r_rating, r_rated_num,
+			r_publish_at, r_unpublish_at, r_deleted_at
+		)
+		VALUES (
+			COALESCE(NULLIF($1, 0), nextval(pg_get_serial_sequence('recipe','r_id'))),
+			$2, $3, $4, $5, COALESCE($6::real, 0), COALESCE($7::integer, 0), $8, $9, $10
+		)
+		RETURNING r_id
+		`, id, r.Name, r.PrepareTime, r.Difficulty, r.IsVegetarian, rating, ratedNum,
+			r.PublishAt, r.UnpublishAt, r.DeletedAt); err != nil {
+			res.Action, res.Error = recipeImportFailed, err.Error()
+			return res
+		}
+	}
+	tx.MustExec(`
+	INSERT INTO hellofresh_user_recipe(hur_hu_id, hur_r_id)
+	VALUES ($1, $2)
+	`, ownerID, res.ID)
+	d.addRecipeRevision(tx, res.ID, token)
+	d.addRecipeEvent(tx, recipeImportEvents[res.Action], res.ID)
+	return res
+}
+
+func (d *sqlxPostgreSQL) getRecipeByCredential(id int, token string) *Recipe {
+	var res Recipe
+	if err := d.db().Get(&res, `
+	SELECT `+recipeColumns+` FROM recipe
+	INNER JOIN hellofresh_user_recipe
+	ON recipe.r_id = hellofresh_user_recipe.hur_r_id
+	WHERE recipe.r_id = $1 AND recipe.r_deleted_at IS NULL AND hellofresh_user_recipe.hur_hu_id= (
+		SELECT hu_id FROM hellofresh_user
+		WHERE hu_access_token = $2
+	)
+	`, id, token); err != nil {
+		return nil
+	}
+	return &res
+}
+
+func (d *sqlxPostgreSQL) getRecipeVersionByCredential(id int, token string) null.Int {
+	var res null.Int
+	if err := d.db().Get(&res, `
+	SELECT recipe.r_version
+	FROM recipe
+	INNER JOIN hellofresh_user_recipe
+	ON recipe.r_id = hellofresh_user_recipe.hur_r_id
+	WHERE recipe.r_id = $1 AND recipe.r_deleted_at IS NULL AND hellofresh_user_recipe.hur_hu_id= (
+		SELECT hu_id FROM hellofresh_user
+		WHERE hu_access_token = $2
+	)
+	`, id, token); err != nil {
+		return null.Int{}
+	}
+	return res
+}
+
+// credentialUser is the user of a credential. An admin can export and import
+// the recipes of every user, and anyone else only their own ones.
+type credentialUser struct {
+	ID      int    `db:"hu_id"`
+	Account string `db:"hu_account"`
+	Admin   bool   `db:"hu_admin"`
+}
+
+// rowGetter is the database or a transaction to look up a row in.
+type rowGetter interface {
+	Get(dest interface{}, query string, args ...interface{}) error
+}
+
+// getUserByCredential looks up the user of a credential, and records them as
+// the user of the request for the access log.
+func (d *sqlxPostgreSQL) getUserByCredential(q rowGetter, token string) *credentialUser {
+	var res credentialUser
+	if err := q.Get(&res, `
+	SELECT hu_id, hu_account, hu_admin FROM hellofresh_user
+	WHERE hu_access_token = $1
+	`, token); err != nil {
+		return nil
+	}
+	recordRequestUser(d.ctx, res.ID)
 	return &res
 }
 
-func (d *sqlxPostgreSQL) deleteAndGetRecipeByCredential(id int, token string) *Recipe {
+func (d *sqlxPostgreSQL) getAccountByCredential(token string) null.String {
+	if user := d.getUserByCredential(d.db(), token); user != nil {
+		return null.StringFrom(user.Account)
+	}
+	return null.String{}
+}
+
+func (d *sqlxPostgreSQL) isAdminByCredential(token string) bool {
+	if user := d.getUserByCredential(d.db(), token); user != nil {
+		return user.Admin
+	}
+	return false
+}
+
+// rateAndGetRecipe rates the recipe and returns it as rated, in a single
+// statement, so that concurrent ratings each get their own version of it.
+// The revision of the rating is authored by the user of the credential, and
+// has no author if the rating is anonymous.
+func (d *sqlxPostgreSQL) rateAndGetRecipe(arg *PostRateRecipeArg, id int, token string) *Recipe {
 	var res Recipe
-	tx := d.sqlxDB.MustBegin()
-	if err := d.sqlxDB.Get(&res, `
-	SELECT r_id, r_name, r_prep_time, r_difficulty, r_vegetarian, r_rating, r_rated_num FROM recipe
-	WHERE r_id = $1
-	`, id); err != nil {
+	tx := d.db().MustBegin()
+	if err := tx.Get(&res, `
+	UPDATE recipe
+	SET	r_rating = ((r_rating*r_rated_num) + $1)/(r_rated_num + 1),
+		r_rated_num = r_rated_num + 1,
+		r_version = r_version + 1
+	WHERE r_id = $2 AND `+recipeIsNotDeleted+` AND `+recipeIsPublished+`
+	RETURNING `+recipeColumns, arg.Rating, id); err != nil {
 		if err := tx.Rollback(); err != nil {
 			panic(err)
 		}
 		return nil
 	}
-	slqResult := d.sqlxDB.MustExec(`
-	DELETE FROM recipe
+	d.addRecipeRevision(tx, id, token)
+	d.addRecipeEvent(tx, recipeRatedEvent, id)
+	tx.Commit()
+	return &res
+}
+
+// publishRecipeScheduleEvents writes an event to the outbox for every recipe
+// that entered or left its publishing window since the time the last call
+// processed up to, and stores the time as processed. So the transitions that
+// fell due while the service was down are still published, once, when it is
+// back up. The first call only stores the time. It returns how many events
+// were written.
+func (d *sqlxPostgreSQL) publishRecipeScheduleEvents(to time.Time) int {
+	tx := d.db().MustBegin()
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			panic(err)
+		}
+	}()
+	tx.MustExec(`
+	INSERT INTO recipe_schedule_checkpoint(rsc_processed_until)
+	VALUES ($1)
+	ON CONFLICT DO NOTHING
+	`, to)
+	var from time.Time
+	if err := tx.Get(&from, `
+	SELECT rsc_processed_until FROM recipe_schedule_checkpoint
+	FOR UPDATE
+	`); err != nil {
+		panic(err)
+	}
+	if !from.Before(to) {
+		if err := tx.Commit(); err != nil {
+			panic(err)
+		}
+		return 0
+	}
+	events := make([]*recipeEvent, 0)
+	if err := tx.Select(&events, `
+	SELECT * FROM (
+		SELECT r_id, $3::text AS e_type, r_publish_at AS e_time FROM recipe
+		WHERE r_publish_at > $1 AND r_publish_at <= $2 AND `+recipeIsNotDeleted+`
+		UNION ALL
+		SELECT r_id, $4::text AS e_type, r_unpublish_at AS e_time FROM recipe
+		WHERE r_unpublish_at > $1 AND r_unpublish_at <= $2 AND `+recipeIsNotDeleted+`
+	) AS schedule
+	ORDER BY e_time, r_id
+	`, from, to, recipePublishedEvent, recipeUnpublishedEvent); err != nil {
+		panic(err)
+	}
+	for _, e := range events {
+		d.addRecipeEvent(tx, e.Type, e.RecipeID)
+	}
+	tx.MustExec(`
+	UPDATE recipe_schedule_checkpoint
+	SET	rsc_processed_until = $1
+	`, to)
+	if err := tx.Commit(); err != nil {
+		panic(err)
+	}
+	return len(events)
+}
+
+func (d *sqlxPostgreSQL) nextRecipeScheduleTime(after time.Time) null.Time {
+	var res null.Time
+	if err := d.db().Get(&res, `
+	SELECT MIN(e_time) FROM (
+		SELECT r_publish_at AS e_time FROM recipe
+		WHERE r_publish_at > $1 AND `+recipeIsNotDeleted+`
+		UNION ALL
+		SELECT r_unpublish_at AS e_time FROM recipe
+		WHERE r_unpublish_at > $1 AND `+recipeIsNotDeleted+`
+	) AS schedule
+	`, after); err != nil {
+		panic(err)
+	}
+	return res
+}
+
+func (d *sqlxPostgreSQL) listDeletedRecipesByCredential(token string, p *paging) []*Recipe {
+	if p == nil {
+		panic("nil *paging variable not allowed")
+	}
+	user := d.getUserByCredential(d.db(), token)
+	if user == nil {
+		return nil
+	}
+	res := make([]*Recipe, 0)
+	if err := d.db().Select(&res, `
+	SELECT `+recipeColumns+` FROM recipe
+	INNER JOIN hellofresh_user_recipe
+	ON recipe.r_id = hellofresh_user_recipe.hur_r_id
+	WHERE hellofresh_user_recipe.hur_hu_id = $1 AND recipe.r_deleted_at IS NOT NULL
+	ORDER BY r_deleted_at DESC, r_id`+p.limitClause()+p.offsetClause(), user.ID); err != nil {
+		panic(err)
+	}
+	return res
+}
+
+func (d *sqlxPostgreSQL) restoreAndGetRecipeByCredential(id int, token string) *Recipe {
+	var res Recipe
+	tx := d.db().MustBegin()
+	if err := tx.Get(&res, `
+	UPDATE recipe
+	SET	r_deleted_at = NULL,
+		r_version = r_version + 1
 	WHERE r_id = (
 		SELECT recipe.r_id
 		FROM recipe
 		INNER JOIN hellofresh_user_recipe
 		ON recipe.r_id = hellofresh_user_recipe.hur_r_id
-		WHERE recipe.r_id = $1 AND hellofresh_user_recipe.hur_hu_id= (
+		WHERE recipe.r_id = $1 AND recipe.r_deleted_at IS NOT NULL AND hellofresh_user_recipe.hur_hu_id= (
 			SELECT hu_id FROM hellofresh_user
 			WHERE hu_access_token = $2
 		)
 	)
-	`, id, token)
-	if cnt, err := slqResult.RowsAffected(); err != nil {
-		panic(err)
-	} else if cnt == 0 {
+	RETURNING `+recipeColumns, id, token); err != nil {
 		if err := tx.Rollback(); err != nil {
 			panic(err)
 		}
 		return nil
 	}
+	d.addRecipeRevision(tx, id, token)
+	d.addRecipeEvent(tx, recipeUpdatedEvent, id)
 	tx.Commit()
 	return &res
 }
 
-func (d *sqlxPostgreSQL) rateAndGetRecipe(arg *PostRateRecipeArg, id int) *Recipe {
-	var res Recipe
-	tx := d.sqlxDB.MustBegin()
-	if err := d.sqlxDB.Get(&res, `
-	SELECT r_id, r_name, r_prep_time, r_difficulty, r_vegetarian, r_rating, r_rated_num FROM recipe
+func (d *sqlxPostgreSQL) purgeDeletedRecipes(deletedBefore time.Time) int64 {
+	slqResult := d.db().MustExec(`
+	DELETE FROM recipe
+	WHERE r_deleted_at < $1
+	`, deletedBefore)
+	cnt, err := slqResult.RowsAffected()
+	if err != nil {
+		panic(err)
+	}
+	return cnt
+}
+
+// addRecipeRevision stores a snapshot of every column of the recipe as its
+// next revision, authored by the user of the token. A change made without
+// a token, like a rating, has no author.
+func (d *sqlxPostgreSQL) addRecipeRevision(tx *tracedTx, id int, token string) {
+	tx.MustExec(`
+	INSERT INTO recipe_revision(
+		rr_r_id, rr_number, rr_hu_id,
+		rr_name, rr_prep_time, rr_difficulty, rr_vegetarian, rr_rating, rr_rated_num,
+		rr_publish_at, rr_unpublish_at, rr_deleted_at, rr_version
+	)
+	SELECT r_id, (
+			SELECT COALESCE(MAX(rr_number), 0) + 1 FROM recipe_revision
+			WHERE rr_r_id = $1
+		), (
+			SELECT hu_id FROM hellofresh_user
+			WHERE hu_access_token = $2
+		),
+		r_name, r_prep_time, r_difficulty, r_vegetarian, r_rating, r_rated_num,
+		r_publish_at, r_unpublish_at, r_deleted_at, r_version
+	FROM recipe
+	WHERE r_id = $1
+	`, id, token)
+}
+
+// addRecipeEvent writes an event of the recipe to the outbox, in the same
+// transaction as the change, so that the event is published if and only if
+// the change is committed. The event keeps the traceparent of the change.
+func (d *sqlxPostgreSQL) addRecipeEvent(tx *tracedTx, eventType string, id int) {
+	var r Recipe
+	if err := tx.Get(&r, `
+	SELECT `+recipeColumns+` FROM recipe
 	WHERE r_id = $1
 	`, id); err != nil {
-		if err := tx.Rollback(); err != nil {
-			panic(err)
-		}
+		panic(err)
+	}
+	payload, err := stdjson.Marshal(&r)
+	if err != nil {
+		panic(err)
+	}
+	tx.MustExec(`
+	INSERT INTO recipe_outbox(ro_type, ro_r_id, ro_payload, ro_traceparent)
+	VALUES ($1, $2, $3, $4)
+	`, eventType, id, string(payload), traceparentOf(d.ctx))
+}
+
+func (d *sqlxPostgreSQL) listRecipeRevisionsByCredential(id int, token string) []*RecipeRevision {
+	res := make([]*RecipeRevision, 0)
+	if err := d.db().Select(&res, `
+	SELECT `+recipeRevisionColumns+` FROM recipe_revision
+	LEFT JOIN hellofresh_user
+	ON recipe_revision.rr_hu_id = hellofresh_user.hu_id
+	WHERE rr_r_id = (
+		SELECT hur_r_id FROM hellofresh_user_recipe
+		WHERE hur_r_id = $1 AND hur_hu_id = (
+			SELECT hu_id FROM hellofresh_user
+			WHERE hu_access_token = $2
+		)
+	)
+	ORDER BY rr_number
+	`, id, token); err != nil {
+		panic(err)
+	}
+	if len(res) == 0 {
 		return nil
 	}
-	arg.updateRatedRecipe(&res)
-	slqResult := tx.MustExec(`
+	return res
+}
+
+func (d *sqlxPostgreSQL) getRecipeRevisionByCredential(id int, revision int, token string) *RecipeRevision {
+	var res RecipeRevision
+	if err := d.db().Get(&res, `
+	SELECT `+recipeRevisionColumns+` FROM recipe_revision
+	LEFT JOIN hellofresh_user
+	ON recipe_revision.rr_hu_id = hellofresh_user.hu_id
+	WHERE rr_number = $2 AND rr_r_id = (
+		SELECT hur_r_id FROM hellofresh_user_recipe
+		WHERE hur_r_id = $1 AND hur_hu_id = (
+			SELECT hu_id FROM hellofresh_user
+			WHERE hu_access_token = $3
+		)
+	)
+	`, id, revision, token); err != nil {
+		return nil
+	}
+	return &res
+}
+
+func (d *sqlxPostgreSQL) restoreRecipeRevisionByCredential(id int, revision int, token string) *Recipe {
+	var res Recipe
+	tx := d.db().MustBegin()
+	if err := tx.Get(&res, `
 	UPDATE recipe
-	SET	r_rating = ((r_rating*r_rated_num) + $1)/(r_rated_num + 1),
-		r_rated_num = r_rated_num + 1
-	WHERE r_id = $2
-	`, arg.Rating, id)
-	if cnt, err := slqResult.RowsAffected(); err != nil {
-		panic(err)
-	} else if cnt == 0 {
+	SET	r_name = rr_name,
+		r_prep_time = rr_prep_time,
+		r_difficulty = rr_difficulty,
+		r_vegetarian = rr_vegetarian,
+		r_publish_at = rr_publish_at,
+		r_unpublish_at = rr_unpublish_at,
+		r_version = r_version + 1
+	FROM recipe_revision
+	WHERE r_id = rr_r_id AND rr_number = $2 AND r_id = (
+		SELECT recipe.r_id
+		FROM recipe
+		INNER JOIN hellofresh_user_recipe
+		ON recipe.r_id = hellofresh_user_recipe.hur_r_id
+		WHERE recipe.r_id = $1 AND recipe.r_deleted_at IS NULL AND hellofresh_user_recipe.hur_hu_id= (
+			SELECT hu_id FROM hellofresh_user
+			WHERE hu_access_token = $3
+		)
+	)
+	RETURNING `+recipeColumns, id, revision, token); err != nil {
 		if err := tx.Rollback(); err != nil {
 			panic(err)
 		}
 		return nil
 	}
+	d.addRecipeRevision(tx, id, token)
+	d.addRecipeEvent(tx, recipeUpdatedEvent, id)
 	tx.Commit()
 	return &res
 }
+
+func (d *sqlxPostgreSQL) reserveIdempotencyRecord(r *idempotencyRecord, expiredBefore time.Time) bool {
+	res := d.db().MustExec(`
+	INSERT INTO idempotency_key(ik_scope, ik_key, ik_fingerprint)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (ik_scope, ik_key) DO UPDATE
+	SET	ik_fingerprint = EXCLUDED.ik_fingerprint,
+		ik_status = 0,
+		ik_content_type = '',
+		ik_etag = '',
+		ik_body = '',
+		ik_created_at = now()
+	WHERE idempotency_key.ik_created_at <= $4
+	`, r.Scope, r.Key, r.Fingerprint, expiredBefore)
+	cnt, err := res.RowsAffected()
+	if err != nil {
+		panic(err)
+	}
+	return cnt > 0
+}
+
+func (d *sqlxPostgreSQL) getIdempotencyRecord(scope string, key string, expiredBefore time.Time) *idempotencyRecord {
+	var res idempotencyRecord
+	if err := d.db().Get(&res, `
+	SELECT `+idempotencyRecordColumns+` FROM idempotency_key
+	WHERE ik_scope = $1 AND ik_key = $2 AND ik_created_at > $3
+	`, scope, key, expiredBefore); err != nil {
+		return nil
+	}
+	return &res
+}
+
+func (d *sqlxPostgreSQL) completeIdempotencyRecord(r *idempotencyRecord) {
+	d.db().MustExec(`
+	UPDATE idempotency_key
+	SET	ik_status = $1,
+		ik_content_type = $2,
+		ik_etag = $3,
+		ik_body = $4
+	WHERE ik_scope = $5 AND ik_key = $6
+	`, r.Status, r.ContentType, r.ETag, r.Body, r.Scope, r.Key)
+}
+
+func (d *sqlxPostgreSQL) releaseIdempotencyRecord(r *idempotencyRecord) {
+	d.db().MustExec(`
+	DELETE FROM idempotency_key
+	WHERE ik_scope = $1 AND ik_key = $2 AND ik_status = 0
+	`, r.Scope, r.Key)
+}
+
+func (d *sqlxPostgreSQL) purgeIdempotencyRecords(createdBefore time.Time) int64 {
+	res := d.db().MustExec(`
+	DELETE FROM idempotency_key
+	WHERE ik_created_at <= $1
+	`, createdBefore)
+	cnt, err := res.RowsAffected()
+	if err != nil {
+		panic(err)
+	}
+	return cnt
+}
+
+func (d *sqlxPostgreSQL) addWebhookByCredential(arg *PostWebhookArg, secret string, token string) *Webhook {
+	user := d.getUserByCredential(d.db(), token)
+	if user == nil {
+		return nil
+	}
+	events := pq.StringArray(arg.Events)
+	if events == nil {
+		events = pq.StringArray{}
+	}
+	var res Webhook
+	if err := d.db().Get(&res, `
+	INSERT INTO webhook(wh_hu_id, wh_url, wh_secret, wh_events)
+	VALUES ($1, $2, $3, $4)
+	RETURNING `+webhookColumns+`, wh_secret
+	`, user.ID, arg.URL, secret, events); err != nil {
+		panic(err)
+	}
+	return &res
+}
+
+func (d *sqlxPostgreSQL) listWebhooksByCredential(token string) []*Webhook {
+	user := d.getUserByCredential(d.db(), token)
+	if user == nil {
+		return nil
+	}
+	res := make([]*Webhook, 0)
+	if err := d.db().Select(&res, `
+	SELECT `+webhookColumns+` FROM webhook
+	WHERE wh_hu_id = $1
+	ORDER BY wh_id
+	`, user.ID); err != nil {
+		panic(err)
+	}
+	return res
+}
+
+func (d *sqlxPostgreSQL) deleteWebhookByCredential(id int, token string) *Webhook {
+	var res Webhook
+	if err := d.db().Get(&res, `
+	DELETE FROM webhook
+	WHERE wh_id = $1 AND wh_hu_id = (
+		SELECT hu_id FROM hellofresh_user
+		WHERE hu_access_token = $2
+	)
+	RETURNING `+webhookColumns, id, token); err != nil {
+		return nil
+	}
+	return &res
+}
+
+func (d *sqlxPostgreSQL) listWebhookDeliveriesByCredential(id int, f *WebhookDeliveryFilter, token string, p *paging) []*WebhookDelivery {
+	if f == nil || p == nil {
+		panic("nil *WebhookDeliveryFilter or *paging variable not allowed")
+	}
+	var webhookID int
+	if err := d.db().Get(&webhookID, `
+	SELECT wh_id FROM webhook
+	INNER JOIN hellofresh_user
+	ON webhook.wh_hu_id = hellofresh_user.hu_id
+	WHERE wh_id = $1 AND hu_access_token = $2
+	`, id, token); err != nil {
+		return nil
+	}
+	res := make([]*WebhookDelivery, 0)
+	if err := d.db().Select(&res, `
+	SELECT `+webhookDeliveryColumns+` FROM webhook_delivery
+	WHERE wd_wh_id = $1 AND ($2 = '' OR wd_status = $2)
+	ORDER BY wd_id DESC`+p.limitClause()+p.offsetClause(), webhookID, f.Status); err != nil {
+		panic(err)
+	}
+	return res
+}
+
+func (d *sqlxPostgreSQL) redeliverWebhookDeliveryByCredential(id int, deliveryID int, token string) *WebhookDelivery {
+	var res WebhookDelivery
+	if err := d.db().Get(&res, `
+	UPDATE webhook_delivery
+	SET	wd_status = $1,
+		wd_attempts = 0,
+		wd_next_attempt_at = now(),
+		wd_response_status = NULL,
+		wd_error = NULL,
+		wd_delivered_at = NULL
+	WHERE wd_id = $2 AND wd_wh_id = (
+		SELECT wh_id FROM webhook
+		INNER JOIN hellofresh_user
+		ON webhook.wh_hu_id = hellofresh_user.hu_id
+		WHERE wh_id = $3 AND hu_access_token = $4
+	)
+	RETURNING `+webhookDeliveryColumns, webhookDeliveryPending, deliveryID, id, token); err != nil {
+		return nil
+	}
+	return &res
+}
+
+// enqueueWebhookDeliveries queues a delivery of the event to the webhooks
+// of the recipe owner, and to the webhooks of every admin. The deliveries
+// are traced under the traceparent of the change that made the event.
+func (d *sqlxPostgreSQL) enqueueWebhookDeliveries(eventType string, recipeID int, payload []byte, traceparent string) int64 {
+	res := d.db().MustExec(`
+	INSERT INTO webhook_delivery(wd_wh_id, wd_event, wd_payload, wd_traceparent)
+	SELECT wh_id, $1, $2::JSONB, $4 FROM webhook
+	INNER JOIN hellofresh_user
+	ON webhook.wh_hu_id = hellofresh_user.hu_id
+	WHERE (cardinality(wh_events) = 0 OR $1 = ANY(wh_events)) AND (
+		hu_admin OR EXISTS (
+			SELECT 1 FROM hellofresh_user_recipe
+			WHERE hur_hu_id = hu_id AND hur_r_id = $3
+		)
+	)
+	`, eventType, string(payload), recipeID, traceparent)
+	cnt, err := res.RowsAffected()
+	if err != nil {
+		panic(err)
+	}
+	return cnt
+}
+
+// claimWebhookDeliveries takes the pending deliveries that are due and
+// holds them until the given time, so that no other worker takes them
+// while they are being delivered.
+func (d *sqlxPostgreSQL) claimWebhookDeliveries(heldUntil time.Time, limit int) []*webhookAttempt {
+	res := make([]*webhookAttempt, 0)
+	if err := d.db().Select(&res, `
+	WITH claimed AS (
+		UPDATE webhook_delivery
+		SET	wd_next_attempt_at = $1
+		WHERE wd_id IN (
+			SELECT wd_id FROM webhook_delivery
+			WHERE wd_status = $2 AND wd_next_attempt_at <= now()
+			ORDER BY wd_next_attempt_at, wd_id
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+webhookDeliveryColumns+`, wd_traceparent
+	)
+	SELECT `+webhookDeliveryColumns+`, wd_traceparent, wh_url, wh_secret FROM claimed
+	INNER JOIN webhook
+	ON claimed.wd_wh_id = webhook.wh_id
+	ORDER BY wd_id
+	`, heldUntil, webhookDeliveryPending, limit); err != nil {
+		panic(err)
+	}
+	return res
+}
+
+func (d *sqlxPostgreSQL) recordWebhookDeliveryAttempt(w *WebhookDelivery) {
+	d.db().MustExec(`
+	UPDATE webhook_delivery
+	SET	wd_status = $1,
+		wd_attempts = $2,
+		wd_next_attempt_at = $3,
+		wd_response_status = $4,
+		wd_error = $5,
+		wd_delivered_at = $6
+	WHERE wd_id = $7
+	`, w.Status, w.Attempts, w.NextAttemptAt, w.ResponseStatus, w.Error, w.DeliveredAt, w.ID)
+}
+
+type recipeOutboxEntry struct {
+	recipeEvent
+	Payload stdjson.RawMessage `db:"ro_payload"`
+}
+
+// relayRecipeEvents hands up to limit unpublished events of the outbox to
+// publish in the order they were written, and marks the ones it accepts as
+// published. Once publish rejects an event, the later events of the same
+// recipe are held back until the next relay, and the events after them are
+// read instead, so that a rejected recipe can't hold back the others. The
+// events of a recipe are thus published in order, but the events of
+// different recipes aren't: a held-back event is published after the later
+// events of the other recipes. It returns how many events were published.
+func (d *sqlxPostgreSQL) relayRecipeEvents(limit int, publish func(*recipeEvent) bool) int {
+	tx := d.db().MustBegin()
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			panic(err)
+		}
+	}()
+	var locked bool
+	if err := tx.Get(&locked, `
+	SELECT pg_try_advisory_xact_lock($1)
+	`, recipeOutboxLock); err != nil {
+		panic(err)
+	}
+	if !locked {
+		return 0
+	}
+	held := make(map[int]bool)
+	heldIDs := make([]int64, 0)
+	published := make([]int64, 0, limit)
+	var lastID int64
+	for len(published) < limit {
+		size := limit - len(published)
+		entries := make([]*recipeOutboxEntry, 0)
+		if err := tx.Select(&entries, `
+		SELECT `+recipeOutboxColumns+` FROM recipe_outbox
+		LEFT JOIN hellofresh_user_recipe
+		ON recipe_outbox.ro_r_id = hellofresh_user_recipe.hur_r_id
+		LEFT JOIN hellofresh_user
+		ON hellofresh_user_recipe.hur_hu_id = hellofresh_user.hu_id
+		WHERE ro_published_at IS NULL AND ro_id > $1 AND NOT ro_r_id = ANY($2)
+		ORDER BY ro_id
+		LIMIT $3
+		`, lastID, pq.Array(heldIDs), size); err != nil {
+			panic(err)
+		}
+		for _, entry := range entries {
+			lastID = entry.ID
+			if held[entry.RecipeID] {
+				continue
+			}
+			e := entry.recipeEvent
+			if err := stdjson.Unmarshal(entry.Payload, &e.Recipe); err != nil {
+				panic(err)
+			}
+			if !publish(&e) {
+				held[entry.RecipeID] = true
+				heldIDs = append(heldIDs, int64(entry.RecipeID))
+				continue
+			}
+			published = append(published, e.ID)
+		}
+		if len(entries) < size {
+			break
+		}
+	}
+	tx.MustExec(`
+	UPDATE recipe_outbox
+	SET	ro_published_at = now()
+	WHERE ro_id = ANY($1)
+	`, pq.Array(published))
+	if err := tx.Commit(); err != nil {
+		panic(err)
+	}
+	return len(published)
+}
+
+func (d *sqlxPostgreSQL) purgeRecipeEvents(publishedBefore time.Time) int64 {
+	res := d.db().MustExec(`
+	DELETE FROM recipe_outbox
+	WHERE ro_published_at < $1
+	`, publishedBefore)
+	cnt, err := res.RowsAffected()
+	if err != nil {
+		panic(err)
+	}
+	return cnt
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"context"
+	"time"
+
 	_ "github.com/lib/pq"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -21,14 +24,19 @@ const (
 		r_difficulty SMALLINT,
 		r_vegetarian BOOLEAN NOT NULL,
 		r_rating REAL NOT NULL DEFAULT 0.0,
-		r_rated_num INTEGER NOT NULL DEFAULT 0
+		r_rated_num INTEGER NOT NULL DEFAULT 0,
+		r_publish_at TIMESTAMP WITH TIME ZONE,
+		r_unpublish_at TIMESTAMP WITH TIME ZONE,
+		r_deleted_at TIMESTAMP WITH TIME ZONE,
+		r_version INTEGER NOT NULL DEFAULT 1
 	)
 	`
 	testHellofreshUserTableSchema = `
 	CREATE TABLE hellofresh_user(
 		hu_id SERIAL PRIMARY KEY,
 		hu_account VARCHAR(32) NOT NULL UNIQUE,
-		hu_access_token VARCHAR(32) NOT NULL UNIQUE
+		hu_access_token VARCHAR(32) NOT NULL UNIQUE,
+		hu_admin BOOLEAN NOT NULL DEFAULT false
 	)
 	`
 	testHellofreshUserRecipeTableSchema = `
@@ -46,6 +54,97 @@ const (
 			ON UPDATE RESTRICT
 	)
 	`
+	testRecipeRevisionTableSchema = `
+	CREATE TABLE recipe_revision(
+		rr_r_id INTEGER NOT NULL,
+		rr_number INTEGER NOT NULL,
+		rr_hu_id INTEGER,
+		rr_created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+		rr_name VARCHAR(512) NOT NULL,
+		rr_prep_time SMALLINT,
+		rr_difficulty SMALLINT,
+		rr_vegetarian BOOLEAN NOT NULL,
+		rr_rating REAL NOT NULL DEFAULT 0.0,
+		rr_rated_num INTEGER NOT NULL DEFAULT 0,
+		rr_publish_at TIMESTAMP WITH TIME ZONE,
+		rr_unpublish_at TIMESTAMP WITH TIME ZONE,
+		rr_deleted_at TIMESTAMP WITH TIME ZONE,
+		rr_version INTEGER NOT NULL DEFAULT 1,
+		CONSTRAINT pk_recipe_revision PRIMARY KEY(rr_r_id, rr_number),
+		CONSTRAINT fk_recipe_revision__recipe FOREIGN KEY
+			(rr_r_id) REFERENCES recipe(r_id)
+			ON DELETE CASCADE
+			ON UPDATE RESTRICT,
+		CONSTRAINT fk_recipe_revision__hellofresh_user FOREIGN KEY
+			(rr_hu_id) REFERENCES hellofresh_user(hu_id)
+			ON DELETE SET NULL
+			ON UPDATE RESTRICT
+	)
+	`
+	testIdempotencyKeyTableSchema = `
+	CREATE TABLE idempotency_key(
+		ik_scope CHAR(64) NOT NULL,
+		ik_key VARCHAR(255) NOT NULL,
+		ik_fingerprint CHAR(64) NOT NULL,
+		ik_status SMALLINT NOT NULL DEFAULT 0,
+		ik_content_type VARCHAR(255) NOT NULL DEFAULT '',
+		ik_etag VARCHAR(255) NOT NULL DEFAULT '',
+		ik_body BYTEA NOT NULL DEFAULT '',
+		ik_created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+		CONSTRAINT pk_idempotency_key PRIMARY KEY(ik_scope, ik_key)
+	)
+	`
+	testWebhookTableSchema = `
+	CREATE TABLE webhook(
+		wh_id SERIAL PRIMARY KEY,
+		wh_hu_id INTEGER NOT NULL,
+		wh_url VARCHAR(2048) NOT NULL,
+		wh_secret CHAR(64) NOT NULL,
+		wh_events TEXT[] NOT NULL DEFAULT '{}',
+		wh_created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+		CONSTRAINT fk_webhook__hellofresh_user FOREIGN KEY
+			(wh_hu_id) REFERENCES hellofresh_user(hu_id)
+			ON DELETE CASCADE
+			ON UPDATE RESTRICT
+	)
+	`
+	testWebhookDeliveryTableSchema = `
+	CREATE TABLE webhook_delivery(
+		wd_id SERIAL PRIMARY KEY,
+		wd_wh_id INTEGER NOT NULL,
+		wd_event VARCHAR(64) NOT NULL,
+		wd_payload JSONB NOT NULL,
+		wd_status VARCHAR(16) NOT NULL DEFAULT 'pending',
+		wd_attempts INTEGER NOT NULL DEFAULT 0,
+		wd_next_attempt_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+		wd_response_status SMALLINT,
+		wd_error TEXT,
+		wd_created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+		wd_delivered_at TIMESTAMP WITH TIME ZONE,
+		wd_traceparent TEXT NOT NULL DEFAULT '',
+		CONSTRAINT fk_webhook_delivery__webhook FOREIGN KEY
+			(wd_wh_id) REFERENCES webhook(wh_id)
+			ON DELETE CASCADE
+			ON UPDATE RESTRICT
+	)
+	`
+	testRecipeOutboxTableSchema = `
+	CREATE TABLE recipe_outbox(
+		ro_id BIGSERIAL PRIMARY KEY,
+		ro_type VARCHAR(64) NOT NULL,
+		ro_r_id INTEGER NOT NULL,
+		ro_payload JSONB NOT NULL,
+		ro_created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+		ro_published_at TIMESTAMP WITH TIME ZONE,
+		ro_traceparent TEXT NOT NULL DEFAULT ''
+	)
+	`
+	testRecipeScheduleCheckpointTableSchema = `
+	CREATE TABLE recipe_schedule_checkpoint(
+		rsc_id BOOLEAN PRIMARY KEY DEFAULT true CHECK (rsc_id),
+		rsc_processed_until TIMESTAMP WITH TIME ZONE NOT NULL
+	)
+	`
 )
 
 var _ = Describe("Testing database object", skipIfDatabaseIsNotSet(func() {
@@ -86,6 +185,18 @@ var _ = Describe("Testing database object", skipIfDatabaseIsNotSet(func() {
 			`)
 			testDB.sqlxDB.MustExec(testHellofreshUserRecipeTableSchema)
 			testDB.sqlxDB.MustExec(`
+			DROP TABLE IF EXISTS recipe_revision
+			`)
+			testDB.sqlxDB.MustExec(testRecipeRevisionTableSchema)
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE IF EXISTS recipe_outbox
+			`)
+			testDB.sqlxDB.MustExec(testRecipeOutboxTableSchema)
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE IF EXISTS recipe_schedule_checkpoint
+			`)
+			testDB.sqlxDB.MustExec(testRecipeScheduleCheckpointTableSchema)
+			testDB.sqlxDB.MustExec(`
 			INSERT INTO hellofresh_user(hu_account, hu_access_token)
 			VALUES
 			('foo', 'faketoken')
@@ -95,6 +206,15 @@ var _ = Describe("Testing database object", skipIfDatabaseIsNotSet(func() {
 			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
 			defer testDB.close()
 
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE recipe_schedule_checkpoint
+			`)
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE recipe_outbox
+			`)
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE recipe_revision
+			`)
 			testDB.sqlxDB.MustExec(`
 			DROP TABLE hellofresh_user_recipe
 			`)
@@ -207,6 +327,63 @@ var _ = Describe("Testing database object", skipIfDatabaseIsNotSet(func() {
 				IsVegetarian:   "false",
 			}, newPaging())).To(HaveLen(0))
 		})
+		It("lists only the recipes within their publishing window", func() {
+			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
+			defer testDB.close()
+
+			now := time.Now()
+			testDB.addRecipeByCredential(&PostRecipeArg{
+				Name:         null.StringFrom("name1"),
+				IsVegetarian: null.BoolFrom(false),
+			}, "faketoken")
+			testDB.addRecipeByCredential(&PostRecipeArg{
+				Name:         null.StringFrom("name2"),
+				IsVegetarian: null.BoolFrom(false),
+				PublishAt:    null.TimeFrom(now.Add(time.Hour)),
+			}, "faketoken")
+			testDB.addRecipeByCredential(&PostRecipeArg{
+				Name:         null.StringFrom("name3"),
+				IsVegetarian: null.BoolFrom(false),
+				PublishAt:    null.TimeFrom(now.Add(-time.Hour)),
+				UnpublishAt:  null.TimeFrom(now.Add(-time.Minute)),
+			}, "faketoken")
+			testDB.addRecipeByCredential(&PostRecipeArg{
+				Name:         null.StringFrom("name4"),
+				IsVegetarian: null.BoolFrom(false),
+				PublishAt:    null.TimeFrom(now.Add(-time.Hour)),
+				UnpublishAt:  null.TimeFrom(now.Add(time.Hour)),
+			}, "faketoken")
+			Expect(testDB.listRecipes(&ListFilter{}, newPaging())).To(HaveLen(2))
+			Expect(testDB.getRecipeByID(1)).NotTo(BeNil())
+			Expect(testDB.getRecipeByID(2)).To(BeNil())
+			Expect(testDB.getRecipeByID(3)).To(BeNil())
+			Expect(testDB.getRecipeByID(4)).NotTo(BeNil())
+
+			testDB.sqlxDB.MustExec(`
+			DELETE FROM recipe_outbox
+			`)
+			testDB.sqlxDB.MustExec(`
+			INSERT INTO recipe_schedule_checkpoint(rsc_processed_until)
+			VALUES ($1)
+			`, now.Add(-2*time.Hour))
+			Expect(testDB.publishRecipeScheduleEvents(now)).To(Equal(3))
+			Expect(testDB.publishRecipeScheduleEvents(now.Add(time.Minute))).To(Equal(0))
+			var events []struct {
+				Type     string `db:"ro_type"`
+				RecipeID int    `db:"ro_r_id"`
+			}
+			Expect(testDB.sqlxDB.Select(&events, `
+			SELECT ro_type, ro_r_id FROM recipe_outbox
+			ORDER BY ro_id
+			`)).To(Succeed())
+			Expect(events).To(HaveLen(3))
+			Expect(events[0].Type).To(Equal(recipePublishedEvent))
+			Expect(events[2].Type).To(Equal(recipeUnpublishedEvent))
+			Expect(events[2].RecipeID).To(Equal(3))
+			next := testDB.nextRecipeScheduleTime(now)
+			Expect(next.Valid).To(BeTrue())
+			Expect(next.Time).To(BeTemporally("~", now.Add(time.Hour), time.Millisecond))
+		})
 	})
 	Context("adding a new recipe", func() {
 		BeforeEach(func() {
@@ -226,6 +403,14 @@ var _ = Describe("Testing database object", skipIfDatabaseIsNotSet(func() {
 			`)
 			testDB.sqlxDB.MustExec(testHellofreshUserRecipeTableSchema)
 			testDB.sqlxDB.MustExec(`
+			DROP TABLE IF EXISTS recipe_revision
+			`)
+			testDB.sqlxDB.MustExec(testRecipeRevisionTableSchema)
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE IF EXISTS recipe_outbox
+			`)
+			testDB.sqlxDB.MustExec(testRecipeOutboxTableSchema)
+			testDB.sqlxDB.MustExec(`
 			INSERT INTO hellofresh_user(hu_account, hu_access_token)
 			VALUES
 			('foo', 'faketoken')
@@ -235,6 +420,12 @@ var _ = Describe("Testing database object", skipIfDatabaseIsNotSet(func() {
 			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
 			defer testDB.close()
 
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE recipe_outbox
+			`)
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE recipe_revision
+			`)
 			testDB.sqlxDB.MustExec(`
 			DROP TABLE hellofresh_user_recipe
 			`)
@@ -284,6 +475,51 @@ var _ = Describe("Testing database object", skipIfDatabaseIsNotSet(func() {
 			Expect(actual).To(BeNil())
 			Expect(testDB.listRecipes(&ListFilter{}, newPaging())).To(HaveLen(0))
 		})
+		It("records the user of a credential it looks up for the request", func() {
+			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
+			defer testDB.close()
+
+			ctx, user := contextWithRequestUser(context.Background())
+			Expect(testDB.withContext(ctx).getAccountByCredential("faketoken")).To(Equal(null.StringFrom("foo")))
+			id, ok := user.get()
+			Expect(ok).To(BeTrue())
+			Expect(id).To(Equal(int64(1)))
+
+			ctx, user = contextWithRequestUser(context.Background())
+			Expect(testDB.withContext(ctx).isAdminByCredential("faild_token")).To(BeFalse())
+			_, ok = user.get()
+			Expect(ok).To(BeFalse())
+			Expect(testDB.getAccountByCredential("faild_token").Valid).To(BeFalse())
+		})
+		It("traces the statements and keeps the traceparent of the event", func() {
+			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
+			defer testDB.close()
+
+			exporter := &recordingSpanExporter{}
+			t := newTracer(exporter, time.Hour)
+			go t.run()
+			root := t.startSpan(spanContext{}, "test", spanKindInternal)
+			d := &instrumentedDatastore{datastore: testDB, ctx: contextWithSpan(context.Background(), root)}
+			Expect(d.addRecipeByCredential(&PostRecipeArg{
+				Name:         null.StringFrom("name1"),
+				IsVegetarian: null.BoolFrom(false),
+			}, "faketoken")).NotTo(BeNil())
+			root.finish()
+			stopTracer(t)
+
+			call := exporter.span("datastore.addRecipeByCredential")
+			Expect(call).NotTo(BeNil())
+			Expect(call.parent).To(Equal(root.context.spanID))
+			insert := exporter.span("INSERT")
+			Expect(insert).NotTo(BeNil())
+			Expect(insert.parent).To(Equal(call.context.spanID))
+			Expect(insert.attributes).To(HaveKeyWithValue("db.system", "postgresql"))
+			Expect(insert.attributes["db.statement"]).To(HavePrefix("INSERT INTO"))
+
+			var traceparent string
+			Expect(testDB.sqlxDB.Get(&traceparent, `SELECT ro_traceparent FROM recipe_outbox`)).To(Succeed())
+			Expect(traceparent).To(Equal(call.context.traceparent()))
+		})
 	})
 	Context("updating a recipe", func() {
 		BeforeEach(func() {
@@ -302,6 +538,14 @@ var _ = Describe("Testing database object", skipIfDatabaseIsNotSet(func() {
 			DROP TABLE IF EXISTS hellofresh_user_recipe
 			`)
 			testDB.sqlxDB.MustExec(testHellofreshUserRecipeTableSchema)
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE IF EXISTS recipe_revision
+			`)
+			testDB.sqlxDB.MustExec(testRecipeRevisionTableSchema)
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE IF EXISTS recipe_outbox
+			`)
+			testDB.sqlxDB.MustExec(testRecipeOutboxTableSchema)
 
 			testDB.sqlxDB.MustExec(`
 			INSERT INTO hellofresh_user(hu_account, hu_access_token)
@@ -319,6 +563,12 @@ var _ = Describe("Testing database object", skipIfDatabaseIsNotSet(func() {
 			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
 			defer testDB.close()
 
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE recipe_outbox
+			`)
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE recipe_revision
+			`)
 			testDB.sqlxDB.MustExec(`
 			DROP TABLE hellofresh_user_recipe
 			`)
@@ -338,7 +588,7 @@ var _ = Describe("Testing database object", skipIfDatabaseIsNotSet(func() {
 				PrepareTime:  null.IntFrom(3),
 				Difficulty:   null.IntFrom(4),
 				IsVegetarian: null.BoolFrom(false),
-			}, 1, "faketoken")
+			}, 1, 0, "faketoken")
 
 			Expect(actual.Name).To(Equal("name1_updated"))
 			Expect(actual.PrepareTime.Int64).To(Equal(int64(3)))
@@ -354,7 +604,7 @@ var _ = Describe("Testing database object", skipIfDatabaseIsNotSet(func() {
 				PrepareTime:  null.IntFrom(3),
 				Difficulty:   null.IntFrom(4),
 				IsVegetarian: null.BoolFrom(false),
-			}, 2, "faketoken")
+			}, 2, 0, "faketoken")
 
 			Expect(actual).To(BeNil())
 		})
@@ -367,10 +617,135 @@ var _ = Describe("Testing database object", skipIfDatabaseIsNotSet(func() {
 				PrepareTime:  null.IntFrom(3),
 				Difficulty:   null.IntFrom(4),
 				IsVegetarian: null.BoolFrom(false),
-			}, 1, "failed_faketoken")
+			}, 1, 0, "failed_faketoken")
 
 			Expect(actual).To(BeNil())
 		})
+		It("updates the recipe only if its version matches", func() {
+			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
+			defer testDB.close()
+
+			Expect(testDB.getRecipeVersionByCredential(1, "faketoken").Int64).To(Equal(int64(1)))
+			Expect(testDB.getRecipeVersionByCredential(1, "failed_faketoken").Valid).To(BeFalse())
+			Expect(testDB.updateAndGetRecipeByCredential(&PutRecipeArg{
+				Name:         null.StringFrom("name1_updated"),
+				IsVegetarian: null.BoolFrom(false),
+			}, 1, 2, "faketoken")).To(BeNil())
+
+			actual := testDB.updateAndGetRecipeByCredential(&PutRecipeArg{
+				Name:         null.StringFrom("name1_updated"),
+				IsVegetarian: null.BoolFrom(false),
+			}, 1, 1, "faketoken")
+			Expect(actual.Name).To(Equal("name1_updated"))
+			Expect(actual.Version).To(Equal(2))
+			Expect(testDB.getRecipeVersionByCredential(1, "faketoken").Int64).To(Equal(int64(2)))
+
+			Expect(testDB.deleteAndGetRecipeByCredential(1, 1, "faketoken")).To(BeNil())
+			Expect(testDB.deleteAndGetRecipeByCredential(1, 2, "faketoken").Version).To(Equal(3))
+		})
+		It("gets a recipe of the user regardless of its publishing window", func() {
+			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
+			defer testDB.close()
+
+			testDB.updateAndGetRecipeByCredential(&PutRecipeArg{
+				Name:         null.StringFrom("name1"),
+				IsVegetarian: null.BoolFrom(false),
+				PublishAt:    null.TimeFrom(time.Now().Add(time.Hour)),
+			}, 1, 0, "faketoken")
+			Expect(testDB.getRecipeByID(1)).To(BeNil())
+			Expect(testDB.getRecipeByCredential(1, "faketoken").Name).To(Equal("name1"))
+			Expect(testDB.getRecipeByCredential(1, "failed_faketoken")).To(BeNil())
+			Expect(testDB.getRecipeByCredential(2, "faketoken")).To(BeNil())
+		})
+		It("records a revision for every change of the recipe", func() {
+			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
+			defer testDB.close()
+
+			testDB.updateAndGetRecipeByCredential(&PutRecipeArg{
+				Name:         null.StringFrom("name1_updated"),
+				PrepareTime:  null.IntFrom(2),
+				IsVegetarian: null.BoolFrom(false),
+			}, 1, 0, "faketoken")
+			testDB.updateAndGetRecipeByCredential(&PutRecipeArg{
+				Name:         null.StringFrom("name1_updated"),
+				PrepareTime:  null.IntFrom(10),
+				IsVegetarian: null.BoolFrom(false),
+			}, 1, 0, "faketoken")
+
+			revisions := testDB.listRecipeRevisionsByCredential(1, "faketoken")
+			Expect(revisions).To(HaveLen(3))
+			Expect(revisions[0].Revision).To(Equal(1))
+			Expect(revisions[0].Name).To(Equal("name1"))
+			Expect(revisions[0].Author.String).To(Equal("foo"))
+			Expect(revisions[2].Name).To(Equal("name1_updated"))
+			Expect(revisions[2].PrepareTime.Int64).To(Equal(int64(10)))
+			Expect(testDB.listRecipeRevisionsByCredential(1, "failed_faketoken")).To(BeNil())
+
+			revision := testDB.getRecipeRevisionByCredential(1, 2, "faketoken")
+			Expect(revision.Name).To(Equal("name1_updated"))
+			Expect(revision.PrepareTime.Int64).To(Equal(int64(2)))
+			Expect(testDB.getRecipeRevisionByCredential(1, 4, "faketoken")).To(BeNil())
+			Expect(testDB.getRecipeRevisionByCredential(1, 2, "failed_faketoken")).To(BeNil())
+		})
+		It("restores a recipe to a previous revision", func() {
+			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
+			defer testDB.close()
+
+			testDB.updateAndGetRecipeByCredential(&PutRecipeArg{
+				Name:         null.StringFrom("name1_updated"),
+				PrepareTime:  null.IntFrom(10),
+				IsVegetarian: null.BoolFrom(false),
+			}, 1, 0, "faketoken")
+
+			Expect(testDB.restoreRecipeRevisionByCredential(1, 1, "failed_faketoken")).To(BeNil())
+			Expect(testDB.restoreRecipeRevisionByCredential(1, 3, "faketoken")).To(BeNil())
+			actual := testDB.restoreRecipeRevisionByCredential(1, 1, "faketoken")
+			Expect(actual.Name).To(Equal("name1"))
+			Expect(actual.PrepareTime.Int64).To(Equal(int64(2)))
+			Expect(testDB.getRecipeByID(1).Name).To(Equal("name1"))
+			Expect(testDB.listRecipeRevisionsByCredential(1, "faketoken")).To(HaveLen(3))
+		})
+		It("executes a batch of operations all or nothing", func() {
+			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
+			defer testDB.close()
+
+			ops := []*RecipeBatchOperation{
+				{Op: recipeBatchCreate, Recipe: &PostRecipeArg{Name: null.StringFrom("name2"), IsVegetarian: null.BoolFrom(true)}},
+				{Op: recipeBatchUpdate, ID: 1, Recipe: &PostRecipeArg{Name: null.StringFrom("name1_updated"), IsVegetarian: null.BoolFrom(false)}},
+				{Op: recipeBatchDelete, ID: 3},
+			}
+			actual := testDB.executeRecipeBatchByCredential(ops, true, "faketoken")
+			Expect(actual).To(HaveLen(3))
+			Expect(actual[0]).NotTo(BeNil())
+			Expect(actual[1]).NotTo(BeNil())
+			Expect(actual[2]).To(BeNil())
+			Expect(testDB.getRecipeByID(1).Name).To(Equal("name1"))
+			Expect(testDB.getRecipeByCredential(actual[0].ID, "faketoken")).To(BeNil())
+
+			ops[2] = &RecipeBatchOperation{Op: recipeBatchDelete, ID: 1, Version: 2}
+			actual = testDB.executeRecipeBatchByCredential(ops, true, "faketoken")
+			Expect(actual[0].Name).To(Equal("name2"))
+			Expect(actual[1].Name).To(Equal("name1_updated"))
+			Expect(actual[2].DeletedAt.Valid).To(BeTrue())
+			Expect(testDB.getRecipeByID(1)).To(BeNil())
+			Expect(testDB.getRecipeByID(actual[0].ID).Name).To(Equal("name2"))
+		})
+		It("executes a batch of operations on a best-effort basis", func() {
+			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
+			defer testDB.close()
+
+			actual := testDB.executeRecipeBatchByCredential([]*RecipeBatchOperation{
+				{Op: recipeBatchDelete, ID: 1, Version: 5},
+				{Op: recipeBatchCreate, Recipe: &PostRecipeArg{Name: null.StringFrom("name2"), IsVegetarian: null.BoolFrom(true)}},
+				{Op: recipeBatchUpdate, ID: 1, Recipe: &PostRecipeArg{Name: null.StringFrom("name1_updated"), IsVegetarian: null.BoolFrom(false)}},
+			}, false, "faketoken")
+			Expect(actual).To(HaveLen(3))
+			Expect(actual[0]).To(BeNil())
+			Expect(actual[1].Name).To(Equal("name2"))
+			Expect(actual[2].Name).To(Equal("name1_updated"))
+			Expect(testDB.getRecipeByID(1).Name).To(Equal("name1_updated"))
+			Expect(testDB.getRecipeByID(actual[1].ID).Name).To(Equal("name2"))
+		})
 	})
 	Context("deleting a recipe", func() {
 		BeforeEach(func() {
@@ -390,6 +765,14 @@ var _ = Describe("Testing database object", skipIfDatabaseIsNotSet(func() {
 			`)
 			testDB.sqlxDB.MustExec(testHellofreshUserRecipeTableSchema)
 			testDB.sqlxDB.MustExec(`
+			DROP TABLE IF EXISTS recipe_revision
+			`)
+			testDB.sqlxDB.MustExec(testRecipeRevisionTableSchema)
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE IF EXISTS recipe_outbox
+			`)
+			testDB.sqlxDB.MustExec(testRecipeOutboxTableSchema)
+			testDB.sqlxDB.MustExec(`
 			INSERT INTO hellofresh_user(hu_account, hu_access_token)
 			VALUES
 			('foo', 'faketoken')
@@ -411,6 +794,12 @@ var _ = Describe("Testing database object", skipIfDatabaseIsNotSet(func() {
 			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
 			defer testDB.close()
 
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE recipe_outbox
+			`)
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE recipe_revision
+			`)
 			testDB.sqlxDB.MustExec(`
 			DROP TABLE hellofresh_user_recipe
 			`)
@@ -425,7 +814,7 @@ var _ = Describe("Testing database object", skipIfDatabaseIsNotSet(func() {
 			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
 			defer testDB.close()
 
-			deletedRecipe := testDB.deleteAndGetRecipeByCredential(1, "faketoken")
+			deletedRecipe := testDB.deleteAndGetRecipeByCredential(1, 0, "faketoken")
 			Expect(testDB.getRecipeByID(1)).To(BeNil())
 			Expect(testDB.getRecipeByID(2)).NotTo(BeNil())
 			Expect(deletedRecipe.Name).To(Equal("name1"))
@@ -438,7 +827,7 @@ var _ = Describe("Testing database object", skipIfDatabaseIsNotSet(func() {
 			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
 			defer testDB.close()
 
-			deletedRecipe := testDB.deleteAndGetRecipeByCredential(3, "faketoken")
+			deletedRecipe := testDB.deleteAndGetRecipeByCredential(3, 0, "faketoken")
 			Expect(deletedRecipe).To(BeNil())
 			Expect(testDB.getRecipeByID(1)).NotTo(BeNil())
 			Expect(testDB.getRecipeByID(2)).NotTo(BeNil())
@@ -448,12 +837,58 @@ var _ = Describe("Testing database object", skipIfDatabaseIsNotSet(func() {
 			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
 			defer testDB.close()
 
-			deletedRecipe := testDB.deleteAndGetRecipeByCredential(1, "failed_token")
+			deletedRecipe := testDB.deleteAndGetRecipeByCredential(1, 0, "failed_token")
 			Expect(deletedRecipe).To(BeNil())
 			Expect(testDB.getRecipeByID(1)).NotTo(BeNil())
 			Expect(testDB.getRecipeByID(2)).NotTo(BeNil())
 			Expect(testDB.listRecipes(&ListFilter{}, newPaging())).To(HaveLen(2))
 		})
+		It("keeps a deleted recipe in the trash of its owner", func() {
+			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
+			defer testDB.close()
+
+			deletedRecipe := testDB.deleteAndGetRecipeByCredential(1, 0, "faketoken")
+			Expect(deletedRecipe.DeletedAt.Valid).To(BeTrue())
+			Expect(testDB.deleteAndGetRecipeByCredential(1, 0, "faketoken")).To(BeNil())
+			Expect(testDB.listDeletedRecipesByCredential("faketoken", newPaging())).To(HaveLen(1))
+			Expect(testDB.listDeletedRecipesByCredential("failed_token", newPaging())).To(BeNil())
+			Expect(testDB.updateAndGetRecipeByCredential(&PutRecipeArg{
+				Name:         null.StringFrom("name1_updated"),
+				IsVegetarian: null.BoolFrom(false),
+			}, 1, 0, "faketoken")).To(BeNil())
+			Expect(testDB.rateAndGetRecipe(&PostRateRecipeArg{
+				Rating: null.IntFrom(3),
+			}, 1, "")).To(BeNil())
+		})
+		It("restores a deleted recipe", func() {
+			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
+			defer testDB.close()
+
+			Expect(testDB.restoreAndGetRecipeByCredential(1, "faketoken")).To(BeNil())
+			testDB.deleteAndGetRecipeByCredential(1, 0, "faketoken")
+			Expect(testDB.restoreAndGetRecipeByCredential(1, "failed_token")).To(BeNil())
+			restoredRecipe := testDB.restoreAndGetRecipeByCredential(1, "faketoken")
+			Expect(restoredRecipe.Name).To(Equal("name1"))
+			Expect(restoredRecipe.DeletedAt.Valid).To(BeFalse())
+			revisions := testDB.listRecipeRevisionsByCredential(1, "faketoken")
+			Expect(revisions).To(HaveLen(3))
+			Expect(revisions[1].DeletedAt.Valid).To(BeTrue())
+			Expect(revisions[1].Author.String).To(Equal("foo"))
+			Expect(revisions[2].DeletedAt.Valid).To(BeFalse())
+			Expect(testDB.getRecipeByID(1)).NotTo(BeNil())
+			Expect(testDB.listDeletedRecipesByCredential("faketoken", newPaging())).To(HaveLen(0))
+		})
+		It("purges the recipes deleted before the given time", func() {
+			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
+			defer testDB.close()
+
+			testDB.deleteAndGetRecipeByCredential(1, 0, "faketoken")
+			Expect(testDB.purgeDeletedRecipes(time.Now().Add(-time.Hour))).To(Equal(int64(0)))
+			Expect(testDB.purgeDeletedRecipes(time.Now().Add(time.Hour))).To(Equal(int64(1)))
+			Expect(testDB.listDeletedRecipesByCredential("faketoken", newPaging())).To(HaveLen(0))
+			Expect(testDB.restoreAndGetRecipeByCredential(1, "faketoken")).To(BeNil())
+			Expect(testDB.getRecipeByID(2)).NotTo(BeNil())
+		})
 	})
 	Context("rating a recipe", func() {
 		BeforeEach(func() {
@@ -472,6 +907,14 @@ var _ = Describe("Testing database object", skipIfDatabaseIsNotSet(func() {
 			DROP TABLE IF EXISTS hellofresh_user_recipe
 			`)
 			testDB.sqlxDB.MustExec(testHellofreshUserRecipeTableSchema)
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE IF EXISTS recipe_revision
+			`)
+			testDB.sqlxDB.MustExec(testRecipeRevisionTableSchema)
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE IF EXISTS recipe_outbox
+			`)
+			testDB.sqlxDB.MustExec(testRecipeOutboxTableSchema)
 
 			testDB.sqlxDB.MustExec(`
 			INSERT INTO hellofresh_user(hu_account, hu_access_token)
@@ -489,6 +932,12 @@ var _ = Describe("Testing database object", skipIfDatabaseIsNotSet(func() {
 			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
 			defer testDB.close()
 
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE recipe_outbox
+			`)
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE recipe_revision
+			`)
 			testDB.sqlxDB.MustExec(`
 			DROP TABLE hellofresh_user_recipe
 			`)
@@ -505,24 +954,32 @@ var _ = Describe("Testing database object", skipIfDatabaseIsNotSet(func() {
 
 			actual := testDB.rateAndGetRecipe(&PostRateRecipeArg{
 				Rating: null.IntFrom(3),
-			}, 1)
+			}, 1, "")
 			Expect(actual.Name).To(Equal("name1"))
 			Expect(actual.RatedNum.Int64).To(Equal(int64(1)))
 			Expect(actual.Rating.Float64).To(Equal(float64(3)))
 
 			actual = testDB.rateAndGetRecipe(&PostRateRecipeArg{
 				Rating: null.IntFrom(4),
-			}, 1)
+			}, 1, "faketoken")
 			Expect(actual.Name).To(Equal("name1"))
 			Expect(actual.RatedNum.Int64).To(Equal(int64(2)))
 			Expect(actual.Rating.Float64).To(Equal(float64(3.5)))
 
 			actual = testDB.rateAndGetRecipe(&PostRateRecipeArg{
 				Rating: null.IntFrom(5),
-			}, 1)
+			}, 1, "")
 			Expect(actual.Name).To(Equal("name1"))
 			Expect(actual.RatedNum.Int64).To(Equal(int64(3)))
 			Expect(actual.Rating.Float64).To(Equal(float64(4)))
+			Expect(actual.Version).To(Equal(4))
+
+			revisions := testDB.listRecipeRevisionsByCredential(1, "faketoken")
+			Expect(revisions).To(HaveLen(4))
+			Expect(revisions[2].Author).To(Equal(null.StringFrom("foo")))
+			Expect(revisions[3].Author.Valid).To(BeFalse())
+			Expect(revisions[3].RatedNum.Int64).To(Equal(int64(3)))
+			Expect(revisions[3].Rating.Float64).To(Equal(float64(4)))
 		})
 		It("does nothing if the recipe doesn't exist", func() {
 			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
@@ -530,8 +987,610 @@ var _ = Describe("Testing database object", skipIfDatabaseIsNotSet(func() {
 
 			actual := testDB.rateAndGetRecipe(&PostRateRecipeArg{
 				Rating: null.IntFrom(3),
-			}, 2)
+			}, 2, "")
 			Expect(actual).To(BeNil())
 		})
+		It("does nothing if the recipe is deleted or unpublished", func() {
+			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
+			defer testDB.close()
+
+			testDB.sqlxDB.MustExec(`
+			UPDATE recipe SET r_deleted_at = now() WHERE r_id = 1
+			`)
+			Expect(testDB.rateAndGetRecipe(&PostRateRecipeArg{Rating: null.IntFrom(3)}, 1, "")).To(BeNil())
+			testDB.sqlxDB.MustExec(`
+			UPDATE recipe SET r_deleted_at = NULL, r_unpublish_at = now() - interval '1 hour' WHERE r_id = 1
+			`)
+			Expect(testDB.rateAndGetRecipe(&PostRateRecipeArg{Rating: null.IntFrom(3)}, 1, "")).To(BeNil())
+			Expect(testDB.getRecipeByCredential(1, "faketoken").RatedNum.Int64).To(Equal(int64(0)))
+		})
+	})
+	Context("exporting and importing recipes", func() {
+		BeforeEach(func() {
+			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
+			defer testDB.close()
+
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE IF EXISTS recipe
+			`)
+			testDB.sqlxDB.MustExec(testRecipeTableSchema)
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE IF EXISTS hellofresh_user
+			`)
+			testDB.sqlxDB.MustExec(testHellofreshUserTableSchema)
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE IF EXISTS hellofresh_user_recipe
+			`)
+			testDB.sqlxDB.MustExec(testHellofreshUserRecipeTableSchema)
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE IF EXISTS recipe_revision
+			`)
+			testDB.sqlxDB.MustExec(testRecipeRevisionTableSchema)
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE IF EXISTS recipe_outbox
+			`)
+			testDB.sqlxDB.MustExec(testRecipeOutboxTableSchema)
+
+			testDB.sqlxDB.MustExec(`
+			INSERT INTO hellofresh_user(hu_account, hu_access_token)
+			VALUES
+			('foo', 'faketoken'),
+			('bar', 'barfaketoken')
+			`)
+			testDB.addRecipeByCredential(&PostRecipeArg{
+				Name:         null.StringFrom("name1"),
+				PrepareTime:  null.IntFrom(2),
+				IsVegetarian: null.BoolFrom(false),
+			}, "faketoken")
+			testDB.rateAndGetRecipe(&PostRateRecipeArg{Rating: null.IntFrom(4)}, 1, "")
+		})
+		AfterEach(func() {
+			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
+			defer testDB.close()
+
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE recipe_outbox
+			`)
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE recipe_revision
+			`)
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE hellofresh_user_recipe
+			`)
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE hellofresh_user
+			`)
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE recipe
+			`)
+		})
+		It("exports the recipes of the user, or every recipe to an admin, with their owners", func() {
+			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
+			defer testDB.close()
+
+			testDB.deleteAndGetRecipeByCredential(1, 0, "faketoken")
+			testDB.addRecipeByCredential(&PostRecipeArg{
+				Name:         null.StringFrom("name2"),
+				IsVegetarian: null.BoolFrom(true),
+			}, "barfaketoken")
+			actual := make([]*RecipeExport, 0)
+			Expect(testDB.exportRecipesByCredential("faketoken", func(r *RecipeExport) {
+				actual = append(actual, r)
+			})).To(BeTrue())
+			Expect(actual).To(HaveLen(1))
+			Expect(actual[0].Name).To(Equal("name1"))
+			Expect(actual[0].Owner.String).To(Equal("foo"))
+			Expect(actual[0].RatedNum.Int64).To(Equal(int64(1)))
+			Expect(actual[0].DeletedAt.Valid).To(BeTrue())
+			Expect(testDB.exportRecipesByCredential("failed_faketoken", func(r *RecipeExport) {})).To(BeFalse())
+
+			testDB.sqlxDB.MustExec(`
+			UPDATE hellofresh_user SET hu_admin = true WHERE hu_account = 'foo'
+			`)
+			actual = make([]*RecipeExport, 0)
+			Expect(testDB.exportRecipesByCredential("faketoken", func(r *RecipeExport) {
+				actual = append(actual, r)
+			})).To(BeTrue())
+			Expect(actual).To(HaveLen(2))
+			Expect(actual[1].Name).To(Equal("name2"))
+			Expect(actual[1].Owner.String).To(Equal("bar"))
+		})
+		It("lists the owners of the given recipes", func() {
+			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
+			defer testDB.close()
+
+			testDB.addRecipeByCredential(&PostRecipeArg{
+				Name:         null.StringFrom("name2"),
+				IsVegetarian: null.BoolFrom(true),
+			}, "barfaketoken")
+			Expect(testDB.listRecipeOwners([]int{1, 2, 3})).To(Equal(map[int]string{1: "foo", 2: "bar"}))
+			Expect(testDB.listRecipeOwners([]int{})).To(BeEmpty())
+		})
+		It("imports recipes with the given conflict strategy", func() {
+			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
+			defer testDB.close()
+
+			recipes := []*RecipeExport{
+				{Recipe: Recipe{ID: 1, Name: "name1_imported", Rating: null.FloatFrom(5), RatedNum: null.IntFrom(3)}, Owner: null.StringFrom("bar")},
+				{Recipe: Recipe{ID: 7, Name: "name7", IsVegetarian: true}, Owner: null.StringFrom("bar")},
+				{Recipe: Recipe{ID: 8, Name: "name8"}, Owner: null.StringFrom("baz")},
+			}
+			Expect(testDB.importRecipesByCredential(recipes, &ImportRecipesArg{Strategy: recipeImportSkip}, "failed_faketoken")).To(BeNil())
+			testDB.sqlxDB.MustExec(`
+			UPDATE hellofresh_user SET hu_admin = true WHERE hu_account = 'foo'
+			`)
+
+			actual := testDB.importRecipesByCredential(recipes, &ImportRecipesArg{Strategy: recipeImportOverwrite, DryRun: true}, "faketoken")
+			Expect(actual[0].Action).To(Equal(recipeImportOverwritten))
+			Expect(actual[1].Action).To(Equal(recipeImportCreated))
+			Expect(actual[2].Action).To(Equal(recipeImportFailed))
+			Expect(testDB.getRecipeByID(1).Name).To(Equal("name1"))
+			Expect(testDB.getRecipeByID(7)).To(BeNil())
+
+			actual = testDB.importRecipesByCredential(recipes, &ImportRecipesArg{Strategy: recipeImportSkip}, "faketoken")
+			Expect(actual[0].Action).To(Equal(recipeImportSkipped))
+			Expect(actual[1].Action).To(Equal(recipeImportCreated))
+			Expect(actual[1].ID).To(Equal(7))
+			Expect(testDB.getRecipeByID(1).Name).To(Equal("name1"))
+			Expect(testDB.getRecipeByCredential(7, "barfaketoken").Name).To(Equal("name7"))
+
+			actual = testDB.importRecipesByCredential(recipes[:2], &ImportRecipesArg{Strategy: recipeImportRename}, "faketoken")
+			Expect(actual[0].Action).To(Equal(recipeImportRenamed))
+			Expect(actual[0].ID).To(Equal(8))
+			Expect(actual[1].Action).To(Equal(recipeImportRenamed))
+			Expect(actual[1].ID).To(Equal(9))
+
+			actual = testDB.importRecipesByCredential(recipes[:1], &ImportRecipesArg{Strategy: recipeImportOverwrite}, "faketoken")
+			Expect(actual[0].Action).To(Equal(recipeImportOverwritten))
+			Expect(testDB.getRecipeByID(1).Name).To(Equal("name1_imported"))
+			Expect(testDB.getRecipeByID(1).Rating.Float64).To(Equal(float64(5)))
+			Expect(testDB.getRecipeByCredential(1, "faketoken")).To(BeNil())
+			Expect(testDB.getRecipeByCredential(1, "barfaketoken").Name).To(Equal("name1_imported"))
+			Expect(testDB.addRecipeByCredential(&PostRecipeArg{
+				Name:         null.StringFrom("name10"),
+				IsVegetarian: null.BoolFrom(false),
+			}, "faketoken").ID).To(Equal(10))
+		})
+		It("imports only the user's own recipes unless the user is an admin", func() {
+			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
+			defer testDB.close()
+
+			recipes := []*RecipeExport{
+				{Recipe: Recipe{ID: 1, Name: "name1_imported"}, Owner: null.StringFrom("bar")},
+				{Recipe: Recipe{ID: 7, Name: "name7"}, Owner: null.StringFrom("foo")},
+				{Recipe: Recipe{ID: 8, Name: "name8"}, Owner: null.StringFrom("bar")},
+			}
+			actual := testDB.importRecipesByCredential(recipes, &ImportRecipesArg{Strategy: recipeImportOverwrite}, "barfaketoken")
+			Expect(actual[0].Action).To(Equal(recipeImportFailed))
+			Expect(actual[0].Error).To(Equal("only an admin can overwrite recipes of other users"))
+			Expect(actual[1].Action).To(Equal(recipeImportFailed))
+			Expect(actual[1].Error).To(Equal("only an admin can import recipes of other users"))
+			Expect(actual[2].Action).To(Equal(recipeImportCreated))
+			Expect(testDB.getRecipeByCredential(1, "faketoken").Name).To(Equal("name1"))
+			Expect(testDB.getRecipeByID(7)).To(BeNil())
+
+			recipes[0].Owner = null.StringFrom("foo")
+			actual = testDB.importRecipesByCredential(recipes[:1], &ImportRecipesArg{Strategy: recipeImportOverwrite}, "faketoken")
+			Expect(actual[0].Action).To(Equal(recipeImportOverwritten))
+			Expect(testDB.getRecipeByCredential(1, "faketoken").Name).To(Equal("name1_imported"))
+		})
+		It("imports the ratings of the recipes for an admin only", func() {
+			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
+			defer testDB.close()
+
+			testDB.sqlxDB.MustExec(`
+			UPDATE hellofresh_user SET hu_admin = true WHERE hu_account = 'foo'
+			`)
+			recipes := []*RecipeExport{
+				{Recipe: Recipe{ID: 8, Name: "name8", Rating: null.FloatFrom(4.5), RatedNum: null.IntFrom(2)}, Owner: null.StringFrom("bar")},
+			}
+			testDB.importRecipesByCredential(recipes, &ImportRecipesArg{}, "faketoken")
+			actual := testDB.getRecipeByID(8)
+			Expect(actual.Rating.Float64).To(Equal(4.5))
+			Expect(actual.RatedNum.Int64).To(Equal(int64(2)))
+
+			recipes = []*RecipeExport{
+				{Recipe: Recipe{ID: 8, Name: "name8", Rating: null.FloatFrom(5), RatedNum: null.IntFrom(100)}, Owner: null.StringFrom("bar")},
+				{Recipe: Recipe{ID: 9, Name: "name9", Rating: null.FloatFrom(5), RatedNum: null.IntFrom(100)}, Owner: null.StringFrom("bar")},
+			}
+			results := testDB.importRecipesByCredential(recipes, &ImportRecipesArg{Strategy: recipeImportOverwrite}, "barfaketoken")
+			Expect(results[0].Action).To(Equal(recipeImportOverwritten))
+			Expect(results[1].Action).To(Equal(recipeImportCreated))
+			actual = testDB.getRecipeByID(8)
+			Expect(actual.Rating.Float64).To(Equal(4.5))
+			Expect(actual.RatedNum.Int64).To(Equal(int64(2)))
+			actual = testDB.getRecipeByID(9)
+			Expect(actual.Rating.Float64).To(Equal(float64(0)))
+			Expect(actual.RatedNum.Int64).To(Equal(int64(0)))
+		})
+	})
+	Context("keeping idempotency keys", func() {
+		BeforeEach(func() {
+			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
+			defer testDB.close()
+
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE IF EXISTS idempotency_key
+			`)
+			testDB.sqlxDB.MustExec(testIdempotencyKeyTableSchema)
+		})
+		AfterEach(func() {
+			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
+			defer testDB.close()
+
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE idempotency_key
+			`)
+		})
+		It("reserves a key only once until it expires", func() {
+			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
+			defer testDB.close()
+
+			record := &idempotencyRecord{Scope: idempotencyScope("faketoken"), Key: "key1", Fingerprint: idempotencyScope("body")}
+			now := time.Now()
+			Expect(testDB.reserveIdempotencyRecord(record, now.Add(-time.Hour))).To(BeTrue())
+			Expect(testDB.reserveIdempotencyRecord(record, now.Add(-time.Hour))).To(BeFalse())
+			Expect(testDB.getIdempotencyRecord(record.Scope, record.Key, now.Add(-time.Hour)).completed()).To(BeFalse())
+
+			record.Status = 200
+			record.ContentType = "application/json; charset=utf-8"
+			record.Body = []byte(`{"id":1}`)
+			testDB.completeIdempotencyRecord(record)
+			actual := testDB.getIdempotencyRecord(record.Scope, record.Key, now.Add(-time.Hour))
+			Expect(actual.Status).To(Equal(200))
+			Expect(actual.Fingerprint).To(Equal(record.Fingerprint))
+			Expect(string(actual.Body)).To(Equal(`{"id":1}`))
+			Expect(testDB.getIdempotencyRecord(idempotencyScope("other"), record.Key, now.Add(-time.Hour))).To(BeNil())
+
+			Expect(testDB.getIdempotencyRecord(record.Scope, record.Key, now.Add(time.Hour))).To(BeNil())
+			Expect(testDB.reserveIdempotencyRecord(record, now.Add(time.Hour))).To(BeTrue())
+			Expect(testDB.getIdempotencyRecord(record.Scope, record.Key, now.Add(-time.Hour)).completed()).To(BeFalse())
+		})
+		It("releases an uncompleted key", func() {
+			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
+			defer testDB.close()
+
+			record := &idempotencyRecord{Scope: idempotencyScope("faketoken"), Key: "key1", Fingerprint: idempotencyScope("body")}
+			Expect(testDB.reserveIdempotencyRecord(record, time.Now().Add(-time.Hour))).To(BeTrue())
+			testDB.releaseIdempotencyRecord(record)
+			Expect(testDB.getIdempotencyRecord(record.Scope, record.Key, time.Now().Add(-time.Hour))).To(BeNil())
+			Expect(testDB.reserveIdempotencyRecord(record, time.Now().Add(-time.Hour))).To(BeTrue())
+		})
+		It("purges the keys created before the given time", func() {
+			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
+			defer testDB.close()
+
+			testDB.reserveIdempotencyRecord(&idempotencyRecord{Scope: idempotencyScope("faketoken"), Key: "key1"}, time.Now())
+			testDB.reserveIdempotencyRecord(&idempotencyRecord{Scope: idempotencyScope("faketoken"), Key: "key2"}, time.Now())
+			Expect(testDB.purgeIdempotencyRecords(time.Now().Add(-time.Hour))).To(Equal(int64(0)))
+			Expect(testDB.purgeIdempotencyRecords(time.Now().Add(time.Hour))).To(Equal(int64(2)))
+		})
+	})
+	Context("delivering webhooks", func() {
+		BeforeEach(func() {
+			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
+			defer testDB.close()
+
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE IF EXISTS recipe
+			`)
+			testDB.sqlxDB.MustExec(testRecipeTableSchema)
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE IF EXISTS hellofresh_user
+			`)
+			testDB.sqlxDB.MustExec(testHellofreshUserTableSchema)
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE IF EXISTS hellofresh_user_recipe
+			`)
+			testDB.sqlxDB.MustExec(testHellofreshUserRecipeTableSchema)
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE IF EXISTS recipe_revision
+			`)
+			testDB.sqlxDB.MustExec(testRecipeRevisionTableSchema)
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE IF EXISTS recipe_outbox
+			`)
+			testDB.sqlxDB.MustExec(testRecipeOutboxTableSchema)
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE IF EXISTS webhook
+			`)
+			testDB.sqlxDB.MustExec(testWebhookTableSchema)
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE IF EXISTS webhook_delivery
+			`)
+			testDB.sqlxDB.MustExec(testWebhookDeliveryTableSchema)
+
+			testDB.sqlxDB.MustExec(`
+			INSERT INTO hellofresh_user(hu_account, hu_access_token, hu_admin)
+			VALUES
+			('foo', 'faketoken', false),
+			('bar', 'barfaketoken', false),
+			('admin', 'adminfaketoken', true)
+			`)
+			testDB.addRecipeByCredential(&PostRecipeArg{
+				Name:         null.StringFrom("name1"),
+				IsVegetarian: null.BoolFrom(false),
+			}, "faketoken")
+		})
+		AfterEach(func() {
+			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
+			defer testDB.close()
+
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE webhook_delivery
+			`)
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE webhook
+			`)
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE recipe_outbox
+			`)
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE recipe_revision
+			`)
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE hellofresh_user_recipe
+			`)
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE hellofresh_user
+			`)
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE recipe
+			`)
+		})
+		It("manages the webhooks of a user", func() {
+			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
+			defer testDB.close()
+
+			webhook := testDB.addWebhookByCredential(&PostWebhookArg{URL: "http://localhost/hook"}, "secret", "faketoken")
+			Expect(webhook.ID).To(Equal(1))
+			Expect(webhook.Events).To(BeEmpty())
+			Expect(testDB.addWebhookByCredential(&PostWebhookArg{URL: "http://localhost/hook"}, "secret", "nofaketoken")).To(BeNil())
+
+			webhooks := testDB.listWebhooksByCredential("faketoken")
+			Expect(webhooks).To(HaveLen(1))
+			Expect(webhooks[0].Secret).To(BeEmpty())
+			Expect(testDB.listWebhooksByCredential("barfaketoken")).To(BeEmpty())
+
+			Expect(testDB.deleteWebhookByCredential(1, "barfaketoken")).To(BeNil())
+			Expect(testDB.deleteWebhookByCredential(1, "faketoken").URL).To(Equal("http://localhost/hook"))
+			Expect(testDB.listWebhooksByCredential("faketoken")).To(BeEmpty())
+		})
+		It("queues deliveries to the webhooks of the owner and the admins", func() {
+			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
+			defer testDB.close()
+
+			testDB.addWebhookByCredential(&PostWebhookArg{URL: "http://localhost/foo"}, "secret", "faketoken")
+			testDB.addWebhookByCredential(&PostWebhookArg{URL: "http://localhost/bar"}, "secret", "barfaketoken")
+			testDB.addWebhookByCredential(&PostWebhookArg{URL: "http://localhost/admin", Events: []string{recipeDeletedEvent}}, "secret", "adminfaketoken")
+
+			Expect(testDB.enqueueWebhookDeliveries(recipeCreatedEvent, 1, []byte(`{"recipe_id":1}`), "")).To(Equal(int64(1)))
+			Expect(testDB.enqueueWebhookDeliveries(recipeDeletedEvent, 1, []byte(`{"recipe_id":1}`), "")).To(Equal(int64(2)))
+			Expect(testDB.listWebhookDeliveriesByCredential(2, &WebhookDeliveryFilter{}, "barfaketoken", newPaging())).To(BeEmpty())
+			Expect(testDB.listWebhookDeliveriesByCredential(2, &WebhookDeliveryFilter{}, "faketoken", newPaging())).To(BeNil())
+
+			deliveries := testDB.listWebhookDeliveriesByCredential(1, &WebhookDeliveryFilter{}, "faketoken", newPaging())
+			Expect(deliveries).To(HaveLen(2))
+			Expect(deliveries[0].Event).To(Equal(recipeDeletedEvent))
+			Expect(string(deliveries[1].Payload)).To(MatchJSON(`{"recipe_id":1}`))
+		})
+		It("claims due deliveries and records their attempts", func() {
+			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
+			defer testDB.close()
+
+			testDB.addWebhookByCredential(&PostWebhookArg{URL: "http://localhost/foo"}, "secret", "faketoken")
+			testDB.enqueueWebhookDeliveries(recipeCreatedEvent, 1, []byte(`{"recipe_id":1}`), "")
+
+			attempts := testDB.claimWebhookDeliveries(time.Now().Add(time.Minute), 10)
+			Expect(attempts).To(HaveLen(1))
+			Expect(attempts[0].URL).To(Equal("http://localhost/foo"))
+			Expect(attempts[0].Secret).To(Equal("secret"))
+			Expect(testDB.claimWebhookDeliveries(time.Now().Add(time.Minute), 10)).To(BeEmpty())
+
+			delivery := attempts[0].WebhookDelivery
+			delivery.Status = webhookDeliveryDead
+			delivery.Attempts = 8
+			delivery.NextAttemptAt = null.Time{}
+			delivery.ResponseStatus = null.IntFrom(500)
+			delivery.Error = null.StringFrom("unexpected response status 500 Internal Server Error")
+			testDB.recordWebhookDeliveryAttempt(&delivery)
+			dead := testDB.listWebhookDeliveriesByCredential(1, &WebhookDeliveryFilter{Status: webhookDeliveryDead}, "faketoken", newPaging())
+			Expect(dead).To(HaveLen(1))
+			Expect(dead[0].ResponseStatus).To(Equal(null.IntFrom(500)))
+
+			Expect(testDB.redeliverWebhookDeliveryByCredential(1, delivery.ID, "barfaketoken")).To(BeNil())
+			redelivered := testDB.redeliverWebhookDeliveryByCredential(1, delivery.ID, "faketoken")
+			Expect(redelivered.Status).To(Equal(webhookDeliveryPending))
+			Expect(redelivered.Attempts).To(Equal(0))
+			Expect(testDB.claimWebhookDeliveries(time.Now().Add(time.Minute), 10)).To(HaveLen(1))
+		})
+	})
+	Context("relaying recipe events from the outbox", func() {
+		BeforeEach(func() {
+			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
+			defer testDB.close()
+
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE IF EXISTS recipe
+			`)
+			testDB.sqlxDB.MustExec(testRecipeTableSchema)
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE IF EXISTS hellofresh_user
+			`)
+			testDB.sqlxDB.MustExec(testHellofreshUserTableSchema)
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE IF EXISTS hellofresh_user_recipe
+			`)
+			testDB.sqlxDB.MustExec(testHellofreshUserRecipeTableSchema)
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE IF EXISTS recipe_revision
+			`)
+			testDB.sqlxDB.MustExec(testRecipeRevisionTableSchema)
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE IF EXISTS recipe_outbox
+			`)
+			testDB.sqlxDB.MustExec(testRecipeOutboxTableSchema)
+			testDB.sqlxDB.MustExec(`
+			INSERT INTO hellofresh_user(hu_account, hu_access_token)
+			VALUES
+			('foo', 'faketoken')
+			`)
+		})
+		AfterEach(func() {
+			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
+			defer testDB.close()
+
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE recipe_outbox
+			`)
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE recipe_revision
+			`)
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE hellofresh_user_recipe
+			`)
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE hellofresh_user
+			`)
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE recipe
+			`)
+		})
+		It("writes an event for every change of a recipe", func() {
+			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
+			defer testDB.close()
+
+			testDB.addRecipeByCredential(&PostRecipeArg{
+				Name:         null.StringFrom("name1"),
+				IsVegetarian: null.BoolFrom(false),
+			}, "faketoken")
+			testDB.rateAndGetRecipe(&PostRateRecipeArg{Rating: null.IntFrom(5)}, 1, "")
+			testDB.deleteAndGetRecipeByCredential(1, 0, "faketoken")
+			Expect(testDB.deleteAndGetRecipeByCredential(1, 0, "faketoken")).To(BeNil())
+
+			events := make([]*recipeEvent, 0)
+			Expect(testDB.relayRecipeEvents(10, func(e *recipeEvent) bool {
+				events = append(events, e)
+				return true
+			})).To(Equal(3))
+			Expect(events).To(HaveLen(3))
+			Expect(events[0].Type).To(Equal(recipeCreatedEvent))
+			Expect(events[0].Recipe.Name).To(Equal("name1"))
+			Expect(events[0].Owner).To(Equal("foo"))
+			Expect(events[1].Type).To(Equal(recipeRatedEvent))
+			Expect(events[1].Recipe.Rating.Float64).To(Equal(float64(5)))
+			Expect(events[2].Type).To(Equal(recipeDeletedEvent))
+			Expect(events[2].ID).To(BeNumerically(">", events[1].ID))
+			Expect(testDB.relayRecipeEvents(10, func(e *recipeEvent) bool { return true })).To(Equal(0))
+		})
+		It("holds back the later events of a recipe until its rejected event is published", func() {
+			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
+			defer testDB.close()
+
+			for _, name := range []string{"name1", "name2"} {
+				testDB.addRecipeByCredential(&PostRecipeArg{
+					Name:         null.StringFrom(name),
+					IsVegetarian: null.BoolFrom(false),
+				}, "faketoken")
+			}
+			testDB.rateAndGetRecipe(&PostRateRecipeArg{Rating: null.IntFrom(5)}, 1, "")
+
+			published := make([]int, 0)
+			Expect(testDB.relayRecipeEvents(10, func(e *recipeEvent) bool {
+				if e.RecipeID == 1 {
+					return false
+				}
+				published = append(published, e.RecipeID)
+				return true
+			})).To(Equal(1))
+			Expect(published).To(Equal([]int{2}))
+
+			types := make([]string, 0)
+			Expect(testDB.relayRecipeEvents(10, func(e *recipeEvent) bool {
+				types = append(types, e.Type)
+				return true
+			})).To(Equal(2))
+			Expect(types).To(Equal([]string{recipeCreatedEvent, recipeRatedEvent}))
+		})
+		It("reads past the held back events of a recipe", func() {
+			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
+			defer testDB.close()
+
+			testDB.addRecipeByCredential(&PostRecipeArg{
+				Name:         null.StringFrom("name1"),
+				IsVegetarian: null.BoolFrom(false),
+			}, "faketoken")
+			for i := 0; i < 3; i++ {
+				testDB.rateAndGetRecipe(&PostRateRecipeArg{Rating: null.IntFrom(5)}, 1, "")
+			}
+			testDB.addRecipeByCredential(&PostRecipeArg{
+				Name:         null.StringFrom("name2"),
+				IsVegetarian: null.BoolFrom(false),
+			}, "faketoken")
+
+			published := make([]int, 0)
+			Expect(testDB.relayRecipeEvents(2, func(e *recipeEvent) bool {
+				if e.RecipeID == 1 {
+					return false
+				}
+				published = append(published, e.RecipeID)
+				return true
+			})).To(Equal(1))
+			Expect(published).To(Equal([]int{2}))
+		})
+		It("purges the published events", func() {
+			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
+			defer testDB.close()
+
+			for _, name := range []string{"name1", "name2"} {
+				testDB.addRecipeByCredential(&PostRecipeArg{
+					Name:         null.StringFrom(name),
+					IsVegetarian: null.BoolFrom(false),
+				}, "faketoken")
+			}
+			testDB.relayRecipeEvents(10, func(e *recipeEvent) bool {
+				return e.RecipeID == 1
+			})
+			Expect(testDB.purgeRecipeEvents(time.Now().Add(time.Minute))).To(Equal(int64(1)))
+			Expect(testDB.relayRecipeEvents(10, func(e *recipeEvent) bool { return true })).To(Equal(1))
+		})
+	})
+	Context("checking the health of the database", func() {
+		BeforeEach(func() {
+			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
+			defer testDB.close()
+
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE IF EXISTS hellofresh_user
+			`)
+			testDB.sqlxDB.MustExec(testHellofreshUserTableSchema)
+			testDB.sqlxDB.MustExec(`
+			INSERT INTO hellofresh_user(hu_account, hu_access_token, hu_admin)
+			VALUES ('admin', 'admintoken', true), ('user', 'faketoken', false)
+			`)
+		})
+		AfterEach(func() {
+			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
+			defer testDB.close()
+
+			testDB.sqlxDB.MustExec(`
+			DROP TABLE hellofresh_user
+			`)
+		})
+		It("pings the database", func() {
+			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
+			defer testDB.close()
+
+			Expect(testDB.ping(time.Second)).To(Succeed())
+			Expect(testDB.poolStats().OpenConnections).To(BeNumerically(">", 0))
+		})
+		It("tells the admins apart by their credentials", func() {
+			testDB := newSqlxPostgreSQL(testDBConnectionStringWithDatabase)
+			defer testDB.close()
+
+			Expect(testDB.isAdminByCredential("admintoken")).To(BeTrue())
+			Expect(testDB.isAdminByCredential("faketoken")).To(BeFalse())
+			Expect(testDB.isAdminByCredential("faild_token")).To(BeFalse())
+		})
 	})
 }))
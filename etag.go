@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+func recipeETag(r *Recipe) string {
+	return fmt.Sprintf(`"%d-%d"`, r.ID, r.Version)
+}
+
+func splitETags(header string) []string {
+	tags := make([]string, 0)
+	for _, tag := range strings.Split(header, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func matchesETagWeakly(header string, etag string) bool {
+	for _, tag := range splitETags(header) {
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+func expectedRecipeVersion(header string, id int) int {
+	tags := splitETags(header)
+	if len(tags) == 0 {
+		return 0
+	}
+	for _, tag := range tags {
+		if tag == "*" {
+			return 0
+		}
+		if strings.HasPrefix(tag, "W/") {
+			continue
+		}
+		parts := strings.SplitN(strings.Trim(tag, `"`), "-", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		tagID, err := strconv.Atoi(parts[0])
+		if err != nil || tagID != id {
+			continue
+		}
+		if version, err := strconv.Atoi(parts[1]); err == nil && version > 0 {
+			return version
+		}
+	}
+	return -1
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecipeETag(t *testing.T) {
+	assert.Equal(t, `"32-3"`, recipeETag(&Recipe{ID: 32, Version: 3}))
+}
+
+func TestMatchesETagWeakly(t *testing.T) {
+	testCases := []struct {
+		header   string
+		expected bool
+	}{
+		{``, false},
+		{`*`, true},
+		{`"32-3"`, true},
+		{`W/"32-3"`, true},
+		{`"32-2", "32-3"`, true},
+		{`"32-2"`, false},
+		{`"31-3"`, false},
+	}
+	for i, v := range testCases {
+		assert.Equal(t, v.expected, matchesETagWeakly(v.header, `"32-3"`), "Case [%d]: %#v", i, v.header)
+	}
+}
+
+func TestExpectedRecipeVersion(t *testing.T) {
+	testCases := []struct {
+		header   string
+		expected int
+	}{
+		{``, 0},
+		{`*`, 0},
+		{`"32-3"`, 3},
+		{`"31-2", "32-3"`, 3},
+		{`W/"32-3"`, -1},
+		{`"31-3"`, -1},
+		{`"32-0"`, -1},
+		{`"32-x"`, -1},
+		{`"foo"`, -1},
+	}
+	for i, v := range testCases {
+		assert.Equal(t, v.expected, expectedRecipeVersion(v.header, 32), "Case [%d]: %#v", i, v.header)
+	}
+}
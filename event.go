@@ -0,0 +1,54 @@
+package main
+
+import (
+	"time"
+)
+
+const (
+	recipePublishedEvent   = "recipe.published"
+	recipeUnpublishedEvent = "recipe.unpublished"
+	recipeCreatedEvent     = "recipe.created"
+	recipeUpdatedEvent     = "recipe.updated"
+	recipeDeletedEvent     = "recipe.deleted"
+	recipeRatedEvent       = "recipe.rated"
+)
+
+type recipeEvent struct {
+	ID       int64     `json:"id,omitempty" db:"ro_id"`
+	Type     string    `json:"type" db:"e_type"`
+	RecipeID int       `json:"recipe_id" db:"r_id"`
+	Time     time.Time `json:"time" db:"e_time"`
+	Owner    string    `json:"owner,omitempty" db:"hu_account"`
+	Recipe   *Recipe   `json:"recipe,omitempty" db:"-"`
+	// Traceparent is the trace context of the change that made the event.
+	Traceparent string `json:"-" db:"ro_traceparent"`
+}
+
+// recipeImportEvents maps the outcomes of importing a recipe to the events
+// they make.
+var recipeImportEvents = map[string]string{
+	recipeImportCreated:     recipeCreatedEvent,
+	recipeImportRenamed:     recipeCreatedEvent,
+	recipeImportOverwritten: recipeUpdatedEvent,
+}
+
+// matches tells if an event is of the recipe and the owner the filter asks
+// for, and of a recipe that was within its publishing window at the time,
+// except for the event of a recipe leaving the window.
+func (f *EventFilter) matches(e *recipeEvent) bool {
+	if f.RecipeID != 0 && e.RecipeID != f.RecipeID {
+		return false
+	}
+	if f.Owner != "" && e.Owner != f.Owner {
+		return false
+	}
+	if r := e.Recipe; r != nil && e.Type != recipeUnpublishedEvent {
+		if r.PublishAt.Valid && r.PublishAt.Time.After(e.Time) {
+			return false
+		}
+		if r.UnpublishAt.Valid && !r.UnpublishAt.Time.After(e.Time) {
+			return false
+		}
+	}
+	return true
+}
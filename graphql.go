@@ -0,0 +1,1048 @@
+package main
+
+import (
+	"bytes"
+	stdjson "encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	null "gopkg.in/guregu/null.v3"
+)
+
+const (
+	graphQLCodeBadUserInput         = "BAD_USER_INPUT"
+	graphQLCodeNotFound             = "NOT_FOUND"
+	graphQLCodePreconditionFailed   = "PRECONDITION_FAILED"
+	graphQLCodePreconditionRequired = "PRECONDITION_REQUIRED"
+)
+
+type GraphQLRequest struct {
+	Query         string             `json:"query" validate:"required"`
+	OperationName null.String        `json:"operationName"`
+	Variables     stdjson.RawMessage `json:"variables"`
+}
+
+type GraphQLResponse struct {
+	Data   interface{}     `json:"data"`
+	Errors []*GraphQLError `json:"errors,omitempty"`
+}
+
+// GraphQLRequestErrors is the response to a request that can't be executed,
+// which has no data.
+type GraphQLRequestErrors struct {
+	Errors     []*GraphQLError        `json:"errors"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+func newGraphQLRequestErrors(c *gin.Context, errs []*GraphQLError) *GraphQLRequestErrors {
+	return &GraphQLRequestErrors{Errors: errs, Extensions: map[string]interface{}{"request_id": requestID(c)}}
+}
+
+type GraphQLError struct {
+	Message    string                 `json:"message"`
+	Locations  []*GraphQLLocation     `json:"locations,omitempty"`
+	Path       []interface{}          `json:"path,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+type GraphQLLocation struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+func newGraphQLError(message string, locations ...*GraphQLLocation) *GraphQLError {
+	return &GraphQLError{Message: message, Locations: locations}
+}
+
+// graphQLResolverError is an error of a resolver with the code the client
+// tells it apart by, like the status code of the REST endpoints.
+type graphQLResolverError struct {
+	code    string
+	message string
+}
+
+func (e *graphQLResolverError) Error() string {
+	return e.message
+}
+
+var errGraphQLNotFound = &graphQLResolverError{code: graphQLCodeNotFound, message: "not found"}
+
+// graphQLObject is an object of the response, whose fields keep the order
+// they're selected in.
+type graphQLObject []*graphQLObjectField
+
+type graphQLObjectField struct {
+	key   string
+	value interface{}
+}
+
+func (o graphQLObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := stdjson.Marshal(f.key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := stdjson.Marshal(f.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+type graphQLResolver func(e *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error)
+
+type graphQLFieldDefinition struct {
+	typ     string
+	args    []*graphQLArgumentDefinition
+	resolve graphQLResolver
+}
+
+type graphQLArgumentDefinition struct {
+	name         string
+	typ          string
+	defaultValue interface{}
+}
+
+var graphQLScalarTypes = map[string]bool{
+	"Int": true, "Float": true, "String": true, "Boolean": true, "DateTime": true,
+}
+
+var graphQLInputTypes = map[string][]*graphQLArgumentDefinition{
+	"RecipeFilter": {
+		{name: "name", typ: "String"},
+		{name: "prepareTimeFrom", typ: "Int"},
+		{name: "prepareTimeTo", typ: "Int"},
+		{name: "difficultyFrom", typ: "Int"},
+		{name: "difficultyTo", typ: "Int"},
+		{name: "isVegetarian", typ: "Boolean"},
+	},
+	"RecipeInput": {
+		{name: "name", typ: "String!"},
+		{name: "prepareTime", typ: "Int"},
+		{name: "difficulty", typ: "Int"},
+		{name: "isVegetarian", typ: "Boolean!"},
+		{name: "publishAt", typ: "DateTime"},
+		{name: "unpublishAt", typ: "DateTime"},
+	},
+}
+
+var graphQLObjectTypes = map[string]map[string]*graphQLFieldDefinition{
+	"Query": {
+		"recipes": {
+			typ: "[Recipe!]!",
+			args: []*graphQLArgumentDefinition{
+				{name: "filter", typ: "RecipeFilter"},
+				{name: "page", typ: "Int", defaultValue: newPaging().pageNumber},
+				{name: "pageSize", typ: "Int", defaultValue: newPaging().pageSize},
+			},
+			resolve: (*graphQLExecution).resolveRecipes,
+		},
+		"recipe": {
+			typ:     "Recipe",
+			args:    []*graphQLArgumentDefinition{{name: "id", typ: "Int!"}},
+			resolve: (*graphQLExecution).resolveRecipe,
+		},
+	},
+	"Mutation": {
+		"addRecipe": {
+			typ:     "Recipe",
+			args:    []*graphQLArgumentDefinition{{name: "input", typ: "RecipeInput!"}},
+			resolve: (*graphQLExecution).resolveAddRecipe,
+		},
+		"updateRecipe": {
+			typ: "Recipe",
+			args: []*graphQLArgumentDefinition{
+				{name: "id", typ: "Int!"},
+				{name: "input", typ: "RecipeInput!"},
+				{name: "version", typ: "Int"},
+			},
+			resolve: (*graphQLExecution).resolveUpdateRecipe,
+		},
+		"deleteRecipe": {
+			typ: "Recipe",
+			args: []*graphQLArgumentDefinition{
+				{name: "id", typ: "Int!"},
+				{name: "version", typ: "Int"},
+			},
+			resolve: (*graphQLExecution).resolveDeleteRecipe,
+		},
+		"rateRecipe": {
+			typ: "Recipe",
+			args: []*graphQLArgumentDefinition{
+				{name: "id", typ: "Int!"},
+				{name: "rating", typ: "Int!"},
+			},
+			resolve: (*graphQLExecution).resolveRateRecipe,
+		},
+	},
+	"Recipe": {
+		"id":           {typ: "Int!", resolve: recipeField(func(r *Recipe) interface{} { return r.ID })},
+		"name":         {typ: "String!", resolve: recipeField(func(r *Recipe) interface{} { return r.Name })},
+		"prepareTime":  {typ: "Int", resolve: recipeField(func(r *Recipe) interface{} { return r.PrepareTime })},
+		"difficulty":   {typ: "Int", resolve: recipeField(func(r *Recipe) interface{} { return r.Difficulty })},
+		"isVegetarian": {typ: "Boolean!", resolve: recipeField(func(r *Recipe) interface{} { return r.IsVegetarian })},
+		"rating":       {typ: "Rating!", resolve: recipeField(func(r *Recipe) interface{} { return newRecipeV2(r).Rating })},
+		"owner":        {typ: "User", resolve: (*graphQLExecution).resolveRecipeOwner},
+		"publishAt":    {typ: "DateTime", resolve: recipeField(func(r *Recipe) interface{} { return r.PublishAt })},
+		"unpublishAt":  {typ: "DateTime", resolve: recipeField(func(r *Recipe) interface{} { return r.UnpublishAt })},
+		"version":      {typ: "Int!", resolve: recipeField(func(r *Recipe) interface{} { return r.Version })},
+	},
+	"Rating": {
+		"average": {typ: "Float", resolve: func(_ *graphQLExecution, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			return source.(*RecipeRatingV2).Average, nil
+		}},
+		"count": {typ: "Int!", resolve: func(_ *graphQLExecution, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			return source.(*RecipeRatingV2).Count, nil
+		}},
+	},
+	"User": {
+		"account": {typ: "String!", resolve: func(_ *graphQLExecution, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			return source, nil
+		}},
+	},
+}
+
+var graphQLDirectives = map[string]bool{"skip": true, "include": true}
+
+func recipeField(get func(*Recipe) interface{}) graphQLResolver {
+	return func(_ *graphQLExecution, source interface{}, _ map[string]interface{}) (interface{}, error) {
+		return get(source.(*Recipe)), nil
+	}
+}
+
+// namedGraphQLType strips the list and non-null wrappers from a type
+// reference, like "[Recipe!]!" to "Recipe".
+func namedGraphQLType(typ string) string {
+	return strings.Trim(typ, "[]!")
+}
+
+func isGraphQLInputType(typ string) bool {
+	name := namedGraphQLType(typ)
+	_, isInput := graphQLInputTypes[name]
+	return isInput || graphQLScalarTypes[name]
+}
+
+// recipeOwnerLoader looks up the owners of every recipe resolved so far in
+// one call, when the owner of any of them is asked for first, rather than
+// one call per recipe.
+type recipeOwnerLoader struct {
+	datastore datastore
+	pending   []int
+	loaded    map[int]bool
+	owners    map[int]string
+}
+
+func newRecipeOwnerLoader(d datastore) *recipeOwnerLoader {
+	return &recipeOwnerLoader{
+		datastore: d,
+		loaded:    make(map[int]bool),
+		owners:    make(map[int]string),
+	}
+}
+
+func (l *recipeOwnerLoader) queue(recipes ...*Recipe) {
+	for _, r := range recipes {
+		if r != nil && !l.loaded[r.ID] {
+			l.pending = append(l.pending, r.ID)
+		}
+	}
+}
+
+func (l *recipeOwnerLoader) load(id int) (string, bool) {
+	if !l.loaded[id] {
+		ids := make([]int, 0, len(l.pending)+1)
+		for _, pending := range append(l.pending, id) {
+			if !l.loaded[pending] {
+				l.loaded[pending] = true
+				ids = append(ids, pending)
+			}
+		}
+		l.pending = nil
+		owners := l.datastore.listRecipeOwners(ids)
+		if owners == nil {
+			panic("got nil in method listRecipeOwners")
+		}
+		for recipeID, account := range owners {
+			l.owners[recipeID] = account
+		}
+	}
+	account, ok := l.owners[id]
+	return account, ok
+}
+
+type graphQLExecution struct {
+	server    *apiServer
+	datastore datastore
+	doc       *graphQLDocument
+	variables map[string]interface{}
+	token     string
+	owners    *recipeOwnerLoader
+	errors    []*GraphQLError
+}
+
+func (s *apiServer) postGraphQL(c *gin.Context) {
+	if !isJSONMediaType(c.GetHeader("Content-Type")) {
+		c.AbortWithStatus(http.StatusUnsupportedMediaType)
+		return
+	}
+	body, err := c.GetRawData()
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	req := &GraphQLRequest{}
+	if err := stdjson.Unmarshal(body, req); err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	variables := make(map[string]interface{})
+	if len(req.Variables) > 0 {
+		v, err := decodeJSONValue(req.Variables)
+		if m, ok := v.(map[string]interface{}); err == nil && ok {
+			variables = m
+		} else if err != nil || v != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+	}
+
+	doc, err := parseGraphQL(req.Query)
+	if err != nil {
+		e := err.(*graphQLSyntaxError)
+		c.AbortWithStatusJSON(http.StatusBadRequest, newGraphQLRequestErrors(c, []*GraphQLError{newGraphQLError(e.message, e.location)}))
+		return
+	}
+	op, errs := validateGraphQL(doc, req.OperationName.String)
+	if len(errs) > 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, newGraphQLRequestErrors(c, errs))
+		return
+	}
+
+	ds := s.datastoreOf(c)
+	e := &graphQLExecution{
+		server:    s,
+		datastore: ds,
+		doc:       doc,
+		token:     c.GetHeader("Authorization"),
+		owners:    newRecipeOwnerLoader(ds),
+	}
+	if errs := e.coerceVariables(op, variables); len(errs) > 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, newGraphQLRequestErrors(c, errs))
+		return
+	}
+	rootType := "Query"
+	if op.kind == "mutation" {
+		rootType = "Mutation"
+	}
+	data, ok := e.executeSelectionSet(rootType, op.selections, nil, nil)
+	if !ok {
+		data = nil
+	}
+	c.JSON(http.StatusOK, &GraphQLResponse{Data: data, Errors: e.errors})
+}
+
+// validateGraphQL checks a document against the schema before any of it
+// runs, and picks the operation to run.
+func validateGraphQL(doc *graphQLDocument, operationName string) (*graphQLOperation, []*GraphQLError) {
+	errs := make([]*GraphQLError, 0)
+	names := make(map[string]bool)
+	for _, op := range doc.operations {
+		if op.name == "" && len(doc.operations) > 1 {
+			errs = append(errs, newGraphQLError("This anonymous operation must be the only defined operation.", op.location))
+		}
+		if op.name != "" && names[op.name] {
+			errs = append(errs, newGraphQLError(fmt.Sprintf("There can be only one operation named %q.", op.name), op.location))
+		}
+		names[op.name] = true
+	}
+	fragmentNames := make([]string, 0, len(doc.fragments))
+	for name := range doc.fragments {
+		fragmentNames = append(fragmentNames, name)
+	}
+	sort.Strings(fragmentNames)
+	for _, name := range fragmentNames {
+		fragment := doc.fragments[name]
+		if _, ok := graphQLObjectTypes[fragment.typeCondition]; !ok {
+			errs = append(errs, newGraphQLError(fmt.Sprintf("Unknown type %q.", fragment.typeCondition), fragment.location))
+			continue
+		}
+		if cycle := findGraphQLFragmentCycle(doc, fragment, make(map[string]bool)); cycle != "" {
+			errs = append(errs, newGraphQLError(fmt.Sprintf("Cannot spread fragment %q within itself.", cycle), fragment.location))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	var selected *graphQLOperation
+	for _, op := range doc.operations {
+		if operationName == "" || op.name == operationName {
+			selected = op
+		}
+	}
+	switch {
+	case operationName == "" && len(doc.operations) > 1:
+		return nil, []*GraphQLError{newGraphQLError("Must provide operation name if query contains multiple operations.")}
+	case selected == nil:
+		return nil, []*GraphQLError{newGraphQLError(fmt.Sprintf("Unknown operation named %q.", operationName))}
+	}
+
+	v := &graphQLValidator{doc: doc, variables: make(map[string]bool), visited: make(map[string]bool), errors: errs}
+	for _, def := range selected.variables {
+		if v.variables[def.name] {
+			v.fail(def.location, "There can be only one variable named %q.", "$"+def.name)
+		}
+		v.variables[def.name] = true
+		if !isGraphQLInputType(def.typ) {
+			v.fail(def.location, "Variable %q cannot be non-input type %q.", "$"+def.name, def.typ)
+		}
+	}
+	switch selected.kind {
+	case "query":
+		v.selectionSet("Query", selected.selections)
+	case "mutation":
+		v.selectionSet("Mutation", selected.selections)
+	default:
+		v.fail(selected.location, "The %s operation is not supported.", selected.kind)
+	}
+	return selected, v.errors
+}
+
+func findGraphQLFragmentCycle(doc *graphQLDocument, fragment *graphQLFragment, visiting map[string]bool) string {
+	if visiting[fragment.name] {
+		return fragment.name
+	}
+	visiting[fragment.name] = true
+	defer delete(visiting, fragment.name)
+	var find func([]*graphQLSelection) string
+	find = func(selections []*graphQLSelection) string {
+		for _, s := range selections {
+			if spread, ok := doc.fragments[s.fragment]; ok {
+				if cycle := findGraphQLFragmentCycle(doc, spread, visiting); cycle != "" {
+					return cycle
+				}
+			}
+			if cycle := find(s.selections); cycle != "" {
+				return cycle
+			}
+		}
+		return ""
+	}
+	return find(fragment.selections)
+}
+
+type graphQLValidator struct {
+	doc       *graphQLDocument
+	variables map[string]bool
+	visited   map[string]bool
+	errors    []*GraphQLError
+}
+
+func (v *graphQLValidator) fail(location *GraphQLLocation, format string, args ...interface{}) {
+	v.errors = append(v.errors, newGraphQLError(fmt.Sprintf(format, args...), location))
+}
+
+func (v *graphQLValidator) selectionSet(typeName string, selections []*graphQLSelection) {
+	for _, s := range selections {
+		v.directives(s.directives)
+		switch {
+		case s.fragment != "":
+			fragment, ok := v.doc.fragments[s.fragment]
+			if !ok {
+				v.fail(s.location, "Unknown fragment %q.", s.fragment)
+				continue
+			}
+			if fragment.typeCondition != typeName {
+				v.fail(s.location, "Fragment %q cannot be spread here as objects of type %q can never be of type %q.", s.fragment, typeName, fragment.typeCondition)
+				continue
+			}
+			if key := typeName + " " + s.fragment; !v.visited[key] {
+				v.visited[key] = true
+				v.selectionSet(typeName, fragment.selections)
+			}
+		case s.inline:
+			if s.typeCondition != "" && s.typeCondition != typeName {
+				v.fail(s.location, "Fragment cannot be spread here as objects of type %q can never be of type %q.", typeName, s.typeCondition)
+				continue
+			}
+			v.selectionSet(typeName, s.selections)
+		case s.name == "__typename":
+			v.arguments(s, nil)
+			if s.selections != nil {
+				v.fail(s.location, "Field %q must not have a selection since type \"String!\" has no subfields.", s.name)
+			}
+		default:
+			def, ok := graphQLObjectTypes[typeName][s.name]
+			if !ok {
+				v.fail(s.location, "Cannot query field %q on type %q.", s.name, typeName)
+				continue
+			}
+			v.arguments(s, def.args)
+			named := namedGraphQLType(def.typ)
+			if _, ok := graphQLObjectTypes[named]; !ok {
+				if s.selections != nil {
+					v.fail(s.location, "Field %q must not have a selection since type %q has no subfields.", s.name, def.typ)
+				}
+				continue
+			}
+			if s.selections == nil {
+				v.fail(s.location, "Field %q of type %q must have a selection of subfields.", s.name, def.typ)
+				continue
+			}
+			v.selectionSet(named, s.selections)
+		}
+	}
+}
+
+func (v *graphQLValidator) arguments(s *graphQLSelection, defs []*graphQLArgumentDefinition) {
+	known := make(map[string]bool)
+	for _, def := range defs {
+		known[def.name] = true
+		if _, ok := s.arguments[def.name]; !ok && strings.HasSuffix(def.typ, "!") && def.defaultValue == nil {
+			v.fail(s.location, "Field %q argument %q of type %q is required, but it was not provided.", s.name, def.name, def.typ)
+		}
+	}
+	for name, value := range s.arguments {
+		if !known[name] {
+			v.fail(value.location, "Unknown argument %q on field %q.", name, s.name)
+		}
+		v.value(value)
+	}
+}
+
+func (v *graphQLValidator) directives(directives []*graphQLDirective) {
+	for _, d := range directives {
+		if !graphQLDirectives[d.name] {
+			v.fail(d.location, "Unknown directive %q.", "@"+d.name)
+			continue
+		}
+		if _, ok := d.arguments["if"]; !ok {
+			v.fail(d.location, "Directive %q argument \"if\" of type \"Boolean!\" is required, but it was not provided.", "@"+d.name)
+		}
+		for _, value := range d.arguments {
+			v.value(value)
+		}
+	}
+}
+
+func (v *graphQLValidator) value(value *graphQLValue) {
+	switch value.kind {
+	case graphQLValueVariable:
+		if !v.variables[value.raw] {
+			v.fail(value.location, "Variable %q is not defined.", "$"+value.raw)
+		}
+	case graphQLValueList:
+		for _, item := range value.list {
+			v.value(item)
+		}
+	case graphQLValueObject:
+		for _, field := range value.fields {
+			v.value(field)
+		}
+	}
+}
+
+func (e *graphQLExecution) coerceVariables(op *graphQLOperation, values map[string]interface{}) []*GraphQLError {
+	errs := make([]*GraphQLError, 0)
+	e.variables = make(map[string]interface{})
+	for _, def := range op.variables {
+		value, ok := values[def.name]
+		if !ok && def.defaultValue != nil {
+			value, ok = def.defaultValue.input(nil), true
+		}
+		if !ok {
+			if strings.HasSuffix(def.typ, "!") {
+				errs = append(errs, newGraphQLError(fmt.Sprintf("Variable %q of required type %q was not provided.", "$"+def.name, def.typ), def.location))
+			}
+			continue
+		}
+		coerced, err := coerceGraphQLInput(def.typ, value)
+		if err != nil {
+			errs = append(errs, newGraphQLError(fmt.Sprintf("Variable %q got invalid value; %s", "$"+def.name, err.Error()), def.location))
+			continue
+		}
+		e.variables[def.name] = coerced
+	}
+	return errs
+}
+
+func (e *graphQLExecution) coerceArguments(defs []*graphQLArgumentDefinition, values map[string]*graphQLValue) (map[string]interface{}, error) {
+	res := make(map[string]interface{})
+	for _, def := range defs {
+		value, ok := values[def.name]
+		if ok && value.kind == graphQLValueVariable {
+			_, ok = e.variables[value.raw]
+		}
+		if !ok {
+			if def.defaultValue != nil {
+				res[def.name] = def.defaultValue
+			} else if strings.HasSuffix(def.typ, "!") {
+				return nil, fmt.Errorf("argument %q of type %q is required", def.name, def.typ)
+			}
+			continue
+		}
+		coerced, err := coerceGraphQLInput(def.typ, value.input(e.variables))
+		if err != nil {
+			return nil, fmt.Errorf("argument %q got invalid value; %s", def.name, err.Error())
+		}
+		res[def.name] = coerced
+	}
+	return res, nil
+}
+
+// coerceGraphQLInput converts a value decoded from JSON with numbers as
+// json.Number to an input of a type: Int to int, Float to float64, DateTime
+// to time.Time, lists to []interface{} and input objects to maps of the
+// fields given. Coerced values are coerced to themselves.
+func coerceGraphQLInput(typ string, value interface{}) (interface{}, error) {
+	if inner := strings.TrimSuffix(typ, "!"); inner != typ {
+		if value == nil {
+			return nil, fmt.Errorf("expected non-nullable type %q not to be null", typ)
+		}
+		return coerceGraphQLInput(inner, value)
+	}
+	if value == nil {
+		return nil, nil
+	}
+	if strings.HasPrefix(typ, "[") {
+		item := typ[1 : len(typ)-1]
+		values, ok := value.([]interface{})
+		if !ok {
+			values = []interface{}{value}
+		}
+		res := make([]interface{}, len(values))
+		for i, v := range values {
+			coerced, err := coerceGraphQLInput(item, v)
+			if err != nil {
+				return nil, err
+			}
+			res[i] = coerced
+		}
+		return res, nil
+	}
+	if fields, ok := graphQLInputTypes[typ]; ok {
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected type %q to be an object", typ)
+		}
+		res := make(map[string]interface{})
+		known := make(map[string]bool)
+		for _, field := range fields {
+			known[field.name] = true
+			v, ok := obj[field.name]
+			if !ok {
+				if strings.HasSuffix(field.typ, "!") {
+					return nil, fmt.Errorf("field %q of required type %q was not provided", typ+"."+field.name, field.typ)
+				}
+				continue
+			}
+			coerced, err := coerceGraphQLInput(field.typ, v)
+			if err != nil {
+				return nil, fmt.Errorf("at %q: %s", typ+"."+field.name, err.Error())
+			}
+			res[field.name] = coerced
+		}
+		for name := range obj {
+			if !known[name] {
+				return nil, fmt.Errorf("field %q is not defined by type %q", name, typ)
+			}
+		}
+		return res, nil
+	}
+
+	invalid := fmt.Errorf("%s cannot represent %v", typ, value)
+	switch typ {
+	case "Int":
+		switch v := value.(type) {
+		case int:
+			return v, nil
+		case stdjson.Number:
+			n, err := strconv.ParseInt(string(v), 10, 32)
+			if err != nil {
+				return nil, invalid
+			}
+			return int(n), nil
+		}
+	case "Float":
+		switch v := value.(type) {
+		case float64:
+			return v, nil
+		case stdjson.Number:
+			f, err := v.Float64()
+			if err != nil || math.IsInf(f, 0) {
+				return nil, invalid
+			}
+			return f, nil
+		}
+	case "String":
+		if v, ok := value.(string); ok {
+			return v, nil
+		}
+	case "Boolean":
+		if v, ok := value.(bool); ok {
+			return v, nil
+		}
+	case "DateTime":
+		switch v := value.(type) {
+		case time.Time:
+			return v, nil
+		case string:
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return nil, fmt.Errorf("DateTime cannot represent %q, which is not an RFC 3339 date-time", v)
+			}
+			return t, nil
+		}
+	default:
+		return nil, fmt.Errorf("unknown type %q", typ)
+	}
+	return nil, invalid
+}
+
+type graphQLFieldGroup struct {
+	key    string
+	fields []*graphQLSelection
+}
+
+// collectFields merges the fields selected with the same response key,
+// following fragments and leaving out the ones skipped by directives.
+func (e *graphQLExecution) collectFields(selections []*graphQLSelection, groups []*graphQLFieldGroup, visited map[string]bool) []*graphQLFieldGroup {
+	for _, s := range selections {
+		if !e.included(s.directives) {
+			continue
+		}
+		switch {
+		case s.fragment != "":
+			if visited[s.fragment] {
+				continue
+			}
+			visited[s.fragment] = true
+			groups = e.collectFields(e.doc.fragments[s.fragment].selections, groups, visited)
+		case s.inline:
+			groups = e.collectFields(s.selections, groups, visited)
+		default:
+			found := false
+			for _, g := range groups {
+				if g.key == s.responseKey() {
+					g.fields = append(g.fields, s)
+					found = true
+					break
+				}
+			}
+			if !found {
+				groups = append(groups, &graphQLFieldGroup{key: s.responseKey(), fields: []*graphQLSelection{s}})
+			}
+		}
+	}
+	return groups
+}
+
+func (e *graphQLExecution) included(directives []*graphQLDirective) bool {
+	for _, d := range directives {
+		v, err := coerceGraphQLInput("Boolean!", d.arguments["if"].input(e.variables))
+		if err != nil {
+			continue
+		}
+		if d.name == "skip" && v.(bool) || d.name == "include" && !v.(bool) {
+			return false
+		}
+	}
+	return true
+}
+
+// executeSelectionSet resolves the fields of an object in order, which
+// runs the fields of a mutation one after another. It returns false when
+// a non-null field is null, and the object is null in its place.
+func (e *graphQLExecution) executeSelectionSet(typeName string, selections []*graphQLSelection, source interface{}, path []interface{}) (interface{}, bool) {
+	res := make(graphQLObject, 0)
+	for _, group := range e.collectFields(selections, nil, make(map[string]bool)) {
+		value, ok := e.executeField(typeName, group, source, appendGraphQLPath(path, group.key))
+		if !ok {
+			return nil, false
+		}
+		res = append(res, &graphQLObjectField{key: group.key, value: value})
+	}
+	return res, true
+}
+
+func (e *graphQLExecution) executeField(typeName string, group *graphQLFieldGroup, source interface{}, path []interface{}) (interface{}, bool) {
+	field := group.fields[0]
+	if field.name == "__typename" {
+		return typeName, true
+	}
+	def := graphQLObjectTypes[typeName][field.name]
+	args, err := e.coerceArguments(def.args, field.arguments)
+	if err == nil {
+		var value interface{}
+		if value, err = def.resolve(e, source, args); err == nil {
+			return e.completeValue(def.typ, group.fields, value, path)
+		}
+	}
+	e.fail(err, field, path)
+	return nil, !strings.HasSuffix(def.typ, "!")
+}
+
+func (e *graphQLExecution) fail(err error, field *graphQLSelection, path []interface{}) {
+	gqlErr := newGraphQLError(err.Error(), field.location)
+	gqlErr.Path = path
+	switch err := err.(type) {
+	case *graphQLResolverError:
+		gqlErr.Extensions = map[string]interface{}{"code": err.code}
+	default:
+		gqlErr.Extensions = map[string]interface{}{"code": graphQLCodeBadUserInput}
+	}
+	e.errors = append(e.errors, gqlErr)
+}
+
+// completeValue shapes the value of a field after its type. It returns
+// false when the value is null for a non-null type, for the enclosing
+// nullable field to be null instead.
+func (e *graphQLExecution) completeValue(typ string, fields []*graphQLSelection, value interface{}, path []interface{}) (interface{}, bool) {
+	inner := strings.TrimSuffix(typ, "!")
+	res, ok := e.completeNullableValue(inner, fields, value, path)
+	if inner == typ {
+		return res, true
+	}
+	if !ok {
+		return nil, false
+	}
+	if res == nil {
+		e.errors = append(e.errors, &GraphQLError{
+			Message:   fmt.Sprintf("Cannot return null for non-nullable field of type %q.", typ),
+			Locations: []*GraphQLLocation{fields[0].location},
+			Path:      path,
+		})
+		return nil, false
+	}
+	return res, true
+}
+
+func (e *graphQLExecution) completeNullableValue(typ string, fields []*graphQLSelection, value interface{}, path []interface{}) (interface{}, bool) {
+	value = graphQLNullable(value)
+	if value == nil {
+		return nil, true
+	}
+	if rv := reflect.ValueOf(value); rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return nil, true
+	}
+	if strings.HasPrefix(typ, "[") {
+		rv := reflect.ValueOf(value)
+		res := make([]interface{}, rv.Len())
+		for i := range res {
+			item, ok := e.completeValue(typ[1:len(typ)-1], fields, rv.Index(i).Interface(), appendGraphQLPath(path, i))
+			if !ok {
+				return nil, false
+			}
+			res[i] = item
+		}
+		return res, true
+	}
+	if _, ok := graphQLObjectTypes[typ]; ok {
+		selections := make([]*graphQLSelection, 0)
+		for _, field := range fields {
+			selections = append(selections, field.selections...)
+		}
+		return e.executeSelectionSet(typ, selections, value, path)
+	}
+	if t, ok := value.(time.Time); ok {
+		return t.Format(time.RFC3339Nano), true
+	}
+	return value, true
+}
+
+// graphQLNullable unwraps the nullable types of the models to their value,
+// or nil when they're null.
+func graphQLNullable(value interface{}) interface{} {
+	switch v := value.(type) {
+	case null.String:
+		return v.Ptr()
+	case null.Int:
+		if v.Valid {
+			return v.Int64
+		}
+	case null.Float:
+		if v.Valid {
+			return v.Float64
+		}
+	case null.Bool:
+		if v.Valid {
+			return v.Bool
+		}
+	case null.Time:
+		if v.Valid {
+			return v.Time
+		}
+	default:
+		return value
+	}
+	return nil
+}
+
+func appendGraphQLPath(path []interface{}, key interface{}) []interface{} {
+	res := make([]interface{}, len(path), len(path)+1)
+	copy(res, path)
+	return append(res, key)
+}
+
+func (e *graphQLExecution) resolveRecipes(_ interface{}, args map[string]interface{}) (interface{}, error) {
+	filter := &ListFilter{}
+	if in, ok := args["filter"].(map[string]interface{}); ok {
+		filter.Name, _ = in["name"].(string)
+		filter.PrepTimeFrom, _ = in["prepareTimeFrom"].(int)
+		filter.PrepTimeTo, _ = in["prepareTimeTo"].(int)
+		filter.DifficultyFrom, _ = in["difficultyFrom"].(int)
+		filter.DifficultyTo, _ = in["difficultyTo"].(int)
+		if isVegetarian, ok := in["isVegetarian"].(bool); ok {
+			filter.IsVegetarian = strconv.FormatBool(isVegetarian)
+		}
+	}
+	paging := newPaging()
+	if page, ok := args["page"].(int); ok {
+		paging.pageNumber = page
+	}
+	if pageSize, ok := args["pageSize"].(int); ok {
+		paging.pageSize = pageSize
+	}
+	if paging.pageNumber < 1 || paging.pageSize < 1 {
+		return nil, &graphQLResolverError{code: graphQLCodeBadUserInput, message: "page and pageSize must be at least 1"}
+	}
+
+	res := e.datastore.listRecipes(filter, paging)
+	if res == nil {
+		panic("got nil in method listRecipes")
+	}
+	e.owners.queue(res...)
+	return res, nil
+}
+
+func (e *graphQLExecution) resolveRecipe(_ interface{}, args map[string]interface{}) (interface{}, error) {
+	res := e.datastore.getRecipeByID(args["id"].(int))
+	e.owners.queue(res)
+	return res, nil
+}
+
+func (e *graphQLExecution) resolveRecipeOwner(source interface{}, _ map[string]interface{}) (interface{}, error) {
+	if account, ok := e.owners.load(source.(*Recipe).ID); ok {
+		return account, nil
+	}
+	return nil, nil
+}
+
+func (e *graphQLExecution) resolveAddRecipe(_ interface{}, args map[string]interface{}) (interface{}, error) {
+	arg := newGraphQLRecipeInput(args["input"].(map[string]interface{})).postRecipeArg()
+	if err := validate.Struct(arg); err != nil {
+		return nil, &graphQLResolverError{code: graphQLCodeBadUserInput, message: err.Error()}
+	}
+
+	res := e.datastore.addRecipeByCredential(arg, e.token)
+	if res == nil {
+		return nil, errGraphQLNotFound
+	}
+	e.owners.queue(res)
+	return res, nil
+}
+
+func (e *graphQLExecution) resolveUpdateRecipe(_ interface{}, args map[string]interface{}) (interface{}, error) {
+	id := args["id"].(int)
+	version, err := e.expectedVersion(args)
+	if err != nil {
+		return nil, err
+	}
+	arg := newGraphQLRecipeInput(args["input"].(map[string]interface{}))
+	if err := validate.Struct(arg); err != nil {
+		return nil, &graphQLResolverError{code: graphQLCodeBadUserInput, message: err.Error()}
+	}
+
+	res := e.datastore.updateAndGetRecipeByCredential(arg, id, version, e.token)
+	if res == nil {
+		return nil, e.failedWrite(id, version)
+	}
+	e.owners.queue(res)
+	return res, nil
+}
+
+func (e *graphQLExecution) resolveDeleteRecipe(_ interface{}, args map[string]interface{}) (interface{}, error) {
+	id := args["id"].(int)
+	version, err := e.expectedVersion(args)
+	if err != nil {
+		return nil, err
+	}
+
+	res := e.datastore.deleteAndGetRecipeByCredential(id, version, e.token)
+	if res == nil {
+		return nil, e.failedWrite(id, version)
+	}
+	e.owners.queue(res)
+	return res, nil
+}
+
+func (e *graphQLExecution) resolveRateRecipe(_ interface{}, args map[string]interface{}) (interface{}, error) {
+	arg := &PostRateRecipeArg{Rating: null.IntFrom(int64(args["rating"].(int)))}
+	if err := validate.Struct(arg); err != nil {
+		return nil, &graphQLResolverError{code: graphQLCodeBadUserInput, message: err.Error()}
+	}
+
+	res := e.datastore.rateAndGetRecipe(arg, args["id"].(int), e.token)
+	if res == nil {
+		return nil, errGraphQLNotFound
+	}
+	e.owners.queue(res)
+	return res, nil
+}
+
+// expectedVersion is the version argument of a write, which stands in for
+// the If-Match header of the REST endpoints.
+func (e *graphQLExecution) expectedVersion(args map[string]interface{}) (int, error) {
+	version, ok := args["version"].(int)
+	if !ok && e.server.requireIfMatch {
+		return 0, &graphQLResolverError{code: graphQLCodePreconditionRequired, message: "version is required"}
+	}
+	return version, nil
+}
+
+func (e *graphQLExecution) failedWrite(id int, version int) error {
+	if e.server.isVersionMismatched(e.datastore, id, version, e.token) {
+		return &graphQLResolverError{code: graphQLCodePreconditionFailed, message: "version does not match"}
+	}
+	return errGraphQLNotFound
+}
+
+func newGraphQLRecipeInput(in map[string]interface{}) *PutRecipeArg {
+	arg := &PutRecipeArg{}
+	if name, ok := in["name"].(string); ok {
+		arg.Name = null.StringFrom(name)
+	}
+	if prepareTime, ok := in["prepareTime"].(int); ok {
+		arg.PrepareTime = null.IntFrom(int64(prepareTime))
+	}
+	if difficulty, ok := in["difficulty"].(int); ok {
+		arg.Difficulty = null.IntFrom(int64(difficulty))
+	}
+	if isVegetarian, ok := in["isVegetarian"].(bool); ok {
+		arg.IsVegetarian = null.BoolFrom(isVegetarian)
+	}
+	if publishAt, ok := in["publishAt"].(time.Time); ok {
+		arg.PublishAt = null.TimeFrom(publishAt)
+	}
+	if unpublishAt, ok := in["unpublishAt"].(time.Time); ok {
+		arg.UnpublishAt = null.TimeFrom(unpublishAt)
+	}
+	return arg
+}
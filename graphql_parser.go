@@ -0,0 +1,582 @@
+package main
+
+import (
+	stdjson "encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	graphQLTokenEOF = iota
+	graphQLTokenPunctuator
+	graphQLTokenName
+	graphQLTokenInt
+	graphQLTokenFloat
+	graphQLTokenString
+)
+
+const (
+	graphQLValueVariable = iota
+	graphQLValueInt
+	graphQLValueFloat
+	graphQLValueString
+	graphQLValueBoolean
+	graphQLValueNull
+	graphQLValueEnum
+	graphQLValueList
+	graphQLValueObject
+)
+
+type graphQLToken struct {
+	kind     int
+	value    string
+	location *GraphQLLocation
+}
+
+type graphQLDocument struct {
+	operations []*graphQLOperation
+	fragments  map[string]*graphQLFragment
+}
+
+type graphQLOperation struct {
+	kind       string
+	name       string
+	variables  []*graphQLVariableDefinition
+	selections []*graphQLSelection
+	location   *GraphQLLocation
+}
+
+type graphQLVariableDefinition struct {
+	name         string
+	typ          string
+	defaultValue *graphQLValue
+	location     *GraphQLLocation
+}
+
+type graphQLFragment struct {
+	name          string
+	typeCondition string
+	selections    []*graphQLSelection
+	location      *GraphQLLocation
+}
+
+// graphQLSelection is a field, a fragment spread when fragment is set, or an
+// inline fragment when inline is set.
+type graphQLSelection struct {
+	alias         string
+	name          string
+	arguments     map[string]*graphQLValue
+	directives    []*graphQLDirective
+	selections    []*graphQLSelection
+	fragment      string
+	inline        bool
+	typeCondition string
+	location      *GraphQLLocation
+}
+
+func (s *graphQLSelection) responseKey() string {
+	if s.alias != "" {
+		return s.alias
+	}
+	return s.name
+}
+
+type graphQLDirective struct {
+	name      string
+	arguments map[string]*graphQLValue
+	location  *GraphQLLocation
+}
+
+type graphQLValue struct {
+	kind     int
+	raw      string
+	list     []*graphQLValue
+	fields   map[string]*graphQLValue
+	location *GraphQLLocation
+}
+
+// input converts a literal to the value JSON would have for it, with
+// numbers as json.Number, so that literals and variables are coerced alike.
+// A variable that isn't given is null.
+func (v *graphQLValue) input(variables map[string]interface{}) interface{} {
+	switch v.kind {
+	case graphQLValueVariable:
+		return variables[v.raw]
+	case graphQLValueInt, graphQLValueFloat:
+		return stdjson.Number(v.raw)
+	case graphQLValueString, graphQLValueEnum:
+		return v.raw
+	case graphQLValueBoolean:
+		return v.raw == "true"
+	case graphQLValueList:
+		res := make([]interface{}, len(v.list))
+		for i, item := range v.list {
+			res[i] = item.input(variables)
+		}
+		return res
+	case graphQLValueObject:
+		res := make(map[string]interface{})
+		for name, field := range v.fields {
+			res[name] = field.input(variables)
+		}
+		return res
+	}
+	return nil
+}
+
+type graphQLSyntaxError struct {
+	message  string
+	location *GraphQLLocation
+}
+
+func (e *graphQLSyntaxError) Error() string {
+	return e.message
+}
+
+// parseGraphQL parses an executable GraphQL document: operations and
+// fragments, without type system definitions.
+func parseGraphQL(source string) (doc *graphQLDocument, err error) {
+	tokens, err := lexGraphQL(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &graphQLParser{tokens: tokens}
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*graphQLSyntaxError)
+			if !ok {
+				panic(r)
+			}
+			doc, err = nil, e
+		}
+	}()
+	return p.parseDocument(), nil
+}
+
+func lexGraphQL(source string) ([]*graphQLToken, error) {
+	tokens := make([]*graphQLToken, 0)
+	line, lineStart := 1, 0
+	for i := 0; i < len(source); {
+		location := &GraphQLLocation{Line: line, Column: utf8.RuneCountInString(source[lineStart:i]) + 1}
+		r, size := utf8.DecodeRuneInString(source[i:])
+		switch {
+		case r == '\n':
+			i++
+			line, lineStart = line+1, i
+		case r == '\r':
+			i++
+			if i < len(source) && source[i] == '\n' {
+				i++
+			}
+			line, lineStart = line+1, i
+		case r == ' ' || r == '\t' || r == ',' || r == '\uFEFF':
+			i += size
+		case r == '#':
+			for i < len(source) && source[i] != '\n' && source[i] != '\r' {
+				i++
+			}
+		case strings.HasPrefix(source[i:], "..."):
+			tokens = append(tokens, &graphQLToken{kind: graphQLTokenPunctuator, value: "...", location: location})
+			i += 3
+		case strings.ContainsRune("!$&():=@[]{}|", r):
+			tokens = append(tokens, &graphQLToken{kind: graphQLTokenPunctuator, value: string(r), location: location})
+			i++
+		case r == '_' || r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z':
+			j := i + 1
+			for j < len(source) && isGraphQLNameChar(source[j]) {
+				j++
+			}
+			tokens = append(tokens, &graphQLToken{kind: graphQLTokenName, value: source[i:j], location: location})
+			i = j
+		case r == '-' || r >= '0' && r <= '9':
+			token, n, err := lexGraphQLNumber(source[i:], location)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token)
+			i += n
+		case r == '"':
+			value, n, err := lexGraphQLString(source[i:], location)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, &graphQLToken{kind: graphQLTokenString, value: value, location: location})
+			i += n
+		default:
+			return nil, &graphQLSyntaxError{message: fmt.Sprintf("Syntax Error: Unexpected character %q.", r), location: location}
+		}
+	}
+	location := &GraphQLLocation{Line: line, Column: utf8.RuneCountInString(source[lineStart:]) + 1}
+	return append(tokens, &graphQLToken{kind: graphQLTokenEOF, value: "<EOF>", location: location}), nil
+}
+
+func isGraphQLNameChar(c byte) bool {
+	return c == '_' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9'
+}
+
+func lexGraphQLNumber(source string, location *GraphQLLocation) (*graphQLToken, int, error) {
+	digits := func(i int) int {
+		for i < len(source) && source[i] >= '0' && source[i] <= '9' {
+			i++
+		}
+		return i
+	}
+	invalid := &graphQLSyntaxError{message: "Syntax Error: Invalid number.", location: location}
+	i := 0
+	if source[i] == '-' {
+		i++
+	}
+	j := digits(i)
+	if j == i || source[i] == '0' && j-i > 1 {
+		return nil, 0, invalid
+	}
+	kind := graphQLTokenInt
+	if j < len(source) && source[j] == '.' {
+		kind = graphQLTokenFloat
+		k := digits(j + 1)
+		if k == j+1 {
+			return nil, 0, invalid
+		}
+		j = k
+	}
+	if j < len(source) && (source[j] == 'e' || source[j] == 'E') {
+		kind = graphQLTokenFloat
+		j++
+		if j < len(source) && (source[j] == '+' || source[j] == '-') {
+			j++
+		}
+		k := digits(j)
+		if k == j {
+			return nil, 0, invalid
+		}
+		j = k
+	}
+	if j < len(source) && (isGraphQLNameChar(source[j]) || source[j] == '.') {
+		return nil, 0, invalid
+	}
+	return &graphQLToken{kind: kind, value: source[:j], location: location}, j, nil
+}
+
+func lexGraphQLString(source string, location *GraphQLLocation) (string, int, error) {
+	if strings.HasPrefix(source, `"""`) {
+		return "", 0, &graphQLSyntaxError{message: "Syntax Error: Block strings are not supported.", location: location}
+	}
+	var b strings.Builder
+	for i := 1; i < len(source); {
+		r, size := utf8.DecodeRuneInString(source[i:])
+		switch r {
+		case '"':
+			return b.String(), i + 1, nil
+		case '\n', '\r':
+			i = len(source)
+			continue
+		case '\\':
+			if i+1 >= len(source) {
+				i = len(source)
+				continue
+			}
+			escape := source[i+1]
+			switch escape {
+			case '"', '\\', '/':
+				b.WriteByte(escape)
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				if i+6 > len(source) {
+					return "", 0, &graphQLSyntaxError{message: "Syntax Error: Invalid Unicode escape sequence.", location: location}
+				}
+				code, err := strconv.ParseUint(source[i+2:i+6], 16, 32)
+				if err != nil {
+					return "", 0, &graphQLSyntaxError{message: "Syntax Error: Invalid Unicode escape sequence.", location: location}
+				}
+				b.WriteRune(rune(code))
+				i += 6
+				continue
+			default:
+				return "", 0, &graphQLSyntaxError{message: fmt.Sprintf("Syntax Error: Invalid character escape sequence \\%c.", escape), location: location}
+			}
+			i += 2
+			continue
+		}
+		b.WriteRune(r)
+		i += size
+	}
+	return "", 0, &graphQLSyntaxError{message: "Syntax Error: Unterminated string.", location: location}
+}
+
+type graphQLParser struct {
+	tokens []*graphQLToken
+	pos    int
+}
+
+func (p *graphQLParser) peek() *graphQLToken {
+	return p.tokens[p.pos]
+}
+
+func (p *graphQLParser) next() *graphQLToken {
+	token := p.tokens[p.pos]
+	if token.kind != graphQLTokenEOF {
+		p.pos++
+	}
+	return token
+}
+
+func (p *graphQLParser) fail(token *graphQLToken, format string, args ...interface{}) {
+	panic(&graphQLSyntaxError{message: "Syntax Error: " + fmt.Sprintf(format, args...), location: token.location})
+}
+
+func (p *graphQLParser) peekPunctuator(value string) bool {
+	token := p.peek()
+	return token.kind == graphQLTokenPunctuator && token.value == value
+}
+
+func (p *graphQLParser) skipPunctuator(value string) bool {
+	if p.peekPunctuator(value) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *graphQLParser) expectPunctuator(value string) *graphQLToken {
+	token := p.next()
+	if token.kind != graphQLTokenPunctuator || token.value != value {
+		p.fail(token, "Expected %q, found %s.", value, describeGraphQLToken(token))
+	}
+	return token
+}
+
+func (p *graphQLParser) expectName() *graphQLToken {
+	token := p.next()
+	if token.kind != graphQLTokenName {
+		p.fail(token, "Expected Name, found %s.", describeGraphQLToken(token))
+	}
+	return token
+}
+
+func describeGraphQLToken(token *graphQLToken) string {
+	switch token.kind {
+	case graphQLTokenEOF:
+		return token.value
+	case graphQLTokenString:
+		return strconv.Quote(token.value)
+	}
+	return fmt.Sprintf("%q", token.value)
+}
+
+func (p *graphQLParser) parseDocument() *graphQLDocument {
+	doc := &graphQLDocument{fragments: make(map[string]*graphQLFragment)}
+	for p.peek().kind != graphQLTokenEOF {
+		token := p.peek()
+		switch {
+		case token.kind == graphQLTokenPunctuator && token.value == "{":
+			doc.operations = append(doc.operations, &graphQLOperation{kind: "query", selections: p.parseSelectionSet(), location: token.location})
+		case token.kind == graphQLTokenName && token.value == "fragment":
+			fragment := p.parseFragment()
+			if _, ok := doc.fragments[fragment.name]; ok {
+				p.fail(token, "There can be only one fragment named %q.", fragment.name)
+			}
+			doc.fragments[fragment.name] = fragment
+		case token.kind == graphQLTokenName && (token.value == "query" || token.value == "mutation" || token.value == "subscription"):
+			doc.operations = append(doc.operations, p.parseOperation())
+		default:
+			p.fail(token, "Unexpected %s.", describeGraphQLToken(token))
+		}
+	}
+	if len(doc.operations) == 0 {
+		p.fail(p.peek(), "Expected an operation, found %s.", describeGraphQLToken(p.peek()))
+	}
+	return doc
+}
+
+func (p *graphQLParser) parseOperation() *graphQLOperation {
+	token := p.next()
+	op := &graphQLOperation{kind: token.value, location: token.location}
+	if p.peek().kind == graphQLTokenName {
+		op.name = p.next().value
+	}
+	if p.skipPunctuator("(") {
+		for !p.skipPunctuator(")") {
+			op.variables = append(op.variables, p.parseVariableDefinition())
+		}
+	}
+	p.parseDirectives(false)
+	op.selections = p.parseSelectionSet()
+	return op
+}
+
+func (p *graphQLParser) parseVariableDefinition() *graphQLVariableDefinition {
+	token := p.expectPunctuator("$")
+	def := &graphQLVariableDefinition{name: p.expectName().value, location: token.location}
+	p.expectPunctuator(":")
+	def.typ = p.parseType()
+	if p.skipPunctuator("=") {
+		def.defaultValue = p.parseValue(true)
+	}
+	return def
+}
+
+// parseType returns a type reference as it's written, like "[Int!]!".
+func (p *graphQLParser) parseType() string {
+	var typ string
+	if p.skipPunctuator("[") {
+		typ = "[" + p.parseType() + "]"
+		p.expectPunctuator("]")
+	} else {
+		typ = p.expectName().value
+	}
+	if p.skipPunctuator("!") {
+		typ += "!"
+	}
+	return typ
+}
+
+func (p *graphQLParser) parseFragment() *graphQLFragment {
+	token := p.next()
+	fragment := &graphQLFragment{location: token.location}
+	name := p.expectName()
+	if name.value == "on" {
+		p.fail(name, "Unexpected %s.", describeGraphQLToken(name))
+	}
+	fragment.name = name.value
+	if on := p.expectName(); on.value != "on" {
+		p.fail(on, "Expected \"on\", found %s.", describeGraphQLToken(on))
+	}
+	fragment.typeCondition = p.expectName().value
+	p.parseDirectives(false)
+	fragment.selections = p.parseSelectionSet()
+	return fragment
+}
+
+func (p *graphQLParser) parseSelectionSet() []*graphQLSelection {
+	p.expectPunctuator("{")
+	selections := make([]*graphQLSelection, 0)
+	for {
+		selections = append(selections, p.parseSelection())
+		if p.skipPunctuator("}") {
+			return selections
+		}
+	}
+}
+
+func (p *graphQLParser) parseSelection() *graphQLSelection {
+	if token := p.peek(); p.skipPunctuator("...") {
+		s := &graphQLSelection{location: token.location}
+		if name := p.peek(); name.kind == graphQLTokenName && name.value != "on" {
+			s.fragment = p.next().value
+			s.directives = p.parseDirectives(false)
+			return s
+		}
+		s.inline = true
+		if name := p.peek(); name.kind == graphQLTokenName {
+			p.next()
+			s.typeCondition = p.expectName().value
+		}
+		s.directives = p.parseDirectives(false)
+		s.selections = p.parseSelectionSet()
+		return s
+	}
+
+	name := p.expectName()
+	s := &graphQLSelection{name: name.value, location: name.location}
+	if p.skipPunctuator(":") {
+		s.alias, s.name = s.name, p.expectName().value
+	}
+	s.arguments = p.parseArguments(false)
+	s.directives = p.parseDirectives(false)
+	if p.peekPunctuator("{") {
+		s.selections = p.parseSelectionSet()
+	}
+	return s
+}
+
+func (p *graphQLParser) parseArguments(isConst bool) map[string]*graphQLValue {
+	args := make(map[string]*graphQLValue)
+	if !p.skipPunctuator("(") {
+		return args
+	}
+	for {
+		name := p.expectName()
+		if _, ok := args[name.value]; ok {
+			p.fail(name, "There can be only one argument named %q.", name.value)
+		}
+		p.expectPunctuator(":")
+		args[name.value] = p.parseValue(isConst)
+		if p.skipPunctuator(")") {
+			return args
+		}
+	}
+}
+
+func (p *graphQLParser) parseDirectives(isConst bool) []*graphQLDirective {
+	directives := make([]*graphQLDirective, 0)
+	for p.peekPunctuator("@") {
+		token := p.next()
+		directives = append(directives, &graphQLDirective{
+			name:      p.expectName().value,
+			arguments: p.parseArguments(isConst),
+			location:  token.location,
+		})
+	}
+	return directives
+}
+
+func (p *graphQLParser) parseValue(isConst bool) *graphQLValue {
+	token := p.next()
+	v := &graphQLValue{raw: token.value, location: token.location}
+	switch token.kind {
+	case graphQLTokenInt:
+		v.kind = graphQLValueInt
+	case graphQLTokenFloat:
+		v.kind = graphQLValueFloat
+	case graphQLTokenString:
+		v.kind = graphQLValueString
+	case graphQLTokenName:
+		switch token.value {
+		case "true", "false":
+			v.kind = graphQLValueBoolean
+		case "null":
+			v.kind = graphQLValueNull
+		default:
+			v.kind = graphQLValueEnum
+		}
+	case graphQLTokenPunctuator:
+		switch token.value {
+		case "$":
+			if isConst {
+				p.fail(token, "Unexpected variable in a constant value.")
+			}
+			v.kind, v.raw = graphQLValueVariable, p.expectName().value
+		case "[":
+			v.kind, v.list = graphQLValueList, make([]*graphQLValue, 0)
+			for !p.skipPunctuator("]") {
+				v.list = append(v.list, p.parseValue(isConst))
+			}
+		case "{":
+			v.kind, v.fields = graphQLValueObject, make(map[string]*graphQLValue)
+			for !p.skipPunctuator("}") {
+				name := p.expectName()
+				if _, ok := v.fields[name.value]; ok {
+					p.fail(name, "There can be only one input field named %q.", name.value)
+				}
+				p.expectPunctuator(":")
+				v.fields[name.value] = p.parseValue(isConst)
+			}
+		default:
+			p.fail(token, "Unexpected %s.", describeGraphQLToken(token))
+		}
+	default:
+		p.fail(token, "Unexpected %s.", describeGraphQLToken(token))
+	}
+	return v
+}
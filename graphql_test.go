@@ -0,0 +1,139 @@
+package main
+
+import (
+	stdjson "encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseGraphQL(t *testing.T) {
+	doc, err := parseGraphQL(`
+	# a comment
+	query Get($id: Int!, $ids: [Int!] = [1, 2]) {
+		first: recipe(id: $id) { ...Fields, owner @skip(if: true) { account } }
+		... on Query { recipes(filter: {name: "a\"bé", prepareTimeFrom: -1}) { id } }
+	}
+	fragment Fields on Recipe { name }
+	`)
+	assert.NoError(t, err)
+	assert.Len(t, doc.operations, 1)
+	op := doc.operations[0]
+	assert.Equal(t, "query", op.kind)
+	assert.Equal(t, "Get", op.name)
+	assert.Equal(t, "[Int!]", op.variables[1].typ)
+	assert.Equal(t, []interface{}{stdjson.Number("1"), stdjson.Number("2")}, op.variables[1].defaultValue.input(nil))
+
+	first := op.selections[0]
+	assert.Equal(t, "first", first.responseKey())
+	assert.Equal(t, "recipe", first.name)
+	assert.Equal(t, 32, first.arguments["id"].input(map[string]interface{}{"id": 32}))
+	assert.Equal(t, "Fields", first.selections[0].fragment)
+	assert.Equal(t, "skip", first.selections[1].directives[0].name)
+	assert.Equal(t, &GraphQLLocation{Line: 4, Column: 3}, first.location)
+
+	inline := op.selections[1]
+	assert.True(t, inline.inline)
+	assert.Equal(t, "Query", inline.typeCondition)
+	assert.Equal(t, map[string]interface{}{"name": "a\"bé", "prepareTimeFrom": stdjson.Number("-1")}, inline.selections[0].arguments["filter"].input(nil))
+
+	assert.Equal(t, "Recipe", doc.fragments["Fields"].typeCondition)
+
+	doc, err = parseGraphQL(`{ recipe(id: 01) { id } }`)
+	assert.Nil(t, doc)
+	assert.Equal(t, &graphQLSyntaxError{message: "Syntax Error: Invalid number.", location: &GraphQLLocation{Line: 1, Column: 14}}, err)
+
+	_, err = parseGraphQL("{ recipes {\n id }\n} }")
+	assert.Equal(t, &graphQLSyntaxError{message: `Syntax Error: Unexpected "}".`, location: &GraphQLLocation{Line: 3, Column: 3}}, err)
+
+	_, err = parseGraphQL(`{ recipe(id: "1) { id } }`)
+	assert.EqualError(t, err, "Syntax Error: Unterminated string.")
+
+	_, err = parseGraphQL(`query($id: Int = $other) { recipe(id: $id) { id } }`)
+	assert.EqualError(t, err, "Syntax Error: Unexpected variable in a constant value.")
+}
+
+func TestValidateGraphQL(t *testing.T) {
+	messages := func(query, operationName string) []string {
+		doc, err := parseGraphQL(query)
+		assert.NoError(t, err)
+		_, errs := validateGraphQL(doc, operationName)
+		res := make([]string, len(errs))
+		for i, e := range errs {
+			res[i] = e.Message
+		}
+		return res
+	}
+
+	assert.Empty(t, messages(`query A { recipes { id } } mutation B { deleteRecipe(id: 1) { id } }`, "B"))
+	assert.Equal(t, []string{`Unknown operation named "C".`}, messages(`query A { recipes { id } } query B { recipes { id } }`, "C"))
+	assert.Equal(t, []string{"Must provide operation name if query contains multiple operations."}, messages(`query A { recipes { id } } query B { recipes { id } }`, ""))
+	assert.Equal(t, []string{`Cannot spread fragment "A" within itself.`, `Cannot spread fragment "B" within itself.`}, messages(`{ recipes { ...A } } fragment B on Recipe { ...A } fragment A on Recipe { ...B }`, ""))
+	assert.Equal(t, []string{`Variable "$id" is not defined.`}, messages(`{ recipe(id: $id) { id } }`, ""))
+	assert.Equal(t, []string{`Field "recipe" argument "id" of type "Int!" is required, but it was not provided.`}, messages(`{ recipe { id } }`, ""))
+	assert.Equal(t, []string{`Unknown argument "size" on field "recipes".`}, messages(`{ recipes(size: 1) { id } }`, ""))
+	assert.Equal(t, []string{`Field "recipes" of type "[Recipe!]!" must have a selection of subfields.`}, messages(`{ recipes }`, ""))
+	assert.Equal(t, []string{`Field "name" must not have a selection since type "String!" has no subfields.`}, messages(`{ recipes { name { id } } }`, ""))
+	assert.Equal(t, []string{`Fragment "F" cannot be spread here as objects of type "Query" can never be of type "Recipe".`}, messages(`{ ...F } fragment F on Recipe { id }`, ""))
+	assert.Equal(t, []string{`Unknown directive "@defer".`}, messages(`{ recipes @defer { id } }`, ""))
+	assert.Equal(t, []string{"The subscription operation is not supported."}, messages(`subscription { recipes { id } }`, ""))
+}
+
+func TestCoerceGraphQLInput(t *testing.T) {
+	v, err := coerceGraphQLInput("RecipeInput!", map[string]interface{}{
+		"name":         "name1",
+		"prepareTime":  stdjson.Number("5"),
+		"isVegetarian": true,
+		"publishAt":    "2018-03-01T00:00:00Z",
+		"difficulty":   nil,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"name":         "name1",
+		"prepareTime":  5,
+		"isVegetarian": true,
+		"publishAt":    time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC),
+		"difficulty":   nil,
+	}, v)
+
+	v, err = coerceGraphQLInput("[Int]", stdjson.Number("1"))
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{1}, v)
+
+	_, err = coerceGraphQLInput("RecipeInput", map[string]interface{}{"name": "name1"})
+	assert.EqualError(t, err, `field "RecipeInput.isVegetarian" of required type "Boolean!" was not provided`)
+	_, err = coerceGraphQLInput("RecipeFilter", map[string]interface{}{"owner": "foo"})
+	assert.EqualError(t, err, `field "owner" is not defined by type "RecipeFilter"`)
+	_, err = coerceGraphQLInput("Int", stdjson.Number("1.5"))
+	assert.EqualError(t, err, "Int cannot represent 1.5")
+	_, err = coerceGraphQLInput("Int!", nil)
+	assert.EqualError(t, err, `expected non-nullable type "Int!" not to be null`)
+}
+
+func TestGraphQLObjectKeepsTheOrderOfFields(t *testing.T) {
+	doc, err := stdjson.Marshal(graphQLObject{
+		{key: "name", value: "name1"},
+		{key: "id", value: 1},
+		{key: "rating", value: graphQLObject{}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name":"name1","id":1,"rating":{}}`, string(doc))
+}
+
+func TestRecipeOwnerLoader(t *testing.T) {
+	md := &mockDatastore{}
+	l := newRecipeOwnerLoader(md)
+	l.queue(&Recipe{ID: 1}, &Recipe{ID: 2}, nil)
+
+	account, ok := l.load(2)
+	assert.True(t, ok)
+	assert.Equal(t, "foo", account)
+	account, ok = l.load(1)
+	assert.True(t, ok)
+	assert.Equal(t, "foo", account)
+	assert.Equal(t, 1, md.ownerLookups)
+
+	l.load(3)
+	assert.Equal(t, 2, md.ownerLookups)
+}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	null "gopkg.in/guregu/null.v3"
+)
+
+const (
+	grpcServiceName    = "recipes.v1.Recipes"
+	grpcMaxMessageSize = 4 * 1024 * 1024
+)
+
+var errGRPCNotFound = status.Error(codes.NotFound, "not found")
+
+type grpcUnaryMethod struct {
+	newRequest func() proto.Message
+	handle     func(s *grpcServer, ds datastore, req proto.Message, token string) (proto.Message, error)
+}
+
+var grpcUnaryMethods = map[string]*grpcUnaryMethod{
+	"GetRecipe": {
+		newRequest: func() proto.Message { return &GRPCGetRecipeRequest{} },
+		handle: func(s *grpcServer, ds datastore, req proto.Message, token string) (proto.Message, error) {
+			return s.getRecipe(ds, req.(*GRPCGetRecipeRequest))
+		},
+	},
+	"AddRecipe": {
+		newRequest: func() proto.Message { return &GRPCAddRecipeRequest{} },
+		handle: func(s *grpcServer, ds datastore, req proto.Message, token string) (proto.Message, error) {
+			return s.addRecipe(ds, req.(*GRPCAddRecipeRequest), token)
+		},
+	},
+	"UpdateRecipe": {
+		newRequest: func() proto.Message { return &GRPCUpdateRecipeRequest{} },
+		handle: func(s *grpcServer, ds datastore, req proto.Message, token string) (proto.Message, error) {
+			return s.updateRecipe(ds, req.(*GRPCUpdateRecipeRequest), token)
+		},
+	},
+	"DeleteRecipe": {
+		newRequest: func() proto.Message { return &GRPCDeleteRecipeRequest{} },
+		handle: func(s *grpcServer, ds datastore, req proto.Message, token string) (proto.Message, error) {
+			return s.deleteRecipe(ds, req.(*GRPCDeleteRecipeRequest), token)
+		},
+	},
+	"RateRecipe": {
+		newRequest: func() proto.Message { return &GRPCRateRecipeRequest{} },
+		handle: func(s *grpcServer, ds datastore, req proto.Message, token string) (proto.Message, error) {
+			return s.rateRecipe(ds, req.(*GRPCRateRecipeRequest), token)
+		},
+	},
+}
+
+// grpcCodec encodes the messages of grpc_proto.go with the proto package
+// that they are written for, instead of the one of the default codec.
+type grpcCodec struct{}
+
+func (grpcCodec) Marshal(v interface{}) ([]byte, error) {
+	return proto.Marshal(v.(proto.Message))
+}
+
+func (grpcCodec) Unmarshal(data []byte, v interface{}) error {
+	return proto.Unmarshal(data, v.(proto.Message))
+}
+
+func (grpcCodec) Name() string {
+	return "proto"
+}
+
+// grpcServer serves the Recipes service of proto/recipes.proto, sharing the
+// datastore and the credentials of the HTTP API. The calls are traced,
+// logged and measured like the requests of the HTTP API.
+type grpcServer struct {
+	*grpc.Server
+	api *apiServer
+}
+
+func newGRPCServer(api *apiServer) *grpcServer {
+	s := &grpcServer{
+		Server: grpc.NewServer(grpc.ForceServerCodec(grpcCodec{}), grpc.MaxRecvMsgSize(grpcMaxMessageSize)),
+		api:    api,
+	}
+	desc := &grpc.ServiceDesc{
+		ServiceName: grpcServiceName,
+		HandlerType: (*interface{})(nil),
+		Streams: []grpc.StreamDesc{
+			{StreamName: "ListRecipes", Handler: s.serveListRecipes, ServerStreams: true},
+		},
+		Metadata: "proto/recipes.proto",
+	}
+	for name, m := range grpcUnaryMethods {
+		desc.Methods = append(desc.Methods, grpc.MethodDesc{MethodName: name, Handler: s.unaryHandler(name, m)})
+	}
+	s.RegisterService(desc, s)
+	return s
+}
+
+func (s *grpcServer) run(address string) {
+	lis, err := net.Listen("tcp", address)
+	if err == nil {
+		err = s.Serve(lis)
+	}
+	if err != nil {
+		logger.error("error starting grpc service", logFields{"error": err})
+		os.Exit(1)
+	}
+}
+
+// shutdown waits for the calls in flight, and cancels them once the
+// context is done.
+func (s *grpcServer) shutdown(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.Stop()
+	}
+}
+
+func (s *grpcServer) unaryHandler(name string, m *grpcUnaryMethod) grpc.MethodHandler {
+	return func(_ interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+		var res proto.Message
+		err := s.serve(ctx, name, func(ctx context.Context, ds datastore, token string) (int, error) {
+			req := m.newRequest()
+			if err := decodeGRPCMessage(dec, req); err != nil {
+				return 0, err
+			}
+			var err error
+			if res, err = m.handle(s, ds, req, token); err != nil {
+				return 0, err
+			}
+			return proto.Size(res), nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return res, nil
+	}
+}
+
+func (s *grpcServer) serveListRecipes(_ interface{}, stream grpc.ServerStream) error {
+	return s.serve(stream.Context(), "ListRecipes", func(ctx context.Context, ds datastore, token string) (int, error) {
+		req := &GRPCListRecipesRequest{}
+		if err := decodeGRPCMessage(stream.RecvMsg, req); err != nil {
+			return 0, err
+		}
+		size := 0
+		err := s.listRecipes(ds, req, func(recipe *GRPCRecipe) error {
+			if err := ctx.Err(); err != nil {
+				return status.FromContextError(err).Err()
+			}
+			size += proto.Size(recipe)
+			return stream.SendMsg(recipe)
+		})
+		return size, err
+	})
+}
+
+// serve runs a call of a method like an HTTP request of the route named
+// after the method: it gets a request ID, runs in a server span, and is
+// logged and measured once it returns. A panic is processed like the one of
+// an HTTP request and reported with the INTERNAL status. The call returns
+// the size of the messages it sent.
+func (s *grpcServer) serve(ctx context.Context, method string, call func(ctx context.Context, ds datastore, token string) (int, error)) (err error) {
+	start := time.Now()
+	h := s.api.httpServer
+	route := "/" + grpcServiceName + "/" + method
+	md, _ := metadata.FromIncomingContext(ctx)
+	id := firstMetadataValue(md, strings.ToLower(requestIDHeader))
+	if !isValidRequestID(id) {
+		id = newRequestID()
+	}
+	grpc.SetHeader(ctx, metadata.Pairs(strings.ToLower(requestIDHeader), id))
+	parent, _ := parseTraceparent(firstMetadataValue(md, traceparentHeader))
+	rs := h.tracer.startSpan(parent, http.MethodPost+" "+route, spanKindServer)
+	l := h.logger.with(logFields{"request_id": id, "trace_id": rs.context.traceID.String()})
+	ctx, user := contextWithRequestUser(contextWithSpan(ctx, rs))
+
+	size := 0
+	defer func() {
+		if p := recover(); p != nil {
+			report := newPanicReport(id, p, dumpGRPCRequest(route, md))
+			h.logPanic(l, report)
+			h.reportPanic(l, report)
+			err = status.Error(codes.Internal, "internal error")
+		}
+		code := status.Code(err)
+		rs.setAttribute("http.method", http.MethodPost)
+		rs.setAttribute("http.route", route)
+		rs.setAttribute("http.target", route)
+		rs.setAttribute("http.status_code", http.StatusOK)
+		rs.setAttribute("http.request_id", id)
+		rs.setAttribute("rpc.grpc.status_code", int(code))
+		if code == codes.Internal {
+			rs.setError(status.Convert(err).Message())
+		}
+		rs.finish()
+
+		fields := logFields{
+			"method":      http.MethodPost,
+			"route":       route,
+			"path":        route,
+			"status":      http.StatusOK,
+			"latency_ms":  float64(time.Since(start)) / float64(time.Millisecond),
+			"bytes":       size,
+			"client_ip":   grpcClientIP(ctx),
+			"grpc_status": int(code),
+		}
+		if userID, ok := user.get(); ok {
+			fields["user_id"] = userID
+		}
+		if code == codes.Internal {
+			l.error("request", fields)
+		} else {
+			l.info("request", fields)
+		}
+		httpRequestsTotal.inc(http.MethodPost, route, strconv.Itoa(http.StatusOK))
+		httpRequestDuration.observeSince(start, http.MethodPost, route)
+	}()
+	size, err = call(ctx, s.api.datastore.withContext(ctx), firstMetadataValue(md, "authorization"))
+	return err
+}
+
+// decodeGRPCMessage reads the request message of a call, and reports a
+// message that is missing or can't be decoded as INVALID_ARGUMENT rather
+// than INTERNAL.
+func decodeGRPCMessage(dec func(interface{}) error, m proto.Message) error {
+	err := dec(m)
+	if err == io.EOF {
+		return status.Error(codes.InvalidArgument, "missing request message")
+	}
+	if status.Code(err) == codes.Internal {
+		return status.Error(codes.InvalidArgument, "malformed request message: "+status.Convert(err).Message())
+	}
+	return err
+}
+
+func firstMetadataValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func grpcClientIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+// dumpGRPCRequest dumps a call like an HTTP request without its body, with
+// the metadata as its header.
+func dumpGRPCRequest(route string, md metadata.MD) []byte {
+	r := &http.Request{
+		Method:     http.MethodPost,
+		URL:        &url.URL{Path: route},
+		Proto:      "HTTP/2.0",
+		ProtoMajor: 2,
+		Host:       firstMetadataValue(md, ":authority"),
+		Header:     make(http.Header),
+	}
+	for key, values := range md {
+		if strings.HasPrefix(key, ":") {
+			continue
+		}
+		for _, value := range values {
+			r.Header.Add(key, value)
+		}
+	}
+	r.Header = redactHeader(r.Header)
+	res, _ := httputil.DumpRequest(r, false)
+	return res
+}
+
+// listRecipes sends the recipes matching the filter a page at a time.
+func (s *grpcServer) listRecipes(ds datastore, req *GRPCListRecipesRequest, send func(*GRPCRecipe) error) error {
+	filter := &ListFilter{
+		Name:           req.Name,
+		PrepTimeFrom:   int(req.PrepareTimeFrom),
+		PrepTimeTo:     int(req.PrepareTimeTo),
+		DifficultyFrom: int(req.DifficultyFrom),
+		DifficultyTo:   int(req.DifficultyTo),
+		IsVegetarian:   req.IsVegetarian,
+	}
+	if filter.IsVegetarian != "" {
+		if _, err := strconv.ParseBool(filter.IsVegetarian); err != nil {
+			return status.Error(codes.InvalidArgument, "is_vegetarian must be a boolean")
+		}
+	}
+
+	paging := newPaging()
+	for {
+		res := ds.listRecipes(filter, paging)
+		if res == nil {
+			panic("got nil in method listRecipes")
+		}
+		for _, r := range res {
+			if err := send(newGRPCRecipe(r)); err != nil {
+				return err
+			}
+		}
+		if len(res) < paging.pageSize {
+			return nil
+		}
+		paging.pageNumber++
+	}
+}
+
+func (s *grpcServer) getRecipe(ds datastore, req *GRPCGetRecipeRequest) (proto.Message, error) {
+	if res := ds.getRecipeByID(int(req.Id)); res != nil {
+		return newGRPCRecipe(res), nil
+	}
+	return nil, errGRPCNotFound
+}
+
+func (s *grpcServer) addRecipe(ds datastore, req *GRPCAddRecipeRequest, token string) (proto.Message, error) {
+	put, err := newGRPCPutRecipeArg(req.Recipe)
+	if err != nil {
+		return nil, err
+	}
+	arg := put.postRecipeArg()
+	if err := validate.Struct(arg); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if res := ds.addRecipeByCredential(arg, token); res != nil {
+		return newGRPCRecipe(res), nil
+	}
+	return nil, errGRPCNotFound
+}
+
+func (s *grpcServer) updateRecipe(ds datastore, req *GRPCUpdateRecipeRequest, token string) (proto.Message, error) {
+	if req.Version == 0 && s.api.requireIfMatch {
+		return nil, status.Error(codes.FailedPrecondition, "version is required")
+	}
+	arg, err := newGRPCPutRecipeArg(req.Recipe)
+	if err != nil {
+		return nil, err
+	}
+	if err := validate.Struct(arg); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if res := ds.updateAndGetRecipeByCredential(arg, int(req.Id), int(req.Version), token); res != nil {
+		return newGRPCRecipe(res), nil
+	}
+	return nil, s.failedWrite(ds, int(req.Id), int(req.Version), token)
+}
+
+func (s *grpcServer) deleteRecipe(ds datastore, req *GRPCDeleteRecipeRequest, token string) (proto.Message, error) {
+	if req.Version == 0 && s.api.requireIfMatch {
+		return nil, status.Error(codes.FailedPrecondition, "version is required")
+	}
+
+	if res := ds.deleteAndGetRecipeByCredential(int(req.Id), int(req.Version), token); res != nil {
+		return newGRPCRecipe(res), nil
+	}
+	return nil, s.failedWrite(ds, int(req.Id), int(req.Version), token)
+}
+
+func (s *grpcServer) rateRecipe(ds datastore, req *GRPCRateRecipeRequest, token string) (proto.Message, error) {
+	arg := &PostRateRecipeArg{Rating: null.IntFrom(int64(req.Rating))}
+	if err := validate.Struct(arg); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if res := ds.rateAndGetRecipe(arg, int(req.Id), token); res != nil {
+		return newGRPCRecipe(res), nil
+	}
+	return nil, errGRPCNotFound
+}
+
+func (s *grpcServer) failedWrite(ds datastore, id int, version int, token string) error {
+	if s.api.isVersionMismatched(ds, id, version, token) {
+		return status.Error(codes.FailedPrecondition, "version does not match")
+	}
+	return errGRPCNotFound
+}
+
+func newGRPCRecipe(r *Recipe) *GRPCRecipe {
+	return &GRPCRecipe{
+		Id:           int64(r.ID),
+		Name:         r.Name,
+		PrepareTime:  int32(r.PrepareTime.Int64),
+		Difficulty:   int32(r.Difficulty.Int64),
+		IsVegetarian: r.IsVegetarian,
+		Rating:       r.Rating.Float64,
+		RatedNum:     r.RatedNum.Int64,
+		PublishAt:    formatGRPCTime(r.PublishAt),
+		UnpublishAt:  formatGRPCTime(r.UnpublishAt),
+		Version:      int64(r.Version),
+	}
+}
+
+// newGRPCPutRecipeArg reads the zero values of the optional fields of a
+// recipe as null.
+func newGRPCPutRecipeArg(in *GRPCRecipeInput) (*PutRecipeArg, error) {
+	if in == nil {
+		return nil, status.Error(codes.InvalidArgument, "recipe is required")
+	}
+	arg := &PutRecipeArg{
+		Name:         null.StringFrom(in.Name),
+		IsVegetarian: null.BoolFrom(in.IsVegetarian),
+	}
+	if in.PrepareTime != 0 {
+		arg.PrepareTime = null.IntFrom(int64(in.PrepareTime))
+	}
+	if in.Difficulty != 0 {
+		arg.Difficulty = null.IntFrom(int64(in.Difficulty))
+	}
+	var err error
+	if arg.PublishAt, err = parseGRPCTime("publish_at", in.PublishAt); err != nil {
+		return nil, err
+	}
+	if arg.UnpublishAt, err = parseGRPCTime("unpublish_at", in.UnpublishAt); err != nil {
+		return nil, err
+	}
+	return arg, nil
+}
+
+func formatGRPCTime(t null.Time) string {
+	if !t.Valid {
+		return ""
+	}
+	return t.Time.Format(time.RFC3339Nano)
+}
+
+func parseGRPCTime(name, s string) (null.Time, error) {
+	if s == "" {
+		return null.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return null.Time{}, status.Error(codes.InvalidArgument, name+" must be an RFC 3339 date-time")
+	}
+	return null.TimeFrom(t), nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import "github.com/golang/protobuf/proto"
+
+// The messages of proto/recipes.proto, which must be kept in sync with it.
+// The Go types carry a GRPC prefix to keep apart from the models.
+
+type GRPCRecipe struct {
+	Id           int64   `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Name         string  `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	PrepareTime  int32   `protobuf:"varint,3,opt,name=prepare_time,json=prepareTime,proto3" json:"prepare_time,omitempty"`
+	Difficulty   int32   `protobuf:"varint,4,opt,name=difficulty,proto3" json:"difficulty,omitempty"`
+	IsVegetarian bool    `protobuf:"varint,5,opt,name=is_vegetarian,json=isVegetarian,proto3" json:"is_vegetarian,omitempty"`
+	Rating       float64 `protobuf:"fixed64,6,opt,name=rating,proto3" json:"rating,omitempty"`
+	RatedNum     int64   `protobuf:"varint,7,opt,name=rated_num,json=ratedNum,proto3" json:"rated_num,omitempty"`
+	PublishAt    string  `protobuf:"bytes,8,opt,name=publish_at,json=publishAt,proto3" json:"publish_at,omitempty"`
+	UnpublishAt  string  `protobuf:"bytes,9,opt,name=unpublish_at,json=unpublishAt,proto3" json:"unpublish_at,omitempty"`
+	Version      int64   `protobuf:"varint,10,opt,name=version,proto3" json:"version,omitempty"`
+}
+
+func (m *GRPCRecipe) Reset()         { *m = GRPCRecipe{} }
+func (m *GRPCRecipe) String() string { return proto.CompactTextString(m) }
+func (*GRPCRecipe) ProtoMessage()    {}
+
+type GRPCRecipeInput struct {
+	Name         string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	PrepareTime  int32  `protobuf:"varint,2,opt,name=prepare_time,json=prepareTime,proto3" json:"prepare_time,omitempty"`
+	Difficulty   int32  `protobuf:"varint,3,opt,name=difficulty,proto3" json:"difficulty,omitempty"`
+	IsVegetarian bool   `protobuf:"varint,4,opt,name=is_vegetarian,json=isVegetarian,proto3" json:"is_vegetarian,omitempty"`
+	PublishAt    string `protobuf:"bytes,5,opt,name=publish_at,json=publishAt,proto3" json:"publish_at,omitempty"`
+	UnpublishAt  string `protobuf:"bytes,6,opt,name=unpublish_at,json=unpublishAt,proto3" json:"unpublish_at,omitempty"`
+}
+
+func (m *GRPCRecipeInput) Reset()         { *m = GRPCRecipeInput{} }
+func (m *GRPCRecipeInput) String() string { return proto.CompactTextString(m) }
+func (*GRPCRecipeInput) ProtoMessage()    {}
+
+type GRPCListRecipesRequest struct {
+	Name            string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	PrepareTimeFrom int32  `protobuf:"varint,2,opt,name=prepare_time_from,json=prepareTimeFrom,proto3" json:"prepare_time_from,omitempty"`
+	PrepareTimeTo   int32  `protobuf:"varint,3,opt,name=prepare_time_to,json=prepareTimeTo,proto3" json:"prepare_time_to,omitempty"`
+	DifficultyFrom  int32  `protobuf:"varint,4,opt,name=difficulty_from,json=difficultyFrom,proto3" json:"difficulty_from,omitempty"`
+	DifficultyTo    int32  `protobuf:"varint,5,opt,name=difficulty_to,json=difficultyTo,proto3" json:"difficulty_to,omitempty"`
+	IsVegetarian    string `protobuf:"bytes,6,opt,name=is_vegetarian,json=isVegetarian,proto3" json:"is_vegetarian,omitempty"`
+}
+
+func (m *GRPCListRecipesRequest) Reset()         { *m = GRPCListRecipesRequest{} }
+func (m *GRPCListRecipesRequest) String() string { return proto.CompactTextString(m) }
+func (*GRPCListRecipesRequest) ProtoMessage()    {}
+
+type GRPCGetRecipeRequest struct {
+	Id int64 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+}
+
+func (m *GRPCGetRecipeRequest) Reset()         { *m = GRPCGetRecipeRequest{} }
+func (m *GRPCGetRecipeRequest) String() string { return proto.CompactTextString(m) }
+func (*GRPCGetRecipeRequest) ProtoMessage()    {}
+
+type GRPCAddRecipeRequest struct {
+	Recipe *GRPCRecipeInput `protobuf:"bytes,1,opt,name=recipe,proto3" json:"recipe,omitempty"`
+}
+
+func (m *GRPCAddRecipeRequest) Reset()         { *m = GRPCAddRecipeRequest{} }
+func (m *GRPCAddRecipeRequest) String() string { return proto.CompactTextString(m) }
+func (*GRPCAddRecipeRequest) ProtoMessage()    {}
+
+type GRPCUpdateRecipeRequest struct {
+	Id      int64            `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Recipe  *GRPCRecipeInput `protobuf:"bytes,2,opt,name=recipe,proto3" json:"recipe,omitempty"`
+	Version int64            `protobuf:"varint,3,opt,name=version,proto3" json:"version,omitempty"`
+}
+
+func (m *GRPCUpdateRecipeRequest) Reset()         { *m = GRPCUpdateRecipeRequest{} }
+func (m *GRPCUpdateRecipeRequest) String() string { return proto.CompactTextString(m) }
+func (*GRPCUpdateRecipeRequest) ProtoMessage()    {}
+
+type GRPCDeleteRecipeRequest struct {
+	Id      int64 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Version int64 `protobuf:"varint,2,opt,name=version,proto3" json:"version,omitempty"`
+}
+
+func (m *GRPCDeleteRecipeRequest) Reset()         { *m = GRPCDeleteRecipeRequest{} }
+func (m *GRPCDeleteRecipeRequest) String() string { return proto.CompactTextString(m) }
+func (*GRPCDeleteRecipeRequest) ProtoMessage()    {}
+
+type GRPCRateRecipeRequest struct {
+	Id     int64 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Rating int32 `protobuf:"varint,2,opt,name=rating,proto3" json:"rating,omitempty"`
+}
+
+func (m *GRPCRateRecipeRequest) Reset()         { *m = GRPCRateRecipeRequest{} }
+func (m *GRPCRateRecipeRequest) String() string { return proto.CompactTextString(m) }
+func (*GRPCRateRecipeRequest) ProtoMessage()    {}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	stdjson "encoding/json"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	null "gopkg.in/guregu/null.v3"
+)
+
+// grpcRawCodec sends the bytes of a request message as they are, to call
+// the service with messages that can't be decoded.
+type grpcRawCodec struct{ grpcCodec }
+
+func (c grpcRawCodec) Marshal(v interface{}) ([]byte, error) {
+	if b, ok := v.([]byte); ok {
+		return b, nil
+	}
+	return c.grpcCodec.Marshal(v)
+}
+
+// startGRPCServer serves the service on a local port, and returns a client
+// connected to it and a function to stop both.
+func startGRPCServer(t *testing.T, api *apiServer) (*grpc.ClientConn, func()) {
+	s := newGRPCServer(api)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go s.Serve(lis)
+	conn, err := grpc.NewClient(lis.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(grpcRawCodec{})))
+	assert.NoError(t, err)
+	return conn, func() {
+		conn.Close()
+		s.Stop()
+	}
+}
+
+func callGRPC(conn *grpc.ClientConn, method string, req interface{}, token string) (*GRPCRecipe, metadata.MD, error) {
+	ctx := context.Background()
+	if token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", token)
+	}
+	res := &GRPCRecipe{}
+	var header metadata.MD
+	err := conn.Invoke(ctx, "/"+grpcServiceName+"/"+method, req, res, grpc.Header(&header))
+	return res, header, err
+}
+
+func listGRPCRecipes(t *testing.T, conn *grpc.ClientConn, req proto.Message) ([]*GRPCRecipe, error) {
+	stream, err := conn.NewStream(context.Background(), &grpc.StreamDesc{ServerStreams: true}, "/"+grpcServiceName+"/ListRecipes")
+	assert.NoError(t, err)
+	assert.NoError(t, stream.SendMsg(req))
+	assert.NoError(t, stream.CloseSend())
+	res := make([]*GRPCRecipe, 0)
+	for {
+		r := &GRPCRecipe{}
+		if err := stream.RecvMsg(r); err == io.EOF {
+			return res, nil
+		} else if err != nil {
+			return res, err
+		}
+		res = append(res, r)
+	}
+}
+
+func TestGRPCListRecipes(t *testing.T) {
+	conn, stop := startGRPCServer(t, newTestAPIServer([]*Recipe{
+		{ID: 1, Name: "name1", Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0)},
+		{ID: 11, Name: "name11", PrepareTime: null.IntFrom(1), IsVegetarian: true, Rating: null.FloatFrom(4.5), RatedNum: null.IntFrom(2)},
+	}))
+	defer stop()
+
+	res, err := listGRPCRecipes(t, conn, &GRPCListRecipesRequest{IsVegetarian: "true"})
+	assert.NoError(t, err)
+	assert.Len(t, res, 2)
+	assert.True(t, proto.Equal(&GRPCRecipe{Id: 1, Name: "name1"}, res[0]))
+	assert.True(t, proto.Equal(&GRPCRecipe{Id: 11, Name: "name11", PrepareTime: 1, IsVegetarian: true, Rating: 4.5, RatedNum: 2}, res[1]))
+
+	_, err = listGRPCRecipes(t, conn, &GRPCListRecipesRequest{IsVegetarian: "maybe"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestGRPCUnaryMethods(t *testing.T) {
+	conn, stop := startGRPCServer(t, newTestAPIServer(&Recipe{ID: 32, Name: "name3", Rating: null.FloatFrom(0.0), RatedNum: null.IntFrom(0), Version: 2}))
+	defer stop()
+
+	res, _, err := callGRPC(conn, "AddRecipe", &GRPCAddRecipeRequest{Recipe: &GRPCRecipeInput{Name: "name3", PublishAt: "2018-03-01T00:00:00Z"}}, "faketoken")
+	assert.NoError(t, err)
+	assert.True(t, proto.Equal(&GRPCRecipe{Id: 32, Name: "name3", Version: 2}, res))
+
+	_, _, err = callGRPC(conn, "AddRecipe", &GRPCAddRecipeRequest{Recipe: &GRPCRecipeInput{Name: "name3", Difficulty: 4}}, "faketoken")
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, _, err = callGRPC(conn, "AddRecipe", &GRPCAddRecipeRequest{}, "faketoken")
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Equal(t, "recipe is required", status.Convert(err).Message())
+
+	_, _, err = callGRPC(conn, "UpdateRecipe", &GRPCUpdateRecipeRequest{Id: 32, Recipe: &GRPCRecipeInput{Name: "name4"}, Version: 1}, "faketoken")
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	_, _, err = callGRPC(conn, "DeleteRecipe", &GRPCDeleteRecipeRequest{Id: 32, Version: 2}, "faketoken")
+	assert.NoError(t, err)
+	_, _, err = callGRPC(conn, "RateRecipe", &GRPCRateRecipeRequest{Id: 32, Rating: 6}, "")
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, _, err = callGRPC(conn, "PatchRecipe", &GRPCGetRecipeRequest{Id: 32}, "")
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+
+	conn, stop = startGRPCServer(t, newTestAPIServer(nil))
+	defer stop()
+	_, _, err = callGRPC(conn, "GetRecipe", &GRPCGetRecipeRequest{Id: 32}, "")
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Equal(t, "not found", status.Convert(err).Message())
+}
+
+func TestGRPCInstrumentation(t *testing.T) {
+	api := newTestAPIServer(nil)
+	buf := &bytes.Buffer{}
+	api.httpServer.logger = newJSONLogger(buf, logLevelInfo)
+	api.datastore = &mockDatastore{dataFunc: func() interface{} {
+		panic("connection refused")
+	}}
+	conn, stop := startGRPCServer(t, api)
+
+	_, header, err := callGRPC(conn, "GetRecipe", &GRPCGetRecipeRequest{Id: 32}, "")
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.Equal(t, "internal error", status.Convert(err).Message())
+	assert.Len(t, header.Get("x-request-id"), 1)
+	assert.Regexp(t, `^[0-9a-f]{32}$`, header.Get("x-request-id")[0])
+
+	_, _, err = callGRPC(conn, "GetRecipe", []byte("\x08"), "")
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "malformed request message")
+	stop()
+
+	lines := make([]map[string]interface{}, 0)
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		m := make(map[string]interface{})
+		assert.NoError(t, stdjson.Unmarshal(line, &m))
+		lines = append(lines, m)
+	}
+	assert.Len(t, lines, 3)
+	assert.Equal(t, "panic", lines[0]["msg"])
+	assert.Equal(t, "connection refused", lines[0]["panic"])
+	assert.Equal(t, "error", lines[1]["level"])
+	assert.Equal(t, "/"+grpcServiceName+"/GetRecipe", lines[1]["route"])
+	assert.Equal(t, 13.0, lines[1]["grpc_status"])
+	assert.Equal(t, "127.0.0.1", lines[1]["client_ip"])
+	assert.Equal(t, header.Get("x-request-id")[0], lines[1]["request_id"])
+	assert.Equal(t, "info", lines[2]["level"])
+	assert.Equal(t, 3.0, lines[2]["grpc_status"])
+}
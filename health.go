@@ -0,0 +1,160 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	null "gopkg.in/guregu/null.v3"
+)
+
+const (
+	healthStatusOK           = "ok"
+	healthStatusDown         = "down"
+	healthStatusShuttingDown = "shutting down"
+
+	readinessTimeout = 2 * time.Second
+)
+
+// buildVersion is the version the service is built as, which is set by
+// go build -ldflags "-X main.buildVersion=...".
+var buildVersion = "dev"
+
+// HealthStatus is the response of the liveness check.
+type HealthStatus struct {
+	Status string `json:"status"`
+}
+
+// DependencyStatus is the result of a check of a dependency of the service.
+type DependencyStatus struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// ConnectionPoolStatus is how busy the connection pool of the database is.
+// The saturation is the ratio of the connections in use to the maximum
+// number of open connections, which is null when the pool is unlimited.
+type ConnectionPoolStatus struct {
+	MaxOpen    int        `json:"max_open"`
+	Open       int        `json:"open"`
+	InUse      int        `json:"in_use"`
+	Idle       int        `json:"idle"`
+	WaitCount  int64      `json:"wait_count"`
+	Saturation null.Float `json:"saturation"`
+}
+
+// ReadinessStatus is the response of the readiness check.
+type ReadinessStatus struct {
+	Status   string                `json:"status"`
+	Database *DependencyStatus     `json:"database,omitempty"`
+	Pool     *ConnectionPoolStatus `json:"pool,omitempty"`
+}
+
+// ServiceStatus is the detailed state of the service for the admins.
+type ServiceStatus struct {
+	Version       string                       `json:"version"`
+	GoVersion     string                       `json:"go_version"`
+	StartedAt     time.Time                    `json:"started_at"`
+	UptimeSeconds float64                      `json:"uptime_seconds"`
+	Ready         bool                         `json:"ready"`
+	Dependencies  map[string]*DependencyStatus `json:"dependencies"`
+	Pool          *ConnectionPoolStatus        `json:"pool"`
+}
+
+func newConnectionPoolStatus(stats sql.DBStats) *ConnectionPoolStatus {
+	res := &ConnectionPoolStatus{
+		MaxOpen:   stats.MaxOpenConnections,
+		Open:      stats.OpenConnections,
+		InUse:     stats.InUse,
+		Idle:      stats.Idle,
+		WaitCount: stats.WaitCount,
+	}
+	if stats.MaxOpenConnections > 0 {
+		res.Saturation = null.FloatFrom(float64(stats.InUse) / float64(stats.MaxOpenConnections))
+	}
+	return res
+}
+
+// checkDependency times a check of a dependency, which fails if it returns
+// an error or panics.
+func checkDependency(check func() error) (res *DependencyStatus) {
+	res = &DependencyStatus{Status: healthStatusOK}
+	start := time.Now()
+	defer func() {
+		if p := recover(); p != nil {
+			res.Status, res.Error = healthStatusDown, fmt.Sprint(p)
+		}
+		res.LatencyMS = float64(time.Since(start)) / float64(time.Millisecond)
+	}()
+	if err := check(); err != nil {
+		res.Status, res.Error = healthStatusDown, err.Error()
+	}
+	return res
+}
+
+// beginShutdown makes the readiness check fail, so that no more traffic is
+// routed to the service while it shuts down.
+func (s *apiServer) beginShutdown() {
+	atomic.StoreInt32(&s.shuttingDown, 1)
+}
+
+func (s *apiServer) isShuttingDown() bool {
+	return atomic.LoadInt32(&s.shuttingDown) != 0
+}
+
+func (s *apiServer) getHealth(c *gin.Context) {
+	c.JSON(http.StatusOK, &HealthStatus{Status: healthStatusOK})
+}
+
+// getReadiness pings the database within readinessTimeout. The error of a
+// failed ping is only logged, since the check is public.
+func (s *apiServer) getReadiness(c *gin.Context) {
+	if s.isShuttingDown() {
+		c.JSON(http.StatusServiceUnavailable, &ReadinessStatus{Status: healthStatusShuttingDown})
+		return
+	}
+	ds := s.datastoreOf(c)
+	database := checkDependency(func() error {
+		return ds.ping(readinessTimeout)
+	})
+	res := &ReadinessStatus{
+		Status:   healthStatusOK,
+		Database: database,
+		Pool:     newConnectionPoolStatus(ds.poolStats()),
+	}
+	if database.Status != healthStatusOK {
+		requestLogger(c).warn("database is not ready", logFields{"error": database.Error})
+		database.Error = ""
+		res.Status = healthStatusDown
+		c.JSON(http.StatusServiceUnavailable, res)
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+func (s *apiServer) getStatus(c *gin.Context) {
+	ds := s.datastoreOf(c)
+	if !ds.isAdminByCredential(c.GetHeader("Authorization")) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	res := &ServiceStatus{
+		Version:       buildVersion,
+		GoVersion:     runtime.Version(),
+		StartedAt:     s.startedAt,
+		UptimeSeconds: time.Since(s.startedAt).Seconds(),
+		Dependencies:  make(map[string]*DependencyStatus),
+	}
+	res.Dependencies["database"] = checkDependency(func() error {
+		return ds.ping(readinessTimeout)
+	})
+	res.Pool = newConnectionPoolStatus(ds.poolStats())
+	res.Ready = !s.isShuttingDown() && res.Dependencies["database"].Status == healthStatusOK
+	c.JSON(http.StatusOK, res)
+}
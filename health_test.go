@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Checking the health", func() {
+	It("responses to the liveness check", func() {
+		server := newTestAPIServer(nil)
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/healthz", nil)
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(rr.Body.String()).To(MatchJSON(`{"status":"ok"}`))
+	})
+	It("is ready while the database can be pinged and the service isn't shutting down", func() {
+		server := newTestAPIServer(nil)
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/readyz", nil)
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(rr.Body.String()).To(MatchRegexp(`^\{"status":"ok","database":\{"status":"ok","latency_ms":[0-9.e-]+\},`))
+		Expect(rr.Body.String()).To(ContainSubstring(`"pool":{"max_open":10,"open":3,"in_use":2,"idle":1,"wait_count":0,"saturation":0.2}`))
+
+		server.datastore = &mockDatastore{pingErr: errors.New("dial tcp 10.0.0.1:5432: connection refused")}
+		rr = httptest.NewRecorder()
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(rr.Body.String()).To(MatchRegexp(`^\{"status":"down","database":\{"status":"down","latency_ms":[0-9.e-]+\},`))
+		Expect(rr.Body.String()).NotTo(ContainSubstring("10.0.0.1"))
+
+		server.datastore = &mockDatastore{}
+		server.beginShutdown()
+		rr = httptest.NewRecorder()
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(rr.Body.String()).To(MatchJSON(`{"status":"shutting down"}`))
+
+		rr = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/healthz", nil)
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusOK))
+	})
+	It("reports the status of the service to the admins only", func() {
+		server := newTestAPIServer(nil)
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/status", nil)
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusNotFound))
+
+		server.datastore = &mockDatastore{pingErr: errors.New("connection refused")}
+		rr = httptest.NewRecorder()
+		req.Header.Set("Authorization", "faketoken")
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(rr.Body.String()).To(ContainSubstring(`"version":"dev"`))
+		Expect(rr.Body.String()).To(ContainSubstring(`"ready":false`))
+		Expect(rr.Body.String()).To(MatchRegexp(`"database":\{"status":"down","latency_ms":[0-9.e-]+,"error":"connection refused"\}`))
+		Expect(rr.Body.String()).To(MatchRegexp(`"uptime_seconds":[0-9.e-]+`))
+	})
+})
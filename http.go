@@ -1,38 +1,240 @@
 package main
 
 import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	stdjson "encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httputil"
 	"os"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+const (
+	// routeKey keeps the route of a request that the router tree doesn't
+	// hold, for the metrics.
+	routeKey     = "route"
+	requestIDKey = "request_id"
+	loggerKey    = "logger"
+	// userIDKey keeps the ID of the user of the credential of a request,
+	// for the access log.
+	userIDKey = "user_id"
+
+	requestIDHeader    = "X-Request-ID"
+	maxRequestIDLength = 128
+
+	errorReportTimeout = 10 * time.Second
+)
+
 type ginHTTPServer struct {
 	*http.Server
-	router *gin.Engine
+	router        *gin.Engine
+	staticRoutes  map[string][]gin.HandlerFunc
+	logger        *jsonLogger
+	tracer        *tracer
+	errorReporter errorReporter
 }
 
 func newGinHTTPServer() *ginHTTPServer {
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.New()
-	router.Use(buildPanicProcessor(defaultPanicProcessor))
-	return &ginHTTPServer{
+	s := &ginHTTPServer{
 		&http.Server{Handler: router},
 		router,
+		make(map[string][]gin.HandlerFunc),
+		logger,
+		newTracer(nil, defaultTraceExportInterval),
+		nil,
+	}
+	router.Use(s.assignRequestID, s.traceRequests, s.logRequests, observeRequests, buildPanicProcessor(s.processPanic))
+	router.NoRoute(s.serveStaticRoute)
+	return s
+}
+
+// handleStaticRoute registers a handler for a static path like
+// "/recipes:batch" or "/recipes/import", which the router tree cannot hold
+// next to the parameterized routes sharing its prefix. The handlers run in
+// order until one of them aborts.
+func (s *ginHTTPServer) handleStaticRoute(httpMethod, path string, handlers ...gin.HandlerFunc) {
+	s.staticRoutes[httpMethod+" "+path] = handlers
+}
+
+func (s *ginHTTPServer) serveStaticRoute(c *gin.Context) {
+	handlers, ok := s.staticRoutes[c.Request.Method+" "+c.Request.URL.Path]
+	if ok {
+		c.Set(routeKey, c.Request.URL.Path)
+	} else {
+		c.Set(routeKey, metricsUnmatchedRouteName)
+	}
+	for _, handler := range handlers {
+		if c.IsAborted() {
+			return
+		}
+		handler(c)
 	}
 }
 
+// routes lists the routes of the router tree and the static routes.
+func (s *ginHTTPServer) routes() gin.RoutesInfo {
+	res := s.router.Routes()
+	for key := range s.staticRoutes {
+		route := strings.SplitN(key, " ", 2)
+		res = append(res, gin.RouteInfo{Method: route[0], Path: route[1]})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Path != res[j].Path {
+			return res[i].Path < res[j].Path
+		}
+		return res[i].Method < res[j].Method
+	})
+	return res
+}
+
 func (s *ginHTTPServer) run(address string) {
 	s.Addr = address
 	if err := s.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		fmt.Fprintln(os.Stderr, "error starting http service:", err)
+		s.logger.error("error starting http service", logFields{"error": err})
 		os.Exit(1)
 	}
 }
 
+// assignRequestID propagates the X-Request-ID of a request, or generates
+// one if it has none, and sets it on the response. Everything logged for
+// the request carries it.
+func (s *ginHTTPServer) assignRequestID(c *gin.Context) {
+	id := c.GetHeader(requestIDHeader)
+	if !isValidRequestID(id) {
+		id = newRequestID()
+	}
+	c.Set(requestIDKey, id)
+	c.Set(loggerKey, s.logger.with(logFields{"request_id": id}))
+	c.Header(requestIDHeader, id)
+}
+
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || strings.ContainsRune("-_.:+/=", r)) {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func requestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
+
+// requestLogger is the logger of a request, which adds its request ID.
+func requestLogger(c *gin.Context) *jsonLogger {
+	if l, ok := c.Get(loggerKey); ok {
+		return l.(*jsonLogger)
+	}
+	return logger
+}
+
+// traceRequests runs every request in a server span, under the span of the
+// traceparent header of the request if there is one. The span is named
+// after the route once the request has been handled.
+func (s *ginHTTPServer) traceRequests(c *gin.Context) {
+	parent, _ := parseTraceparent(c.GetHeader(traceparentHeader))
+	rs := s.tracer.startSpan(parent, c.Request.Method, spanKindServer)
+	defer rs.finish()
+	c.Request = c.Request.WithContext(contextWithSpan(c.Request.Context(), rs))
+	c.Set(loggerKey, requestLogger(c).with(logFields{"trace_id": rs.context.traceID.String()}))
+	c.Next()
+
+	route := requestRoute(c)
+	status := c.Writer.Status()
+	rs.setName(c.Request.Method + " " + route)
+	rs.setAttribute("http.method", c.Request.Method)
+	rs.setAttribute("http.route", route)
+	rs.setAttribute("http.target", c.Request.URL.RequestURI())
+	rs.setAttribute("http.status_code", status)
+	rs.setAttribute("http.request_id", requestID(c))
+	if status >= http.StatusInternalServerError {
+		rs.setError(http.StatusText(status))
+	}
+}
+
+// logRequests writes an access log line for every request once it has been
+// handled. The server errors are logged at the error level.
+func (s *ginHTTPServer) logRequests(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+	status := c.Writer.Status()
+	fields := logFields{
+		"method":     c.Request.Method,
+		"route":      requestRoute(c),
+		"path":       c.Request.URL.Path,
+		"status":     status,
+		"latency_ms": float64(time.Since(start)) / float64(time.Millisecond),
+		"bytes":      0,
+		"client_ip":  c.ClientIP(),
+	}
+	if size := c.Writer.Size(); size > 0 {
+		fields["bytes"] = size
+	}
+	if userID, ok := c.Get(userIDKey); ok {
+		fields["user_id"] = userID
+	}
+	if status >= http.StatusInternalServerError {
+		requestLogger(c).error("request", fields)
+	} else {
+		requestLogger(c).info("request", fields)
+	}
+}
+
+// observeRequests counts the requests and measures their latency by their
+// route, like "/recipes/:id", so that the metrics don't grow with the ids.
+func observeRequests(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+	route := requestRoute(c)
+	httpRequestsTotal.inc(c.Request.Method, route, strconv.Itoa(c.Writer.Status()))
+	httpRequestDuration.observeSince(start, c.Request.Method, route)
+}
+
+// requestRoute rebuilds the route of a request from its path parameters, as
+// the router doesn't tell which route it matched.
+func requestRoute(c *gin.Context) string {
+	if route, ok := c.Get(routeKey); ok {
+		return route.(string)
+	}
+	segments := strings.Split(c.Request.URL.Path, "/")
+	params := c.Params
+	for i, segment := range segments {
+		if len(params) == 0 {
+			break
+		}
+		if segment == params[0].Value {
+			segments[i] = ":" + params[0].Key
+			params = params[1:]
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
 type panicProcessor func(c *gin.Context, panic interface{})
 
 func buildPanicProcessor(processor panicProcessor) gin.HandlerFunc {
@@ -49,8 +251,120 @@ func buildPanicProcessor(processor panicProcessor) gin.HandlerFunc {
 	}
 }
 
-func defaultPanicProcessor(c *gin.Context, panicObj interface{}) {
-	httprequest, _ := httputil.DumpRequest(c.Request, false)
-	msg := fmt.Sprintf("[Panic] %s\n%s\n%s\n", time.Now().Format(time.RFC3339), httprequest, panicObj)
-	c.String(http.StatusInternalServerError, msg)
+// panicReport is what is known of a panic that a request ran into. The
+// request is dumped without its body and with its credentials redacted.
+type panicReport struct {
+	RequestID string
+	Time      time.Time
+	Panic     interface{}
+	Stack     []byte
+	Request   []byte
+}
+
+// errorReporter is told about every panic that a request runs into, e.g.
+// to forward it to an error tracking service.
+type errorReporter interface {
+	reportPanic(*panicReport) error
+}
+
+// ErrorReport is the body that an httpErrorReporter POSTs for a panic.
+type ErrorReport struct {
+	RequestID string    `json:"request_id"`
+	Time      time.Time `json:"time"`
+	Panic     string    `json:"panic"`
+	Stack     string    `json:"stack"`
+	Request   string    `json:"request"`
+}
+
+// httpErrorReporter POSTs every panic as an ErrorReport to a URL, like the
+// one of a webhook of an error tracking service.
+type httpErrorReporter struct {
+	url    string
+	client *http.Client
+}
+
+func newHTTPErrorReporter(url string) *httpErrorReporter {
+	return &httpErrorReporter{
+		url:    url,
+		client: &http.Client{Timeout: errorReportTimeout},
+	}
+}
+
+func (r *httpErrorReporter) reportPanic(report *panicReport) error {
+	body, err := stdjson.Marshal(&ErrorReport{
+		RequestID: report.RequestID,
+		Time:      report.Time,
+		Panic:     fmt.Sprint(report.Panic),
+		Stack:     string(report.Stack),
+		Request:   string(report.Request),
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := r.client.Post(r.url, gin.MIMEJSON, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	return nil
+}
+
+// InternalErrorReport is the body of a response to a request that ran into
+// a panic. It holds nothing of the request or the panic but the request ID
+// to look them up in the log with.
+type InternalErrorReport struct {
+	Error     string `json:"error"`
+	RequestID string `json:"request_id"`
+}
+
+// processPanic logs the panic with its stack and the redacted request,
+// responds with an InternalErrorReport, and then hands the panic to the
+// errorReporter if there is one.
+func (s *ginHTTPServer) processPanic(c *gin.Context, panicObj interface{}) {
+	r := c.Request.WithContext(c.Request.Context())
+	r.Header = redactHeader(r.Header)
+	httprequest, _ := httputil.DumpRequest(r, false)
+	report := newPanicReport(requestID(c), panicObj, httprequest)
+	s.logPanic(requestLogger(c), report)
+
+	if c.Writer.Written() {
+		c.Abort()
+	} else {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, &InternalErrorReport{
+			Error:     http.StatusText(http.StatusInternalServerError),
+			RequestID: report.RequestID,
+		})
+	}
+	s.reportPanic(requestLogger(c), report)
+}
+
+func newPanicReport(requestID string, panicObj interface{}, request []byte) *panicReport {
+	return &panicReport{
+		RequestID: requestID,
+		Time:      time.Now(),
+		Panic:     panicObj,
+		Stack:     debug.Stack(),
+		Request:   request,
+	}
+}
+
+func (s *ginHTTPServer) logPanic(l *jsonLogger, report *panicReport) {
+	l.error("panic", logFields{
+		"panic":   fmt.Sprint(report.Panic),
+		"request": string(report.Request),
+		"stack":   string(report.Stack),
+	})
+}
+
+// reportPanic hands a panic to the errorReporter if there is one.
+func (s *ginHTTPServer) reportPanic(l *jsonLogger, report *panicReport) {
+	if s.errorReporter != nil {
+		if err := s.errorReporter.reportPanic(report); err != nil {
+			l.warn("error reporting panic", logFields{"error": err})
+		}
+	}
 }
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxIdempotencyKeyLength = 255
+
+type idempotencyRecord struct {
+	Scope       string    `db:"ik_scope"`
+	Key         string    `db:"ik_key"`
+	Fingerprint string    `db:"ik_fingerprint"`
+	Status      int       `db:"ik_status"`
+	ContentType string    `db:"ik_content_type"`
+	ETag        string    `db:"ik_etag"`
+	Body        []byte    `db:"ik_body"`
+	CreatedAt   time.Time `db:"ik_created_at"`
+}
+
+func (r *idempotencyRecord) completed() bool {
+	return r.Status != 0
+}
+
+func idempotencyScope(credential string) string {
+	sum := sha256.Sum256([]byte(credential))
+	return hex.EncodeToString(sum[:])
+}
+
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func isIdempotentMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+type bodyRecordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+	// limit is how long a body is recorded at most, if it is positive. A
+	// longer body isn't recorded, and is marked as truncated instead.
+	limit     int
+	truncated bool
+}
+
+func (w *bodyRecordingWriter) record(n int, write func()) {
+	if w.truncated {
+		return
+	}
+	if w.limit > 0 && w.body.Len()+n > w.limit {
+		w.truncated = true
+		w.body = bytes.Buffer{}
+		return
+	}
+	write()
+}
+
+func (w *bodyRecordingWriter) Write(b []byte) (int, error) {
+	w.record(len(b), func() { w.body.Write(b) })
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *bodyRecordingWriter) WriteString(s string) (int, error) {
+	w.record(len(s), func() { w.body.WriteString(s) })
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (s *apiServer) idempotency(c *gin.Context) {
+	key := c.GetHeader("Idempotency-Key")
+	if key == "" || s.idempotencyTTL <= 0 || isIdempotentMethod(c.Request.Method) {
+		c.Next()
+		return
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	body, err := c.GetRawData()
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	record := &idempotencyRecord{
+		Scope:       idempotencyScope(c.GetHeader("Authorization")),
+		Key:         key,
+		Fingerprint: requestFingerprint(c.Request, body),
+	}
+	expiredBefore := time.Now().Add(-s.idempotencyTTL)
+	if !s.datastoreOf(c).reserveIdempotencyRecord(record, expiredBefore) {
+		s.replayIdempotencyRecord(c, record, expiredBefore)
+		return
+	}
+
+	w := &bodyRecordingWriter{ResponseWriter: c.Writer}
+	c.Writer = w
+	completed := false
+	defer func() {
+		if !completed {
+			s.datastoreOf(c).releaseIdempotencyRecord(record)
+		}
+	}()
+	c.Next()
+	if w.Status() >= http.StatusInternalServerError {
+		return
+	}
+
+	record.Status = w.Status()
+	record.ContentType = w.Header().Get("Content-Type")
+	record.ETag = w.Header().Get("ETag")
+	record.Body = w.body.Bytes()
+	s.datastoreOf(c).completeIdempotencyRecord(record)
+	completed = true
+}
+
+func (s *apiServer) replayIdempotencyRecord(c *gin.Context, record *idempotencyRecord, expiredBefore time.Time) {
+	stored := s.datastoreOf(c).getIdempotencyRecord(record.Scope, record.Key, expiredBefore)
+	switch {
+	case stored == nil:
+		c.AbortWithStatus(http.StatusConflict)
+	case stored.Fingerprint != record.Fingerprint:
+		c.AbortWithStatus(http.StatusUnprocessableEntity)
+	case !stored.completed():
+		c.AbortWithStatus(http.StatusConflict)
+	default:
+		c.Header("Idempotent-Replayed", "true")
+		if stored.ETag != "" {
+			c.Header("ETag", stored.ETag)
+		}
+		if len(stored.Body) == 0 {
+			c.AbortWithStatus(stored.Status)
+			return
+		}
+		c.Data(stored.Status, stored.ContentType, stored.Body)
+		c.Abort()
+	}
+}
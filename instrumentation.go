@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	null "gopkg.in/guregu/null.v3"
+)
+
+// instrumentedDatastore measures and traces the calls to a datastore, and
+// counts the recipes that the calls change.
+type instrumentedDatastore struct {
+	datastore datastore
+	ctx       context.Context
+}
+
+func (d *instrumentedDatastore) withContext(ctx context.Context) datastore {
+	return &instrumentedDatastore{datastore: d.datastore, ctx: ctx}
+}
+
+// call starts the span of a call, and returns the datastore to make the call
+// with, in the context of the request, so that its statements are traced
+// under the span.
+func (d *instrumentedDatastore) call(method string) (datastore, *span) {
+	ctx, s := startChildSpan(d.ctx, "datastore."+method, spanKindInternal)
+	return d.datastore.withContext(ctx), s
+}
+
+// observe records how long a call took and ends its span, and counts it as
+// failed if it panics. It must be deferred.
+func (d *instrumentedDatastore) observe(method string, s *span, start time.Time) {
+	datastoreCallDuration.observeSince(start, method)
+	if p := recover(); p != nil {
+		datastoreErrorsTotal.inc(method)
+		s.setError(fmt.Sprint(p))
+		s.finish()
+		panic(p)
+	}
+	s.finish()
+}
+
+func (d *instrumentedDatastore) listRecipes(f *ListFilter, p *paging) []*Recipe {
+	ds, s := d.call("listRecipes")
+	defer d.observe("listRecipes", s, time.Now())
+	return ds.listRecipes(f, p)
+}
+
+func (d *instrumentedDatastore) addRecipeByCredential(arg *PostRecipeArg, token string) *Recipe {
+	ds, s := d.call("addRecipeByCredential")
+	defer d.observe("addRecipeByCredential", s, time.Now())
+	res := ds.addRecipeByCredential(arg, token)
+	if res != nil {
+		recipesCreatedTotal.inc()
+	}
+	return res
+}
+
+func (d *instrumentedDatastore) getRecipeByID(id int) *Recipe {
+	ds, s := d.call("getRecipeByID")
+	defer d.observe("getRecipeByID", s, time.Now())
+	return ds.getRecipeByID(id)
+}
+
+func (d *instrumentedDatastore) listRecipeOwners(ids []int) map[int]string {
+	ds, s := d.call("listRecipeOwners")
+	defer d.observe("listRecipeOwners", s, time.Now())
+	return ds.listRecipeOwners(ids)
+}
+
+func (d *instrumentedDatastore) updateAndGetRecipeByCredential(arg *PutRecipeArg, id int, version int, token string) *Recipe {
+	ds, s := d.call("updateAndGetRecipeByCredential")
+	defer d.observe("updateAndGetRecipeByCredential", s, time.Now())
+	res := ds.updateAndGetRecipeByCredential(arg, id, version, token)
+	if res != nil {
+		recipesUpdatedTotal.inc()
+	}
+	return res
+}
+
+func (d *instrumentedDatastore) deleteAndGetRecipeByCredential(id int, version int, token string) *Recipe {
+	ds, s := d.call("deleteAndGetRecipeByCredential")
+	defer d.observe("deleteAndGetRecipeByCredential", s, time.Now())
+	res := ds.deleteAndGetRecipeByCredential(id, version, token)
+	if res != nil {
+		recipesDeletedTotal.inc()
+	}
+	return res
+}
+
+func (d *instrumentedDatastore) getRecipeVersionByCredential(id int, token string) null.Int {
+	ds, s := d.call("getRecipeVersionByCredential")
+	defer d.observe("getRecipeVersionByCredential", s, time.Now())
+	return ds.getRecipeVersionByCredential(id, token)
+}
+
+func (d *instrumentedDatastore) getAccountByCredential(token string) null.String {
+	ds, s := d.call("getAccountByCredential")
+	defer d.observe("getAccountByCredential", s, time.Now())
+	return ds.getAccountByCredential(token)
+}
+
+func (d *instrumentedDatastore) isAdminByCredential(token string) bool {
+	ds, s := d.call("isAdminByCredential")
+	defer d.observe("isAdminByCredential", s, time.Now())
+	return ds.isAdminByCredential(token)
+}
+
+func (d *instrumentedDatastore) getRecipeByCredential(id int, token string) *Recipe {
+	ds, s := d.call("getRecipeByCredential")
+	defer d.observe("getRecipeByCredential", s, time.Now())
+	return ds.getRecipeByCredential(id, token)
+}
+
+func (d *instrumentedDatastore) rateAndGetRecipe(arg *PostRateRecipeArg, id int, token string) *Recipe {
+	ds, s := d.call("rateAndGetRecipe")
+	defer d.observe("rateAndGetRecipe", s, time.Now())
+	res := ds.rateAndGetRecipe(arg, id, token)
+	if res != nil {
+		recipeRatingsTotal.inc()
+	}
+	return res
+}
+
+func (d *instrumentedDatastore) publishRecipeScheduleEvents(to time.Time) int {
+	ds, s := d.call("publishRecipeScheduleEvents")
+	defer d.observe("publishRecipeScheduleEvents", s, time.Now())
+	return ds.publishRecipeScheduleEvents(to)
+}
+
+func (d *instrumentedDatastore) nextRecipeScheduleTime(after time.Time) null.Time {
+	ds, s := d.call("nextRecipeScheduleTime")
+	defer d.observe("nextRecipeScheduleTime", s, time.Now())
+	return ds.nextRecipeScheduleTime(after)
+}
+
+func (d *instrumentedDatastore) listDeletedRecipesByCredential(token string, p *paging) []*Recipe {
+	ds, s := d.call("listDeletedRecipesByCredential")
+	defer d.observe("listDeletedRecipesByCredential", s, time.Now())
+	return ds.listDeletedRecipesByCredential(token, p)
+}
+
+func (d *instrumentedDatastore) restoreAndGetRecipeByCredential(id int, token string) *Recipe {
+	ds, s := d.call("restoreAndGetRecipeByCredential")
+	defer d.observe("restoreAndGetRecipeByCredential", s, time.Now())
+	return ds.restoreAndGetRecipeByCredential(id, token)
+}
+
+func (d *instrumentedDatastore) purgeDeletedRecipes(deletedBefore time.Time) int64 {
+	ds, s := d.call("purgeDeletedRecipes")
+	defer d.observe("purgeDeletedRecipes", s, time.Now())
+	return ds.purgeDeletedRecipes(deletedBefore)
+}
+
+func (d *instrumentedDatastore) listRecipeRevisionsByCredential(id int, token string) []*RecipeRevision {
+	ds, s := d.call("listRecipeRevisionsByCredential")
+	defer d.observe("listRecipeRevisionsByCredential", s, time.Now())
+	return ds.listRecipeRevisionsByCredential(id, token)
+}
+
+func (d *instrumentedDatastore) getRecipeRevisionByCredential(id int, revision int, token string) *RecipeRevision {
+	ds, s := d.call("getRecipeRevisionByCredential")
+	defer d.observe("getRecipeRevisionByCredential", s, time.Now())
+	return ds.getRecipeRevisionByCredential(id, revision, token)
+}
+
+func (d *instrumentedDatastore) restoreRecipeRevisionByCredential(id int, revision int, token string) *Recipe {
+	ds, s := d.call("restoreRecipeRevisionByCredential")
+	defer d.observe("restoreRecipeRevisionByCredential", s, time.Now())
+	return ds.restoreRecipeRevisionByCredential(id, revision, token)
+}
+
+func (d *instrumentedDatastore) executeRecipeBatchByCredential(ops []*RecipeBatchOperation, atomic bool, token string) []*Recipe {
+	ds, s := d.call("executeRecipeBatchByCredential")
+	defer d.observe("executeRecipeBatchByCredential", s, time.Now())
+	res := ds.executeRecipeBatchByCredential(ops, atomic, token)
+	if atomic {
+		// A failed operation rolls back the ones before it.
+		for _, r := range res {
+			if r == nil {
+				return res
+			}
+		}
+	}
+	counters := map[string]*counterVec{
+		recipeBatchCreate: recipesCreatedTotal,
+		recipeBatchUpdate: recipesUpdatedTotal,
+		recipeBatchDelete: recipesDeletedTotal,
+	}
+	for i, r := range res {
+		if r != nil {
+			counters[ops[i].Op].inc()
+		}
+	}
+	return res
+}
+
+func (d *instrumentedDatastore) reserveIdempotencyRecord(r *idempotencyRecord, expiredBefore time.Time) bool {
+	ds, s := d.call("reserveIdempotencyRecord")
+	defer d.observe("reserveIdempotencyRecord", s, time.Now())
+	return ds.reserveIdempotencyRecord(r, expiredBefore)
+}
+
+func (d *instrumentedDatastore) getIdempotencyRecord(scope string, key string, expiredBefore time.Time) *idempotencyRecord {
+	ds, s := d.call("getIdempotencyRecord")
+	defer d.observe("getIdempotencyRecord", s, time.Now())
+	return ds.getIdempotencyRecord(scope, key, expiredBefore)
+}
+
+func (d *instrumentedDatastore) completeIdempotencyRecord(r *idempotencyRecord) {
+	ds, s := d.call("completeIdempotencyRecord")
+	defer d.observe("completeIdempotencyRecord", s, time.Now())
+	ds.completeIdempotencyRecord(r)
+}
+
+func (d *instrumentedDatastore) releaseIdempotencyRecord(r *idempotencyRecord) {
+	ds, s := d.call("releaseIdempotencyRecord")
+	defer d.observe("releaseIdempotencyRecord", s, time.Now())
+	ds.releaseIdempotencyRecord(r)
+}
+
+func (d *instrumentedDatastore) purgeIdempotencyRecords(createdBefore time.Time) int64 {
+	ds, s := d.call("purgeIdempotencyRecords")
+	defer d.observe("purgeIdempotencyRecords", s, time.Now())
+	return ds.purgeIdempotencyRecords(createdBefore)
+}
+
+func (d *instrumentedDatastore) exportRecipesByCredential(token string, emit func(*RecipeExport)) bool {
+	ds, s := d.call("exportRecipesByCredential")
+	defer d.observe("exportRecipesByCredential", s, time.Now())
+	return ds.exportRecipesByCredential(token, emit)
+}
+
+func (d *instrumentedDatastore) importRecipesByCredential(recipes []*RecipeExport, arg *ImportRecipesArg, token string) []*RecipeImportResult {
+	ds, s := d.call("importRecipesByCredential")
+	defer d.observe("importRecipesByCredential", s, time.Now())
+	res := ds.importRecipesByCredential(recipes, arg, token)
+	for _, r := range res {
+		switch r.Action {
+		case recipeImportCreated, recipeImportRenamed:
+			recipesCreatedTotal.inc()
+		case recipeImportOverwritten:
+			recipesUpdatedTotal.inc()
+		}
+	}
+	return res
+}
+
+func (d *instrumentedDatastore) addWebhookByCredential(arg *PostWebhookArg, secret string, token string) *Webhook {
+	ds, s := d.call("addWebhookByCredential")
+	defer d.observe("addWebhookByCredential", s, time.Now())
+	return ds.addWebhookByCredential(arg, secret, token)
+}
+
+func (d *instrumentedDatastore) listWebhooksByCredential(token string) []*Webhook {
+	ds, s := d.call("listWebhooksByCredential")
+	defer d.observe("listWebhooksByCredential", s, time.Now())
+	return ds.listWebhooksByCredential(token)
+}
+
+func (d *instrumentedDatastore) deleteWebhookByCredential(id int, token string) *Webhook {
+	ds, s := d.call("deleteWebhookByCredential")
+	defer d.observe("deleteWebhookByCredential", s, time.Now())
+	return ds.deleteWebhookByCredential(id, token)
+}
+
+func (d *instrumentedDatastore) listWebhookDeliveriesByCredential(id int, f *WebhookDeliveryFilter, token string, p *paging) []*WebhookDelivery {
+	ds, s := d.call("listWebhookDeliveriesByCredential")
+	defer d.observe("listWebhookDeliveriesByCredential", s, time.Now())
+	return ds.listWebhookDeliveriesByCredential(id, f, token, p)
+}
+
+func (d *instrumentedDatastore) redeliverWebhookDeliveryByCredential(id int, deliveryID int, token string) *WebhookDelivery {
+	ds, s := d.call("redeliverWebhookDeliveryByCredential")
+	defer d.observe("redeliverWebhookDeliveryByCredential", s, time.Now())
+	return ds.redeliverWebhookDeliveryByCredential(id, deliveryID, token)
+}
+
+func (d *instrumentedDatastore) enqueueWebhookDeliveries(eventType string, recipeID int, payload []byte, traceparent string) int64 {
+	ds, s := d.call("enqueueWebhookDeliveries")
+	defer d.observe("enqueueWebhookDeliveries", s, time.Now())
+	return ds.enqueueWebhookDeliveries(eventType, recipeID, payload, traceparent)
+}
+
+func (d *instrumentedDatastore) claimWebhookDeliveries(heldUntil time.Time, limit int) []*webhookAttempt {
+	ds, s := d.call("claimWebhookDeliveries")
+	defer d.observe("claimWebhookDeliveries", s, time.Now())
+	return ds.claimWebhookDeliveries(heldUntil, limit)
+}
+
+func (d *instrumentedDatastore) recordWebhookDeliveryAttempt(w *WebhookDelivery) {
+	ds, s := d.call("recordWebhookDeliveryAttempt")
+	defer d.observe("recordWebhookDeliveryAttempt", s, time.Now())
+	ds.recordWebhookDeliveryAttempt(w)
+}
+
+func (d *instrumentedDatastore) relayRecipeEvents(limit int, publish func(*recipeEvent) bool) int {
+	ds, s := d.call("relayRecipeEvents")
+	defer d.observe("relayRecipeEvents", s, time.Now())
+	return ds.relayRecipeEvents(limit, publish)
+}
+
+func (d *instrumentedDatastore) purgeRecipeEvents(publishedBefore time.Time) int64 {
+	ds, s := d.call("purgeRecipeEvents")
+	defer d.observe("purgeRecipeEvents", s, time.Now())
+	return ds.purgeRecipeEvents(publishedBefore)
+}
+
+func (d *instrumentedDatastore) ping(timeout time.Duration) error {
+	ds, s := d.call("ping")
+	defer d.observe("ping", s, time.Now())
+	err := ds.ping(timeout)
+	if err != nil {
+		datastoreErrorsTotal.inc("ping")
+		s.setError(err.Error())
+	}
+	return err
+}
+
+func (d *instrumentedDatastore) poolStats() sql.DBStats {
+	return d.datastore.poolStats()
+}
+
+func (d *instrumentedDatastore) close() {
+	d.datastore.close()
+}
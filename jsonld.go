@@ -0,0 +1,182 @@
+package main
+
+import (
+	stdjson "encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	null "gopkg.in/guregu/null.v3"
+)
+
+const (
+	jsonLDContentType       = "application/ld+json"
+	schemaOrgContext        = "https://schema.org"
+	schemaOrgRecipeType     = "Recipe"
+	schemaOrgRatingType     = "AggregateRating"
+	schemaOrgVegetarianDiet = "VegetarianDiet"
+	schemaOrgVeganDiet      = "VeganDiet"
+)
+
+var (
+	errNoSchemaOrgRecipe = errors.New("no schema.org Recipe found in the document")
+	errInvalidDuration   = errors.New("invalid ISO 8601 duration")
+)
+
+var iso8601Duration = regexp.MustCompile(`^P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
+type SchemaOrgRecipe struct {
+	Context         string                    `json:"@context"`
+	Type            string                    `json:"@type"`
+	Identifier      int                       `json:"identifier"`
+	Name            string                    `json:"name"`
+	PrepTime        string                    `json:"prepTime,omitempty"`
+	SuitableForDiet string                    `json:"suitableForDiet,omitempty"`
+	AggregateRating *SchemaOrgAggregateRating `json:"aggregateRating,omitempty"`
+}
+
+type SchemaOrgAggregateRating struct {
+	Type        string  `json:"@type"`
+	RatingValue float64 `json:"ratingValue"`
+	RatingCount int64   `json:"ratingCount"`
+}
+
+func newSchemaOrgRecipe(r *Recipe) *SchemaOrgRecipe {
+	res := &SchemaOrgRecipe{
+		Context:    schemaOrgContext,
+		Type:       schemaOrgRecipeType,
+		Identifier: r.ID,
+		Name:       r.Name,
+	}
+	if r.PrepareTime.Valid {
+		res.PrepTime = formatISO8601Minutes(r.PrepareTime.Int64)
+	}
+	if r.IsVegetarian {
+		res.SuitableForDiet = schemaOrgContext + "/" + schemaOrgVegetarianDiet
+	}
+	if r.RatedNum.Int64 > 0 {
+		res.AggregateRating = &SchemaOrgAggregateRating{
+			Type:        schemaOrgRatingType,
+			RatingValue: r.Rating.Float64,
+			RatingCount: r.RatedNum.Int64,
+		}
+	}
+	return res
+}
+
+func formatISO8601Minutes(minutes int64) string {
+	res := "PT"
+	if h := minutes / 60; h > 0 {
+		res += strconv.FormatInt(h, 10) + "H"
+	}
+	if m := minutes % 60; m > 0 || minutes == 0 {
+		res += strconv.FormatInt(m, 10) + "M"
+	}
+	return res
+}
+
+func parseISO8601Minutes(s string) (int64, error) {
+	m := iso8601Duration.FindStringSubmatch(s)
+	if m == nil || s == "P" || strings.HasSuffix(s, "T") {
+		return 0, errInvalidDuration
+	}
+	var d time.Duration
+	units := []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute}
+	for i, unit := range units {
+		if m[i+1] == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(m[i+1], 10, 64)
+		if err != nil {
+			return 0, errInvalidDuration
+		}
+		d += time.Duration(n) * unit
+	}
+	if m[5] != "" {
+		sec, err := strconv.ParseFloat(m[5], 64)
+		if err != nil {
+			return 0, errInvalidDuration
+		}
+		d += time.Duration(sec * float64(time.Second))
+	}
+	return int64(math.Round(d.Minutes())), nil
+}
+
+// parseSchemaOrgRecipe finds the first schema.org Recipe node in a JSON-LD
+// document, which may be a single node, an array of nodes or a @graph.
+func parseSchemaOrgRecipe(doc []byte) (*PostRecipeArg, error) {
+	var root interface{}
+	if err := stdjson.Unmarshal(doc, &root); err != nil {
+		return nil, err
+	}
+	node := findSchemaOrgRecipe(root)
+	if node == nil {
+		return nil, errNoSchemaOrgRecipe
+	}
+
+	arg := &PostRecipeArg{
+		IsVegetarian: null.BoolFrom(isSchemaOrgVegetarian(node["suitableForDiet"])),
+	}
+	if name, ok := node["name"].(string); ok {
+		arg.Name = null.StringFrom(strings.TrimSpace(name))
+	}
+	if prepTime, ok := node["prepTime"].(string); ok {
+		minutes, err := parseISO8601Minutes(prepTime)
+		if err != nil {
+			return nil, fmt.Errorf("prepTime: %s", err)
+		}
+		arg.PrepareTime = null.IntFrom(minutes)
+	}
+	return arg, nil
+}
+
+func findSchemaOrgRecipe(v interface{}) map[string]interface{} {
+	switch v := v.(type) {
+	case []interface{}:
+		for _, e := range v {
+			if node := findSchemaOrgRecipe(e); node != nil {
+				return node
+			}
+		}
+	case map[string]interface{}:
+		if hasSchemaOrgType(v["@type"], schemaOrgRecipeType) {
+			return v
+		}
+		return findSchemaOrgRecipe(v["@graph"])
+	}
+	return nil
+}
+
+func hasSchemaOrgType(v interface{}, name string) bool {
+	switch v := v.(type) {
+	case string:
+		return v == name || strings.HasSuffix(v, "schema.org/"+name)
+	case []interface{}:
+		for _, e := range v {
+			if hasSchemaOrgType(e, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isSchemaOrgVegetarian(v interface{}) bool {
+	switch v := v.(type) {
+	case string:
+		return hasSchemaOrgType(v, schemaOrgVegetarianDiet) || hasSchemaOrgType(v, schemaOrgVeganDiet)
+	case []interface{}:
+		for _, e := range v {
+			if isSchemaOrgVegetarian(e) {
+				return true
+			}
+		}
+	case map[string]interface{}:
+		return isSchemaOrgVegetarian(v["@id"])
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	null "gopkg.in/guregu/null.v3"
+)
+
+func TestNewSchemaOrgRecipe(t *testing.T) {
+	actual := newSchemaOrgRecipe(&Recipe{ID: 1, Name: "name1", PrepareTime: null.IntFrom(90), IsVegetarian: true, Rating: null.FloatFrom(4.5), RatedNum: null.IntFrom(2)})
+	assert.Equal(t, &SchemaOrgRecipe{
+		Context:         "https://schema.org",
+		Type:            "Recipe",
+		Identifier:      1,
+		Name:            "name1",
+		PrepTime:        "PT1H30M",
+		SuitableForDiet: "https://schema.org/VegetarianDiet",
+		AggregateRating: &SchemaOrgAggregateRating{Type: "AggregateRating", RatingValue: 4.5, RatingCount: 2},
+	}, actual)
+
+	actual = newSchemaOrgRecipe(&Recipe{ID: 2, Name: "name2", Rating: null.FloatFrom(0), RatedNum: null.IntFrom(0)})
+	assert.Empty(t, actual.PrepTime)
+	assert.Empty(t, actual.SuitableForDiet)
+	assert.Nil(t, actual.AggregateRating)
+}
+
+func TestISO8601Minutes(t *testing.T) {
+	testCases := []struct {
+		minutes int64
+		text    string
+	}{
+		{5, "PT5M"},
+		{60, "PT1H"},
+		{135, "PT2H15M"},
+	}
+	for i, v := range testCases {
+		assert.Equal(t, v.text, formatISO8601Minutes(v.minutes), "Case [%d]: %#v", i, v.minutes)
+		actual, err := parseISO8601Minutes(v.text)
+		assert.NoError(t, err, "Case [%d]: %#v", i, v.text)
+		assert.Equal(t, v.minutes, actual, "Case [%d]: %#v", i, v.text)
+	}
+
+	testParseCases := []struct {
+		text    string
+		minutes int64
+	}{
+		{"PT90M", 90},
+		{"P1DT1H", 1500},
+		{"PT30S", 1},
+		{"PT1M29S", 1},
+	}
+	for i, v := range testParseCases {
+		actual, err := parseISO8601Minutes(v.text)
+		assert.NoError(t, err, "Case [%d]: %#v", i, v.text)
+		assert.Equal(t, v.minutes, actual, "Case [%d]: %#v", i, v.text)
+	}
+
+	for i, v := range []string{"", "P", "PT", "30M", "PT-5M", "PT5", "PT0.5H"} {
+		_, err := parseISO8601Minutes(v)
+		assert.Error(t, err, "Case [%d]: %#v", i, v)
+	}
+}
+
+func TestParseSchemaOrgRecipe(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected *PostRecipeArg
+	}{
+		{
+			`{"@context":"https://schema.org","@type":"Recipe","name":" name1 ","prepTime":"PT20M","suitableForDiet":"https://schema.org/VegetarianDiet"}`,
+			&PostRecipeArg{Name: null.StringFrom("name1"), PrepareTime: null.IntFrom(20), IsVegetarian: null.BoolFrom(true)},
+		},
+		{
+			`{"@context":"https://schema.org","@graph":[{"@type":"WebPage"},{"@type":["Recipe"],"name":"name2","suitableForDiet":[{"@id":"http://schema.org/VeganDiet"}]}]}`,
+			&PostRecipeArg{Name: null.StringFrom("name2"), IsVegetarian: null.BoolFrom(true)},
+		},
+		{
+			`[{"@type":"Person"},{"@type":"http://schema.org/Recipe","name":"name3","suitableForDiet":"LowFatDiet"}]`,
+			&PostRecipeArg{Name: null.StringFrom("name3"), IsVegetarian: null.BoolFrom(false)},
+		},
+	}
+	for i, v := range testCases {
+		actual, err := parseSchemaOrgRecipe([]byte(v.input))
+		assert.NoError(t, err, "Case [%d]: %#v", i, v.input)
+		assert.Equal(t, v.expected, actual, "Case [%d]: %#v", i, v.input)
+	}
+
+	for i, v := range []string{
+		`{"@type":"Person","name":"name1"}`,
+		`{"@type":"Recipe","name":"name1","prepTime":"20 minutes"}`,
+		`{"@type":"Recipe",`,
+	} {
+		_, err := parseSchemaOrgRecipe([]byte(v))
+		assert.Error(t, err, "Case [%d]: %#v", i, v)
+	}
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"context"
+	stdjson "encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	defaultLiveRoomIdleTimeout = 10 * time.Minute
+	liveHubSweepInterval       = time.Minute
+	livePingInterval           = 30 * time.Second
+	liveReadTimeout            = 2 * livePingInterval
+	liveMaxMessageSize         = 4096
+	liveSendBufferSize         = 32
+	maxLiveChatLength          = 1000
+	maxLiveTimerNameLength     = 64
+	maxLiveTimerDuration       = 24 * 60 * 60
+	maxLiveTimers              = 20
+)
+
+// The types of the messages of a live cooking session.
+const (
+	liveStateMessage          = "state"
+	liveJoinedMessage         = "joined"
+	liveLeftMessage           = "left"
+	liveStepMessage           = "step"
+	liveTimerStartMessage     = "timer.start"
+	liveTimerStartedMessage   = "timer.started"
+	liveTimerCancelMessage    = "timer.cancel"
+	liveTimerCancelledMessage = "timer.cancelled"
+	liveTimerFinishedMessage  = "timer.finished"
+	liveChatMessage           = "chat"
+	liveErrorMessage          = "error"
+)
+
+// liveMessage is a message of a live cooking session, either from a
+// participant or to the participants.
+type liveMessage struct {
+	Type         string       `json:"type"`
+	From         string       `json:"from,omitempty"`
+	Step         *int         `json:"step,omitempty"`
+	Timer        *liveTimer   `json:"timer,omitempty"`
+	Timers       []*liveTimer `json:"timers,omitempty"`
+	Text         string       `json:"text,omitempty"`
+	Participants []string     `json:"participants,omitempty"`
+	Error        string       `json:"error,omitempty"`
+	Time         time.Time    `json:"time"`
+}
+
+// liveTimer is a timer shared by the participants. Its duration is in
+// seconds.
+type liveTimer struct {
+	Name      string    `json:"name"`
+	Duration  int       `json:"duration"`
+	EndsAt    time.Time `json:"ends_at"`
+	StartedBy string    `json:"started_by,omitempty"`
+	timer     *time.Timer
+}
+
+// liveHub keeps a room for every recipe that is being cooked live. A room
+// keeps its step and timers while it is idle, so that the participants can
+// come back, and is removed once it has been idle for idleTimeout. The
+// handshakes from browsers are only accepted from the origin of the service
+// and allowedOrigins.
+type liveHub struct {
+	mu             sync.Mutex
+	rooms          map[int]*liveRoom
+	participants   sync.WaitGroup
+	idleTimeout    time.Duration
+	sweepInterval  time.Duration
+	allowedOrigins []string
+	closed         bool
+	quit           chan struct{}
+	done           chan struct{}
+}
+
+func newLiveHub(idleTimeout time.Duration) *liveHub {
+	return &liveHub{
+		rooms:         make(map[int]*liveRoom),
+		idleTimeout:   idleTimeout,
+		sweepInterval: liveHubSweepInterval,
+		quit:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+}
+
+// allowsOrigin accepts a handshake without an Origin header, which isn't
+// from a browser, or from the same host as the request or an allowed origin
+// like "https://cook.example.com".
+func (h *liveHub) allowsOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range h.allowedOrigins {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *liveHub) run() {
+	defer close(h.done)
+	ticker := time.NewTicker(h.sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.quit:
+			return
+		case now := <-ticker.C:
+			h.sweep(now)
+		}
+	}
+}
+
+// sweep removes the rooms that have been idle since before now minus the
+// idle timeout.
+func (h *liveHub) sweep(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for id, room := range h.rooms {
+		if room.idleBefore(now.Add(-h.idleTimeout)) {
+			room.stopTimers()
+			delete(h.rooms, id)
+		}
+	}
+}
+
+// stop closes the connections of all the participants, and waits for them
+// to leave.
+func (h *liveHub) stop(ctx context.Context) {
+	close(h.quit)
+	select {
+	case <-h.done:
+	case <-ctx.Done():
+	}
+
+	h.mu.Lock()
+	h.closed = true
+	for _, room := range h.rooms {
+		room.closeAll(websocketCloseGoingAway, "server is shutting down")
+	}
+	h.mu.Unlock()
+
+	left := make(chan struct{})
+	go func() {
+		h.participants.Wait()
+		close(left)
+	}()
+	select {
+	case <-left:
+	case <-ctx.Done():
+	}
+}
+
+// join puts a participant on the connection into the room of the recipe,
+// and handles its messages until it leaves.
+func (h *liveHub) join(recipeID int, name string, conn *websocketConn) {
+	p := &liveParticipant{
+		name: name,
+		conn: conn,
+		send: make(chan []byte, liveSendBufferSize),
+		done: make(chan struct{}),
+	}
+	conn.readTimeout = liveReadTimeout
+
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		conn.close(websocketCloseGoingAway, "server is shutting down")
+		return
+	}
+	room, ok := h.rooms[recipeID]
+	if !ok {
+		room = newLiveRoom(recipeID)
+		h.rooms[recipeID] = room
+	}
+	room.enter(p)
+	h.participants.Add(1)
+	h.mu.Unlock()
+	defer h.participants.Done()
+
+	go p.write()
+	defer room.leave(p)
+	for {
+		opcode, payload, err := conn.readMessage()
+		if err != nil {
+			return
+		}
+		if opcode != websocketText {
+			conn.close(websocketCloseUnsupportedData, "only text messages are supported")
+			return
+		}
+		room.handle(p, payload)
+	}
+}
+
+type liveParticipant struct {
+	name string
+	conn *websocketConn
+	send chan []byte
+	done chan struct{}
+}
+
+// write sends the queued messages to the participant, and pings it while
+// there are none.
+func (p *liveParticipant) write() {
+	ticker := time.NewTicker(livePingInterval)
+	defer ticker.Stop()
+	for {
+		var err error
+		select {
+		case <-p.done:
+			return
+		case message := <-p.send:
+			err = p.conn.writeMessage(message)
+		case <-ticker.C:
+			err = p.conn.ping()
+		}
+		if err != nil {
+			p.conn.close(websocketCloseGoingAway, "")
+			return
+		}
+	}
+}
+
+// queue sends a message to the participant without blocking. A participant
+// that doesn't keep up is disconnected.
+func (p *liveParticipant) queue(message []byte) {
+	select {
+	case p.send <- message:
+	default:
+		go p.conn.close(websocketClosePolicyViolation, "too slow")
+	}
+}
+
+type liveRoom struct {
+	recipeID     int
+	mu           sync.Mutex
+	participants map[*liveParticipant]struct{}
+	step         int
+	timers       map[string]*liveTimer
+	idleSince    time.Time
+}
+
+func newLiveRoom(recipeID int) *liveRoom {
+	return &liveRoom{
+		recipeID:     recipeID,
+		participants: make(map[*liveParticipant]struct{}),
+		timers:       make(map[string]*liveTimer),
+		idleSince:    time.Now(),
+	}
+}
+
+func (r *liveRoom) enter(p *liveParticipant) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.participants[p] = struct{}{}
+	step := r.step
+	timers := make([]*liveTimer, 0, len(r.timers))
+	for _, t := range r.timers {
+		timers = append(timers, t)
+	}
+	sort.Slice(timers, func(i, j int) bool { return timers[i].EndsAt.Before(timers[j].EndsAt) })
+	p.queue(marshalLiveMessage(&liveMessage{Type: liveStateMessage, Step: &step, Timers: timers, Participants: r.names()}))
+	joined := marshalLiveMessage(&liveMessage{Type: liveJoinedMessage, From: p.name, Participants: r.names()})
+	for other := range r.participants {
+		if other != p {
+			other.queue(joined)
+		}
+	}
+}
+
+func (r *liveRoom) leave(p *liveParticipant) {
+	close(p.done)
+	p.conn.close(websocketCloseNormal, "")
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.participants, p)
+	if len(r.participants) == 0 {
+		r.idleSince = time.Now()
+	}
+	r.broadcast(&liveMessage{Type: liveLeftMessage, From: p.name, Participants: r.names()})
+}
+
+func (r *liveRoom) handle(p *liveParticipant, payload []byte) {
+	m := &liveMessage{}
+	if err := stdjson.Unmarshal(payload, m); err != nil {
+		p.queue(marshalLiveMessage(&liveMessage{Type: liveErrorMessage, Error: "the message is not valid JSON"}))
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.apply(p, m); err != nil {
+		p.queue(marshalLiveMessage(&liveMessage{Type: liveErrorMessage, Error: err.Error()}))
+	}
+}
+
+// apply changes the room by a message of a participant, and tells everyone
+// in the room about it.
+func (r *liveRoom) apply(p *liveParticipant, m *liveMessage) error {
+	switch m.Type {
+	case liveStepMessage:
+		if m.Step == nil || *m.Step < 0 {
+			return errors.New("step must be a number not less than 0")
+		}
+		r.step = *m.Step
+		r.broadcast(&liveMessage{Type: liveStepMessage, From: p.name, Step: m.Step})
+	case liveTimerStartMessage:
+		if m.Timer == nil || m.Timer.Name == "" || utf8.RuneCountInString(m.Timer.Name) > maxLiveTimerNameLength {
+			return fmt.Errorf("timer.name must be 1 to %d characters", maxLiveTimerNameLength)
+		}
+		if m.Timer.Duration < 1 || m.Timer.Duration > maxLiveTimerDuration {
+			return fmt.Errorf("timer.duration must be 1 to %d seconds", maxLiveTimerDuration)
+		}
+		if old, ok := r.timers[m.Timer.Name]; ok {
+			old.timer.Stop()
+		} else if len(r.timers) >= maxLiveTimers {
+			return fmt.Errorf("there can be at most %d timers", maxLiveTimers)
+		}
+		t := &liveTimer{
+			Name:      m.Timer.Name,
+			Duration:  m.Timer.Duration,
+			EndsAt:    time.Now().Add(time.Duration(m.Timer.Duration) * time.Second),
+			StartedBy: p.name,
+		}
+		t.timer = time.AfterFunc(time.Duration(t.Duration)*time.Second, func() { r.finishTimer(t) })
+		r.timers[t.Name] = t
+		r.broadcast(&liveMessage{Type: liveTimerStartedMessage, From: p.name, Timer: t})
+	case liveTimerCancelMessage:
+		if m.Timer == nil || r.timers[m.Timer.Name] == nil {
+			return errors.New("there is no such timer")
+		}
+		t := r.timers[m.Timer.Name]
+		t.timer.Stop()
+		delete(r.timers, t.Name)
+		r.broadcast(&liveMessage{Type: liveTimerCancelledMessage, From: p.name, Timer: t})
+	case liveChatMessage:
+		text := strings.TrimSpace(m.Text)
+		if text == "" || utf8.RuneCountInString(text) > maxLiveChatLength {
+			return fmt.Errorf("text must be 1 to %d characters", maxLiveChatLength)
+		}
+		r.broadcast(&liveMessage{Type: liveChatMessage, From: p.name, Text: text})
+	default:
+		return fmt.Errorf("unknown message type %q", m.Type)
+	}
+	return nil
+}
+
+func (r *liveRoom) finishTimer(t *liveTimer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.timers[t.Name] != t {
+		return
+	}
+	delete(r.timers, t.Name)
+	r.broadcast(&liveMessage{Type: liveTimerFinishedMessage, Timer: t})
+}
+
+// broadcast sends a message to everyone in the room. The caller must hold
+// the lock of the room.
+func (r *liveRoom) broadcast(m *liveMessage) {
+	message := marshalLiveMessage(m)
+	for p := range r.participants {
+		p.queue(message)
+	}
+}
+
+func (r *liveRoom) names() []string {
+	res := make([]string, 0, len(r.participants))
+	for p := range r.participants {
+		res = append(res, p.name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func (r *liveRoom) idleBefore(t time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.participants) == 0 && r.idleSince.Before(t)
+}
+
+func (r *liveRoom) stopTimers() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name, t := range r.timers {
+		t.timer.Stop()
+		delete(r.timers, name)
+	}
+}
+
+func (r *liveRoom) closeAll(code int, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for p := range r.participants {
+		p.conn.close(code, reason)
+	}
+}
+
+func marshalLiveMessage(m *liveMessage) []byte {
+	if m.Time.IsZero() {
+		m.Time = time.Now()
+	}
+	res, err := stdjson.Marshal(m)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
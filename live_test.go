@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	stdjson "encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	null "gopkg.in/guregu/null.v3"
+)
+
+// liveTestDatastore takes the account of a token like "alicetoken" to be
+// "alice".
+type liveTestDatastore struct {
+	*mockDatastore
+}
+
+func (d *liveTestDatastore) withContext(ctx context.Context) datastore {
+	return d
+}
+
+func (d *liveTestDatastore) getAccountByCredential(token string) null.String {
+	if account := strings.TrimSuffix(token, "token"); account != "" && account != token {
+		return null.StringFrom(account)
+	}
+	return null.String{}
+}
+
+// testWebSocketClient speaks just enough of RFC 6455 to take part in a live
+// cooking session.
+type testWebSocketClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialLiveSession(server *httptest.Server, path string, token string) *testWebSocketClient {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	Expect(err).To(BeNil())
+	req, _ := http.NewRequest("GET", server.URL+path, nil)
+	req.Header.Set("Authorization", token)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	Expect(req.Write(conn)).To(Succeed())
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	Expect(err).To(BeNil())
+	Expect(resp.StatusCode).To(Equal(http.StatusSwitchingProtocols))
+	Expect(resp.Header.Get("Sec-WebSocket-Accept")).To(Equal("s3pPLMBiTxaQ9kYGzzhZRbK+xOo="))
+	return &testWebSocketClient{conn: conn, reader: reader}
+}
+
+func (c *testWebSocketClient) writeFrame(header byte, payload []byte) {
+	mask := []byte{1, 2, 3, 4}
+	frame := []byte{header, 0x80 | byte(len(payload))}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	_, err := c.conn.Write(frame)
+	Expect(err).To(BeNil())
+}
+
+func (c *testWebSocketClient) send(message string) {
+	c.writeFrame(0x80|websocketText, []byte(message))
+}
+
+func (c *testWebSocketClient) readFrame() (int, []byte) {
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	header := make([]byte, 2)
+	_, err := io.ReadFull(c.reader, header)
+	Expect(err).To(BeNil())
+	length := int(header[1] & 0x7f)
+	if length == 126 {
+		ext := make([]byte, 2)
+		_, err = io.ReadFull(c.reader, ext)
+		Expect(err).To(BeNil())
+		length = int(binary.BigEndian.Uint16(ext))
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(c.reader, payload)
+	Expect(err).To(BeNil())
+	return int(header[0] & 0x0f), payload
+}
+
+// receive skips the frames until a message of the type.
+func (c *testWebSocketClient) receive(messageType string) *liveMessage {
+	for {
+		opcode, payload := c.readFrame()
+		Expect(opcode).To(Equal(websocketText))
+		m := &liveMessage{}
+		Expect(stdjson.Unmarshal(payload, m)).To(Succeed())
+		if m.Type == messageType {
+			return m
+		}
+	}
+}
+
+func (c *testWebSocketClient) receiveClose() int {
+	for {
+		opcode, payload := c.readFrame()
+		if opcode == websocketClose {
+			return int(binary.BigEndian.Uint16(payload))
+		}
+	}
+}
+
+var _ = Describe("Cooking a recipe live", func() {
+	var server *apiServer
+	var httpServer *httptest.Server
+	BeforeEach(func() {
+		server = newTestAPIServer(&Recipe{ID: 1, Name: "name1"})
+		server.datastore = &liveTestDatastore{server.datastore.(*mockDatastore)}
+		httpServer = httptest.NewServer(server.httpServer.router)
+	})
+	AfterEach(func() {
+		httpServer.Close()
+	})
+	It("keeps the participants of a recipe in sync", func() {
+		alice := dialLiveSession(httpServer, "/recipes/1/live", "alicetoken")
+		defer alice.conn.Close()
+		state := alice.receive(liveStateMessage)
+		Expect(*state.Step).To(Equal(0))
+		Expect(state.Participants).To(Equal([]string{"alice"}))
+
+		bob := dialLiveSession(httpServer, "/recipes/1/live", "bobtoken")
+		defer bob.conn.Close()
+		bob.receive(liveStateMessage)
+		Expect(alice.receive(liveJoinedMessage).Participants).To(Equal([]string{"alice", "bob"}))
+
+		alice.send(`{"type":"step","step":3}`)
+		step := bob.receive(liveStepMessage)
+		Expect(step.From).To(Equal("alice"))
+		Expect(*step.Step).To(Equal(3))
+
+		bob.send(`{"type":"timer.start","timer":{"name":"pasta","duration":1}}`)
+		Expect(alice.receive(liveTimerStartedMessage).Timer.StartedBy).To(Equal("bob"))
+		Expect(alice.receive(liveTimerFinishedMessage).Timer.Name).To(Equal("pasta"))
+
+		bob.send(`{"type":"chat","text":" al dente? "}`)
+		chat := alice.receive(liveChatMessage)
+		Expect(chat.From).To(Equal("bob"))
+		Expect(chat.Text).To(Equal("al dente?"))
+
+		bob.send(`{"type":"step","step":-1}`)
+		Expect(bob.receive(liveErrorMessage).Error).To(ContainSubstring("step"))
+		bob.send(`{"type":"timer.cancel","timer":{"name":"pasta"}}`)
+		Expect(bob.receive(liveErrorMessage).Error).To(Equal("there is no such timer"))
+
+		bob.writeFrame(0x80|websocketClose, []byte{0x03, 0xe8})
+		Expect(bob.receiveClose()).To(Equal(websocketCloseNormal))
+		Expect(alice.receive(liveLeftMessage).From).To(Equal("bob"))
+
+		charlie := dialLiveSession(httpServer, "/recipes/1/live", "charlietoken")
+		defer charlie.conn.Close()
+		state = charlie.receive(liveStateMessage)
+		Expect(*state.Step).To(Equal(3))
+		Expect(state.Participants).To(Equal([]string{"alice", "charlie"}))
+	})
+	It("answers pings and rejects unmasked frames", func() {
+		alice := dialLiveSession(httpServer, "/recipes/1/live", "alicetoken")
+		defer alice.conn.Close()
+		alice.receive(liveStateMessage)
+
+		alice.writeFrame(0x80|websocketPing, []byte("hi"))
+		opcode, payload := alice.readFrame()
+		for opcode == websocketText {
+			opcode, payload = alice.readFrame()
+		}
+		Expect(opcode).To(Equal(websocketPong))
+		Expect(string(payload)).To(Equal("hi"))
+
+		_, err := alice.conn.Write([]byte{0x80 | websocketText, 2, 'h', 'i'})
+		Expect(err).To(BeNil())
+		Expect(alice.receiveClose()).To(Equal(websocketCloseProtocolError))
+	})
+	It("refuses the requests that aren't WebSocket handshakes or of unknown recipes", func() {
+		req, _ := http.NewRequest("GET", httpServer.URL+"/recipes/1/live", nil)
+		req.Header.Set("Authorization", "alicetoken")
+		resp, err := http.DefaultClient.Do(req)
+		Expect(err).To(BeNil())
+		Expect(resp.StatusCode).To(Equal(http.StatusUpgradeRequired))
+		Expect(resp.Header.Get("Upgrade")).To(Equal("websocket"))
+
+		notFound := httptest.NewServer(newTestAPIServer(nil).httpServer.router)
+		defer notFound.Close()
+		req, _ = http.NewRequest("GET", notFound.URL+"/recipes/1/live", nil)
+		req.Header.Set("Authorization", "faketoken")
+		resp, err = http.DefaultClient.Do(req)
+		Expect(err).To(BeNil())
+		Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+	})
+	It("refuses the requests without a valid credential or from other origins", func() {
+		resp, err := http.Get(httpServer.URL + "/recipes/1/live")
+		Expect(err).To(BeNil())
+		Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+
+		server.live.allowedOrigins = []string{"https://cook.example.com"}
+		for origin, status := range map[string]int{
+			"https://evil.example.com": http.StatusForbidden,
+			"https://cook.example.com": http.StatusUpgradeRequired,
+			httpServer.URL:             http.StatusUpgradeRequired,
+		} {
+			req, _ := http.NewRequest("GET", httpServer.URL+"/recipes/1/live", nil)
+			req.Header.Set("Authorization", "alicetoken")
+			req.Header.Set("Origin", origin)
+			resp, err = http.DefaultClient.Do(req)
+			Expect(err).To(BeNil())
+			Expect(resp.StatusCode).To(Equal(status), origin)
+		}
+	})
+	It("removes the idle rooms and closes the sessions on shutdown", func() {
+		alice := dialLiveSession(httpServer, "/recipes/1/live", "alicetoken")
+		defer alice.conn.Close()
+		alice.receive(liveStateMessage)
+		alice.send(`{"type":"step","step":2}`)
+		alice.receive(liveStepMessage)
+
+		server.live.sweep(time.Now().Add(time.Hour))
+		Expect(server.live.rooms).To(HaveLen(1))
+		alice.writeFrame(0x80|websocketClose, nil)
+		Expect(alice.receiveClose()).To(Equal(websocketCloseNormal))
+		Eventually(func() bool {
+			return server.live.rooms[1].idleBefore(time.Now().Add(time.Second))
+		}).Should(BeTrue())
+		server.live.sweep(time.Now().Add(defaultLiveRoomIdleTimeout + time.Second))
+		Expect(server.live.rooms).To(BeEmpty())
+
+		bob := dialLiveSession(httpServer, "/recipes/1/live", "bobtoken")
+		defer bob.conn.Close()
+		Expect(*bob.receive(liveStateMessage).Step).To(Equal(0))
+
+		go server.live.run()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		server.live.stop(ctx)
+		Expect(ctx.Err()).To(BeNil())
+		Expect(bob.receiveClose()).To(Equal(websocketCloseGoingAway))
+	})
+})
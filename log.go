@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	stdjson "encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type logLevel int
+
+const (
+	logLevelDebug logLevel = iota
+	logLevelInfo
+	logLevelWarn
+	logLevelError
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+func (l logLevel) String() string {
+	return logLevelNames[l]
+}
+
+func parseLogLevel(name string) logLevel {
+	for i, n := range logLevelNames {
+		if strings.EqualFold(strings.TrimSpace(name), n) {
+			return logLevel(i)
+		}
+	}
+	panic(fmt.Sprintf("unknown log level %q", name))
+}
+
+// redactedHeaders are the request headers that carry credentials, which
+// must not be logged.
+var redactedHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization"}
+
+const redactedValue = "[REDACTED]"
+
+// redactHeader copies a header with the values of the credentials replaced.
+func redactHeader(h http.Header) http.Header {
+	res := make(http.Header, len(h))
+	for key, values := range h {
+		res[key] = values
+	}
+	for _, key := range redactedHeaders {
+		if _, ok := res[key]; ok {
+			res[key] = []string{redactedValue}
+		}
+	}
+	return res
+}
+
+type logFields map[string]interface{}
+
+type logOutput struct {
+	mu    sync.Mutex
+	w     io.Writer
+	level logLevel
+}
+
+// jsonLogger writes a JSON object per line, with the time, level and
+// message first and then the fields in the order of their names.
+type jsonLogger struct {
+	out    *logOutput
+	fields logFields
+}
+
+func newJSONLogger(w io.Writer, level logLevel) *jsonLogger {
+	return &jsonLogger{out: &logOutput{w: w, level: level}}
+}
+
+func (l *jsonLogger) setLevel(level logLevel) {
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	l.out.level = level
+}
+
+func (l *jsonLogger) enabled(level logLevel) bool {
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	return level >= l.out.level
+}
+
+// with returns a logger sharing the output that adds the fields to every
+// line.
+func (l *jsonLogger) with(fields logFields) *jsonLogger {
+	res := &jsonLogger{out: l.out, fields: make(logFields, len(l.fields)+len(fields))}
+	for k, v := range l.fields {
+		res.fields[k] = v
+	}
+	for k, v := range fields {
+		res.fields[k] = v
+	}
+	return res
+}
+
+func (l *jsonLogger) debug(msg string, fields ...logFields) {
+	l.log(logLevelDebug, msg, fields...)
+}
+
+func (l *jsonLogger) info(msg string, fields ...logFields) {
+	l.log(logLevelInfo, msg, fields...)
+}
+
+func (l *jsonLogger) warn(msg string, fields ...logFields) {
+	l.log(logLevelWarn, msg, fields...)
+}
+
+func (l *jsonLogger) error(msg string, fields ...logFields) {
+	l.log(logLevelError, msg, fields...)
+}
+
+func (l *jsonLogger) log(level logLevel, msg string, fields ...logFields) {
+	if !l.enabled(level) {
+		return
+	}
+	merged := l.fields
+	if len(fields) > 0 {
+		merged = l.with(fields[0]).fields
+		for _, f := range fields[1:] {
+			for k, v := range f {
+				merged[k] = v
+			}
+		}
+	}
+	keys := make([]string, 0, len(merged))
+	for k := range merged {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, `{"time":%s,"level":%s,"msg":%s`,
+		marshalLogValue(time.Now().UTC().Format(time.RFC3339Nano)), marshalLogValue(level.String()), marshalLogValue(msg))
+	for _, k := range keys {
+		fmt.Fprintf(buf, ",%s:%s", marshalLogValue(k), marshalLogValue(merged[k]))
+	}
+	buf.WriteString("}\n")
+
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	l.out.w.Write(buf.Bytes())
+}
+
+// marshalLogValue marshals a value of a field, falling back to its string
+// form, so that logging never fails.
+func marshalLogValue(v interface{}) []byte {
+	switch value := v.(type) {
+	case error:
+		v = value.Error()
+	case fmt.Stringer:
+		v = value.String()
+	}
+	res, err := stdjson.Marshal(v)
+	if err != nil {
+		res, _ = stdjson.Marshal(fmt.Sprint(v))
+	}
+	return res
+}
+
+// logger is the logger of the service. Requests log through the logger of
+// their context, which adds the request ID.
+var logger = newJSONLogger(os.Stderr, logLevelInfo)
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	stdjson "encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// identifyingTestDatastore records the user of the credential it looks up,
+// like the datastore does.
+type identifyingTestDatastore struct {
+	*mockDatastore
+	ctx context.Context
+}
+
+func (d *identifyingTestDatastore) withContext(ctx context.Context) datastore {
+	return &identifyingTestDatastore{mockDatastore: d.mockDatastore, ctx: ctx}
+}
+
+func (d *identifyingTestDatastore) isAdminByCredential(token string) bool {
+	if token == "faketoken" {
+		recordRequestUser(d.ctx, 1)
+	}
+	return d.mockDatastore.isAdminByCredential(token)
+}
+
+type funcErrorReporter func(*panicReport) error
+
+func (f funcErrorReporter) reportPanic(r *panicReport) error {
+	return f(r)
+}
+
+func readLogLines(buf *bytes.Buffer) []map[string]interface{} {
+	res := make([]map[string]interface{}, 0)
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		m := make(map[string]interface{})
+		Expect(stdjson.Unmarshal(line, &m)).To(Succeed())
+		res = append(res, m)
+	}
+	return res
+}
+
+var _ = Describe("Logging", func() {
+	It("writes a JSON object per line at or above the level", func() {
+		buf := &bytes.Buffer{}
+		l := newJSONLogger(buf, logLevelInfo)
+		l.debug("hidden")
+		l.with(logFields{"request_id": "abc"}).warn("slow", logFields{"error": errors.New("timeout"), "count": 2})
+		l.setLevel(logLevelError)
+		l.info("hidden")
+
+		Expect(buf.String()).To(MatchRegexp(`^\{"time":"[^"]+","level":"warn","msg":"slow","count":2,"error":"timeout","request_id":"abc"\}\n$`))
+		Expect(parseLogLevel(" DEBUG")).To(Equal(logLevelDebug))
+		Expect(func() { parseLogLevel("verbose") }).To(Panic())
+	})
+	It("redacts the credentials of a header", func() {
+		h := http.Header{"Authorization": {"faketoken"}, "Accept": {"*/*"}}
+		Expect(redactHeader(h)).To(Equal(http.Header{"Authorization": {redactedValue}, "Accept": {"*/*"}}))
+		Expect(h.Get("Authorization")).To(Equal("faketoken"))
+	})
+	It("logs every request with its request ID", func() {
+		server := newTestAPIServer(nil)
+		server.datastore = &identifyingTestDatastore{mockDatastore: server.datastore.(*mockDatastore)}
+		buf := &bytes.Buffer{}
+		server.httpServer.logger = newJSONLogger(buf, logLevelInfo)
+
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/status", nil)
+		req.Header.Set("Authorization", "faketoken")
+		req.Header.Set("X-Request-ID", "req-1")
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(rr.Header().Get("X-Request-ID")).To(Equal("req-1"))
+
+		rr = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/nowhere", nil)
+		req.Header.Set("X-Request-ID", "not a valid id")
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Header().Get("X-Request-ID")).To(MatchRegexp(`^[0-9a-f]{32}$`))
+
+		lines := readLogLines(buf)
+		Expect(lines).To(HaveLen(2))
+		Expect(lines[0]).To(HaveKeyWithValue("msg", "request"))
+		Expect(lines[0]).To(HaveKeyWithValue("request_id", "req-1"))
+		Expect(lines[0]).To(HaveKeyWithValue("method", "GET"))
+		Expect(lines[0]).To(HaveKeyWithValue("route", "/status"))
+		Expect(lines[0]).To(HaveKeyWithValue("status", 200.0))
+		Expect(lines[0]["bytes"]).To(BeNumerically(">", 0))
+		Expect(lines[0]).To(HaveKeyWithValue("user_id", 1.0))
+		Expect(lines[0]).To(HaveKey("latency_ms"))
+		Expect(lines[1]).To(HaveKeyWithValue("request_id", rr.Header().Get("X-Request-ID")))
+		Expect(lines[1]).To(HaveKeyWithValue("status", 404.0))
+		Expect(lines[1]).NotTo(HaveKey("user_id"))
+	})
+	It("logs a panic without the credential", func() {
+		server := newTestAPIServer(nil)
+		server.datastore = &mockDatastore{
+			dataFunc: func() interface{} {
+				panic("connection refused")
+			},
+		}
+		buf := &bytes.Buffer{}
+		server.httpServer.logger = newJSONLogger(buf, logLevelInfo)
+
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/recipes/3", nil)
+		req.Header.Set("Authorization", "faketoken")
+		req.Header.Set("X-Request-ID", "req-1")
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		Expect(rr.Code).To(Equal(http.StatusInternalServerError))
+		Expect(rr.Body.String()).To(MatchJSON(`{"error":"Internal Server Error","request_id":"req-1"}`))
+		Expect(buf.String()).NotTo(ContainSubstring("faketoken"))
+		lines := readLogLines(buf)
+		Expect(lines).To(HaveLen(2))
+		Expect(lines[0]).To(HaveKeyWithValue("msg", "panic"))
+		Expect(lines[0]).To(HaveKeyWithValue("panic", "connection refused"))
+		Expect(lines[0]["request"]).To(ContainSubstring("Authorization: " + redactedValue))
+		Expect(lines[0]["stack"]).To(ContainSubstring("getRecipeByID"))
+		Expect(lines[1]).To(HaveKeyWithValue("level", "error"))
+		Expect(lines[1]).To(HaveKeyWithValue("request_id", "req-1"))
+	})
+	It("hands a panic to the error reporter", func() {
+		server := newTestAPIServer(nil)
+		server.datastore = &mockDatastore{
+			dataFunc: func() interface{} {
+				panic("connection refused")
+			},
+		}
+		buf := &bytes.Buffer{}
+		server.httpServer.logger = newJSONLogger(buf, logLevelInfo)
+		reports := make([]*panicReport, 0)
+		server.httpServer.errorReporter = funcErrorReporter(func(r *panicReport) error {
+			reports = append(reports, r)
+			return errors.New("unavailable")
+		})
+
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/recipes/3", nil)
+		req.Header.Set("Authorization", "faketoken")
+		req.Header.Set("X-Request-ID", "req-1")
+		server.httpServer.router.ServeHTTP(rr, req)
+
+		Expect(rr.Code).To(Equal(http.StatusInternalServerError))
+		Expect(reports).To(HaveLen(1))
+		Expect(reports[0].RequestID).To(Equal("req-1"))
+		Expect(reports[0].Panic).To(Equal("connection refused"))
+		Expect(reports[0].Time).To(BeTemporally("~", time.Now(), time.Minute))
+		Expect(string(reports[0].Stack)).To(ContainSubstring("getRecipeByID"))
+		Expect(string(reports[0].Request)).To(ContainSubstring("GET /recipes/3"))
+		Expect(string(reports[0].Request)).NotTo(ContainSubstring("faketoken"))
+		lines := readLogLines(buf)
+		Expect(lines).To(HaveLen(3))
+		Expect(lines[1]).To(HaveKeyWithValue("msg", "error reporting panic"))
+		Expect(lines[1]).To(HaveKeyWithValue("error", "unavailable"))
+	})
+	It("POSTs a panic to the URL of the error reports", func() {
+		reports := make(chan *ErrorReport, 1)
+		status := http.StatusAccepted
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			report := &ErrorReport{}
+			Expect(stdjson.NewDecoder(r.Body).Decode(report)).To(Succeed())
+			Expect(r.Header.Get("Content-Type")).To(Equal(gin.MIMEJSON))
+			reports <- report
+			w.WriteHeader(status)
+		}))
+		defer ts.Close()
+
+		at := time.Now()
+		reporter := newHTTPErrorReporter(ts.URL)
+		Expect(reporter.reportPanic(&panicReport{
+			RequestID: "req-1",
+			Time:      at,
+			Panic:     errors.New("connection refused"),
+			Stack:     []byte("goroutine 1 [running]:"),
+			Request:   []byte("GET /recipes/3 HTTP/1.1\r\n"),
+		})).To(Succeed())
+		var report *ErrorReport
+		Eventually(reports).Should(Receive(&report))
+		Expect(report.RequestID).To(Equal("req-1"))
+		Expect(report.Time).To(BeTemporally("==", at))
+		Expect(report.Panic).To(Equal("connection refused"))
+		Expect(report.Stack).To(Equal("goroutine 1 [running]:"))
+		Expect(report.Request).To(Equal("GET /recipes/3 HTTP/1.1\r\n"))
+
+		status = http.StatusServiceUnavailable
+		Expect(reporter.reportPanic(&panicReport{Panic: "connection refused"})).To(MatchError("unexpected response status 503 Service Unavailable"))
+	})
+})
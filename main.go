@@ -1,7 +1,6 @@
 package main
 
 import (
-	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
@@ -22,6 +21,6 @@ func main() {
 func waitForSignal(shutdownFunc func(), signals ...os.Signal) {
 	quitSig := make(chan os.Signal)
 	signal.Notify(quitSig, signals...)
-	fmt.Println(<-quitSig)
+	logger.info("received signal", logFields{"signal": <-quitSig})
 	shutdownFunc()
 }
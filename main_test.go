@@ -11,6 +11,7 @@ import (
 
 func TestByGinkgo(t *testing.T) {
 	RegisterFailHandler(Fail)
+	logger.setLevel(logLevelWarn)
 	RunSpecs(t, "Base Suite")
 }
 
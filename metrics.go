@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	metricsContentType        = "text/plain"
+	metricsExpositionVersion  = "0.0.4"
+	metricsLabelValueSep      = "\xff"
+	metricsUnmatchedRouteName = "unmatched"
+)
+
+// defaultDurationBuckets are the upper bounds of the buckets of the
+// latency histograms, in seconds.
+var defaultDurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// metricsRegistry writes the metrics registered to it in the Prometheus
+// text exposition format.
+type metricsRegistry struct {
+	mu         sync.Mutex
+	collectors []metricsCollector
+}
+
+type metricsCollector interface {
+	writeMetrics(w io.Writer)
+}
+
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{}
+}
+
+func (r *metricsRegistry) register(c metricsCollector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+func (r *metricsRegistry) writeMetrics(w io.Writer) {
+	r.mu.Lock()
+	collectors := append([]metricsCollector{}, r.collectors...)
+	r.mu.Unlock()
+	for _, c := range collectors {
+		c.writeMetrics(w)
+	}
+}
+
+func (r *metricsRegistry) counter(name, help string, labels ...string) *counterVec {
+	c := &counterVec{
+		metricFamily: metricFamily{name: name, help: help, labels: labels},
+		values:       make(map[string]float64),
+	}
+	if len(labels) == 0 {
+		c.values[""] = 0
+	}
+	r.register(c)
+	return c
+}
+
+func (r *metricsRegistry) histogram(name, help string, buckets []float64, labels ...string) *histogramVec {
+	h := &histogramVec{
+		metricFamily: metricFamily{name: name, help: help, labels: labels},
+		buckets:      buckets,
+		values:       make(map[string]*histogramValue),
+	}
+	r.register(h)
+	return h
+}
+
+type metricFamily struct {
+	name   string
+	help   string
+	labels []string
+}
+
+func (f *metricFamily) writeHeader(w io.Writer, metricType string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, metricType)
+}
+
+func (f *metricFamily) key(values []string) string {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s takes %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	return strings.Join(values, metricsLabelValueSep)
+}
+
+// labelPairs formats the labels of a series like `{method="GET",le="0.5"}`.
+func (f *metricFamily) labelPairs(key string, extra ...string) string {
+	pairs := make([]string, 0, len(f.labels)+1)
+	if len(f.labels) > 0 {
+		for i, value := range strings.Split(key, metricsLabelValueSep) {
+			pairs = append(pairs, f.labels[i]+`="`+escapeLabelValue(value)+`"`)
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabelValue(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escapeLabelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func formatMetricValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type counterVec struct {
+	metricFamily
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func (c *counterVec) add(v float64, labelValues ...string) {
+	key := c.key(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[key] += v
+}
+
+func (c *counterVec) inc(labelValues ...string) {
+	c.add(1, labelValues...)
+}
+
+func (c *counterVec) writeMetrics(w io.Writer) {
+	c.writeHeader(w, "counter")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range sortedMetricKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelPairs(key), formatMetricValue(c.values[key]))
+	}
+}
+
+type histogramValue struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+type histogramVec struct {
+	metricFamily
+	buckets []float64
+	mu      sync.Mutex
+	values  map[string]*histogramValue
+}
+
+func (h *histogramVec) observe(v float64, labelValues ...string) {
+	key := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	value, ok := h.values[key]
+	if !ok {
+		value = &histogramValue{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = value
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			value.counts[i]++
+		}
+	}
+	value.count++
+	value.sum += v
+}
+
+func (h *histogramVec) observeSince(start time.Time, labelValues ...string) {
+	h.observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func (h *histogramVec) writeMetrics(w io.Writer) {
+	h.writeHeader(w, "histogram")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	keys := make([]string, 0, len(h.values))
+	for key := range h.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := h.values[key]
+		for i, upper := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(key, "le", formatMetricValue(upper)), value.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(key, "le", "+Inf"), value.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelPairs(key), formatMetricValue(value.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelPairs(key), value.count)
+	}
+}
+
+func sortedMetricKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// dbStatsCollector reports the stats of the connection pool of a database
+// at the time the metrics are scraped.
+type dbStatsCollector struct {
+	db *sql.DB
+}
+
+func (c *dbStatsCollector) writeMetrics(w io.Writer) {
+	stats := c.db.Stats()
+	for _, m := range []struct {
+		name, help, metricType string
+		value                  float64
+	}{
+		{"db_connections_max_open", "Maximum number of open connections to the database.", "gauge", float64(stats.MaxOpenConnections)},
+		{"db_connections_open", "Number of established connections to the database.", "gauge", float64(stats.OpenConnections)},
+		{"db_connections_in_use", "Number of connections to the database in use.", "gauge", float64(stats.InUse)},
+		{"db_connections_idle", "Number of idle connections to the database.", "gauge", float64(stats.Idle)},
+		{"db_connection_waits_total", "Number of times a connection to the database was waited for.", "counter", float64(stats.WaitCount)},
+		{"db_connection_wait_seconds_total", "Time spent waiting for connections to the database.", "counter", stats.WaitDuration.Seconds()},
+	} {
+		family := metricFamily{name: m.name, help: m.help}
+		family.writeHeader(w, m.metricType)
+		fmt.Fprintf(w, "%s %s\n", m.name, formatMetricValue(m.value))
+	}
+}
+
+var (
+	metrics = newMetricsRegistry()
+
+	httpRequestsTotal = metrics.counter("http_requests_total",
+		"Number of HTTP requests by route and status.", "method", "route", "status")
+	httpRequestDuration = metrics.histogram("http_request_duration_seconds",
+		"Latency of the HTTP requests by route.", defaultDurationBuckets, "method", "route")
+	datastoreCallDuration = metrics.histogram("datastore_call_duration_seconds",
+		"Duration of the datastore calls by method.", defaultDurationBuckets, "method")
+	datastoreErrorsTotal = metrics.counter("datastore_errors_total",
+		"Number of datastore calls that failed by method.", "method")
+	recipesCreatedTotal = metrics.counter("recipes_created_total",
+		"Number of recipes created.")
+	recipesUpdatedTotal = metrics.counter("recipes_updated_total",
+		"Number of recipes updated.")
+	recipesDeletedTotal = metrics.counter("recipes_deleted_total",
+		"Number of recipes deleted.")
+	recipeRatingsTotal = metrics.counter("recipe_ratings_total",
+		"Number of ratings submitted.")
+)
+
+// renderMetrics writes all the metrics of the registry into a buffer, so
+// that a failing collector doesn't leave a partial response.
+func renderMetrics(r *metricsRegistry) []byte {
+	buf := &bytes.Buffer{}
+	r.writeMetrics(buf)
+	return buf.Bytes()
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+
+	null "gopkg.in/guregu/null.v3"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// scrapeMetric reads the value of a series from GET /metrics, or 0 if the
+// series isn't there yet.
+func scrapeMetric(server *apiServer, series string) float64 {
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	server.httpServer.router.ServeHTTP(rr, req)
+	Expect(rr.Code).To(Equal(http.StatusOK))
+	scanner := bufio.NewScanner(rr.Body)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), series+" ") {
+			v, err := strconv.ParseFloat(strings.TrimPrefix(scanner.Text(), series+" "), 64)
+			Expect(err).To(BeNil())
+			return v
+		}
+	}
+	return 0
+}
+
+var _ = Describe("Exposing metrics", func() {
+	It("writes the metrics in the Prometheus text format", func() {
+		r := newMetricsRegistry()
+		requests := r.counter("requests_total", "Number of requests.", "path")
+		latency := r.histogram("latency_seconds", "Latency.", []float64{.1, 1}, "path")
+		r.counter("errors_total", "Number of errors.")
+		requests.inc("/b")
+		requests.add(2, "/a\"\n")
+		latency.observe(.05, "/a")
+		latency.observe(.5, "/a")
+
+		Expect(string(renderMetrics(r))).To(Equal(`# HELP requests_total Number of requests.
+# TYPE requests_total counter
+requests_total{path="/a\"\n"} 2
+requests_total{path="/b"} 1
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{path="/a",le="0.1"} 1
+latency_seconds_bucket{path="/a",le="1"} 2
+latency_seconds_bucket{path="/a",le="+Inf"} 2
+latency_seconds_sum{path="/a"} 0.55
+latency_seconds_count{path="/a"} 2
+# HELP errors_total Number of errors.
+# TYPE errors_total counter
+errors_total 0
+`))
+		Expect(func() { requests.inc() }).To(Panic())
+	})
+	It("counts the requests by their routes and statuses", func() {
+		server := newTestAPIServer(&Recipe{ID: 3, Name: "name3", Rating: null.FloatFrom(3.0), RatedNum: null.IntFrom(1)})
+		server.datastore = &instrumentedDatastore{datastore: server.datastore}
+		series := `http_requests_total{method="POST",route="/recipes/:id/rating",status="200"}`
+		unmatched := `http_requests_total{method="GET",route="unmatched",status="404"}`
+		ratings := scrapeMetric(server, "recipe_ratings_total")
+		requests := scrapeMetric(server, series)
+		notFound := scrapeMetric(server, unmatched)
+		calls := scrapeMetric(server, `datastore_call_duration_seconds_count{method="rateAndGetRecipe"}`)
+
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/recipes/3/rating", bytes.NewBufferString(`{"rating":3}`))
+		req.Header.Set("Content-Type", "application/json")
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		rr = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/nowhere/3", nil)
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusNotFound))
+
+		Expect(scrapeMetric(server, series)).To(Equal(requests + 1))
+		Expect(scrapeMetric(server, unmatched)).To(Equal(notFound + 1))
+		Expect(scrapeMetric(server, "recipe_ratings_total")).To(Equal(ratings + 1))
+		Expect(scrapeMetric(server, `datastore_call_duration_seconds_count{method="rateAndGetRecipe"}`)).To(Equal(calls + 1))
+		Expect(scrapeMetric(server, `http_request_duration_seconds_count{method="GET",route="/metrics"}`)).To(BeNumerically(">", 0))
+	})
+	It("counts the datastore calls that fail", func() {
+		md := &mockDatastore{
+			dataFunc: func() interface{} {
+				panic("connection refused")
+			},
+		}
+		d := &instrumentedDatastore{datastore: md}
+		series := `datastore_errors_total{method="getRecipeByID"}`
+		server := newTestAPIServer(nil)
+		errors := scrapeMetric(server, series)
+		Expect(func() { d.getRecipeByID(1) }).To(Panic())
+		Expect(scrapeMetric(server, series)).To(Equal(errors + 1))
+	})
+	It("rebuilds the routes from the path parameters", func() {
+		server := newTestAPIServer(nil)
+		for path, route := range map[string]string{
+			"/recipes/3/revisions/3":  `route="/recipes/:id/revisions/:rev"`,
+			"/v2/recipes/4/revisions": `route="/v2/recipes/:id/revisions"`,
+			"/recipes/5":              `route="/recipes/:id"`,
+		} {
+			rr := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", path, nil)
+			server.httpServer.router.ServeHTTP(rr, req)
+			Expect(scrapeMetric(server, `http_requests_total{method="GET",`+route+`,status="`+strconv.Itoa(rr.Code)+`"}`)).To(BeNumerically(">", 0), path)
+		}
+	})
+})
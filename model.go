@@ -1,11 +1,17 @@
 package main
 
 import (
+	stdjson "encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"reflect"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
 	validator "gopkg.in/go-playground/validator.v9"
 	null "gopkg.in/guregu/null.v3"
 )
@@ -36,9 +42,81 @@ var validate = func() *validator.Validate {
 		},
 		null.Float{},
 	)
+	v.RegisterCustomTypeFunc(
+		func(field reflect.Value) interface{} {
+			return field.Interface().(null.Time).Ptr()
+		},
+		null.Time{},
+	)
+	v.RegisterStructValidation(validatePublishWindow, PostRecipeArg{}, PutRecipeArg{})
 	return v
 }()
 
+func validatePublishWindow(sl validator.StructLevel) {
+	var publishAt, unpublishAt null.Time
+	switch arg := sl.Current().Interface().(type) {
+	case PostRecipeArg:
+		publishAt, unpublishAt = arg.PublishAt, arg.UnpublishAt
+	case PutRecipeArg:
+		publishAt, unpublishAt = arg.PublishAt, arg.UnpublishAt
+	}
+	if publishAt.Valid && unpublishAt.Valid && !unpublishAt.Time.After(publishAt.Time) {
+		sl.ReportError(unpublishAt, "UnpublishAt", "unpublish_at", "gtfield", "PublishAt")
+	}
+}
+
+// abortWithValidationErrors responses with the fields of arg that failed the
+// validation, named as they are in the body or the query string, with
+// `422 unprocessable entity` for a body and `400 bad request` for a query
+// string.
+func abortWithValidationErrors(c *gin.Context, in string, arg interface{}, err error) {
+	fieldErrors, ok := err.(validator.ValidationErrors)
+	if !ok {
+		panic(err)
+	}
+	code, tag := http.StatusUnprocessableEntity, "json"
+	if in == "query" {
+		code, tag = http.StatusBadRequest, "form"
+	}
+	violations := make([]*ContractViolation, 0, len(fieldErrors))
+	for _, fe := range fieldErrors {
+		violations = append(violations, &ContractViolation{
+			In:    in,
+			Name:  validationFieldName(reflect.TypeOf(arg), fe.StructNamespace(), tag),
+			Error: fmt.Sprintf("failed on the '%s' validation", fe.Tag()),
+		})
+	}
+	c.AbortWithStatusJSON(code, &ContractViolationReport{Errors: violations, RequestID: requestID(c)})
+}
+
+// validationFieldName turns the namespace of a field that failed the
+// validation, like RecipeBatchArg.Operations[0].Recipe.Name, into the path
+// of the field by its tag, like operations[0].recipe.name.
+func validationFieldName(t reflect.Type, namespace, tag string) string {
+	segments := strings.Split(namespace, ".")[1:]
+	for i, segment := range segments {
+		for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			break
+		}
+		name, index := segment, ""
+		if j := strings.IndexByte(segment, '['); j >= 0 {
+			name, index = segment[:j], segment[j:]
+		}
+		f, ok := t.FieldByName(name)
+		if !ok {
+			break
+		}
+		if n := strings.Split(f.Tag.Get(tag), ",")[0]; n != "" && n != "-" {
+			segments[i] = n + index
+		}
+		t = f.Type
+	}
+	return strings.Join(segments, ".")
+}
+
 type Recipe struct {
 	ID           int        `json:"id" db:"r_id"`
 	Name         string     `json:"name" db:"r_name"`
@@ -47,6 +125,10 @@ type Recipe struct {
 	IsVegetarian bool       `json:"is_vegetarian" db:"r_vegetarian"`
 	Rating       null.Float `json:"rating" db:"r_rating"`
 	RatedNum     null.Int   `json:"rated_num" db:"r_rated_num"`
+	PublishAt    null.Time  `json:"publish_at" db:"r_publish_at"`
+	UnpublishAt  null.Time  `json:"unpublish_at" db:"r_unpublish_at"`
+	DeletedAt    null.Time  `json:"deleted_at" db:"r_deleted_at"`
+	Version      int        `json:"-" db:"r_version"`
 }
 
 type PostRecipeArg struct {
@@ -54,43 +136,302 @@ type PostRecipeArg struct {
 	PrepareTime  null.Int    `json:"prepare_time" db:"r_prep_time" validate:"omitempty,gt=0"`
 	Difficulty   null.Int    `json:"difficulty" db:"r_difficulty" validate:"omitempty,min=1,max=3"`
 	IsVegetarian null.Bool   `json:"is_vegetarian" db:"r_vegetarian" validate:"required"`
+	PublishAt    null.Time   `json:"publish_at" db:"r_publish_at"`
+	UnpublishAt  null.Time   `json:"unpublish_at" db:"r_unpublish_at"`
 }
 
 type PutRecipeArg struct {
-	Name         null.String `json:"name" validate:"omitempty,gt=0"`
+	Name         null.String `json:"name" validate:"required,gt=0"`
 	PrepareTime  null.Int    `json:"prepare_time" validate:"omitempty,gt=0"`
 	Difficulty   null.Int    `json:"difficulty" validate:"omitempty,min=1,max=3"`
-	IsVegetarian null.Bool   `json:"is_vegetarian"`
+	IsVegetarian null.Bool   `json:"is_vegetarian" validate:"required"`
+	PublishAt    null.Time   `json:"publish_at"`
+	UnpublishAt  null.Time   `json:"unpublish_at"`
+}
+
+func newPutRecipeArg(r *Recipe) *PutRecipeArg {
+	return &PutRecipeArg{
+		Name:         null.StringFrom(r.Name),
+		PrepareTime:  r.PrepareTime,
+		Difficulty:   r.Difficulty,
+		IsVegetarian: null.BoolFrom(r.IsVegetarian),
+		PublishAt:    r.PublishAt,
+		UnpublishAt:  r.UnpublishAt,
+	}
+}
+
+func (a *PutRecipeArg) postRecipeArg() *PostRecipeArg {
+	return &PostRecipeArg{
+		Name:         a.Name,
+		PrepareTime:  a.PrepareTime,
+		Difficulty:   a.Difficulty,
+		IsVegetarian: a.IsVegetarian,
+		PublishAt:    a.PublishAt,
+		UnpublishAt:  a.UnpublishAt,
+	}
 }
 
 func (a *PutRecipeArg) overwriteRecipe(r *Recipe) {
 	if r == nil {
 		return
 	}
-	if a.Name.Valid {
-		r.Name = a.Name.String
+	r.Name = a.Name.String
+	r.PrepareTime = a.PrepareTime
+	r.Difficulty = a.Difficulty
+	r.IsVegetarian = a.IsVegetarian.Bool
+	r.PublishAt = a.PublishAt
+	r.UnpublishAt = a.UnpublishAt
+}
+
+const (
+	recipeBatchCreate = "create"
+	recipeBatchUpdate = "update"
+	recipeBatchDelete = "delete"
+
+	recipeBatchAllOrNothing = "all_or_nothing"
+	recipeBatchBestEffort   = "best_effort"
+)
+
+type RecipeBatchArg struct {
+	Mode       string                  `json:"mode" validate:"omitempty,oneof=all_or_nothing best_effort"`
+	Operations []*RecipeBatchOperation `json:"operations" validate:"required,min=1,max=100,dive,required"`
+}
+
+type RecipeBatchOperation struct {
+	Op      string         `json:"op"`
+	ID      int            `json:"id"`
+	Version int            `json:"version"`
+	Recipe  *PostRecipeArg `json:"recipe" validate:"-"`
+}
+
+func (o *RecipeBatchOperation) validate() error {
+	switch o.Op {
+	case recipeBatchCreate, recipeBatchUpdate:
+		if o.Op == recipeBatchUpdate && o.ID <= 0 {
+			return errors.New("id is required")
+		}
+		if o.Recipe == nil {
+			return errors.New("recipe is required")
+		}
+		return validate.Struct(o.Recipe)
+	case recipeBatchDelete:
+		if o.ID <= 0 {
+			return errors.New("id is required")
+		}
+		return nil
 	}
-	if a.PrepareTime.Valid {
-		r.PrepareTime = a.PrepareTime
+	return fmt.Errorf("unsupported op %q", o.Op)
+}
+
+type RecipeBatchResult struct {
+	Status int     `json:"status"`
+	Error  string  `json:"error,omitempty"`
+	Recipe *Recipe `json:"recipe,omitempty"`
+}
+
+type RecipeBatchResponse struct {
+	Mode      string               `json:"mode"`
+	Committed bool                 `json:"committed"`
+	Results   []*RecipeBatchResult `json:"results"`
+}
+
+const (
+	recipeImportSkip      = "skip"
+	recipeImportOverwrite = "overwrite"
+	recipeImportRename    = "rename"
+
+	recipeImportCreated     = "created"
+	recipeImportOverwritten = "overwritten"
+	recipeImportRenamed     = "renamed"
+	recipeImportSkipped     = "skipped"
+	recipeImportFailed      = "failed"
+)
+
+type RecipeExport struct {
+	Recipe
+	Owner null.String `json:"owner" db:"hu_account"`
+}
+
+func (r *RecipeExport) validate() error {
+	if !r.Owner.Valid || r.Owner.String == "" {
+		return errors.New("owner is required")
+	}
+	if r.ID < 0 || r.Rating.Float64 < 0 || r.RatedNum.Int64 < 0 {
+		return errors.New("id, rating and rated_num must not be negative")
 	}
-	if a.Difficulty.Valid {
-		r.Difficulty = a.Difficulty
+	return validate.Struct(&PostRecipeArg{
+		Name:         null.StringFrom(r.Name),
+		PrepareTime:  r.PrepareTime,
+		Difficulty:   r.Difficulty,
+		IsVegetarian: null.BoolFrom(r.IsVegetarian),
+		PublishAt:    r.PublishAt,
+		UnpublishAt:  r.UnpublishAt,
+	})
+}
+
+type ImportRecipesArg struct {
+	Strategy string `form:"strategy" validate:"omitempty,oneof=skip overwrite rename"`
+	DryRun   bool   `form:"dry_run"`
+}
+
+type RecipeImportResult struct {
+	Line     int    `json:"line"`
+	SourceID int    `json:"source_id,omitempty"`
+	ID       int    `json:"id,omitempty"`
+	Action   string `json:"action"`
+	Error    string `json:"error,omitempty"`
+}
+
+type RecipeImportReport struct {
+	Strategy    string                `json:"strategy"`
+	DryRun      bool                  `json:"dry_run"`
+	Created     int                   `json:"created"`
+	Overwritten int                   `json:"overwritten"`
+	Renamed     int                   `json:"renamed"`
+	Skipped     int                   `json:"skipped"`
+	Failed      int                   `json:"failed"`
+	Results     []*RecipeImportResult `json:"results"`
+}
+
+func newRecipeImportReport(arg *ImportRecipesArg, results []*RecipeImportResult) *RecipeImportReport {
+	report := &RecipeImportReport{
+		Strategy: arg.Strategy,
+		DryRun:   arg.DryRun,
+		Results:  results,
 	}
-	if a.IsVegetarian.Valid {
-		r.IsVegetarian = a.IsVegetarian.Bool
+	for _, r := range results {
+		switch r.Action {
+		case recipeImportCreated:
+			report.Created++
+		case recipeImportOverwritten:
+			report.Overwritten++
+		case recipeImportRenamed:
+			report.Renamed++
+		case recipeImportSkipped:
+			report.Skipped++
+		case recipeImportFailed:
+			report.Failed++
+		}
 	}
+	return report
 }
 
 type PostRateRecipeArg struct {
 	Rating null.Int `json:"rating" validate:"required,min=1,max=5"`
 }
 
-func (a *PostRateRecipeArg) updateRatedRecipe(r *Recipe) {
-	if r == nil {
-		return
+type RecipeRevision struct {
+	RecipeID     int         `json:"recipe_id" db:"rr_r_id"`
+	Revision     int         `json:"revision" db:"rr_number"`
+	Author       null.String `json:"author" db:"hu_account"`
+	CreatedAt    time.Time   `json:"created_at" db:"rr_created_at"`
+	Name         string      `json:"name" db:"rr_name"`
+	PrepareTime  null.Int    `json:"prepare_time" db:"rr_prep_time"`
+	Difficulty   null.Int    `json:"difficulty" db:"rr_difficulty"`
+	IsVegetarian bool        `json:"is_vegetarian" db:"rr_vegetarian"`
+	Rating       null.Float  `json:"rating" db:"rr_rating"`
+	RatedNum     null.Int    `json:"rated_num" db:"rr_rated_num"`
+	PublishAt    null.Time   `json:"publish_at" db:"rr_publish_at"`
+	UnpublishAt  null.Time   `json:"unpublish_at" db:"rr_unpublish_at"`
+	DeletedAt    null.Time   `json:"deleted_at" db:"rr_deleted_at"`
+	Version      int         `json:"-" db:"rr_version"`
+}
+
+type RecipeFieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+type RecipeRevisionDiff struct {
+	RecipeID int                  `json:"recipe_id"`
+	From     int                  `json:"from"`
+	To       int                  `json:"to"`
+	Changes  []*RecipeFieldChange `json:"changes"`
+}
+
+func newRecipeRevisionDiff(from, to *RecipeRevision) *RecipeRevisionDiff {
+	diff := &RecipeRevisionDiff{
+		RecipeID: to.RecipeID,
+		From:     from.Revision,
+		To:       to.Revision,
+		Changes:  make([]*RecipeFieldChange, 0),
+	}
+	fields := []*RecipeFieldChange{
+		{"name", from.Name, to.Name},
+		{"prepare_time", from.PrepareTime.Ptr(), to.PrepareTime.Ptr()},
+		{"difficulty", from.Difficulty.Ptr(), to.Difficulty.Ptr()},
+		{"is_vegetarian", from.IsVegetarian, to.IsVegetarian},
+		{"rating", from.Rating.Ptr(), to.Rating.Ptr()},
+		{"rated_num", from.RatedNum.Ptr(), to.RatedNum.Ptr()},
+		{"publish_at", from.PublishAt.Ptr(), to.PublishAt.Ptr()},
+		{"unpublish_at", from.UnpublishAt.Ptr(), to.UnpublishAt.Ptr()},
+		{"deleted_at", from.DeletedAt.Ptr(), to.DeletedAt.Ptr()},
+	}
+	for _, f := range fields {
+		if !sameFieldValue(f.From, f.To) {
+			diff.Changes = append(diff.Changes, f)
+		}
 	}
-	r.Rating.Float64 = ((r.Rating.Float64 * float64(r.RatedNum.Int64)) + float64(a.Rating.Int64)) / float64(r.RatedNum.Int64+1)
-	r.RatedNum.Int64++
+	return diff
+}
+
+func sameFieldValue(a, b interface{}) bool {
+	if ta, ok := a.(*time.Time); ok {
+		tb := b.(*time.Time)
+		if ta == nil || tb == nil {
+			return ta == tb
+		}
+		return ta.Equal(*tb)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+type RevisionDiffArg struct {
+	From int `form:"from" validate:"required,min=1"`
+	To   int `form:"to" validate:"required,min=1"`
+}
+
+const (
+	webhookDeliveryPending   = "pending"
+	webhookDeliveryDelivered = "delivered"
+	webhookDeliveryDead      = "dead"
+)
+
+type Webhook struct {
+	ID        int            `json:"id" db:"wh_id"`
+	URL       string         `json:"url" db:"wh_url"`
+	Events    pq.StringArray `json:"events" db:"wh_events"`
+	Secret    string         `json:"secret,omitempty" db:"wh_secret"`
+	CreatedAt time.Time      `json:"created_at" db:"wh_created_at"`
+}
+
+type PostWebhookArg struct {
+	URL    string   `json:"url" validate:"required,url,max=2048"`
+	Events []string `json:"events" validate:"omitempty,dive,oneof=recipe.created recipe.updated recipe.deleted recipe.rated recipe.published recipe.unpublished"`
+}
+
+type WebhookDelivery struct {
+	ID             int                `json:"id" db:"wd_id"`
+	WebhookID      int                `json:"webhook_id" db:"wd_wh_id"`
+	Event          string             `json:"event" db:"wd_event"`
+	Payload        stdjson.RawMessage `json:"payload" db:"wd_payload"`
+	Status         string             `json:"status" db:"wd_status"`
+	Attempts       int                `json:"attempts" db:"wd_attempts"`
+	NextAttemptAt  null.Time          `json:"next_attempt_at" db:"wd_next_attempt_at"`
+	ResponseStatus null.Int           `json:"response_status" db:"wd_response_status"`
+	Error          null.String        `json:"error" db:"wd_error"`
+	CreatedAt      time.Time          `json:"created_at" db:"wd_created_at"`
+	DeliveredAt    null.Time          `json:"delivered_at" db:"wd_delivered_at"`
+}
+
+type WebhookDeliveryFilter struct {
+	Status string `form:"status" validate:"omitempty,oneof=pending delivered dead"`
+}
+
+type EventFilter struct {
+	RecipeID int    `form:"recipe_id" validate:"omitempty,min=1"`
+	Owner    string `form:"owner" validate:"omitempty,max=32"`
 }
 
 type ListFilter struct {
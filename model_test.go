@@ -1,7 +1,9 @@
 package main
 
 import (
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	null "gopkg.in/guregu/null.v3"
@@ -11,37 +13,37 @@ func TestPostRecipeArg(t *testing.T) {
 	testErrorCases := []struct {
 		input PostRecipeArg
 	}{
-		{PostRecipeArg{null.StringFromPtr(nil), null.IntFrom(5), null.IntFrom(3), null.BoolFrom(false)}},
-		{PostRecipeArg{null.StringFrom(""), null.IntFrom(5), null.IntFrom(3), null.BoolFrom(false)}},
-		{PostRecipeArg{null.StringFrom("name"), null.IntFrom(5), null.IntFrom(3), null.BoolFromPtr(nil)}},
-		{PostRecipeArg{null.StringFromPtr(nil), null.IntFrom(5), null.IntFrom(3), null.BoolFromPtr(nil)}},
-		{PostRecipeArg{null.StringFrom(""), null.IntFrom(5), null.IntFrom(3), null.BoolFromPtr(nil)}},
-
-		{PostRecipeArg{null.StringFromPtr(nil), null.IntFrom(5), null.IntFromPtr(nil), null.BoolFrom(false)}},
-		{PostRecipeArg{null.StringFrom(""), null.IntFrom(5), null.IntFromPtr(nil), null.BoolFrom(false)}},
-		{PostRecipeArg{null.StringFrom("name"), null.IntFrom(5), null.IntFromPtr(nil), null.BoolFromPtr(nil)}},
-		{PostRecipeArg{null.StringFromPtr(nil), null.IntFrom(5), null.IntFromPtr(nil), null.BoolFromPtr(nil)}},
-		{PostRecipeArg{null.StringFrom(""), null.IntFrom(5), null.IntFromPtr(nil), null.BoolFromPtr(nil)}},
-
-		{PostRecipeArg{null.StringFromPtr(nil), null.IntFromPtr(nil), null.IntFrom(3), null.BoolFrom(false)}},
-		{PostRecipeArg{null.StringFrom(""), null.IntFromPtr(nil), null.IntFrom(3), null.BoolFrom(false)}},
-		{PostRecipeArg{null.StringFrom("name"), null.IntFromPtr(nil), null.IntFrom(3), null.BoolFromPtr(nil)}},
-		{PostRecipeArg{null.StringFromPtr(nil), null.IntFromPtr(nil), null.IntFrom(3), null.BoolFromPtr(nil)}},
-		{PostRecipeArg{null.StringFrom(""), null.IntFromPtr(nil), null.IntFrom(3), null.BoolFromPtr(nil)}},
-
-		{PostRecipeArg{null.StringFromPtr(nil), null.IntFromPtr(nil), null.IntFromPtr(nil), null.BoolFrom(false)}},
-		{PostRecipeArg{null.StringFrom(""), null.IntFromPtr(nil), null.IntFromPtr(nil), null.BoolFrom(false)}},
-		{PostRecipeArg{null.StringFrom("name"), null.IntFromPtr(nil), null.IntFromPtr(nil), null.BoolFromPtr(nil)}},
-		{PostRecipeArg{null.StringFromPtr(nil), null.IntFromPtr(nil), null.IntFromPtr(nil), null.BoolFromPtr(nil)}},
-		{PostRecipeArg{null.StringFrom(""), null.IntFromPtr(nil), null.IntFromPtr(nil), null.BoolFromPtr(nil)}},
-
-		{PostRecipeArg{null.StringFrom("name"), null.IntFrom(0), null.IntFrom(3), null.BoolFrom(false)}},
-		{PostRecipeArg{null.StringFrom("name"), null.IntFrom(-1), null.IntFrom(3), null.BoolFrom(false)}},
-		{PostRecipeArg{null.StringFrom("name"), null.IntFrom(-2), null.IntFrom(3), null.BoolFrom(false)}},
-		{PostRecipeArg{null.StringFrom("name"), null.IntFrom(5), null.IntFrom(0), null.BoolFrom(false)}},
-		{PostRecipeArg{null.StringFrom("name"), null.IntFrom(5), null.IntFrom(-1), null.BoolFrom(false)}},
-		{PostRecipeArg{null.StringFrom("name"), null.IntFrom(5), null.IntFrom(4), null.BoolFrom(false)}},
-		{PostRecipeArg{null.StringFrom("name"), null.IntFrom(5), null.IntFrom(5), null.BoolFrom(false)}},
+		{PostRecipeArg{Name: null.StringFromPtr(nil), PrepareTime: null.IntFrom(5), Difficulty: null.IntFrom(3), IsVegetarian: null.BoolFrom(false)}},
+		{PostRecipeArg{Name: null.StringFrom(""), PrepareTime: null.IntFrom(5), Difficulty: null.IntFrom(3), IsVegetarian: null.BoolFrom(false)}},
+		{PostRecipeArg{Name: null.StringFrom("name"), PrepareTime: null.IntFrom(5), Difficulty: null.IntFrom(3), IsVegetarian: null.BoolFromPtr(nil)}},
+		{PostRecipeArg{Name: null.StringFromPtr(nil), PrepareTime: null.IntFrom(5), Difficulty: null.IntFrom(3), IsVegetarian: null.BoolFromPtr(nil)}},
+		{PostRecipeArg{Name: null.StringFrom(""), PrepareTime: null.IntFrom(5), Difficulty: null.IntFrom(3), IsVegetarian: null.BoolFromPtr(nil)}},
+
+		{PostRecipeArg{Name: null.StringFromPtr(nil), PrepareTime: null.IntFrom(5), Difficulty: null.IntFromPtr(nil), IsVegetarian: null.BoolFrom(false)}},
+		{PostRecipeArg{Name: null.StringFrom(""), PrepareTime: null.IntFrom(5), Difficulty: null.IntFromPtr(nil), IsVegetarian: null.BoolFrom(false)}},
+		{PostRecipeArg{Name: null.StringFrom("name"), PrepareTime: null.IntFrom(5), Difficulty: null.IntFromPtr(nil), IsVegetarian: null.BoolFromPtr(nil)}},
+		{PostRecipeArg{Name: null.StringFromPtr(nil), PrepareTime: null.IntFrom(5), Difficulty: null.IntFromPtr(nil), IsVegetarian: null.BoolFromPtr(nil)}},
+		{PostRecipeArg{Name: null.StringFrom(""), PrepareTime: null.IntFrom(5), Difficulty: null.IntFromPtr(nil), IsVegetarian: null.BoolFromPtr(nil)}},
+
+		{PostRecipeArg{Name: null.StringFromPtr(nil), PrepareTime: null.IntFromPtr(nil), Difficulty: null.IntFrom(3), IsVegetarian: null.BoolFrom(false)}},
+		{PostRecipeArg{Name: null.StringFrom(""), PrepareTime: null.IntFromPtr(nil), Difficulty: null.IntFrom(3), IsVegetarian: null.BoolFrom(false)}},
+		{PostRecipeArg{Name: null.StringFrom("name"), PrepareTime: null.IntFromPtr(nil), Difficulty: null.IntFrom(3), IsVegetarian: null.BoolFromPtr(nil)}},
+		{PostRecipeArg{Name: null.StringFromPtr(nil), PrepareTime: null.IntFromPtr(nil), Difficulty: null.IntFrom(3), IsVegetarian: null.BoolFromPtr(nil)}},
+		{PostRecipeArg{Name: null.StringFrom(""), PrepareTime: null.IntFromPtr(nil), Difficulty: null.IntFrom(3), IsVegetarian: null.BoolFromPtr(nil)}},
+
+		{PostRecipeArg{Name: null.StringFromPtr(nil), PrepareTime: null.IntFromPtr(nil), Difficulty: null.IntFromPtr(nil), IsVegetarian: null.BoolFrom(false)}},
+		{PostRecipeArg{Name: null.StringFrom(""), PrepareTime: null.IntFromPtr(nil), Difficulty: null.IntFromPtr(nil), IsVegetarian: null.BoolFrom(false)}},
+		{PostRecipeArg{Name: null.StringFrom("name"), PrepareTime: null.IntFromPtr(nil), Difficulty: null.IntFromPtr(nil), IsVegetarian: null.BoolFromPtr(nil)}},
+		{PostRecipeArg{Name: null.StringFromPtr(nil), PrepareTime: null.IntFromPtr(nil), Difficulty: null.IntFromPtr(nil), IsVegetarian: null.BoolFromPtr(nil)}},
+		{PostRecipeArg{Name: null.StringFrom(""), PrepareTime: null.IntFromPtr(nil), Difficulty: null.IntFromPtr(nil), IsVegetarian: null.BoolFromPtr(nil)}},
+
+		{PostRecipeArg{Name: null.StringFrom("name"), PrepareTime: null.IntFrom(0), Difficulty: null.IntFrom(3), IsVegetarian: null.BoolFrom(false)}},
+		{PostRecipeArg{Name: null.StringFrom("name"), PrepareTime: null.IntFrom(-1), Difficulty: null.IntFrom(3), IsVegetarian: null.BoolFrom(false)}},
+		{PostRecipeArg{Name: null.StringFrom("name"), PrepareTime: null.IntFrom(-2), Difficulty: null.IntFrom(3), IsVegetarian: null.BoolFrom(false)}},
+		{PostRecipeArg{Name: null.StringFrom("name"), PrepareTime: null.IntFrom(5), Difficulty: null.IntFrom(0), IsVegetarian: null.BoolFrom(false)}},
+		{PostRecipeArg{Name: null.StringFrom("name"), PrepareTime: null.IntFrom(5), Difficulty: null.IntFrom(-1), IsVegetarian: null.BoolFrom(false)}},
+		{PostRecipeArg{Name: null.StringFrom("name"), PrepareTime: null.IntFrom(5), Difficulty: null.IntFrom(4), IsVegetarian: null.BoolFrom(false)}},
+		{PostRecipeArg{Name: null.StringFrom("name"), PrepareTime: null.IntFrom(5), Difficulty: null.IntFrom(5), IsVegetarian: null.BoolFrom(false)}},
 	}
 	for i, v := range testErrorCases {
 		assert.Error(t, validate.Struct(v.input), "Case [%d]: %#v", i, v.input)
@@ -50,10 +52,10 @@ func TestPostRecipeArg(t *testing.T) {
 	testNoErrorCases := []struct {
 		input PostRecipeArg
 	}{
-		{PostRecipeArg{null.StringFrom("name"), null.IntFrom(5), null.IntFrom(3), null.BoolFrom(false)}},
-		{PostRecipeArg{null.StringFrom("name"), null.IntFrom(5), null.IntFromPtr(nil), null.BoolFrom(false)}},
-		{PostRecipeArg{null.StringFrom("name"), null.IntFromPtr(nil), null.IntFrom(3), null.BoolFrom(false)}},
-		{PostRecipeArg{null.StringFrom("name"), null.IntFromPtr(nil), null.IntFromPtr(nil), null.BoolFrom(false)}},
+		{PostRecipeArg{Name: null.StringFrom("name"), PrepareTime: null.IntFrom(5), Difficulty: null.IntFrom(3), IsVegetarian: null.BoolFrom(false)}},
+		{PostRecipeArg{Name: null.StringFrom("name"), PrepareTime: null.IntFrom(5), Difficulty: null.IntFromPtr(nil), IsVegetarian: null.BoolFrom(false)}},
+		{PostRecipeArg{Name: null.StringFrom("name"), PrepareTime: null.IntFromPtr(nil), Difficulty: null.IntFrom(3), IsVegetarian: null.BoolFrom(false)}},
+		{PostRecipeArg{Name: null.StringFrom("name"), PrepareTime: null.IntFromPtr(nil), Difficulty: null.IntFromPtr(nil), IsVegetarian: null.BoolFrom(false)}},
 	}
 	for i, v := range testNoErrorCases {
 		assert.NoError(t, validate.Struct(v.input), "Case [%d]: %#v", i, v.input)
@@ -92,22 +94,35 @@ func TestPutRecipeArg(t *testing.T) {
 	testErrorCases := []struct {
 		input PutRecipeArg
 	}{
-		{PutRecipeArg{null.StringFrom(""), null.IntFrom(5), null.IntFrom(3), null.BoolFrom(false)}},
-		{PutRecipeArg{null.StringFrom(""), null.IntFrom(5), null.IntFrom(3), null.BoolFromPtr(nil)}},
-		{PutRecipeArg{null.StringFrom(""), null.IntFrom(5), null.IntFromPtr(nil), null.BoolFrom(false)}},
-		{PutRecipeArg{null.StringFrom(""), null.IntFrom(5), null.IntFromPtr(nil), null.BoolFromPtr(nil)}},
-		{PutRecipeArg{null.StringFrom(""), null.IntFromPtr(nil), null.IntFrom(3), null.BoolFrom(false)}},
-		{PutRecipeArg{null.StringFrom(""), null.IntFromPtr(nil), null.IntFrom(3), null.BoolFromPtr(nil)}},
-		{PutRecipeArg{null.StringFrom(""), null.IntFromPtr(nil), null.IntFromPtr(nil), null.BoolFrom(false)}},
-		{PutRecipeArg{null.StringFrom(""), null.IntFromPtr(nil), null.IntFromPtr(nil), null.BoolFromPtr(nil)}},
-
-		{PutRecipeArg{null.StringFrom("name"), null.IntFrom(0), null.IntFrom(3), null.BoolFromPtr(nil)}},
-		{PutRecipeArg{null.StringFrom("name"), null.IntFrom(-1), null.IntFrom(3), null.BoolFromPtr(nil)}},
-		{PutRecipeArg{null.StringFrom("name"), null.IntFrom(-2), null.IntFrom(3), null.BoolFromPtr(nil)}},
-		{PutRecipeArg{null.StringFrom("name"), null.IntFrom(5), null.IntFrom(0), null.BoolFromPtr(nil)}},
-		{PutRecipeArg{null.StringFrom("name"), null.IntFrom(5), null.IntFrom(-1), null.BoolFromPtr(nil)}},
-		{PutRecipeArg{null.StringFrom("name"), null.IntFrom(5), null.IntFrom(4), null.BoolFromPtr(nil)}},
-		{PutRecipeArg{null.StringFrom("name"), null.IntFrom(5), null.IntFrom(5), null.BoolFromPtr(nil)}},
+		{PutRecipeArg{Name: null.StringFrom(""), PrepareTime: null.IntFrom(5), Difficulty: null.IntFrom(3), IsVegetarian: null.BoolFrom(false)}},
+		{PutRecipeArg{Name: null.StringFrom(""), PrepareTime: null.IntFrom(5), Difficulty: null.IntFrom(3), IsVegetarian: null.BoolFromPtr(nil)}},
+		{PutRecipeArg{Name: null.StringFrom(""), PrepareTime: null.IntFrom(5), Difficulty: null.IntFromPtr(nil), IsVegetarian: null.BoolFrom(false)}},
+		{PutRecipeArg{Name: null.StringFrom(""), PrepareTime: null.IntFrom(5), Difficulty: null.IntFromPtr(nil), IsVegetarian: null.BoolFromPtr(nil)}},
+		{PutRecipeArg{Name: null.StringFrom(""), PrepareTime: null.IntFromPtr(nil), Difficulty: null.IntFrom(3), IsVegetarian: null.BoolFrom(false)}},
+		{PutRecipeArg{Name: null.StringFrom(""), PrepareTime: null.IntFromPtr(nil), Difficulty: null.IntFrom(3), IsVegetarian: null.BoolFromPtr(nil)}},
+		{PutRecipeArg{Name: null.StringFrom(""), PrepareTime: null.IntFromPtr(nil), Difficulty: null.IntFromPtr(nil), IsVegetarian: null.BoolFrom(false)}},
+		{PutRecipeArg{Name: null.StringFrom(""), PrepareTime: null.IntFromPtr(nil), Difficulty: null.IntFromPtr(nil), IsVegetarian: null.BoolFromPtr(nil)}},
+
+		{PutRecipeArg{Name: null.StringFrom("name"), PrepareTime: null.IntFrom(0), Difficulty: null.IntFrom(3), IsVegetarian: null.BoolFromPtr(nil)}},
+		{PutRecipeArg{Name: null.StringFrom("name"), PrepareTime: null.IntFrom(-1), Difficulty: null.IntFrom(3), IsVegetarian: null.BoolFromPtr(nil)}},
+		{PutRecipeArg{Name: null.StringFrom("name"), PrepareTime: null.IntFrom(-2), Difficulty: null.IntFrom(3), IsVegetarian: null.BoolFromPtr(nil)}},
+		{PutRecipeArg{Name: null.StringFrom("name"), PrepareTime: null.IntFrom(5), Difficulty: null.IntFrom(0), IsVegetarian: null.BoolFromPtr(nil)}},
+		{PutRecipeArg{Name: null.StringFrom("name"), PrepareTime: null.IntFrom(5), Difficulty: null.IntFrom(-1), IsVegetarian: null.BoolFromPtr(nil)}},
+		{PutRecipeArg{Name: null.StringFrom("name"), PrepareTime: null.IntFrom(5), Difficulty: null.IntFrom(4), IsVegetarian: null.BoolFromPtr(nil)}},
+		{PutRecipeArg{Name: null.StringFrom("name"), PrepareTime: null.IntFrom(5), Difficulty: null.IntFrom(5), IsVegetarian: null.BoolFromPtr(nil)}},
+
+		{PutRecipeArg{Name: null.StringFromPtr(nil), PrepareTime: null.IntFrom(5), Difficulty: null.IntFrom(3), IsVegetarian: null.BoolFrom(false)}},
+		{PutRecipeArg{Name: null.StringFrom("name"), PrepareTime: null.IntFrom(5), Difficulty: null.IntFrom(3), IsVegetarian: null.BoolFromPtr(nil)}},
+		{PutRecipeArg{Name: null.StringFromPtr(nil), PrepareTime: null.IntFrom(5), Difficulty: null.IntFrom(3), IsVegetarian: null.BoolFromPtr(nil)}},
+		{PutRecipeArg{Name: null.StringFromPtr(nil), PrepareTime: null.IntFrom(5), Difficulty: null.IntFromPtr(nil), IsVegetarian: null.BoolFrom(false)}},
+		{PutRecipeArg{Name: null.StringFrom("name"), PrepareTime: null.IntFrom(5), Difficulty: null.IntFromPtr(nil), IsVegetarian: null.BoolFromPtr(nil)}},
+		{PutRecipeArg{Name: null.StringFromPtr(nil), PrepareTime: null.IntFrom(5), Difficulty: null.IntFromPtr(nil), IsVegetarian: null.BoolFromPtr(nil)}},
+		{PutRecipeArg{Name: null.StringFromPtr(nil), PrepareTime: null.IntFromPtr(nil), Difficulty: null.IntFrom(3), IsVegetarian: null.BoolFrom(false)}},
+		{PutRecipeArg{Name: null.StringFrom("name"), PrepareTime: null.IntFromPtr(nil), Difficulty: null.IntFrom(3), IsVegetarian: null.BoolFromPtr(nil)}},
+		{PutRecipeArg{Name: null.StringFromPtr(nil), PrepareTime: null.IntFromPtr(nil), Difficulty: null.IntFrom(3), IsVegetarian: null.BoolFromPtr(nil)}},
+		{PutRecipeArg{Name: null.StringFromPtr(nil), PrepareTime: null.IntFromPtr(nil), Difficulty: null.IntFromPtr(nil), IsVegetarian: null.BoolFrom(false)}},
+		{PutRecipeArg{Name: null.StringFrom("name"), PrepareTime: null.IntFromPtr(nil), Difficulty: null.IntFromPtr(nil), IsVegetarian: null.BoolFromPtr(nil)}},
+		{PutRecipeArg{Name: null.StringFromPtr(nil), PrepareTime: null.IntFromPtr(nil), Difficulty: null.IntFromPtr(nil), IsVegetarian: null.BoolFromPtr(nil)}},
 	}
 	for i, v := range testErrorCases {
 		assert.Error(t, validate.Struct(v.input), "Case [%d]: %#v", i, v.input)
@@ -116,28 +131,111 @@ func TestPutRecipeArg(t *testing.T) {
 	testNoErrorCases := []struct {
 		input PutRecipeArg
 	}{
-		{PutRecipeArg{null.StringFromPtr(nil), null.IntFrom(5), null.IntFrom(3), null.BoolFrom(false)}},
-		{PutRecipeArg{null.StringFrom("name"), null.IntFrom(5), null.IntFrom(3), null.BoolFromPtr(nil)}},
-		{PutRecipeArg{null.StringFromPtr(nil), null.IntFrom(5), null.IntFrom(3), null.BoolFromPtr(nil)}},
+		{PutRecipeArg{Name: null.StringFrom("name"), PrepareTime: null.IntFrom(5), Difficulty: null.IntFrom(3), IsVegetarian: null.BoolFrom(false)}},
+		{PutRecipeArg{Name: null.StringFrom("name"), PrepareTime: null.IntFrom(5), Difficulty: null.IntFromPtr(nil), IsVegetarian: null.BoolFrom(false)}},
+		{PutRecipeArg{Name: null.StringFrom("name"), PrepareTime: null.IntFromPtr(nil), Difficulty: null.IntFrom(3), IsVegetarian: null.BoolFrom(false)}},
+		{PutRecipeArg{Name: null.StringFrom("name"), PrepareTime: null.IntFromPtr(nil), Difficulty: null.IntFromPtr(nil), IsVegetarian: null.BoolFrom(false)}},
+	}
+	for i, v := range testNoErrorCases {
+		assert.NoError(t, validate.Struct(v.input), "Case [%d]: %#v", i, v.input)
+	}
+}
 
-		{PutRecipeArg{null.StringFromPtr(nil), null.IntFrom(5), null.IntFromPtr(nil), null.BoolFrom(false)}},
-		{PutRecipeArg{null.StringFrom("name"), null.IntFrom(5), null.IntFromPtr(nil), null.BoolFromPtr(nil)}},
-		{PutRecipeArg{null.StringFromPtr(nil), null.IntFrom(5), null.IntFromPtr(nil), null.BoolFromPtr(nil)}},
+func TestRecipeArgPublishWindow(t *testing.T) {
+	now := time.Now()
+	testErrorCases := []struct {
+		input interface{}
+	}{
+		{PostRecipeArg{Name: null.StringFrom("name"), IsVegetarian: null.BoolFrom(false), PublishAt: null.TimeFrom(now), UnpublishAt: null.TimeFrom(now)}},
+		{PostRecipeArg{Name: null.StringFrom("name"), IsVegetarian: null.BoolFrom(false), PublishAt: null.TimeFrom(now), UnpublishAt: null.TimeFrom(now.Add(-time.Hour))}},
+		{PutRecipeArg{Name: null.StringFrom("name"), IsVegetarian: null.BoolFrom(false), PublishAt: null.TimeFrom(now), UnpublishAt: null.TimeFrom(now.Add(-time.Hour))}},
+	}
+	for i, v := range testErrorCases {
+		assert.Error(t, validate.Struct(v.input), "Case [%d]: %#v", i, v.input)
+	}
 
-		{PutRecipeArg{null.StringFromPtr(nil), null.IntFromPtr(nil), null.IntFrom(3), null.BoolFrom(false)}},
-		{PutRecipeArg{null.StringFrom("name"), null.IntFromPtr(nil), null.IntFrom(3), null.BoolFromPtr(nil)}},
-		{PutRecipeArg{null.StringFromPtr(nil), null.IntFromPtr(nil), null.IntFrom(3), null.BoolFromPtr(nil)}},
+	testNoErrorCases := []struct {
+		input interface{}
+	}{
+		{PostRecipeArg{Name: null.StringFrom("name"), IsVegetarian: null.BoolFrom(false), PublishAt: null.TimeFrom(now), UnpublishAt: null.TimeFrom(now.Add(time.Hour))}},
+		{PostRecipeArg{Name: null.StringFrom("name"), IsVegetarian: null.BoolFrom(false), PublishAt: null.TimeFrom(now)}},
+		{PostRecipeArg{Name: null.StringFrom("name"), IsVegetarian: null.BoolFrom(false), UnpublishAt: null.TimeFrom(now)}},
+		{PutRecipeArg{Name: null.StringFrom("name"), IsVegetarian: null.BoolFrom(false), PublishAt: null.TimeFrom(now), UnpublishAt: null.TimeFrom(now.Add(time.Hour))}},
+		{PutRecipeArg{Name: null.StringFrom("name"), IsVegetarian: null.BoolFrom(false), UnpublishAt: null.TimeFrom(now)}},
+	}
+	for i, v := range testNoErrorCases {
+		assert.NoError(t, validate.Struct(v.input), "Case [%d]: %#v", i, v.input)
+	}
+}
 
-		{PutRecipeArg{null.StringFromPtr(nil), null.IntFromPtr(nil), null.IntFromPtr(nil), null.BoolFrom(false)}},
-		{PutRecipeArg{null.StringFrom("name"), null.IntFromPtr(nil), null.IntFromPtr(nil), null.BoolFromPtr(nil)}},
-		{PutRecipeArg{null.StringFromPtr(nil), null.IntFromPtr(nil), null.IntFromPtr(nil), null.BoolFromPtr(nil)}},
+func TestRecipeBatchOperation(t *testing.T) {
+	recipe := &PostRecipeArg{Name: null.StringFrom("name"), IsVegetarian: null.BoolFrom(false)}
+	testErrorCases := []struct {
+		input *RecipeBatchOperation
+	}{
+		{&RecipeBatchOperation{Op: "upsert", ID: 1, Recipe: recipe}},
+		{&RecipeBatchOperation{Op: recipeBatchCreate}},
+		{&RecipeBatchOperation{Op: recipeBatchCreate, Recipe: &PostRecipeArg{Name: null.StringFrom("name")}}},
+		{&RecipeBatchOperation{Op: recipeBatchUpdate, Recipe: recipe}},
+		{&RecipeBatchOperation{Op: recipeBatchUpdate, ID: 1}},
+		{&RecipeBatchOperation{Op: recipeBatchDelete}},
+	}
+	for i, v := range testErrorCases {
+		assert.Error(t, v.input.validate(), "Case [%d]: %#v", i, v.input)
+	}
 
-		{PutRecipeArg{null.StringFrom("name"), null.IntFrom(5), null.IntFrom(3), null.BoolFrom(false)}},
-		{PutRecipeArg{null.StringFrom("name"), null.IntFrom(5), null.IntFromPtr(nil), null.BoolFrom(false)}},
-		{PutRecipeArg{null.StringFrom("name"), null.IntFromPtr(nil), null.IntFrom(3), null.BoolFrom(false)}},
-		{PutRecipeArg{null.StringFrom("name"), null.IntFromPtr(nil), null.IntFromPtr(nil), null.BoolFrom(false)}},
+	testNoErrorCases := []struct {
+		input *RecipeBatchOperation
+	}{
+		{&RecipeBatchOperation{Op: recipeBatchCreate, Recipe: recipe}},
+		{&RecipeBatchOperation{Op: recipeBatchUpdate, ID: 1, Version: 2, Recipe: recipe}},
+		{&RecipeBatchOperation{Op: recipeBatchDelete, ID: 1}},
 	}
 	for i, v := range testNoErrorCases {
-		assert.NoError(t, validate.Struct(v.input), "Case [%d]: %#v", i, v.input)
+		assert.NoError(t, v.input.validate(), "Case [%d]: %#v", i, v.input)
 	}
 }
+
+func TestNewRecipeRevisionDiff(t *testing.T) {
+	now := time.Now()
+	from := &RecipeRevision{
+		RecipeID:    1,
+		Revision:    1,
+		Name:        "name",
+		PrepareTime: null.IntFrom(5),
+		PublishAt:   null.TimeFrom(now),
+	}
+	to := &RecipeRevision{
+		RecipeID:     1,
+		Revision:     3,
+		Name:         "name_updated",
+		PrepareTime:  null.IntFrom(5),
+		Difficulty:   null.IntFrom(2),
+		IsVegetarian: true,
+		RatedNum:     null.IntFrom(1),
+		PublishAt:    null.TimeFrom(now.UTC()),
+	}
+
+	diff := newRecipeRevisionDiff(from, to)
+	assert.Equal(t, 1, diff.RecipeID)
+	assert.Equal(t, 1, diff.From)
+	assert.Equal(t, 3, diff.To)
+	if assert.Len(t, diff.Changes, 4) {
+		assert.Equal(t, "name", diff.Changes[0].Field)
+		assert.Equal(t, "name", diff.Changes[0].From)
+		assert.Equal(t, "name_updated", diff.Changes[0].To)
+		assert.Equal(t, "difficulty", diff.Changes[1].Field)
+		assert.Nil(t, diff.Changes[1].From)
+		assert.Equal(t, "is_vegetarian", diff.Changes[2].Field)
+		assert.Equal(t, "rated_num", diff.Changes[3].Field)
+	}
+
+	assert.Len(t, newRecipeRevisionDiff(to, to).Changes, 0)
+}
+
+func TestValidationFieldName(t *testing.T) {
+	arg := &RecipeBatchArg{}
+	assert.Equal(t, "operations[1].recipe.name", validationFieldName(reflect.TypeOf(arg), "RecipeBatchArg.Operations[1].Recipe.Name", "json"))
+	assert.Equal(t, "strategy", validationFieldName(reflect.TypeOf(&ImportRecipesArg{}), "ImportRecipesArg.Strategy", "form"))
+	assert.Equal(t, "Unknown", validationFieldName(reflect.TypeOf(arg), "RecipeBatchArg.Unknown", "json"))
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bytes"
+	"encoding"
+	stdjson "encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/gin-gonic/gin/render"
+	"github.com/ugorji/go/codec"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	yamlContentType  = "application/x-yaml"
+	yamlContentType2 = "application/yaml"
+
+	responseFormatKey = "responseFormat"
+	xmlRootElement    = "response"
+	xmlItemElement    = "item"
+
+	// maxRequestBodySize is how long a request body can be that is read as
+	// a whole, to bind it or to check it against the contract. The imports
+	// stream their bodies and limit the length of a line instead.
+	maxRequestBodySize = 1 << 20
+)
+
+var defaultResponseFormats = []string{
+	gin.MIMEJSON,
+	gin.MIMEXML, gin.MIMEXML2,
+	yamlContentType, yamlContentType2,
+	binding.MIMEMSGPACK, binding.MIMEMSGPACK2,
+}
+
+var errUnsupportedMediaType = errors.New("unsupported media type")
+
+// negotiate picks the response format of a route from the Accept header
+// among the default formats and the ones the route produces besides them,
+// and responds with 406 if none of them is acceptable.
+func negotiate(extraFormats ...string) gin.HandlerFunc {
+	return negotiateAmong(append(append([]string{}, defaultResponseFormats...), extraFormats...)...)
+}
+
+func negotiateAmong(formats ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		format := negotiateFormat(c.GetHeader("Accept"), formats)
+		if format == "" {
+			c.AbortWithStatus(http.StatusNotAcceptable)
+			return
+		}
+		c.Set(responseFormatKey, format)
+	}
+}
+
+func responseFormat(c *gin.Context) string {
+	return c.GetString(responseFormatKey)
+}
+
+type mediaRange struct {
+	mediaType string
+	q         float64
+}
+
+func parseAcceptHeader(header string) []mediaRange {
+	res := make([]mediaRange, 0)
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		r := mediaRange{mediaType: strings.ToLower(strings.TrimSpace(params[0])), q: 1}
+		if r.mediaType == "" {
+			continue
+		}
+		for _, param := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) != 2 || strings.ToLower(strings.TrimSpace(kv[0])) != "q" {
+				continue
+			}
+			if q, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64); err == nil {
+				r.q = q
+			}
+		}
+		res = append(res, r)
+	}
+	return res
+}
+
+// mediaRangeSpecificity tells how specifically a media range matches a
+// media type: 3 for an exact match, 2 for type/*, 1 for */* and 0 for none.
+func mediaRangeSpecificity(r string, mediaType string) int {
+	switch {
+	case r == mediaType:
+		return 3
+	case strings.HasSuffix(r, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(r, "*")):
+		return 2
+	case r == "*/*" || r == "*":
+		return 1
+	}
+	return 0
+}
+
+// negotiateFormat returns the offered format with the highest quality in
+// the Accept header. Ties go to the format matched earlier in the header,
+// then to the one offered first. An empty header accepts the first offer.
+func negotiateFormat(header string, offered []string) string {
+	ranges := parseAcceptHeader(header)
+	if len(ranges) == 0 {
+		return offered[0]
+	}
+	best, bestQ, bestIndex := "", 0.0, len(ranges)
+	for _, format := range offered {
+		q, index, specificity := 0.0, len(ranges), 0
+		for i, r := range ranges {
+			if s := mediaRangeSpecificity(r.mediaType, format); s > specificity {
+				q, index, specificity = r.q, i, s
+			}
+		}
+		if q > bestQ || (q == bestQ && q > 0 && index < bestIndex) {
+			best, bestQ, bestIndex = format, q, index
+		}
+	}
+	return best
+}
+
+// respond renders obj in the shape of the API version and in the negotiated
+// response format. XML, YAML and MessagePack are rendered from the JSON
+// representation, so that every format has the same field names and null
+// values.
+func respond(c *gin.Context, code int, obj interface{}) {
+	obj = versionedResponse(apiVersion(c), obj)
+	format := responseFormat(c)
+	if format == "" || format == gin.MIMEJSON {
+		c.JSON(code, obj)
+		return
+	}
+
+	doc, err := stdjson.Marshal(obj)
+	if err != nil {
+		panic(err)
+	}
+	tree, err := decodeOrderedJSON(doc)
+	if err != nil {
+		panic(err)
+	}
+	switch format {
+	case gin.MIMEXML, gin.MIMEXML2:
+		buf := &bytes.Buffer{}
+		buf.WriteString(xml.Header)
+		if err := encodeXMLTree(xml.NewEncoder(buf), xmlRootElement, tree); err != nil {
+			panic(err)
+		}
+		c.Data(code, format+"; charset=utf-8", buf.Bytes())
+	case yamlContentType, yamlContentType2:
+		out, err := yaml.Marshal(tree)
+		if err != nil {
+			panic(err)
+		}
+		c.Data(code, format+"; charset=utf-8", out)
+	case binding.MIMEMSGPACK, binding.MIMEMSGPACK2:
+		c.Header("Content-Type", format)
+		c.Render(code, render.MsgPack{Data: unorderedTree(tree)})
+	default:
+		panic(fmt.Sprintf("no renderer for the response format %q", format))
+	}
+}
+
+// decodeOrderedJSON decodes a JSON document keeping the order of the object
+// members as yaml.MapSlice, and numbers as int64 or float64.
+func decodeOrderedJSON(doc []byte) (interface{}, error) {
+	decoder := stdjson.NewDecoder(bytes.NewReader(doc))
+	decoder.UseNumber()
+	return decodeOrderedJSONValue(decoder)
+}
+
+func decodeOrderedJSONValue(decoder *stdjson.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token := token.(type) {
+	case stdjson.Delim:
+		if token == '[' {
+			res := make([]interface{}, 0)
+			for decoder.More() {
+				v, err := decodeOrderedJSONValue(decoder)
+				if err != nil {
+					return nil, err
+				}
+				res = append(res, v)
+			}
+			_, err := decoder.Token()
+			return res, err
+		}
+		res := yaml.MapSlice{}
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			v, err := decodeOrderedJSONValue(decoder)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, yaml.MapItem{Key: key, Value: v})
+		}
+		_, err := decoder.Token()
+		return res, err
+	case stdjson.Number:
+		if i, err := token.Int64(); err == nil {
+			return i, nil
+		}
+		return token.Float64()
+	}
+	return token, nil
+}
+
+func unorderedTree(v interface{}) interface{} {
+	switch v := v.(type) {
+	case yaml.MapSlice:
+		res := make(map[string]interface{}, len(v))
+		for _, item := range v {
+			res[fmt.Sprint(item.Key)] = unorderedTree(item.Value)
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, e := range v {
+			res[i] = unorderedTree(e)
+		}
+		return res
+	}
+	return v
+}
+
+func encodeXMLTree(encoder *xml.Encoder, name string, v interface{}) error {
+	start := xml.StartElement{Name: xml.Name{Local: xmlElementName(name)}}
+	if err := encoder.EncodeToken(start); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case yaml.MapSlice:
+		for _, item := range v {
+			if err := encodeXMLTree(encoder, fmt.Sprint(item.Key), item.Value); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, e := range v {
+			if err := encodeXMLTree(encoder, xmlItemElement, e); err != nil {
+				return err
+			}
+		}
+	case nil:
+	default:
+		if err := encoder.EncodeToken(xml.CharData(fmt.Sprint(v))); err != nil {
+			return err
+		}
+	}
+	if err := encoder.EncodeToken(start.End()); err != nil {
+		return err
+	}
+	return encoder.Flush()
+}
+
+func xmlElementName(name string) string {
+	res := []rune(name)
+	for i, r := range res {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '-' && r != '.' {
+			res[i] = '_'
+		}
+	}
+	if len(res) == 0 || !unicode.IsLetter(res[0]) && res[0] != '_' {
+		return "_" + string(res)
+	}
+	return string(res)
+}
+
+// limitRequestBody makes reading the request body fail once it is longer
+// than maxRequestBodySize.
+func limitRequestBody(c *gin.Context) {
+	if c.Request.Body != nil {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxRequestBodySize)
+	}
+}
+
+// bindBody decodes the request body into obj by its Content-Type. XML,
+// YAML and MessagePack documents use the same field names as JSON ones.
+func bindBody(c *gin.Context, obj interface{}) error {
+	limitRequestBody(c)
+	switch c.ContentType() {
+	case "", gin.MIMEJSON:
+		return c.ShouldBindJSON(obj)
+	case gin.MIMEXML, gin.MIMEXML2:
+		return decodeXMLBody(c.Request.Body, obj)
+	case yamlContentType, yamlContentType2:
+		var v interface{}
+		body, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			return err
+		}
+		if err := yaml.Unmarshal(body, &v); err != nil {
+			return err
+		}
+		return decodeTree(v, obj)
+	case binding.MIMEMSGPACK, binding.MIMEMSGPACK2:
+		var v interface{}
+		handle := &codec.MsgpackHandle{RawToString: true}
+		handle.MapType = reflect.TypeOf(map[string]interface{}(nil))
+		if err := codec.NewDecoder(c.Request.Body, handle).Decode(&v); err != nil {
+			return err
+		}
+		return decodeTree(v, obj)
+	}
+	return errUnsupportedMediaType
+}
+
+func bindErrorStatus(err error) int {
+	if err == errUnsupportedMediaType {
+		return http.StatusUnsupportedMediaType
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// decodeTree decodes a generic document tree into obj through JSON.
+func decodeTree(v interface{}, obj interface{}) error {
+	doc, err := stdjson.Marshal(jsonCompatibleTree(v))
+	if err != nil {
+		return err
+	}
+	return stdjson.Unmarshal(doc, obj)
+}
+
+func jsonCompatibleTree(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			res[fmt.Sprint(k)] = jsonCompatibleTree(e)
+		}
+		return res
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			res[k] = jsonCompatibleTree(e)
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, e := range v {
+			res[i] = jsonCompatibleTree(e)
+		}
+		return res
+	case []byte:
+		return string(v)
+	}
+	return v
+}
+
+type xmlNode struct {
+	XMLName  xml.Name
+	Content  string    `xml:",chardata"`
+	Children []xmlNode `xml:",any"`
+}
+
+func decodeXMLBody(r io.Reader, obj interface{}) error {
+	var root xmlNode
+	if err := xml.NewDecoder(r).Decode(&root); err != nil {
+		return err
+	}
+	return assignXMLNode(&root, reflect.ValueOf(obj))
+}
+
+// assignXMLNode sets v from an XML element whose children are named after
+// the JSON names of the fields of v, and whose items are any elements.
+func assignXMLNode(n *xmlNode, v reflect.Value) error {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	text := strings.TrimSpace(n.Content)
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(text))
+	}
+
+	var err error
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			name := strings.Split(f.Tag.Get("json"), ",")[0]
+			if f.PkgPath != "" || name == "-" || name == "" {
+				continue
+			}
+			for j := range n.Children {
+				if n.Children[j].XMLName.Local != name {
+					continue
+				}
+				if err := assignXMLNode(&n.Children[j], v.Field(i)); err != nil {
+					return fmt.Errorf("%s: %s", name, err)
+				}
+			}
+		}
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), 0, len(n.Children)))
+		for j := range n.Children {
+			e := reflect.New(v.Type().Elem()).Elem()
+			if err := assignXMLNode(&n.Children[j], e); err != nil {
+				return err
+			}
+			v.Set(reflect.Append(v, e))
+		}
+	case reflect.String:
+		v.SetString(text)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		if i, err = strconv.ParseInt(text, 10, 64); err == nil {
+			v.SetInt(i)
+		}
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		if f, err = strconv.ParseFloat(text, 64); err == nil {
+			v.SetFloat(f)
+		}
+	case reflect.Bool:
+		var b bool
+		if b, err = strconv.ParseBool(text); err == nil {
+			v.SetBool(b)
+		}
+	default:
+		err = fmt.Errorf("cannot decode XML into %s", v.Type())
+	}
+	return err
+}
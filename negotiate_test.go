@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ugorji/go/codec"
+	null "gopkg.in/guregu/null.v3"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	offered := []string{"application/json", "application/xml", "application/x-yaml"}
+	assert.Equal(t, "application/json", negotiateFormat("", offered))
+	assert.Equal(t, "application/json", negotiateFormat("*/*", offered))
+	assert.Equal(t, "application/xml", negotiateFormat("application/xml", offered))
+	assert.Equal(t, "application/xml", negotiateFormat("application/*;q=0.5, application/xml", offered))
+	assert.Equal(t, "application/x-yaml", negotiateFormat("application/json;q=0.2, application/x-yaml;q=0.8", offered))
+	assert.Equal(t, "application/x-yaml", negotiateFormat("application/x-yaml, application/xml", offered))
+	assert.Equal(t, "application/xml", negotiateFormat("application/*, application/json;q=0", offered))
+	assert.Equal(t, "", negotiateFormat("text/html", offered))
+	assert.Equal(t, "", negotiateFormat("application/json;q=0", offered))
+}
+
+func TestXMLElementName(t *testing.T) {
+	assert.Equal(t, "prepare_time", xmlElementName("prepare_time"))
+	assert.Equal(t, "_type", xmlElementName("@type"))
+	assert.Equal(t, "_1", xmlElementName("1"))
+	assert.Equal(t, "_", xmlElementName(""))
+}
+
+func TestDecodeXMLBody(t *testing.T) {
+	arg := &RecipeBatchArg{}
+	assert.NoError(t, decodeXMLBody(bytes.NewBufferString(`
+	<batch>
+		<mode>best_effort</mode>
+		<operations>
+			<item><op>create</op><recipe><name>name1</name><is_vegetarian>true</is_vegetarian></recipe></item>
+			<item><op>delete</op><id>11</id><version>2</version></item>
+		</operations>
+	</batch>`), arg))
+	assert.Equal(t, &RecipeBatchArg{
+		Mode: "best_effort",
+		Operations: []*RecipeBatchOperation{
+			{Op: "create", Recipe: &PostRecipeArg{Name: null.StringFrom("name1"), IsVegetarian: null.BoolFrom(true)}},
+			{Op: "delete", ID: 11, Version: 2},
+		},
+	}, arg)
+
+	assert.Error(t, decodeXMLBody(bytes.NewBufferString(`<recipe><prepare_time>five</prepare_time></recipe>`), &PostRecipeArg{}))
+}
+
+func TestDecodeTree(t *testing.T) {
+	var doc interface{}
+	buf := &bytes.Buffer{}
+	assert.NoError(t, codec.NewEncoder(buf, &codec.MsgpackHandle{}).Encode(map[string]interface{}{
+		"name": "name1", "prepare_time": 5, "is_vegetarian": false,
+	}))
+	handle := &codec.MsgpackHandle{RawToString: true}
+	assert.NoError(t, codec.NewDecoder(buf, handle).Decode(&doc))
+
+	arg := &PostRecipeArg{}
+	assert.NoError(t, decodeTree(doc, arg))
+	assert.Equal(t, &PostRecipeArg{Name: null.StringFrom("name1"), PrepareTime: null.IntFrom(5), IsVegetarian: null.BoolFrom(false)}, arg)
+}
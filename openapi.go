@@ -0,0 +1,645 @@
+package main
+
+import (
+	stdjson "encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	null "gopkg.in/guregu/null.v3"
+)
+
+const (
+	openAPIVersion     = "3.0.3"
+	apiTitle           = "Recipes API"
+	apiDocumentVersion = "1.0.0"
+	accessTokenScheme  = "accessToken"
+	htmlContentType    = "text/html"
+	openAPISchemaRef   = "#/components/schemas/"
+)
+
+var (
+	nullStringType = reflect.TypeOf(null.String{})
+	nullIntType    = reflect.TypeOf(null.Int{})
+	nullFloatType  = reflect.TypeOf(null.Float{})
+	nullBoolType   = reflect.TypeOf(null.Bool{})
+	nullTimeType   = reflect.TypeOf(null.Time{})
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(stdjson.RawMessage{})
+)
+
+var routeParam = regexp.MustCompile(`/[:*](\w+)`)
+
+// apiOperation documents a route of apiServer.routes(). The requests and
+// responses map the media types of a body to a value of the type it is
+// decoded into or rendered from.
+type apiOperation struct {
+	summary   string
+	protected bool
+	paging    bool
+	headers   []string
+	query     interface{}
+	requests  map[string]interface{}
+	responses map[string]interface{}
+	statuses  []int
+}
+
+var apiOperations = map[string]*apiOperation{
+	"GET /recipes": {
+		summary:   "Search recipes",
+		paging:    true,
+		query:     &ListFilter{},
+		responses: contentOf([]*Recipe{}, defaultResponseFormats, csvContentType),
+		statuses:  []int{http.StatusOK, http.StatusBadRequest, http.StatusNotAcceptable},
+	},
+	"POST /recipes": {
+		summary:   "Add a new recipe",
+		protected: true,
+		requests:  contentOf(&PostRecipeArg{}, defaultResponseFormats),
+		responses: contentOf(&Recipe{}, defaultResponseFormats),
+		statuses:  []int{http.StatusOK, http.StatusBadRequest, http.StatusNotFound, http.StatusNotAcceptable, http.StatusUnsupportedMediaType, http.StatusUnprocessableEntity},
+	},
+	"POST /recipes:batch": {
+		summary:   "Create, replace and delete recipes in a batch",
+		protected: true,
+		requests:  contentOf(&RecipeBatchArg{}, defaultResponseFormats),
+		responses: contentOf(&RecipeBatchResponse{}, defaultResponseFormats),
+		statuses:  []int{http.StatusOK, http.StatusBadRequest, http.StatusNotAcceptable, http.StatusUnsupportedMediaType, http.StatusUnprocessableEntity},
+	},
+	"POST /recipes/import": {
+		summary:   "Add a recipe from schema.org JSON-LD",
+		protected: true,
+		requests:  contentOf(nil, nil, jsonLDContentType, gin.MIMEJSON),
+		responses: contentOf(&Recipe{}, defaultResponseFormats),
+		statuses:  []int{http.StatusOK, http.StatusBadRequest, http.StatusNotFound, http.StatusNotAcceptable, http.StatusUnprocessableEntity},
+	},
+	"POST /recipes/import.csv": {
+		summary:   "Add recipes from CSV",
+		protected: true,
+		requests:  contentOf("", nil, csvContentType),
+		responses: contentOf(&RecipeCSVImportReport{}, defaultResponseFormats),
+		statuses:  []int{http.StatusOK, http.StatusBadRequest, http.StatusNotFound, http.StatusNotAcceptable, http.StatusUnprocessableEntity},
+	},
+	"GET /recipes/:id": {
+		summary:   "Get an existent recipe",
+		headers:   []string{"If-None-Match"},
+		responses: mergeContent(contentOf(&Recipe{}, defaultResponseFormats), contentOf(&SchemaOrgRecipe{}, nil, jsonLDContentType)),
+		statuses:  []int{http.StatusOK, http.StatusNotModified, http.StatusNotFound, http.StatusNotAcceptable},
+	},
+	"PUT /recipes/:id": {
+		summary:   "Replace an existent recipe",
+		protected: true,
+		headers:   []string{"If-Match"},
+		requests:  contentOf(&PutRecipeArg{}, defaultResponseFormats),
+		responses: contentOf(&Recipe{}, defaultResponseFormats),
+		statuses:  []int{http.StatusOK, http.StatusBadRequest, http.StatusNotFound, http.StatusNotAcceptable, http.StatusPreconditionFailed, http.StatusUnsupportedMediaType, http.StatusUnprocessableEntity, http.StatusPreconditionRequired},
+	},
+	"PATCH /recipes/:id": {
+		summary:   "Modify an existent recipe",
+		protected: true,
+		headers:   []string{"If-Match"},
+		requests:  mergeContent(contentOf(&PutRecipeArg{}, nil, mergePatchContentType), contentOf([]*jsonPatchOperation{}, nil, jsonPatchContentType)),
+		responses: contentOf(&Recipe{}, defaultResponseFormats),
+		statuses:  []int{http.StatusOK, http.StatusBadRequest, http.StatusNotFound, http.StatusNotAcceptable, http.StatusConflict, http.StatusPreconditionFailed, http.StatusUnsupportedMediaType, http.StatusUnprocessableEntity, http.StatusPreconditionRequired},
+	},
+	"DELETE /recipes/:id": {
+		summary:   "Delete an existent recipe",
+		protected: true,
+		headers:   []string{"If-Match"},
+		responses: contentOf(&Recipe{}, defaultResponseFormats),
+		statuses:  []int{http.StatusOK, http.StatusNotFound, http.StatusNotAcceptable, http.StatusPreconditionFailed, http.StatusPreconditionRequired},
+	},
+	"POST /recipes/:id/rating": {
+		summary:   "Rate an existent recipe",
+		requests:  contentOf(&PostRateRecipeArg{}, defaultResponseFormats),
+		responses: contentOf(&Recipe{}, defaultResponseFormats),
+		statuses:  []int{http.StatusOK, http.StatusBadRequest, http.StatusNotFound, http.StatusNotAcceptable, http.StatusUnsupportedMediaType, http.StatusUnprocessableEntity},
+	},
+	"POST /recipes/:id/restore": {
+		summary:   "Restore a deleted recipe",
+		protected: true,
+		responses: contentOf(&Recipe{}, defaultResponseFormats),
+		statuses:  []int{http.StatusOK, http.StatusNotFound, http.StatusNotAcceptable},
+	},
+	"GET /trash": {
+		summary:   "List deleted recipes",
+		protected: true,
+		paging:    true,
+		responses: contentOf([]*Recipe{}, defaultResponseFormats),
+		statuses:  []int{http.StatusOK, http.StatusNotFound, http.StatusNotAcceptable},
+	},
+	"GET /recipes/:id/revisions": {
+		summary:   "List revisions of a recipe",
+		protected: true,
+		responses: contentOf([]*RecipeRevision{}, defaultResponseFormats),
+		statuses:  []int{http.StatusOK, http.StatusNotFound, http.StatusNotAcceptable},
+	},
+	"GET /recipes/:id/revisions/:rev": {
+		summary:   "Get a revision of a recipe",
+		protected: true,
+		responses: contentOf(&RecipeRevision{}, defaultResponseFormats),
+		statuses:  []int{http.StatusOK, http.StatusNotFound, http.StatusNotAcceptable},
+	},
+	"POST /recipes/:id/revisions/:rev/restore": {
+		summary:   "Restore a revision of a recipe",
+		protected: true,
+		responses: contentOf(&Recipe{}, defaultResponseFormats),
+		statuses:  []int{http.StatusOK, http.StatusNotFound, http.StatusNotAcceptable},
+	},
+	"GET /recipes/:id/diff": {
+		summary:   "Compare revisions of a recipe",
+		protected: true,
+		query:     &RevisionDiffArg{},
+		responses: contentOf(&RecipeRevisionDiff{}, defaultResponseFormats),
+		statuses:  []int{http.StatusOK, http.StatusBadRequest, http.StatusNotFound, http.StatusNotAcceptable},
+	},
+	"GET /recipes/:id/live": {
+		summary:   "Cook a recipe live with others over a WebSocket",
+		protected: true,
+		responses: contentOf(nil, nil),
+		statuses:  []int{http.StatusSwitchingProtocols, http.StatusForbidden, http.StatusNotFound, http.StatusUpgradeRequired},
+	},
+	"GET /export/recipes": {
+		summary:   "Export recipes",
+		protected: true,
+		responses: contentOf(&RecipeExport{}, nil, ndjsonContentType),
+		statuses:  []int{http.StatusOK, http.StatusNotFound, http.StatusNotAcceptable},
+	},
+	"POST /import/recipes": {
+		summary:   "Import recipes",
+		protected: true,
+		query:     &ImportRecipesArg{},
+		requests:  contentOf(&RecipeExport{}, nil, ndjsonContentType),
+		responses: contentOf(&RecipeImportReport{}, defaultResponseFormats),
+		statuses:  []int{http.StatusOK, http.StatusBadRequest, http.StatusNotFound, http.StatusNotAcceptable},
+	},
+	"GET /webhooks": {
+		summary:   "List webhooks",
+		protected: true,
+		responses: contentOf([]*Webhook{}, defaultResponseFormats),
+		statuses:  []int{http.StatusOK, http.StatusNotFound, http.StatusNotAcceptable},
+	},
+	"POST /webhooks": {
+		summary:   "Register a webhook",
+		protected: true,
+		requests:  contentOf(&PostWebhookArg{}, defaultResponseFormats),
+		responses: contentOf(&Webhook{}, defaultResponseFormats),
+		statuses:  []int{http.StatusOK, http.StatusBadRequest, http.StatusNotFound, http.StatusNotAcceptable, http.StatusUnsupportedMediaType, http.StatusUnprocessableEntity},
+	},
+	"DELETE /webhooks/:id": {
+		summary:   "Remove a webhook",
+		protected: true,
+		responses: contentOf(&Webhook{}, defaultResponseFormats),
+		statuses:  []int{http.StatusOK, http.StatusNotFound, http.StatusNotAcceptable},
+	},
+	"GET /webhooks/:id/deliveries": {
+		summary:   "List deliveries of a webhook",
+		protected: true,
+		paging:    true,
+		query:     &WebhookDeliveryFilter{},
+		responses: contentOf([]*WebhookDelivery{}, defaultResponseFormats),
+		statuses:  []int{http.StatusOK, http.StatusBadRequest, http.StatusNotFound, http.StatusNotAcceptable},
+	},
+	"POST /webhooks/:id/deliveries/:delivery/redeliver": {
+		summary:   "Deliver a webhook delivery again",
+		protected: true,
+		responses: contentOf(&WebhookDelivery{}, defaultResponseFormats),
+		statuses:  []int{http.StatusAccepted, http.StatusNotFound, http.StatusNotAcceptable},
+	},
+	"GET /events": {
+		summary:   "Stream recipe events",
+		headers:   []string{"Last-Event-ID"},
+		query:     &EventFilter{},
+		responses: contentOf("", nil, eventStreamContentType),
+		statuses:  []int{http.StatusOK, http.StatusBadRequest, http.StatusNotAcceptable},
+	},
+	"POST /graphql": {
+		summary:   "Query and change recipes with GraphQL",
+		requests:  contentOf(&GraphQLRequest{}, nil, gin.MIMEJSON),
+		responses: contentOf(&GraphQLResponse{}, nil, gin.MIMEJSON),
+		statuses:  []int{http.StatusOK, http.StatusBadRequest, http.StatusNotAcceptable, http.StatusUnsupportedMediaType, http.StatusInternalServerError},
+	},
+	"GET /openapi.json": {
+		summary:   "Get the OpenAPI document of the API",
+		responses: contentOf(nil, nil, gin.MIMEJSON),
+		statuses:  []int{http.StatusOK, http.StatusNotAcceptable},
+	},
+	"GET /docs": {
+		summary:   "Browse the documentation of the API",
+		responses: contentOf("", nil, htmlContentType),
+		statuses:  []int{http.StatusOK, http.StatusNotAcceptable},
+	},
+	"GET /metrics": {
+		summary:   "Scrape the metrics of the service in the Prometheus text format",
+		responses: contentOf("", nil, metricsContentType),
+		statuses:  []int{http.StatusOK, http.StatusNotAcceptable},
+	},
+	"GET /healthz": {
+		summary:   "Check that the service is alive",
+		responses: contentOf(&HealthStatus{}, nil, gin.MIMEJSON),
+		statuses:  []int{http.StatusOK, http.StatusNotAcceptable},
+	},
+	"GET /readyz": {
+		summary:   "Check that the service is ready to take requests",
+		responses: contentOf(&ReadinessStatus{}, nil, gin.MIMEJSON),
+		statuses:  []int{http.StatusOK, http.StatusNotAcceptable, http.StatusServiceUnavailable},
+	},
+	"GET /status": {
+		summary:   "Get the detailed status of the service",
+		protected: true,
+		responses: contentOf(&ServiceStatus{}, nil, gin.MIMEJSON),
+		statuses:  []int{http.StatusOK, http.StatusNotFound, http.StatusNotAcceptable},
+	},
+}
+
+func contentOf(v interface{}, mediaTypes []string, extraMediaTypes ...string) map[string]interface{} {
+	res := make(map[string]interface{})
+	for _, mediaType := range append(append([]string{}, mediaTypes...), extraMediaTypes...) {
+		res[mediaType] = v
+	}
+	return res
+}
+
+func versionedContent(version int, content map[string]interface{}) map[string]interface{} {
+	res := make(map[string]interface{})
+	for mediaType, v := range content {
+		res[mediaType] = versionedResponse(version, v)
+	}
+	return res
+}
+
+func mergeContent(contents ...map[string]interface{}) map[string]interface{} {
+	res := make(map[string]interface{})
+	for _, content := range contents {
+		for mediaType, v := range content {
+			res[mediaType] = v
+		}
+	}
+	return res
+}
+
+type openAPIDocument struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       *openAPIInfo                            `json:"info"`
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components *openAPIComponents                      `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type openAPIComponents struct {
+	Schemas         map[string]*openAPISchema         `json:"schemas"`
+	SecuritySchemes map[string]*openAPISecurityScheme `json:"securitySchemes"`
+}
+
+type openAPISecurityScheme struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+	In   string `json:"in"`
+}
+
+type openAPIOperation struct {
+	OperationID string                      `json:"operationId"`
+	Summary     string                      `json:"summary,omitempty"`
+	Parameters  []*openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*openAPIResponse `json:"responses"`
+	Security    []map[string][]string       `json:"security,omitempty"`
+}
+
+type openAPIParameter struct {
+	Name     string         `json:"name"`
+	In       string         `json:"in"`
+	Required bool           `json:"required,omitempty"`
+	Schema   *openAPISchema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                         `json:"required"`
+	Content  map[string]*openAPIMediaType `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                       `json:"description"`
+	Content     map[string]*openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIMediaType struct {
+	Schema *openAPISchema `json:"schema"`
+}
+
+type openAPISchema struct {
+	Ref              string                    `json:"$ref,omitempty"`
+	Type             string                    `json:"type,omitempty"`
+	Format           string                    `json:"format,omitempty"`
+	Nullable         bool                      `json:"nullable,omitempty"`
+	Enum             []string                  `json:"enum,omitempty"`
+	Minimum          *float64                  `json:"minimum,omitempty"`
+	ExclusiveMinimum bool                      `json:"exclusiveMinimum,omitempty"`
+	Maximum          *float64                  `json:"maximum,omitempty"`
+	ExclusiveMaximum bool                      `json:"exclusiveMaximum,omitempty"`
+	MinLength        *int                      `json:"minLength,omitempty"`
+	MaxLength        *int                      `json:"maxLength,omitempty"`
+	MinItems         *int                      `json:"minItems,omitempty"`
+	MaxItems         *int                      `json:"maxItems,omitempty"`
+	Items            *openAPISchema            `json:"items,omitempty"`
+	Properties       map[string]*openAPISchema `json:"properties,omitempty"`
+	Required         []string                  `json:"required,omitempty"`
+	AllOf            []*openAPISchema          `json:"allOf,omitempty"`
+	SkipValidation   bool                      `json:"x-skip-validation,omitempty"`
+}
+
+// newOpenAPIDocument describes the routes with apiOperations. A route that
+// isn't documented is still listed, with a default response only.
+func newOpenAPIDocument(routes gin.RoutesInfo) *openAPIDocument {
+	g := &openAPIGenerator{schemas: make(map[string]*openAPISchema)}
+	doc := &openAPIDocument{
+		OpenAPI: openAPIVersion,
+		Info:    &openAPIInfo{Title: apiTitle, Version: apiDocumentVersion},
+		Paths:   make(map[string]map[string]*openAPIOperation),
+	}
+	for _, route := range routes {
+		path := routeParam.ReplaceAllString(route.Path, "/{$1}")
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = make(map[string]*openAPIOperation)
+		}
+		doc.Paths[path][strings.ToLower(route.Method)] = g.operation(route.Method, route.Path)
+	}
+	doc.Components = &openAPIComponents{
+		Schemas: g.schemas,
+		SecuritySchemes: map[string]*openAPISecurityScheme{
+			accessTokenScheme: {Type: "apiKey", Name: "Authorization", In: "header"},
+		},
+	}
+	return doc
+}
+
+type openAPIGenerator struct {
+	schemas map[string]*openAPISchema
+}
+
+func (g *openAPIGenerator) operation(method, path string) *openAPIOperation {
+	op := &openAPIOperation{
+		OperationID: operationID(method, path),
+		Parameters:  make([]*openAPIParameter, 0),
+		Responses:   make(map[string]*openAPIResponse),
+	}
+	for _, m := range routeParam.FindAllStringSubmatch(path, -1) {
+		op.Parameters = append(op.Parameters, &openAPIParameter{Name: m[1], In: "path", Required: true, Schema: &openAPISchema{Type: "integer"}})
+	}
+	if !isIdempotentMethod(method) {
+		maxLength := maxIdempotencyKeyLength
+		op.Parameters = append(op.Parameters, &openAPIParameter{Name: "Idempotency-Key", In: "header", Schema: &openAPISchema{Type: "string", MaxLength: &maxLength}})
+	}
+
+	unversioned, version := unversionedPath(path)
+	if version == 0 {
+		version = defaultAPIVersion
+		minimum, maximum := 1.0, float64(latestAPIVersion)
+		op.Parameters = append(op.Parameters, &openAPIParameter{Name: apiVersionHeader, In: "header", Schema: &openAPISchema{Type: "integer", Minimum: &minimum, Maximum: &maximum}})
+	}
+
+	doc, ok := apiOperations[method+" "+unversioned]
+	if !ok {
+		op.Responses["default"] = &openAPIResponse{Description: "Undocumented"}
+		return op
+	}
+	op.Summary = doc.summary
+	if doc.protected {
+		op.Security = []map[string][]string{{accessTokenScheme: {}}}
+	}
+	if doc.paging {
+		minimum := 1.0
+		for _, header := range []string{"page-size", "page-number"} {
+			op.Parameters = append(op.Parameters, &openAPIParameter{Name: header, In: "header", Schema: &openAPISchema{Type: "integer", Minimum: &minimum}})
+		}
+	}
+	for _, header := range doc.headers {
+		op.Parameters = append(op.Parameters, &openAPIParameter{Name: header, In: "header", Schema: &openAPISchema{Type: "string"}})
+	}
+	if doc.query != nil {
+		op.Parameters = append(op.Parameters, g.queryParameters(reflect.TypeOf(doc.query).Elem())...)
+	}
+	if len(doc.requests) > 0 {
+		op.RequestBody = &openAPIRequestBody{Required: true, Content: g.content(doc.requests)}
+		op.Responses[strconv.Itoa(http.StatusRequestEntityTooLarge)] = &openAPIResponse{Description: http.StatusText(http.StatusRequestEntityTooLarge)}
+	}
+	for _, status := range doc.statuses {
+		res := &openAPIResponse{Description: http.StatusText(status)}
+		if status == http.StatusOK {
+			res.Content = g.content(versionedContent(version, doc.responses))
+		}
+		op.Responses[strconv.Itoa(status)] = res
+	}
+	return op
+}
+
+func (g *openAPIGenerator) content(bodies map[string]interface{}) map[string]*openAPIMediaType {
+	res := make(map[string]*openAPIMediaType)
+	for mediaType, v := range bodies {
+		schema := &openAPISchema{}
+		if v != nil {
+			schema = g.schemaOf(reflect.TypeOf(v))
+		}
+		res[mediaType] = &openAPIMediaType{Schema: schema}
+	}
+	return res
+}
+
+func (g *openAPIGenerator) queryParameters(t reflect.Type) []*openAPIParameter {
+	res := make([]*openAPIParameter, 0)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := f.Tag.Get("form")
+		if name == "" || name == "-" {
+			continue
+		}
+		schema := g.schemaOf(f.Type)
+		required := applyValidateTag(schema, f.Tag.Get("validate"))
+		res = append(res, &openAPIParameter{Name: name, In: "query", Required: required, Schema: schema})
+	}
+	return res
+}
+
+// schemaOf describes a type the way encoding/json marshals it. Named
+// structs are described once in the components and referred to.
+func (g *openAPIGenerator) schemaOf(t reflect.Type) *openAPISchema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t {
+	case nullStringType:
+		return &openAPISchema{Type: "string", Nullable: true}
+	case nullIntType:
+		return &openAPISchema{Type: "integer", Format: "int64", Nullable: true}
+	case nullFloatType:
+		return &openAPISchema{Type: "number", Format: "double", Nullable: true}
+	case nullBoolType:
+		return &openAPISchema{Type: "boolean", Nullable: true}
+	case nullTimeType:
+		return &openAPISchema{Type: "string", Format: "date-time", Nullable: true}
+	case timeType:
+		return &openAPISchema{Type: "string", Format: "date-time"}
+	case rawMessageType:
+		return &openAPISchema{}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &openAPISchema{Type: "string"}
+	case reflect.Bool:
+		return &openAPISchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &openAPISchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &openAPISchema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &openAPISchema{Type: "array", Items: g.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &openAPISchema{Type: "object"}
+	case reflect.Struct:
+		if _, ok := g.schemas[t.Name()]; !ok {
+			schema := &openAPISchema{Type: "object", Properties: make(map[string]*openAPISchema)}
+			g.schemas[t.Name()] = schema
+			g.addProperties(schema, t)
+		}
+		return &openAPISchema{Ref: openAPISchemaRef + t.Name()}
+	}
+	return &openAPISchema{}
+}
+
+func (g *openAPIGenerator) addProperties(schema *openAPISchema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if f.Anonymous && tag == "" {
+			g.addProperties(schema, f.Type)
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if f.PkgPath != "" || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		property := g.schemaOf(f.Type)
+		// A field the validator skips is checked by the operation itself,
+		// so the request validation is told to skip it too.
+		if f.Tag.Get("validate") == "-" {
+			property = &openAPISchema{AllOf: []*openAPISchema{property}, SkipValidation: true}
+		}
+		if applyValidateTag(property, f.Tag.Get("validate")) {
+			schema.Required = append(schema.Required, name)
+		}
+		schema.Properties[name] = property
+	}
+}
+
+// applyValidateTag maps the validator constraints of a field to its schema,
+// and tells whether the field is required. The constraints after "dive"
+// apply to the items.
+func applyValidateTag(schema *openAPISchema, tag string) (required bool) {
+	rules := strings.Split(tag, ",")
+	for i, rule := range rules {
+		kv := strings.SplitN(rule, "=", 2)
+		switch kv[0] {
+		case "dive":
+			if schema.Items != nil {
+				applyValidateTag(schema.Items, strings.Join(rules[i+1:], ","))
+			}
+			return
+		case "url":
+			schema.Format = "uri"
+		case "required":
+			required = true
+			schema.Nullable = false
+		case "oneof":
+			schema.Enum = strings.Fields(kv[1])
+		case "min", "gte":
+			schema.setBound(kv[1], false, false)
+		case "gt":
+			schema.setBound(kv[1], false, true)
+		case "max", "lte":
+			schema.setBound(kv[1], true, false)
+		case "lt":
+			schema.setBound(kv[1], true, true)
+		}
+	}
+	return
+}
+
+// setBound sets a lower or upper bound on the value of a number, or on the
+// length of a string or an array, like the validator's "min" and "max".
+func (s *openAPISchema) setBound(param string, upper bool, exclusive bool) {
+	n, err := strconv.ParseFloat(param, 64)
+	if err != nil {
+		return
+	}
+	if s.Type == "string" || s.Type == "array" {
+		length := int(n)
+		switch {
+		case exclusive && upper:
+			length--
+		case exclusive:
+			length++
+		}
+		switch {
+		case s.Type == "string" && upper:
+			s.MaxLength = &length
+		case s.Type == "string":
+			s.MinLength = &length
+		case upper:
+			s.MaxItems = &length
+		default:
+			s.MinItems = &length
+		}
+		return
+	}
+	if upper {
+		s.Maximum, s.ExclusiveMaximum = &n, exclusive
+		return
+	}
+	s.Minimum, s.ExclusiveMinimum = &n, exclusive
+}
+
+// operationID names an operation after its method and path, like
+// "getRecipesIdRevisions" for "GET /recipes/:id/revisions".
+func operationID(method, path string) string {
+	words := strings.FieldsFunc(path, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	res := strings.ToLower(method)
+	for _, w := range words {
+		res += strings.ToUpper(w[:1]) + w[1:]
+	}
+	return res
+}
+
+const apiDocsPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>` + apiTitle + `</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+<script>
+window.onload = function() {
+	window.ui = SwaggerUIBundle({url: "/openapi.json", dom_id: "#swagger-ui"});
+};
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenAPISchemaOf(t *testing.T) {
+	g := &openAPIGenerator{schemas: make(map[string]*openAPISchema)}
+	assert.Equal(t, &openAPISchema{Type: "array", Items: &openAPISchema{Ref: "#/components/schemas/Recipe"}}, g.schemaOf(reflect.TypeOf([]*Recipe{})))
+	assert.NotContains(t, g.schemas["Recipe"].Properties, "Version")
+	assert.Equal(t, &openAPISchema{Type: "integer", Format: "int64", Nullable: true}, g.schemas["Recipe"].Properties["prepare_time"])
+
+	g.schemaOf(reflect.TypeOf(&RecipeExport{}))
+	assert.Contains(t, g.schemas["RecipeExport"].Properties, "id")
+	assert.Contains(t, g.schemas["RecipeExport"].Properties, "owner")
+
+	g.schemaOf(reflect.TypeOf(&PostRecipeArg{}))
+	one, three := 1.0, 3.0
+	zero, minLength := 0.0, 1
+	assert.Equal(t, []string{"name", "is_vegetarian"}, g.schemas["PostRecipeArg"].Required)
+	assert.Equal(t, &openAPISchema{Type: "string", MinLength: &minLength}, g.schemas["PostRecipeArg"].Properties["name"])
+	assert.Equal(t, &openAPISchema{Type: "integer", Format: "int64", Nullable: true, Minimum: &zero, ExclusiveMinimum: true}, g.schemas["PostRecipeArg"].Properties["prepare_time"])
+	assert.Equal(t, &openAPISchema{Type: "integer", Format: "int64", Nullable: true, Minimum: &one, Maximum: &three}, g.schemas["PostRecipeArg"].Properties["difficulty"])
+
+	g.schemaOf(reflect.TypeOf(&RecipeBatchArg{}))
+	minItems, maxItems := 1, 100
+	assert.Equal(t, []string{"all_or_nothing", "best_effort"}, g.schemas["RecipeBatchArg"].Properties["mode"].Enum)
+	assert.Equal(t, &minItems, g.schemas["RecipeBatchArg"].Properties["operations"].MinItems)
+	assert.Equal(t, &maxItems, g.schemas["RecipeBatchArg"].Properties["operations"].MaxItems)
+
+	g.schemaOf(reflect.TypeOf(&PostWebhookArg{}))
+	assert.Equal(t, "uri", g.schemas["PostWebhookArg"].Properties["url"].Format)
+	assert.Equal(t, []string{"recipe.created", "recipe.updated", "recipe.deleted", "recipe.rated", "recipe.published", "recipe.unpublished"}, g.schemas["PostWebhookArg"].Properties["events"].Items.Enum)
+}
+
+func TestNewOpenAPIDocument(t *testing.T) {
+	doc := newOpenAPIDocument(gin.RoutesInfo{
+		{Method: "GET", Path: "/recipes/:id/diff"},
+		{Method: "POST", Path: "/recipes:batch"},
+		{Method: "GET", Path: "/undocumented"},
+	})
+
+	op := doc.Paths["/recipes/{id}/diff"]["get"]
+	assert.Equal(t, "getRecipesIdDiff", op.OperationID)
+	assert.Equal(t, []map[string][]string{{"accessToken": {}}}, op.Security)
+	names := make([]string, 0)
+	for _, p := range op.Parameters {
+		names = append(names, p.In+":"+p.Name)
+	}
+	assert.Equal(t, []string{"path:id", "header:API-Version", "query:from", "query:to"}, names)
+	assert.True(t, op.Parameters[2].Required)
+	assert.Contains(t, op.Responses["200"].Content, "application/xml")
+
+	op = doc.Paths["/recipes:batch"]["post"]
+	assert.Equal(t, "Idempotency-Key", op.Parameters[0].Name)
+	assert.Equal(t, "#/components/schemas/RecipeBatchArg", op.RequestBody.Content["application/json"].Schema.Ref)
+	assert.Contains(t, doc.Components.Schemas, "RecipeBatchOperation")
+
+	assert.Contains(t, doc.Paths["/undocumented"]["get"].Responses, "default")
+}
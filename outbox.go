@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	stdjson "encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRelayPollInterval = time.Second
+	recipeEventRelayBatch    = 100
+	recipeEventRetention     = 24 * time.Hour
+	recipeEventReplaySize    = 1000
+
+	eventSinkStdout  = "stdout"
+	eventSinkFile    = "file"
+	eventSinkWebhook = "webhook"
+	eventSinkBus     = "bus"
+)
+
+// eventSink is where the relay publishes the events of the outbox to. A
+// sink may get an event more than once, so it should tell the events apart
+// by their IDs, and it may get the events of different recipes out of the
+// order of their IDs, so it mustn't take the ID of an event for a cursor.
+type eventSink interface {
+	publish(*recipeEvent) error
+}
+
+// recipeEventRelay publishes the events that the datastore writes to its
+// outbox to every sink. An event is only marked as published once all the
+// sinks accept it, and an event that a sink rejects holds back the later
+// events of the same recipe, so every sink gets the events of a recipe at
+// least once and in order. The events of the other recipes go on meanwhile,
+// so the events of different recipes may be published out of order.
+type recipeEventRelay struct {
+	datastore    datastore
+	sinks        []eventSink
+	pollInterval time.Duration
+	quit         chan struct{}
+	done         chan struct{}
+}
+
+func newRecipeEventRelay(ds datastore, sinks []eventSink, pollInterval time.Duration) *recipeEventRelay {
+	return &recipeEventRelay{
+		datastore:    ds,
+		sinks:        sinks,
+		pollInterval: pollInterval,
+		quit:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+}
+
+func (r *recipeEventRelay) run() {
+	defer close(r.done)
+	var backoff workerBackoff
+	for {
+		ok := runWorkerStep("relay", r.relay)
+		timer := time.NewTimer(backoff.next(ok, r.pollInterval))
+		select {
+		case <-r.quit:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+func (r *recipeEventRelay) relay() {
+	for r.datastore.relayRecipeEvents(recipeEventRelayBatch, r.publish) == recipeEventRelayBatch {
+		select {
+		case <-r.quit:
+			return
+		default:
+		}
+	}
+}
+
+func (r *recipeEventRelay) publish(e *recipeEvent) bool {
+	for _, s := range r.sinks {
+		if err := publishToSink(s, e); err != nil {
+			logger.error("error publishing recipe event", logFields{"event_id": e.ID, "sink": fmt.Sprintf("%T", s), "error": err})
+			return false
+		}
+	}
+	return true
+}
+
+func publishToSink(s eventSink, e *recipeEvent) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("%v", p)
+		}
+	}()
+	return s.publish(e)
+}
+
+func (r *recipeEventRelay) stop(ctx context.Context) {
+	close(r.quit)
+	select {
+	case <-r.done:
+	case <-ctx.Done():
+	}
+}
+
+// writerEventSink writes every event as a line of JSON.
+type writerEventSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *writerEventSink) publish(e *recipeEvent) error {
+	line, err := stdjson.Marshal(e)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(append(line, '\n'))
+	return err
+}
+
+// fileEventSink appends every event to a newline-delimited JSON file, and
+// syncs it before the event counts as published.
+type fileEventSink struct {
+	writerEventSink
+	file *os.File
+}
+
+func newFileEventSink(path string) *fileEventSink {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		panic(err)
+	}
+	return &fileEventSink{
+		writerEventSink: writerEventSink{w: file},
+		file:            file,
+	}
+}
+
+func (s *fileEventSink) publish(e *recipeEvent) error {
+	if err := s.writerEventSink.publish(e); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+// eventBus hands the events to the subscribers in the same process, and
+// keeps the last replaySize events so that a subscriber can catch up on the
+// events it missed. A subscriber that doesn't keep up is dropped and its
+// channel closed.
+type eventBus struct {
+	mu          sync.Mutex
+	subscribers map[chan *busEvent]struct{}
+	replay      []*busEvent
+	replaySize  int
+	// seq is the sequence number of the last event published. It starts at
+	// the time the bus was created in microseconds, so that it keeps
+	// increasing across the restarts of the service.
+	seq    int64
+	closed bool
+}
+
+// busEvent is an event with the sequence number that the bus assigned to it
+// when it was published. Unlike the IDs of the events, the sequence numbers
+// follow the order that the events were published in, so the subscribers
+// catch up on the events after the sequence number of the last one they
+// got.
+type busEvent struct {
+	*recipeEvent
+	seq int64
+}
+
+func newEventBus(replaySize int) *eventBus {
+	return &eventBus{
+		subscribers: make(map[chan *busEvent]struct{}),
+		replay:      make([]*busEvent, 0, replaySize),
+		replaySize:  replaySize,
+		seq:         time.Now().UnixNano() / int64(time.Microsecond),
+	}
+}
+
+// subscribe returns the kept events published after the event lastSeq, and a
+// channel of the events published from now on.
+func (b *eventBus) subscribe(lastSeq int64, size int) ([]*busEvent, chan *busEvent) {
+	ch := make(chan *busEvent, size)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	missed := make([]*busEvent, 0)
+	if lastSeq > 0 {
+		for _, e := range b.replay {
+			if e.seq > lastSeq {
+				missed = append(missed, e)
+			}
+		}
+	}
+	if b.closed {
+		close(ch)
+	} else {
+		b.subscribers[ch] = struct{}{}
+	}
+	return missed, ch
+}
+
+func (b *eventBus) unsubscribe(ch chan *busEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subscribers[ch]; ok {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+func (b *eventBus) publish(e *recipeEvent) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.seq++
+	be := &busEvent{recipeEvent: e, seq: b.seq}
+	if b.replaySize > 0 {
+		if len(b.replay) == b.replaySize {
+			b.replay = append(b.replay[:0], b.replay[1:]...)
+		}
+		b.replay = append(b.replay, be)
+	}
+	for ch := range b.subscribers {
+		select {
+		case ch <- be:
+		default:
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+	return nil
+}
+
+// close drops all the subscribers, so that the streams fed by the bus end
+// when the server shuts down.
+func (b *eventBus) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for ch := range b.subscribers {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// newEventSinks builds the sinks named by a comma-separated list like
+// "webhook,bus".
+func newEventSinks(names string, file string, webhooks *webhookDispatcher, bus *eventBus) []eventSink {
+	res := make([]eventSink, 0)
+	for _, name := range strings.Split(names, ",") {
+		switch strings.TrimSpace(name) {
+		case "":
+		case eventSinkStdout:
+			res = append(res, &writerEventSink{w: os.Stdout})
+		case eventSinkFile:
+			res = append(res, newFileEventSink(file))
+		case eventSinkWebhook:
+			res = append(res, webhooks)
+		case eventSinkBus:
+			res = append(res, bus)
+		default:
+			panic(fmt.Sprintf("unknown event sink %q", name))
+		}
+	}
+	return res
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type funcEventSink func(*recipeEvent) error
+
+func (f funcEventSink) publish(e *recipeEvent) error {
+	return f(e)
+}
+
+var _ = Describe("Relaying recipe events", func() {
+	It("publishes the events of the outbox to every sink", func() {
+		at := time.Now()
+		md := &mockDatastore{
+			dataFunc: func() interface{} {
+				return []*recipeEvent{
+					{ID: 1, Type: recipeCreatedEvent, RecipeID: 1, Time: at},
+					{ID: 2, Type: recipeRatedEvent, RecipeID: 1, Time: at},
+				}
+			},
+		}
+		bus := newEventBus(recipeEventReplaySize)
+		_, ch := bus.subscribe(0, 16)
+		buf := &bytes.Buffer{}
+		relay := newRecipeEventRelay(md, []eventSink{&writerEventSink{w: buf}, bus}, time.Minute)
+		go relay.run()
+
+		var e *busEvent
+		Eventually(ch).Should(Receive(&e))
+		Expect(e.ID).To(Equal(int64(1)))
+		Eventually(ch).Should(Receive(&e))
+		Expect(e.ID).To(Equal(int64(2)))
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		relay.stop(ctx)
+		Expect(ctx.Err()).To(BeNil())
+		Expect(buf.String()).To(HavePrefix(`{"id":1,"type":"recipe.created","recipe_id":1,`))
+	})
+	It("keeps relaying after a failure", func() {
+		calls := 0
+		md := &mockDatastore{
+			dataFunc: func() interface{} {
+				calls++
+				if calls == 1 {
+					panic("connection refused")
+				}
+				return []*recipeEvent{{ID: 1, Type: recipeCreatedEvent, RecipeID: 1, Time: time.Now()}}
+			},
+		}
+		bus := newEventBus(recipeEventReplaySize)
+		_, ch := bus.subscribe(0, 16)
+		relay := newRecipeEventRelay(md, []eventSink{bus}, 10*time.Millisecond)
+		go relay.run()
+
+		Eventually(ch).Should(Receive())
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		relay.stop(ctx)
+		Expect(ctx.Err()).To(BeNil())
+	})
+	It("rejects an event that a sink fails to publish", func() {
+		published := 0
+		counter := funcEventSink(func(e *recipeEvent) error {
+			published++
+			return nil
+		})
+		failing := funcEventSink(func(e *recipeEvent) error {
+			return errors.New("unavailable")
+		})
+		panicking := funcEventSink(func(e *recipeEvent) error {
+			panic("broken")
+		})
+
+		e := &recipeEvent{ID: 1, Type: recipeCreatedEvent, RecipeID: 1}
+		Expect(newRecipeEventRelay(&mockDatastore{}, []eventSink{counter, counter}, time.Minute).publish(e)).To(BeTrue())
+		Expect(published).To(Equal(2))
+		Expect(newRecipeEventRelay(&mockDatastore{}, []eventSink{failing, counter}, time.Minute).publish(e)).To(BeFalse())
+		Expect(newRecipeEventRelay(&mockDatastore{}, []eventSink{panicking, counter}, time.Minute).publish(e)).To(BeFalse())
+		Expect(published).To(Equal(2))
+	})
+})
+
+var _ = Describe("Publishing recipe events to sinks", func() {
+	It("appends the events to a file", func() {
+		dir, err := ioutil.TempDir("", "events")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "events.ndjson")
+		sink := newFileEventSink(path)
+		Expect(sink.publish(&recipeEvent{ID: 1, Type: recipeCreatedEvent, RecipeID: 1})).To(Succeed())
+		Expect(sink.publish(&recipeEvent{ID: 2, Type: recipeDeletedEvent, RecipeID: 1})).To(Succeed())
+
+		content, err := ioutil.ReadFile(path)
+		Expect(err).To(BeNil())
+		lines := bytes.Split(bytes.TrimSpace(content), []byte("\n"))
+		Expect(lines).To(HaveLen(2))
+		Expect(string(lines[1])).To(ContainSubstring(`"type":"recipe.deleted"`))
+	})
+	It("drops the subscribers of the bus that don't keep up", func() {
+		bus := newEventBus(recipeEventReplaySize)
+		_, slow := bus.subscribe(0, 1)
+		_, fast := bus.subscribe(0, 2)
+		for id := int64(1); id <= 2; id++ {
+			Expect(bus.publish(&recipeEvent{ID: id})).To(Succeed())
+		}
+		Expect((<-slow).recipeEvent).To(Equal(&recipeEvent{ID: 1}))
+		Expect(slow).To(BeClosed())
+		Expect(fast).To(HaveLen(2))
+
+		bus.unsubscribe(fast)
+		Expect(bus.publish(&recipeEvent{ID: 3})).To(Succeed())
+		Expect(fast).To(HaveLen(2))
+		bus.close()
+		_, late := bus.subscribe(0, 1)
+		Expect(late).To(BeClosed())
+	})
+	It("replays the kept events after the last one a subscriber got", func() {
+		bus := newEventBus(3)
+		bus.seq = 0
+		for id := int64(1); id <= 5; id++ {
+			Expect(bus.publish(&recipeEvent{ID: id})).To(Succeed())
+		}
+		missed, _ := bus.subscribe(3, 1)
+		Expect(missed).To(Equal([]*busEvent{{&recipeEvent{ID: 4}, 4}, {&recipeEvent{ID: 5}, 5}}))
+		missed, _ = bus.subscribe(1, 1)
+		Expect(missed).To(HaveLen(3))
+		Expect(missed[0].ID).To(Equal(int64(3)))
+		missed, _ = bus.subscribe(0, 1)
+		Expect(missed).To(BeEmpty())
+	})
+	It("numbers the events in the order they are published rather than by their IDs", func() {
+		bus := newEventBus(recipeEventReplaySize)
+		Expect(bus.seq).To(BeNumerically("~", time.Now().UnixNano()/int64(time.Microsecond), int64(time.Minute/time.Microsecond)))
+		Expect(bus.publish(&recipeEvent{ID: 3})).To(Succeed())
+		Expect(bus.publish(&recipeEvent{ID: 2})).To(Succeed())
+		missed, _ := bus.subscribe(bus.seq-2, 1)
+		Expect(missed).To(HaveLen(2))
+		Expect(missed[0].ID).To(Equal(int64(3)))
+		Expect(missed[1].ID).To(Equal(int64(2)))
+		Expect(missed[1].seq).To(Equal(missed[0].seq + 1))
+	})
+	It("builds the sinks by their names", func() {
+		webhooks := newWebhookDispatcher(&mockDatastore{}, defaultWebhookAttempts, time.Minute)
+		bus := newEventBus(recipeEventReplaySize)
+		sinks := newEventSinks(" webhook, bus ,stdout", "", webhooks, bus)
+		Expect(sinks).To(HaveLen(3))
+		Expect(sinks[0]).To(BeIdenticalTo(webhooks))
+		Expect(sinks[1]).To(BeIdenticalTo(bus))
+		Expect(newEventSinks("", "", webhooks, bus)).To(BeEmpty())
+		Expect(func() { newEventSinks("kafka", "", webhooks, bus) }).To(Panic())
+	})
+})
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	stdjson "encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	mergePatchContentType = "application/merge-patch+json"
+	jsonPatchContentType  = "application/json-patch+json"
+)
+
+var (
+	errMalformedPatch  = errors.New("malformed patch document")
+	errPatchTestFailed = errors.New("patch test operation failed")
+)
+
+type patchFunc func(doc []byte, patch []byte) ([]byte, error)
+
+func patchFuncByContentType(contentType string) patchFunc {
+	switch contentType {
+	case mergePatchContentType:
+		return applyMergePatch
+	case jsonPatchContentType:
+		return applyJSONPatch
+	}
+	return nil
+}
+
+func patchRecipeArg(arg *PutRecipeArg, patch []byte, apply patchFunc) (*PutRecipeArg, error) {
+	doc, err := stdjson.Marshal(arg)
+	if err != nil {
+		panic(err)
+	}
+	patched, err := apply(doc, patch)
+	if err != nil {
+		return nil, err
+	}
+	res := &PutRecipeArg{}
+	decoder := stdjson.NewDecoder(bytes.NewReader(patched))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func applyMergePatch(doc []byte, patch []byte) ([]byte, error) {
+	var target, patchObj interface{}
+	if err := stdjson.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+	if err := stdjson.Unmarshal(patch, &patchObj); err != nil {
+		return nil, errMalformedPatch
+	}
+	return stdjson.Marshal(mergePatch(target, patchObj))
+}
+
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObj, ok := target.(map[string]interface{})
+	if !ok {
+		targetObj = make(map[string]interface{})
+	}
+	for k, v := range patchObj {
+		if v == nil {
+			delete(targetObj, k)
+			continue
+		}
+		targetObj[k] = mergePatch(targetObj[k], v)
+	}
+	return targetObj
+}
+
+type jsonPatchOperation struct {
+	Op    string             `json:"op"`
+	Path  *string            `json:"path"`
+	From  *string            `json:"from"`
+	Value stdjson.RawMessage `json:"value"`
+}
+
+func applyJSONPatch(doc []byte, patch []byte) ([]byte, error) {
+	var target interface{}
+	if err := stdjson.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+	var ops []*jsonPatchOperation
+	if err := stdjson.Unmarshal(patch, &ops); err != nil {
+		return nil, errMalformedPatch
+	}
+	for _, op := range ops {
+		var err error
+		if target, err = op.apply(target); err != nil {
+			return nil, err
+		}
+	}
+	return stdjson.Marshal(target)
+}
+
+func (op *jsonPatchOperation) apply(doc interface{}) (interface{}, error) {
+	if op == nil || op.Path == nil {
+		return nil, errMalformedPatch
+	}
+	path, err := parseJSONPointer(*op.Path)
+	if err != nil {
+		return nil, err
+	}
+	switch op.Op {
+	case "add", "replace", "test":
+		value, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "add" {
+			return jsonPointerAdd(doc, path, value)
+		}
+		current, err := jsonPointerGet(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "test" {
+			if !reflect.DeepEqual(current, value) {
+				return nil, errPatchTestFailed
+			}
+			return doc, nil
+		}
+		if doc, err = jsonPointerRemove(doc, path); err != nil {
+			return nil, err
+		}
+		return jsonPointerAdd(doc, path, value)
+	case "remove":
+		return jsonPointerRemove(doc, path)
+	case "move", "copy":
+		if op.From == nil {
+			return nil, errMalformedPatch
+		}
+		from, err := parseJSONPointer(*op.From)
+		if err != nil {
+			return nil, err
+		}
+		value, err := jsonPointerGet(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "copy" {
+			return jsonPointerAdd(doc, path, deepCopyJSON(value))
+		}
+		if len(from) < len(path) && reflect.DeepEqual(from, path[:len(from)]) {
+			return nil, fmt.Errorf("cannot move %q into its own child %q", *op.From, *op.Path)
+		}
+		if doc, err = jsonPointerRemove(doc, from); err != nil {
+			return nil, err
+		}
+		return jsonPointerAdd(doc, path, value)
+	}
+	return nil, errMalformedPatch
+}
+
+func (op *jsonPatchOperation) value() (interface{}, error) {
+	if len(op.Value) == 0 {
+		return nil, errMalformedPatch
+	}
+	var value interface{}
+	if err := stdjson.Unmarshal(op.Value, &value); err != nil {
+		return nil, errMalformedPatch
+	}
+	return value, nil
+}
+
+func deepCopyJSON(value interface{}) interface{} {
+	b, err := stdjson.Marshal(value)
+	if err != nil {
+		panic(err)
+	}
+	var res interface{}
+	if err := stdjson.Unmarshal(b, &res); err != nil {
+		panic(err)
+	}
+	return res
+}
+
+func parseJSONPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, errMalformedPatch
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+func jsonPointerGet(doc interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			v, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("path %q doesn't exist", token)
+			}
+			doc = v
+		case []interface{}:
+			i, err := jsonArrayIndex(token, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[i]
+		default:
+			return nil, fmt.Errorf("path %q doesn't exist", token)
+		}
+	}
+	return doc, nil
+}
+
+func jsonPointerAdd(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	return jsonPointerUpdate(doc, path, func(parent interface{}, token string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			node[token] = value
+			return node, nil
+		case []interface{}:
+			if token == "-" {
+				return append(node, value), nil
+			}
+			i, err := jsonArrayIndex(token, len(node))
+			if err != nil {
+				return nil, err
+			}
+			node = append(node, nil)
+			copy(node[i+1:], node[i:])
+			node[i] = value
+			return node, nil
+		}
+		return nil, fmt.Errorf("path %q doesn't exist", token)
+	}, value)
+}
+
+func jsonPointerRemove(doc interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, errors.New("cannot remove the whole document")
+	}
+	return jsonPointerUpdate(doc, path, func(parent interface{}, token string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			if _, ok := node[token]; !ok {
+				return nil, fmt.Errorf("path %q doesn't exist", token)
+			}
+			delete(node, token)
+			return node, nil
+		case []interface{}:
+			i, err := jsonArrayIndex(token, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+			return append(node[:i], node[i+1:]...), nil
+		}
+		return nil, fmt.Errorf("path %q doesn't exist", token)
+	}, nil)
+}
+
+func jsonPointerUpdate(doc interface{}, path []string, update func(interface{}, string) (interface{}, error), whole interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return whole, nil
+	}
+	if len(path) == 1 {
+		return update(doc, path[0])
+	}
+	child, err := jsonPointerGet(doc, path[:1])
+	if err != nil {
+		return nil, err
+	}
+	if child, err = jsonPointerUpdate(child, path[1:], update, whole); err != nil {
+		return nil, err
+	}
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		node[path[0]] = child
+	case []interface{}:
+		i, _ := jsonArrayIndex(path[0], len(node)-1)
+		node[i] = child
+	}
+	return doc, nil
+}
+
+func jsonArrayIndex(token string, max int) (int, error) {
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i > max || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	return i, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyMergePatch(t *testing.T) {
+	testCases := []struct {
+		doc      string
+		patch    string
+		expected string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`{"a":"foo"}`, `["c"]`, `["c"]`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for i, v := range testCases {
+		actual, err := applyMergePatch([]byte(v.doc), []byte(v.patch))
+		if assert.NoError(t, err, "Case [%d]: %#v", i, v) {
+			assert.JSONEq(t, v.expected, string(actual), "Case [%d]: %#v", i, v)
+		}
+	}
+
+	_, err := applyMergePatch([]byte(`{}`), []byte(`{"a":1,}`))
+	assert.Equal(t, errMalformedPatch, err)
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	testCases := []struct {
+		doc      string
+		patch    string
+		expected string
+	}{
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"foo":"bar","baz":"qux"}`},
+		{`{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+		{`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":"qux"}]`, `{"foo":["bar","qux"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+		{`{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":null}]`, `{"baz":null,"foo":"bar"}`},
+		{`{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`, `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{`{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`},
+		{`{"foo":{"bar":1}}`, `[{"op":"copy","from":"/foo","path":"/baz"}]`, `{"foo":{"bar":1},"baz":{"bar":1}}`},
+		{`{"baz":"qux","foo":["a",2,"c"]}`, `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`, `{"baz":"qux","foo":["a",2,"c"]}`},
+		{`{"a/b":1,"m~n":2}`, `[{"op":"replace","path":"/a~1b","value":3},{"op":"remove","path":"/m~0n"}]`, `{"a/b":3}`},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"","value":{"baz":1}}]`, `{"baz":1}`},
+	}
+	for i, v := range testCases {
+		actual, err := applyJSONPatch([]byte(v.doc), []byte(v.patch))
+		if assert.NoError(t, err, "Case [%d]: %#v", i, v) {
+			assert.JSONEq(t, v.expected, string(actual), "Case [%d]: %#v", i, v)
+		}
+	}
+
+	testErrorCases := []struct {
+		doc      string
+		patch    string
+		expected error
+	}{
+		{`{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`, errPatchTestFailed},
+		{`{"baz":"qux"}`, `[{"op":"replace","path":"/baz"}]`, errMalformedPatch},
+		{`{"baz":"qux"}`, `[{"op":"unknown","path":"/baz"}]`, errMalformedPatch},
+		{`{"baz":"qux"}`, `[{"op":"remove"}]`, errMalformedPatch},
+		{`{"baz":"qux"}`, `[{"op":"remove","path":"baz"}]`, errMalformedPatch},
+		{`{"baz":"qux"}`, `{"op":"remove","path":"/baz"}`, errMalformedPatch},
+	}
+	for i, v := range testErrorCases {
+		_, err := applyJSONPatch([]byte(v.doc), []byte(v.patch))
+		assert.Equal(t, v.expected, err, "Case [%d]: %#v", i, v)
+	}
+
+	testFailureCases := []struct {
+		doc   string
+		patch string
+	}{
+		{`{"baz":"qux"}`, `[{"op":"remove","path":"/foo"}]`},
+		{`{"baz":"qux"}`, `[{"op":"replace","path":"/foo","value":1}]`},
+		{`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/2","value":1}]`},
+		{`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/01","value":1}]`},
+		{`{"foo":{"bar":1}}`, `[{"op":"move","from":"/foo","path":"/foo/bar/baz"}]`},
+	}
+	for i, v := range testFailureCases {
+		_, err := applyJSONPatch([]byte(v.doc), []byte(v.patch))
+		assert.Error(t, err, "Case [%d]: %#v", i, v)
+	}
+}
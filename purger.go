@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+const defaultRetentionPurgeInterval = time.Hour
+
+// retentionPurger deletes what the service keeps only for a while: the
+// recipes in the trash, the published recipe events and the expired
+// idempotency keys. The purges run one after another, so that one failing
+// doesn't hold back the others.
+type retentionPurger struct {
+	datastore      datastore
+	trashRetention time.Duration
+	eventRetention time.Duration
+	idempotencyTTL time.Duration
+	interval       time.Duration
+	quit           chan struct{}
+	done           chan struct{}
+}
+
+func newRetentionPurger(ds datastore, trashRetention, idempotencyTTL, interval time.Duration) *retentionPurger {
+	return &retentionPurger{
+		datastore:      ds,
+		trashRetention: trashRetention,
+		eventRetention: recipeEventRetention,
+		idempotencyTTL: idempotencyTTL,
+		interval:       interval,
+		quit:           make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+}
+
+func (p *retentionPurger) run() {
+	defer close(p.done)
+	var backoff workerBackoff
+	for {
+		ok := runWorkerStep("trash purger", p.purgeTrash)
+		ok = runWorkerStep("event purger", p.purgeEvents) && ok
+		ok = runWorkerStep("idempotency purger", p.purgeIdempotencyKeys) && ok
+		timer := time.NewTimer(backoff.next(ok, p.interval))
+		select {
+		case <-p.quit:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+func (p *retentionPurger) purgeTrash() {
+	if cnt := p.datastore.purgeDeletedRecipes(time.Now().Add(-p.trashRetention)); cnt > 0 {
+		logger.info("purged deleted recipes", logFields{"count": cnt})
+	}
+}
+
+func (p *retentionPurger) purgeEvents() {
+	if cnt := p.datastore.purgeRecipeEvents(time.Now().Add(-p.eventRetention)); cnt > 0 {
+		logger.info("purged published recipe events", logFields{"count": cnt})
+	}
+}
+
+// purgeIdempotencyKeys does nothing while the idempotency keys are disabled.
+func (p *retentionPurger) purgeIdempotencyKeys() {
+	if p.idempotencyTTL <= 0 {
+		return
+	}
+	if cnt := p.datastore.purgeIdempotencyRecords(time.Now().Add(-p.idempotencyTTL)); cnt > 0 {
+		logger.info("purged expired idempotency keys", logFields{"count": cnt})
+	}
+}
+
+func (p *retentionPurger) stop(ctx context.Context) {
+	close(p.quit)
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// purgerTestDatastore fails to purge the trash, and reports the times it's
+// asked to purge the events and the idempotency keys before.
+type purgerTestDatastore struct {
+	*mockDatastore
+	events          chan time.Time
+	idempotencyKeys chan time.Time
+}
+
+func (d *purgerTestDatastore) purgeDeletedRecipes(deletedBefore time.Time) int64 {
+	panic("connection refused")
+}
+
+func (d *purgerTestDatastore) purgeRecipeEvents(publishedBefore time.Time) int64 {
+	select {
+	case d.events <- publishedBefore:
+	default:
+	}
+	return 0
+}
+
+func (d *purgerTestDatastore) purgeIdempotencyRecords(createdBefore time.Time) int64 {
+	select {
+	case d.idempotencyKeys <- createdBefore:
+	default:
+	}
+	return 0
+}
+
+var _ = Describe("Purging what is kept for a while", func() {
+	It("purges the events and the idempotency keys after their retention, even if the trash fails", func() {
+		ds := &purgerTestDatastore{
+			mockDatastore:   &mockDatastore{},
+			events:          make(chan time.Time, 16),
+			idempotencyKeys: make(chan time.Time, 16),
+		}
+		purger := newRetentionPurger(ds, time.Hour, time.Minute, time.Hour)
+		go purger.run()
+
+		var before time.Time
+		Eventually(ds.events).Should(Receive(&before))
+		Expect(before).To(BeTemporally("~", time.Now().Add(-recipeEventRetention), time.Minute))
+		Eventually(ds.idempotencyKeys).Should(Receive(&before))
+		Expect(before).To(BeTemporally("~", time.Now().Add(-time.Minute), time.Second))
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		purger.stop(ctx)
+		Expect(ctx.Err()).To(BeNil())
+	})
+})
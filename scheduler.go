@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+const defaultSchedulePollInterval = time.Minute
+
+// recipeScheduler writes the events of the recipes that enter or leave their
+// publishing window to the outbox, from where the relay publishes them like
+// any other recipe event.
+type recipeScheduler struct {
+	datastore    datastore
+	pollInterval time.Duration
+	quit         chan struct{}
+	done         chan struct{}
+}
+
+func newRecipeScheduler(ds datastore, pollInterval time.Duration) *recipeScheduler {
+	return &recipeScheduler{
+		datastore:    ds,
+		pollInterval: pollInterval,
+		quit:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+}
+
+func (s *recipeScheduler) run() {
+	defer close(s.done)
+	var backoff workerBackoff
+	for {
+		wait := s.pollInterval
+		ok := runWorkerStep("scheduler", func() {
+			now := time.Now()
+			s.datastore.publishRecipeScheduleEvents(now)
+			wait = s.nextWait(now)
+		})
+		timer := time.NewTimer(backoff.next(ok, wait))
+		select {
+		case <-s.quit:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+func (s *recipeScheduler) nextWait(last time.Time) time.Duration {
+	wait := s.pollInterval
+	if next := s.datastore.nextRecipeScheduleTime(last); next.Valid {
+		if d := time.Until(next.Time); d < wait {
+			wait = d
+		}
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	return wait
+}
+
+func (s *recipeScheduler) stop(ctx context.Context) {
+	close(s.quit)
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// schedulerTestDatastore fails the first time it's asked to publish the
+// schedule events, and reports the later times it's asked to publish up to.
+type schedulerTestDatastore struct {
+	*mockDatastore
+	failed    bool
+	published chan time.Time
+}
+
+func (d *schedulerTestDatastore) publishRecipeScheduleEvents(to time.Time) int {
+	if !d.failed {
+		d.failed = true
+		panic("connection refused")
+	}
+	select {
+	case d.published <- to:
+	default:
+	}
+	return 0
+}
+
+var _ = Describe("Scheduling recipes", func() {
+	It("publishes the schedule events through the datastore, and keeps going after a failure", func() {
+		ds := &schedulerTestDatastore{
+			mockDatastore: &mockDatastore{dataFunc: func() interface{} { return nil }},
+			published:     make(chan time.Time, 16),
+		}
+		scheduler := newRecipeScheduler(ds, 10*time.Millisecond)
+		start := time.Now()
+		go scheduler.run()
+
+		var to time.Time
+		Eventually(ds.published).Should(Receive(&to))
+		Expect(to.After(start)).To(BeTrue())
+		Eventually(ds.published).Should(Receive(&to))
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		scheduler.stop(ctx)
+		Expect(ctx.Err()).To(BeNil())
+	})
+	It("stops even if it has never been started", func() {
+		scheduler := newRecipeScheduler(&mockDatastore{}, time.Minute)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		scheduler.stop(ctx)
+		Expect(ctx.Err()).NotTo(BeNil())
+	})
+})
@@ -1,5 +1,11 @@
 SET NAMES 'UTF8';
 
+DROP TABLE IF EXISTS recipe_schedule_checkpoint;
+DROP TABLE IF EXISTS recipe_outbox;
+DROP TABLE IF EXISTS webhook_delivery;
+DROP TABLE IF EXISTS webhook;
+DROP TABLE IF EXISTS idempotency_key;
+DROP TABLE IF EXISTS recipe_revision;
 DROP TABLE IF EXISTS hellofresh_user_recipe;
 DROP TABLE IF EXISTS hellofresh_user;
 DROP TABLE IF EXISTS recipe;
\ No newline at end of file
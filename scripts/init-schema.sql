@@ -6,13 +6,18 @@ CREATE TABLE IF NOT EXISTS recipe(
     r_difficulty SMALLINT,
     r_vegetarian BOOLEAN NOT NULL,
     r_rating REAL NOT NULL DEFAULT 0.0,
-    r_rated_num INTEGER NOT NULL DEFAULT 0
+    r_rated_num INTEGER NOT NULL DEFAULT 0,
+    r_publish_at TIMESTAMP WITH TIME ZONE,
+    r_unpublish_at TIMESTAMP WITH TIME ZONE,
+    r_deleted_at TIMESTAMP WITH TIME ZONE,
+    r_version INTEGER NOT NULL DEFAULT 1
 );
 
 CREATE TABLE IF NOT EXISTS hellofresh_user(
     hu_id SERIAL PRIMARY KEY,
     hu_account VARCHAR(32) NOT NULL UNIQUE,
-    hu_access_token VARCHAR(32) NOT NULL UNIQUE
+    hu_access_token VARCHAR(32) NOT NULL UNIQUE,
+    hu_admin BOOLEAN NOT NULL DEFAULT false
 );
 
 CREATE TABLE IF NOT EXISTS hellofresh_user_recipe(
@@ -28,3 +33,146 @@ CREATE TABLE IF NOT EXISTS hellofresh_user_recipe(
 		ON DELETE CASCADE
 		ON UPDATE RESTRICT
 );
+
+CREATE TABLE IF NOT EXISTS recipe_revision(
+    rr_r_id INTEGER NOT NULL,
+    rr_number INTEGER NOT NULL,
+    rr_hu_id INTEGER,
+    rr_created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    rr_name VARCHAR(512) NOT NULL,
+    rr_prep_time SMALLINT,
+    rr_difficulty SMALLINT,
+    rr_vegetarian BOOLEAN NOT NULL,
+    rr_rating REAL NOT NULL DEFAULT 0.0,
+    rr_rated_num INTEGER NOT NULL DEFAULT 0,
+    rr_publish_at TIMESTAMP WITH TIME ZONE,
+    rr_unpublish_at TIMESTAMP WITH TIME ZONE,
+    rr_deleted_at TIMESTAMP WITH TIME ZONE,
+    rr_version INTEGER NOT NULL DEFAULT 1,
+    CONSTRAINT pk_recipe_revision PRIMARY KEY(rr_r_id, rr_number),
+    CONSTRAINT fk_recipe_revision__recipe FOREIGN KEY
+        (rr_r_id) REFERENCES recipe(r_id)
+        ON DELETE CASCADE
+        ON UPDATE RESTRICT,
+    CONSTRAINT fk_recipe_revision__hellofresh_user FOREIGN KEY
+        (rr_hu_id) REFERENCES hellofresh_user(hu_id)
+        ON DELETE SET NULL
+        ON UPDATE RESTRICT
+);
+
+CREATE TABLE IF NOT EXISTS idempotency_key(
+    ik_scope CHAR(64) NOT NULL,
+    ik_key VARCHAR(255) NOT NULL,
+    ik_fingerprint CHAR(64) NOT NULL,
+    ik_status SMALLINT NOT NULL DEFAULT 0,
+    ik_content_type VARCHAR(255) NOT NULL DEFAULT '',
+    ik_etag VARCHAR(255) NOT NULL DEFAULT '',
+    ik_body BYTEA NOT NULL DEFAULT '',
+    ik_created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    CONSTRAINT pk_idempotency_key PRIMARY KEY(ik_scope, ik_key)
+);
+
+CREATE TABLE IF NOT EXISTS webhook(
+    wh_id SERIAL PRIMARY KEY,
+    wh_hu_id INTEGER NOT NULL,
+    wh_url VARCHAR(2048) NOT NULL,
+    wh_secret CHAR(64) NOT NULL,
+    wh_events TEXT[] NOT NULL DEFAULT '{}',
+    wh_created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    CONSTRAINT fk_webhook__hellofresh_user FOREIGN KEY
+        (wh_hu_id) REFERENCES hellofresh_user(hu_id)
+        ON DELETE CASCADE
+        ON UPDATE RESTRICT
+);
+
+CREATE TABLE IF NOT EXISTS webhook_delivery(
+    wd_id SERIAL PRIMARY KEY,
+    wd_wh_id INTEGER NOT NULL,
+    wd_event VARCHAR(64) NOT NULL,
+    wd_payload JSONB NOT NULL,
+    wd_status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    wd_attempts INTEGER NOT NULL DEFAULT 0,
+    wd_next_attempt_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+    wd_response_status SMALLINT,
+    wd_error TEXT,
+    wd_created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    wd_delivered_at TIMESTAMP WITH TIME ZONE,
+    wd_traceparent TEXT NOT NULL DEFAULT '',
+    CONSTRAINT fk_webhook_delivery__webhook FOREIGN KEY
+        (wd_wh_id) REFERENCES webhook(wh_id)
+        ON DELETE CASCADE
+        ON UPDATE RESTRICT
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_delivery__next_attempt ON webhook_delivery(wd_next_attempt_at)
+    WHERE wd_status = 'pending';
+
+CREATE TABLE IF NOT EXISTS recipe_outbox(
+    ro_id BIGSERIAL PRIMARY KEY,
+    ro_type VARCHAR(64) NOT NULL,
+    ro_r_id INTEGER NOT NULL,
+    ro_payload JSONB NOT NULL,
+    ro_created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    ro_published_at TIMESTAMP WITH TIME ZONE,
+    ro_traceparent TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_recipe_outbox__pending ON recipe_outbox(ro_id)
+    WHERE ro_published_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS recipe_schedule_checkpoint(
+    rsc_id BOOLEAN PRIMARY KEY DEFAULT true CHECK (rsc_id),
+    rsc_processed_until TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+-- CREATE TABLE IF NOT EXISTS leaves the tables of an existing database as
+-- they are, so the columns added to a table after it was first released are
+-- added here to the databases that don't have them yet.
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns
+            WHERE table_schema = current_schema() AND table_name = 'recipe' AND column_name = 'r_publish_at') THEN
+        ALTER TABLE recipe ADD COLUMN r_publish_at TIMESTAMP WITH TIME ZONE;
+    END IF;
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns
+            WHERE table_schema = current_schema() AND table_name = 'recipe' AND column_name = 'r_unpublish_at') THEN
+        ALTER TABLE recipe ADD COLUMN r_unpublish_at TIMESTAMP WITH TIME ZONE;
+    END IF;
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns
+            WHERE table_schema = current_schema() AND table_name = 'recipe' AND column_name = 'r_deleted_at') THEN
+        ALTER TABLE recipe ADD COLUMN r_deleted_at TIMESTAMP WITH TIME ZONE;
+    END IF;
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns
+            WHERE table_schema = current_schema() AND table_name = 'recipe' AND column_name = 'r_version') THEN
+        ALTER TABLE recipe ADD COLUMN r_version INTEGER NOT NULL DEFAULT 1;
+    END IF;
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns
+            WHERE table_schema = current_schema() AND table_name = 'recipe_revision' AND column_name = 'rr_rating') THEN
+        ALTER TABLE recipe_revision ADD COLUMN rr_rating REAL NOT NULL DEFAULT 0.0;
+    END IF;
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns
+            WHERE table_schema = current_schema() AND table_name = 'recipe_revision' AND column_name = 'rr_rated_num') THEN
+        ALTER TABLE recipe_revision ADD COLUMN rr_rated_num INTEGER NOT NULL DEFAULT 0;
+    END IF;
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns
+            WHERE table_schema = current_schema() AND table_name = 'recipe_revision' AND column_name = 'rr_deleted_at') THEN
+        ALTER TABLE recipe_revision ADD COLUMN rr_deleted_at TIMESTAMP WITH TIME ZONE;
+    END IF;
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns
+            WHERE table_schema = current_schema() AND table_name = 'recipe_revision' AND column_name = 'rr_version') THEN
+        ALTER TABLE recipe_revision ADD COLUMN rr_version INTEGER NOT NULL DEFAULT 1;
+    END IF;
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns
+            WHERE table_schema = current_schema() AND table_name = 'hellofresh_user' AND column_name = 'hu_admin') THEN
+        ALTER TABLE hellofresh_user ADD COLUMN hu_admin BOOLEAN NOT NULL DEFAULT false;
+    END IF;
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns
+            WHERE table_schema = current_schema() AND table_name = 'webhook_delivery' AND column_name = 'wd_traceparent') THEN
+        ALTER TABLE webhook_delivery ADD COLUMN wd_traceparent TEXT NOT NULL DEFAULT '';
+    END IF;
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns
+            WHERE table_schema = current_schema() AND table_name = 'recipe_outbox' AND column_name = 'ro_traceparent') THEN
+        ALTER TABLE recipe_outbox ADD COLUMN ro_traceparent TEXT NOT NULL DEFAULT '';
+    END IF;
+END
+$$;
@@ -1,8 +1,8 @@
 SET NAMES 'UTF8';
 
-INSERT INTO hellofresh_user(hu_account, hu_access_token)
+INSERT INTO hellofresh_user(hu_account, hu_access_token, hu_admin)
 VALUES
-('hellofresh', 'aGVsbG9mcmVzaDpoZWxsb2ZyZXNo');
+('hellofresh', 'aGVsbG9mcmVzaDpoZWxsb2ZyZXNo', true);
 
 INSERT INTO hellofresh_user(hu_account, hu_access_token)
 VALUES
@@ -3,6 +3,40 @@
 cd scripts
 ./set-up-test-environment.sh "integration_test"
 
+HTTP_CODE=$(
+curl -sL -w "%{http_code}\\n" \
+     -X GET http://localhost/healthz \
+     -o /dev/null --connect-timeout 1
+)
+if [ $HTTP_CODE -eq 200 ];then
+    echo "[ PASSED ] GET /healthz"
+else
+    echo "[ FAILED ] GET /healthz"
+fi
+
+HTTP_CODE=$(
+curl -sL -w "%{http_code}\\n" \
+     -X GET http://localhost/readyz \
+     -o /dev/null --connect-timeout 1
+)
+if [ $HTTP_CODE -eq 200 ];then
+    echo "[ PASSED ] GET /readyz"
+else
+    echo "[ FAILED ] GET /readyz"
+fi
+
+HTTP_CODE=$(
+curl -sL -w "%{http_code}\\n" \
+     -X GET http://localhost/status \
+     -H "Authorization: aGVsbG9mcmVzaDpoZWxsb2ZyZXNo" \
+     -o /dev/null --connect-timeout 1
+)
+if [ $HTTP_CODE -eq 200 ];then
+    echo "[ PASSED ] GET /status"
+else
+    echo "[ FAILED ] GET /status"
+fi
+
 HTTP_CODE=$(
 curl -sL -w "%{http_code}\\n" \
      -X GET http://localhost/recipes \
@@ -53,6 +87,20 @@ else
     echo "[ FAILED ] PUT /recipes/{id}"
 fi
 
+HTTP_CODE=$(
+curl -sL -w "%{http_code}\\n" \
+     -X PATCH http://localhost/recipes/1 \
+     -H "Content-Type: application/merge-patch+json" \
+     -H "Authorization: aGVsbG9mcmVzaDpoZWxsb2ZyZXNo" \
+     -d '{"difficulty":null}' \
+     -o /dev/null --connect-timeout 1
+)
+if [ $HTTP_CODE -eq 200 ];then
+    echo "[ PASSED ] PATCH /recipes/{id}"
+else
+    echo "[ FAILED ] PATCH /recipes/{id}"
+fi
+
 HTTP_CODE=$(
 curl -sL -w "%{http_code}\\n" \
      -X POST http://localhost/recipes/1/rating \
@@ -0,0 +1,612 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	stdjson "encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	traceparentHeader = "traceparent"
+	// traceparentVersion is the version of the W3C Trace Context that the
+	// traceparent headers are written in.
+	traceparentVersion = "00"
+	traceFlagSampled   = 0x01
+
+	tracingServiceName         = "recipes"
+	defaultTraceExportInterval = 5 * time.Second
+	traceExportBatchSize       = 512
+	traceQueueSize             = 2048
+	traceExportTimeout         = 10 * time.Second
+	maxTracedStatementLength   = 1024
+
+	traceExporterNone   = "none"
+	traceExporterStdout = "stdout"
+	traceExporterOTLP   = "otlp"
+
+	defaultOTLPEndpoint = "http://localhost:4318/v1/traces"
+)
+
+// The kinds and status codes of the spans, as numbered by OTLP.
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+	spanKindClient   = 3
+
+	spanStatusUnset = 0
+	spanStatusOK    = 1
+	spanStatusError = 2
+)
+
+type traceID [16]byte
+
+func (id traceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+type spanID [8]byte
+
+func (id spanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// spanContext is what identifies a span across processes.
+type spanContext struct {
+	traceID traceID
+	spanID  spanID
+	sampled bool
+}
+
+func (c spanContext) isValid() bool {
+	return c.traceID != traceID{} && c.spanID != spanID{}
+}
+
+func (c spanContext) traceparent() string {
+	flags := 0
+	if c.sampled {
+		flags = traceFlagSampled
+	}
+	return fmt.Sprintf("%s-%s-%s-%02x", traceparentVersion, c.traceID, c.spanID, flags)
+}
+
+// parseTraceparent parses a W3C traceparent header like
+// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01".
+func parseTraceparent(header string) (spanContext, bool) {
+	var res spanContext
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == traceparentVersion && len(parts) != 4) {
+		return res, false
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return res, false
+	}
+	for _, p := range parts[:4] {
+		if strings.ToLower(p) != p {
+			return res, false
+		}
+	}
+	if _, err := hex.Decode(res.traceID[:], []byte(parts[1])); err != nil {
+		return res, false
+	}
+	if _, err := hex.Decode(res.spanID[:], []byte(parts[2])); err != nil {
+		return res, false
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return res, false
+	}
+	res.sampled = flags&traceFlagSampled != 0
+	return res, res.isValid()
+}
+
+// span is an operation of a trace. The methods of a nil span do nothing, so
+// that the operations without a parent to trace them under go untraced.
+type span struct {
+	tracer        *tracer
+	name          string
+	kind          int
+	context       spanContext
+	parent        spanID
+	start         time.Time
+	mu            sync.Mutex
+	end           time.Time
+	attributes    map[string]interface{}
+	status        int
+	statusMessage string
+}
+
+func (s *span) setName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.name = name
+}
+
+func (s *span) setAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attributes[key] = value
+}
+
+func (s *span) setError(message string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status, s.statusMessage = spanStatusError, message
+}
+
+// finish ends the span and queues it to be exported. A span is exported
+// only once.
+func (s *span) finish() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if !s.end.IsZero() {
+		s.mu.Unlock()
+		return
+	}
+	s.end = time.Now()
+	s.mu.Unlock()
+	if s.context.sampled {
+		s.tracer.queue(s)
+	}
+}
+
+type spanContextKey struct{}
+
+func contextWithSpan(ctx context.Context, s *span) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, s)
+}
+
+func spanFromContext(ctx context.Context) *span {
+	if ctx == nil {
+		return nil
+	}
+	s, _ := ctx.Value(spanContextKey{}).(*span)
+	return s
+}
+
+// startChildSpan starts a span under the span of the context, or returns a
+// nil span if there is none.
+func startChildSpan(ctx context.Context, name string, kind int) (context.Context, *span) {
+	parent := spanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	s := parent.tracer.startSpan(parent.context, name, kind)
+	return contextWithSpan(ctx, s), s
+}
+
+// traceparentOf is the traceparent header of the span of the context, or ""
+// if there is none.
+func traceparentOf(ctx context.Context) string {
+	if s := spanFromContext(ctx); s != nil {
+		return s.context.traceparent()
+	}
+	return ""
+}
+
+// spanExporter sends the finished spans to where they are collected.
+type spanExporter interface {
+	exportSpans(spans []*span) error
+}
+
+// tracer starts spans and exports the finished ones in batches in the
+// background. Without an exporter, the spans are only propagated.
+type tracer struct {
+	exporter spanExporter
+	spans    chan *span
+	interval time.Duration
+	quit     chan struct{}
+	done     chan struct{}
+}
+
+func newTracer(exporter spanExporter, interval time.Duration) *tracer {
+	return &tracer{
+		exporter: exporter,
+		spans:    make(chan *span, traceQueueSize),
+		interval: interval,
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// startSpan starts a span under the parent, or a new trace if the parent
+// isn't valid. The sampling decision of the parent is kept.
+func (t *tracer) startSpan(parent spanContext, name string, kind int) *span {
+	s := &span{
+		tracer:     t,
+		name:       name,
+		kind:       kind,
+		context:    spanContext{traceID: parent.traceID, spanID: newSpanID(), sampled: parent.sampled},
+		start:      time.Now(),
+		attributes: make(map[string]interface{}),
+	}
+	if parent.isValid() {
+		s.parent = parent.spanID
+	} else {
+		s.context.traceID, s.context.sampled = newTraceID(), true
+	}
+	return s
+}
+
+// queue hands a finished span to run without blocking. The spans are
+// dropped when the queue is full.
+func (t *tracer) queue(s *span) {
+	if t.exporter == nil {
+		return
+	}
+	select {
+	case t.spans <- s:
+	default:
+	}
+}
+
+func (t *tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	batch := make([]*span, 0, traceExportBatchSize)
+	for {
+		select {
+		case <-t.quit:
+			for {
+				select {
+				case s := <-t.spans:
+					batch = append(batch, s)
+				default:
+					t.export(batch)
+					return
+				}
+			}
+		case s := <-t.spans:
+			batch = append(batch, s)
+			if len(batch) < traceExportBatchSize {
+				continue
+			}
+		case <-ticker.C:
+		}
+		t.export(batch)
+		batch = make([]*span, 0, traceExportBatchSize)
+	}
+}
+
+func (t *tracer) export(batch []*span) {
+	if len(batch) == 0 {
+		return
+	}
+	if err := t.exporter.exportSpans(batch); err != nil {
+		logger.warn("error exporting spans", logFields{"count": len(batch), "error": err})
+	}
+}
+
+// stop exports the spans that are queued, and waits for it until the
+// context is done.
+func (t *tracer) stop(ctx context.Context) {
+	close(t.quit)
+	select {
+	case <-t.done:
+	case <-ctx.Done():
+	}
+}
+
+func newTraceID() traceID {
+	var res traceID
+	if _, err := rand.Read(res[:]); err != nil {
+		panic(err)
+	}
+	return res
+}
+
+func newSpanID() spanID {
+	var res spanID
+	if _, err := rand.Read(res[:]); err != nil {
+		panic(err)
+	}
+	return res
+}
+
+// newSpanExporter builds the exporter of the name, or returns nil for
+// "none".
+func newSpanExporter(name, endpoint string) spanExporter {
+	switch strings.TrimSpace(name) {
+	case traceExporterNone, "":
+		return nil
+	case traceExporterStdout:
+		return &writerSpanExporter{w: os.Stdout}
+	case traceExporterOTLP:
+		return newOTLPSpanExporter(endpoint)
+	default:
+		panic(fmt.Sprintf("unknown trace exporter %q", name))
+	}
+}
+
+// The spans in the JSON encoding of OTLP, which the stdout exporter writes
+// too.
+type otlpExportRequest struct {
+	ResourceSpans []*otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource      `json:"resource"`
+	ScopeSpans []*otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []*otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope   `json:"scope"`
+	Spans []*otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string           `json:"traceId"`
+	SpanID            string           `json:"spanId"`
+	ParentSpanID      string           `json:"parentSpanId,omitempty"`
+	Name              string           `json:"name"`
+	Kind              int              `json:"kind"`
+	StartTimeUnixNano string           `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string           `json:"endTimeUnixNano"`
+	Attributes        []*otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus       `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string             `json:"key"`
+	Value otlpAttributeValue `json:"value"`
+}
+
+type otlpAttributeValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+func newOTLPAttribute(key string, value interface{}) *otlpAttribute {
+	res := &otlpAttribute{Key: key}
+	switch v := value.(type) {
+	case int:
+		s := strconv.Itoa(v)
+		res.Value.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		res.Value.IntValue = &s
+	case float64:
+		res.Value.DoubleValue = &v
+	case bool:
+		res.Value.BoolValue = &v
+	default:
+		s := fmt.Sprint(v)
+		res.Value.StringValue = &s
+	}
+	return res
+}
+
+func newOTLPSpan(s *span) *otlpSpan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := &otlpSpan{
+		TraceID:           s.context.traceID.String(),
+		SpanID:            s.context.spanID.String(),
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		Status:            otlpStatus{Code: s.status, Message: s.statusMessage},
+	}
+	if s.parent != (spanID{}) {
+		res.ParentSpanID = s.parent.String()
+	}
+	keys := make([]string, 0, len(s.attributes))
+	for key := range s.attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		res.Attributes = append(res.Attributes, newOTLPAttribute(key, s.attributes[key]))
+	}
+	return res
+}
+
+func newOTLPExportRequest(spans []*span) *otlpExportRequest {
+	scope := &otlpScopeSpans{Scope: otlpScope{Name: tracingServiceName}}
+	for _, s := range spans {
+		scope.Spans = append(scope.Spans, newOTLPSpan(s))
+	}
+	return &otlpExportRequest{ResourceSpans: []*otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: []*otlpAttribute{newOTLPAttribute("service.name", tracingServiceName)}},
+		ScopeSpans: []*otlpScopeSpans{scope},
+	}}}
+}
+
+// writerSpanExporter writes a span in the JSON encoding of OTLP per line.
+type writerSpanExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (e *writerSpanExporter) exportSpans(spans []*span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	encoder := stdjson.NewEncoder(e.w)
+	for _, s := range spans {
+		if err := encoder.Encode(newOTLPSpan(s)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// otlpSpanExporter POSTs the spans to an OTLP/HTTP collector in JSON.
+type otlpSpanExporter struct {
+	endpoint string
+	client   *http.Client
+}
+
+func newOTLPSpanExporter(endpoint string) *otlpSpanExporter {
+	return &otlpSpanExporter{
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: traceExportTimeout},
+	}
+}
+
+func (e *otlpSpanExporter) exportSpans(spans []*span) error {
+	body, err := stdjson.Marshal(newOTLPExportRequest(spans))
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.endpoint, gin.MIMEJSON, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	return nil
+}
+
+// tracedDB runs the statements of a datastore call in spans under the span
+// of the call. It only uses the context for the span, so that the
+// statements aren't cancelled with the request.
+type tracedDB struct {
+	*sqlx.DB
+	ctx context.Context
+}
+
+func (db *tracedDB) Get(dest interface{}, query string, args ...interface{}) error {
+	s := startStatementSpan(db.ctx, query)
+	err := db.DB.Get(dest, query, args...)
+	finishStatementSpan(s, err)
+	return err
+}
+
+func (db *tracedDB) Select(dest interface{}, query string, args ...interface{}) error {
+	s := startStatementSpan(db.ctx, query)
+	err := db.DB.Select(dest, query, args...)
+	finishStatementSpan(s, err)
+	return err
+}
+
+func (db *tracedDB) MustExec(query string, args ...interface{}) sql.Result {
+	s := startStatementSpan(db.ctx, query)
+	res, err := db.DB.Exec(query, args...)
+	finishStatementSpan(s, err)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+func (db *tracedDB) Queryx(query string, args ...interface{}) (*sqlx.Rows, error) {
+	s := startStatementSpan(db.ctx, query)
+	rows, err := db.DB.Queryx(query, args...)
+	finishStatementSpan(s, err)
+	return rows, err
+}
+
+func (db *tracedDB) MustBegin() *tracedTx {
+	return &tracedTx{Tx: db.DB.MustBegin(), ctx: db.ctx}
+}
+
+type tracedTx struct {
+	*sqlx.Tx
+	ctx context.Context
+}
+
+func (tx *tracedTx) Get(dest interface{}, query string, args ...interface{}) error {
+	s := startStatementSpan(tx.ctx, query)
+	err := tx.Tx.Get(dest, query, args...)
+	finishStatementSpan(s, err)
+	return err
+}
+
+func (tx *tracedTx) Select(dest interface{}, query string, args ...interface{}) error {
+	s := startStatementSpan(tx.ctx, query)
+	err := tx.Tx.Select(dest, query, args...)
+	finishStatementSpan(s, err)
+	return err
+}
+
+func (tx *tracedTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	s := startStatementSpan(tx.ctx, query)
+	res, err := tx.Tx.Exec(query, args...)
+	finishStatementSpan(s, err)
+	return res, err
+}
+
+func (tx *tracedTx) MustExec(query string, args ...interface{}) sql.Result {
+	res, err := tx.Exec(query, args...)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+func (tx *tracedTx) NamedExec(query string, arg interface{}) (sql.Result, error) {
+	s := startStatementSpan(tx.ctx, query)
+	res, err := tx.Tx.NamedExec(query, arg)
+	finishStatementSpan(s, err)
+	return res, err
+}
+
+// startStatementSpan starts the span of a SQL statement, named after its
+// operation like "SELECT".
+func startStatementSpan(ctx context.Context, query string) *span {
+	statement := strings.Join(strings.Fields(query), " ")
+	operation := statement
+	if i := strings.IndexByte(statement, ' '); i > 0 {
+		operation = statement[:i]
+	}
+	if len(statement) > maxTracedStatementLength {
+		statement = statement[:maxTracedStatementLength]
+	}
+	_, s := startChildSpan(ctx, strings.ToUpper(operation), spanKindClient)
+	s.setAttribute("db.system", "postgresql")
+	s.setAttribute("db.statement", statement)
+	return s
+}
+
+func finishStatementSpan(s *span, err error) {
+	if err != nil && err != sql.ErrNoRows {
+		s.setError(err.Error())
+	}
+	s.finish()
+}
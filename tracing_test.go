@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	stdjson "encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	null "gopkg.in/guregu/null.v3"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// recordingSpanExporter keeps the spans it exports in memory.
+type recordingSpanExporter struct {
+	mu    sync.Mutex
+	spans []*span
+}
+
+func (e *recordingSpanExporter) exportSpans(spans []*span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *recordingSpanExporter) span(name string) *span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, s := range e.spans {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func stopTracer(t *tracer) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	t.stop(ctx)
+	Expect(ctx.Err()).To(BeNil())
+}
+
+var _ = Describe("Tracing", func() {
+	It("parses and formats the traceparent headers", func() {
+		header := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+		c, ok := parseTraceparent(header)
+		Expect(ok).To(BeTrue())
+		Expect(c.traceID.String()).To(Equal("4bf92f3577b34da6a3ce929d0e0e4736"))
+		Expect(c.spanID.String()).To(Equal("00f067aa0ba902b7"))
+		Expect(c.sampled).To(BeTrue())
+		Expect(c.traceparent()).To(Equal(header))
+
+		c, ok = parseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+		Expect(ok).To(BeTrue())
+		Expect(c.sampled).To(BeFalse())
+
+		for _, invalid := range []string{
+			"",
+			"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+			"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+			"00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01",
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		} {
+			_, ok := parseTraceparent(invalid)
+			Expect(ok).To(BeFalse(), invalid)
+		}
+	})
+	It("traces a request and its datastore calls under the incoming traceparent", func() {
+		exporter := &recordingSpanExporter{}
+		t := newTracer(exporter, time.Hour)
+		go t.run()
+		server := newTestAPIServer(&Recipe{ID: 3, Name: "name3", Rating: null.FloatFrom(3.0), RatedNum: null.IntFrom(1)})
+		server.httpServer.tracer = t
+		server.datastore = &instrumentedDatastore{datastore: server.datastore}
+
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/recipes/3", nil)
+		req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		req.Header.Set("X-Request-ID", "req-1")
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		stopTracer(t)
+
+		request := exporter.span("GET /recipes/:id")
+		Expect(request).NotTo(BeNil())
+		Expect(request.kind).To(Equal(spanKindServer))
+		Expect(request.context.traceID.String()).To(Equal("4bf92f3577b34da6a3ce929d0e0e4736"))
+		Expect(request.parent.String()).To(Equal("00f067aa0ba902b7"))
+		Expect(request.attributes).To(HaveKeyWithValue("http.route", "/recipes/:id"))
+		Expect(request.attributes).To(HaveKeyWithValue("http.status_code", http.StatusOK))
+		Expect(request.attributes).To(HaveKeyWithValue("http.request_id", "req-1"))
+		Expect(request.status).To(Equal(spanStatusUnset))
+
+		call := exporter.span("datastore.getRecipeByID")
+		Expect(call).NotTo(BeNil())
+		Expect(call.context.traceID).To(Equal(request.context.traceID))
+		Expect(call.parent).To(Equal(request.context.spanID))
+		Expect(call.end.After(request.end)).To(BeFalse())
+	})
+	It("marks the spans of a failed request and datastore call as errors", func() {
+		exporter := &recordingSpanExporter{}
+		t := newTracer(exporter, time.Hour)
+		go t.run()
+		server := newTestAPIServer(nil)
+		server.httpServer.tracer = t
+		server.datastore = &instrumentedDatastore{datastore: &mockDatastore{
+			dataFunc: func() interface{} {
+				panic("connection refused")
+			},
+		}}
+
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/recipes/3", nil)
+		server.httpServer.router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusInternalServerError))
+		stopTracer(t)
+
+		request := exporter.span("GET /recipes/:id")
+		Expect(request).NotTo(BeNil())
+		Expect(request.parent).To(Equal(spanID{}))
+		Expect(request.status).To(Equal(spanStatusError))
+		call := exporter.span("datastore.getRecipeByID")
+		Expect(call).NotTo(BeNil())
+		Expect(call.status).To(Equal(spanStatusError))
+		Expect(call.statusMessage).To(Equal("connection refused"))
+	})
+	It("passes the trace of the change that made the event on to the webhook", func() {
+		received := make(chan *http.Request, 1)
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received <- r
+		}))
+		defer receiver.Close()
+
+		exporter := &recordingSpanExporter{}
+		t := newTracer(exporter, time.Hour)
+		go t.run()
+		ds := newWebhookTestDatastore(receiver.URL)
+		ds.deliveries[0].Traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+		dispatcher := newLoopbackWebhookDispatcher(ds, defaultWebhookAttempts)
+		dispatcher.tracer = t
+		dispatcher.deliverDue()
+		stopTracer(t)
+
+		var r *http.Request
+		Eventually(received).Should(Receive(&r))
+		c, ok := parseTraceparent(r.Header.Get("traceparent"))
+		Expect(ok).To(BeTrue())
+		Expect(c.traceID.String()).To(Equal("4bf92f3577b34da6a3ce929d0e0e4736"))
+		delivery := exporter.span("webhook delivery")
+		Expect(delivery).NotTo(BeNil())
+		Expect(delivery.kind).To(Equal(spanKindClient))
+		Expect(delivery.context.spanID).To(Equal(c.spanID))
+		Expect(delivery.parent.String()).To(Equal("00f067aa0ba902b7"))
+		Expect(delivery.attributes).To(HaveKeyWithValue("http.status_code", http.StatusOK))
+	})
+	It("POSTs the spans to an OTLP/HTTP collector", func() {
+		requests := make(chan *http.Request, 1)
+		bodies := make(chan []byte, 1)
+		collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			requests <- r
+			bodies <- body
+		}))
+		defer collector.Close()
+
+		t := newTracer(newSpanExporter("otlp", collector.URL+"/v1/traces"), time.Hour)
+		go t.run()
+		parent, _ := parseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		s := t.startSpan(parent, "GET /recipes/:id", spanKindServer)
+		s.setAttribute("http.status_code", 200)
+		s.setAttribute("http.route", "/recipes/:id")
+		s.finish()
+		stopTracer(t)
+
+		var r *http.Request
+		var body []byte
+		Eventually(requests).Should(Receive(&r))
+		Eventually(bodies).Should(Receive(&body))
+		Expect(r.Method).To(Equal("POST"))
+		Expect(r.URL.Path).To(Equal("/v1/traces"))
+		Expect(r.Header.Get("Content-Type")).To(Equal("application/json"))
+		var export struct {
+			ResourceSpans []struct {
+				Resource struct {
+					Attributes []map[string]interface{} `json:"attributes"`
+				} `json:"resource"`
+				ScopeSpans []struct {
+					Spans []map[string]interface{} `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		Expect(stdjson.Unmarshal(body, &export)).To(Succeed())
+		Expect(export.ResourceSpans).To(HaveLen(1))
+		Expect(export.ResourceSpans[0].Resource.Attributes).To(ContainElement(map[string]interface{}{
+			"key": "service.name", "value": map[string]interface{}{"stringValue": "recipes"},
+		}))
+		spans := export.ResourceSpans[0].ScopeSpans[0].Spans
+		Expect(spans).To(HaveLen(1))
+		Expect(spans[0]).To(HaveKeyWithValue("traceId", "4bf92f3577b34da6a3ce929d0e0e4736"))
+		Expect(spans[0]).To(HaveKeyWithValue("parentSpanId", "00f067aa0ba902b7"))
+		Expect(spans[0]).To(HaveKeyWithValue("name", "GET /recipes/:id"))
+		Expect(spans[0]).To(HaveKeyWithValue("kind", 2.0))
+		Expect(spans[0]["startTimeUnixNano"]).To(MatchRegexp(`^[0-9]+$`))
+		Expect(spans[0]["attributes"]).To(Equal([]interface{}{
+			map[string]interface{}{"key": "http.route", "value": map[string]interface{}{"stringValue": "/recipes/:id"}},
+			map[string]interface{}{"key": "http.status_code", "value": map[string]interface{}{"intValue": "200"}},
+		}))
+		Expect(func() { newSpanExporter("zipkin", "") }).To(Panic())
+	})
+})
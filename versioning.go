@@ -0,0 +1,158 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	null "gopkg.in/guregu/null.v3"
+)
+
+const (
+	apiVersionKey     = "apiVersion"
+	apiVersionHeader  = "API-Version"
+	defaultAPIVersion = 1
+	latestAPIVersion  = 2
+)
+
+var versionPrefix = regexp.MustCompile(`^/v(\d+)(/.*)$`)
+
+// fixedAPIVersion serves a route of a version group like "/v1".
+func (s *apiServer) fixedAPIVersion(version int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		s.setAPIVersion(c, version, "/v"+strconv.Itoa(version))
+	}
+}
+
+// negotiateAPIVersion serves a route without a version prefix in the
+// version asked for by the API-Version header, or in the default version.
+func (s *apiServer) negotiateAPIVersion(c *gin.Context) {
+	version := defaultAPIVersion
+	if h := c.GetHeader(apiVersionHeader); h != "" {
+		v, err := strconv.Atoi(h)
+		if err != nil || v < 1 || v > latestAPIVersion {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		version = v
+	}
+	c.Writer.Header().Add("Vary", apiVersionHeader)
+	s.setAPIVersion(c, version, "")
+}
+
+// setAPIVersion marks a deprecated version by the Deprecation, Link and
+// Sunset headers, at the dates that the service is configured with.
+func (s *apiServer) setAPIVersion(c *gin.Context, version int, prefix string) {
+	c.Set(apiVersionKey, version)
+	c.Header(apiVersionHeader, strconv.Itoa(version))
+	if deprecatedAt, ok := s.deprecations[version]; ok {
+		c.Header("Deprecation", "@"+strconv.FormatInt(deprecatedAt.Unix(), 10))
+		successor := "/v" + strconv.Itoa(latestAPIVersion) + c.Request.URL.Path[len(prefix):]
+		c.Header("Link", "<"+successor+`>; rel="successor-version"`)
+	}
+	if sunsetAt, ok := s.sunsets[version]; ok {
+		c.Header("Sunset", sunsetAt.Format(http.TimeFormat))
+	}
+}
+
+func apiVersion(c *gin.Context) int {
+	if version, ok := c.Get(apiVersionKey); ok {
+		return version.(int)
+	}
+	return defaultAPIVersion
+}
+
+// unversionedPath strips the version prefix from a route path, like
+// "/v2/recipes/:id" to "/recipes/:id" and 2. The version of a path without
+// a prefix is 0.
+func unversionedPath(path string) (string, int) {
+	if m := versionPrefix.FindStringSubmatch(path); m != nil {
+		version, _ := strconv.Atoi(m[1])
+		return m[2], version
+	}
+	return path, 0
+}
+
+type RecipeV2 struct {
+	ID           int             `json:"id"`
+	Name         string          `json:"name"`
+	PrepareTime  null.Int        `json:"prepare_time"`
+	Difficulty   null.Int        `json:"difficulty"`
+	IsVegetarian bool            `json:"is_vegetarian"`
+	Rating       *RecipeRatingV2 `json:"rating"`
+	PublishAt    null.Time       `json:"publish_at"`
+	UnpublishAt  null.Time       `json:"unpublish_at"`
+	DeletedAt    null.Time       `json:"deleted_at"`
+}
+
+type RecipeRatingV2 struct {
+	Average null.Float `json:"average"`
+	Count   int64      `json:"count"`
+}
+
+type RecipeBatchResultV2 struct {
+	Status int       `json:"status"`
+	Error  string    `json:"error,omitempty"`
+	Recipe *RecipeV2 `json:"recipe,omitempty"`
+}
+
+type RecipeBatchResponseV2 struct {
+	Mode      string                 `json:"mode"`
+	Committed bool                   `json:"committed"`
+	Results   []*RecipeBatchResultV2 `json:"results"`
+}
+
+type RecipeCSVImportReportV2 struct {
+	Imported int                  `json:"imported"`
+	Errors   []*RecipeCSVRowError `json:"errors"`
+	Recipes  []*RecipeV2          `json:"recipes"`
+}
+
+func newRecipeV2(r *Recipe) *RecipeV2 {
+	if r == nil {
+		return nil
+	}
+	return &RecipeV2{
+		ID:           r.ID,
+		Name:         r.Name,
+		PrepareTime:  r.PrepareTime,
+		Difficulty:   r.Difficulty,
+		IsVegetarian: r.IsVegetarian,
+		Rating:       &RecipeRatingV2{Average: r.Rating, Count: r.RatedNum.Int64},
+		PublishAt:    r.PublishAt,
+		UnpublishAt:  r.UnpublishAt,
+		DeletedAt:    r.DeletedAt,
+	}
+}
+
+func newRecipesV2(recipes []*Recipe) []*RecipeV2 {
+	res := make([]*RecipeV2, len(recipes))
+	for i, r := range recipes {
+		res[i] = newRecipeV2(r)
+	}
+	return res
+}
+
+// versionedResponse converts a response body to its shape in a version.
+// The bodies without recipes have the same shape in every version.
+func versionedResponse(version int, obj interface{}) interface{} {
+	if version < 2 {
+		return obj
+	}
+	switch obj := obj.(type) {
+	case *Recipe:
+		return newRecipeV2(obj)
+	case []*Recipe:
+		return newRecipesV2(obj)
+	case *RecipeBatchResponse:
+		res := &RecipeBatchResponseV2{Mode: obj.Mode, Committed: obj.Committed, Results: make([]*RecipeBatchResultV2, len(obj.Results))}
+		for i, r := range obj.Results {
+			res.Results[i] = &RecipeBatchResultV2{Status: r.Status, Error: r.Error, Recipe: newRecipeV2(r.Recipe)}
+		}
+		return res
+	case *RecipeCSVImportReport:
+		return &RecipeCSVImportReportV2{Imported: obj.Imported, Errors: obj.Errors, Recipes: newRecipesV2(obj.Recipes)}
+	}
+	return obj
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	null "gopkg.in/guregu/null.v3"
+)
+
+func TestUnversionedPath(t *testing.T) {
+	path, version := unversionedPath("/v2/recipes/:id")
+	assert.Equal(t, "/recipes/:id", path)
+	assert.Equal(t, 2, version)
+
+	path, version = unversionedPath("/recipes/:id")
+	assert.Equal(t, "/recipes/:id", path)
+	assert.Equal(t, 0, version)
+
+	path, version = unversionedPath("/v1/recipes:batch")
+	assert.Equal(t, "/recipes:batch", path)
+	assert.Equal(t, 1, version)
+}
+
+func TestAPIVersionDatesConfig(t *testing.T) {
+	cfg := newApplicationConfig()
+	assert.Equal(t, defaultV1Deprecation, cfg.v1Deprecation)
+	assert.Equal(t, defaultV1Sunset, cfg.v1Sunset)
+
+	v := viper.New()
+	v.Set("v1-sunset", "2027-10-19")
+	cfg.bind(v)
+	assert.Equal(t, defaultV1Deprecation, cfg.v1Deprecation)
+	assert.True(t, time.Date(2027, 10, 19, 0, 0, 0, 0, time.UTC).Equal(cfg.v1Sunset))
+}
+
+func TestVersionedResponse(t *testing.T) {
+	recipe := &Recipe{ID: 1, Name: "name1", IsVegetarian: true, Rating: null.FloatFrom(4.5), RatedNum: null.IntFrom(2)}
+	assert.Equal(t, recipe, versionedResponse(1, recipe))
+	assert.Equal(t, &RecipeV2{ID: 1, Name: "name1", IsVegetarian: true, Rating: &RecipeRatingV2{Average: null.FloatFrom(4.5), Count: 2}}, versionedResponse(2, recipe))
+	assert.Equal(t, []*RecipeV2{}, versionedResponse(2, []*Recipe{}))
+
+	assert.Equal(t, &RecipeBatchResponseV2{
+		Mode:      recipeBatchBestEffort,
+		Committed: true,
+		Results: []*RecipeBatchResultV2{
+			{Status: 200, Recipe: &RecipeV2{ID: 1, Name: "name1", IsVegetarian: true, Rating: &RecipeRatingV2{Average: null.FloatFrom(4.5), Count: 2}}},
+			{Status: 404},
+		},
+	}, versionedResponse(2, &RecipeBatchResponse{
+		Mode:      recipeBatchBestEffort,
+		Committed: true,
+		Results:   []*RecipeBatchResult{{Status: 200, Recipe: recipe}, {Status: 404}},
+	}))
+
+	diff := &RecipeRevisionDiff{RecipeID: 1}
+	assert.Equal(t, diff, versionedResponse(2, diff))
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	stdjson "encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	null "gopkg.in/guregu/null.v3"
+)
+
+const (
+	defaultWebhookPollInterval = 5 * time.Second
+	defaultWebhookRetryBackoff = 30 * time.Second
+	maxWebhookRetryBackoff     = 6 * time.Hour
+	webhookDeliveryTimeout     = 10 * time.Second
+	webhookDeliveryBatchSize   = 20
+	maxWebhookResponseSize     = 64 * 1024
+	webhookUserAgent           = "recipes-webhooks/1.0"
+	webhookSignatureHeader     = "X-Webhook-Signature"
+	webhookTimestampHeader     = "X-Webhook-Timestamp"
+)
+
+// webhookEvents are the recipe events that webhooks subscribe to.
+var webhookEvents = map[string]bool{
+	recipeCreatedEvent:     true,
+	recipeUpdatedEvent:     true,
+	recipeDeletedEvent:     true,
+	recipeRatedEvent:       true,
+	recipePublishedEvent:   true,
+	recipeUnpublishedEvent: true,
+}
+
+// webhookAttempt is a claimed delivery along with where and how to sign it.
+type webhookAttempt struct {
+	WebhookDelivery
+	URL         string `db:"wh_url"`
+	Secret      string `db:"wh_secret"`
+	Traceparent string `db:"wd_traceparent"`
+}
+
+// webhookDispatcher queues a delivery for every event a webhook subscribes
+// to, and POSTs the due deliveries in the background. A delivery that fails
+// is retried with an exponential backoff, and ends up dead after
+// maxAttempts attempts. The deliveries only connect to the addresses that
+// allowAddress allows, so that a webhook can't reach into the network of
+// the service.
+type webhookDispatcher struct {
+	datastore    datastore
+	client       *http.Client
+	allowAddress func(net.IP) bool
+	pollInterval time.Duration
+	maxAttempts  int
+	retryBackoff time.Duration
+	tracer       *tracer
+	wake         chan struct{}
+	quit         chan struct{}
+	done         chan struct{}
+}
+
+func newWebhookDispatcher(ds datastore, maxAttempts int, pollInterval time.Duration) *webhookDispatcher {
+	d := &webhookDispatcher{
+		datastore:    ds,
+		allowAddress: isPublicIP,
+		pollInterval: pollInterval,
+		maxAttempts:  maxAttempts,
+		retryBackoff: defaultWebhookRetryBackoff,
+		tracer:       newTracer(nil, defaultTraceExportInterval),
+		wake:         make(chan struct{}, 1),
+		quit:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	dialer := &net.Dialer{
+		Timeout: webhookDeliveryTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			return d.checkAddress(address)
+		},
+	}
+	d.client = &http.Client{
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+		Timeout:   webhookDeliveryTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return d
+}
+
+// checkAddress is checked right before a delivery connects to the resolved
+// address of its URL, so that a name can't resolve to a forbidden address
+// after the webhook has been registered.
+func (d *webhookDispatcher) checkAddress(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !d.allowAddress(ip) {
+		return fmt.Errorf("%s is not a public address", host)
+	}
+	return nil
+}
+
+// checkURL rejects a webhook URL that isn't HTTP or that names a forbidden
+// address, like http://127.0.0.1/ or http://localhost/. The names that
+// resolve to a forbidden address are only rejected when delivering.
+func (d *webhookDispatcher) checkURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("must be an http or https URL")
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		host = "127.0.0.1"
+	}
+	if ip := net.ParseIP(host); ip != nil && !d.allowAddress(ip) {
+		return errors.New("must not point to a loopback, private or link-local address")
+	}
+	return nil
+}
+
+// isPublicIP tells if an address is neither loopback, private, link-local,
+// multicast nor unspecified.
+func isPublicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() && !ip.IsUnspecified()
+}
+
+// publish queues the deliveries of an event, which makes the dispatcher an
+// eventSink.
+func (d *webhookDispatcher) publish(e *recipeEvent) error {
+	if !webhookEvents[e.Type] {
+		return nil
+	}
+	payload, err := stdjson.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if d.datastore.enqueueWebhookDeliveries(e.Type, e.RecipeID, payload, e.Traceparent) > 0 {
+		d.notify()
+	}
+	return nil
+}
+
+// notify wakes the dispatcher up without waiting for the next poll.
+func (d *webhookDispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *webhookDispatcher) run() {
+	defer close(d.done)
+	var backoff workerBackoff
+	for {
+		ok := runWorkerStep("webhooks", d.deliverDue)
+		timer := time.NewTimer(backoff.next(ok, d.pollInterval))
+		select {
+		case <-d.quit:
+			timer.Stop()
+			return
+		case <-timer.C:
+		case <-d.wake:
+			timer.Stop()
+		}
+	}
+}
+
+func (d *webhookDispatcher) deliverDue() {
+	for {
+		attempts := d.datastore.claimWebhookDeliveries(time.Now().Add(2*webhookDeliveryTimeout), webhookDeliveryBatchSize)
+		for _, a := range attempts {
+			select {
+			case <-d.quit:
+				return
+			default:
+			}
+			d.deliver(a)
+		}
+		if len(attempts) < webhookDeliveryBatchSize {
+			return
+		}
+	}
+}
+
+func (d *webhookDispatcher) deliver(a *webhookAttempt) {
+	status, err := d.post(a)
+	now := time.Now()
+	a.Attempts++
+	a.ResponseStatus = null.NewInt(int64(status), status != 0)
+	a.Error = null.String{}
+	switch {
+	case err == nil:
+		a.Status = webhookDeliveryDelivered
+		a.NextAttemptAt = null.Time{}
+		a.DeliveredAt = null.TimeFrom(now)
+	case a.Attempts >= d.maxAttempts:
+		a.Status = webhookDeliveryDead
+		a.NextAttemptAt = null.Time{}
+		a.Error = null.StringFrom(err.Error())
+	default:
+		a.Status = webhookDeliveryPending
+		a.NextAttemptAt = null.TimeFrom(now.Add(webhookRetryDelay(d.retryBackoff, a.Attempts)))
+		a.Error = null.StringFrom(err.Error())
+	}
+	d.datastore.recordWebhookDeliveryAttempt(&a.WebhookDelivery)
+	if a.Status == webhookDeliveryDead {
+		logger.warn("webhook delivery is dead", logFields{"delivery_id": a.ID, "attempts": a.Attempts, "error": err})
+	}
+}
+
+// post sends a delivery in a span under the trace of the change that made
+// the event, and passes the span on to the receiver in the traceparent
+// header.
+func (d *webhookDispatcher) post(a *webhookAttempt) (status int, err error) {
+	parent, _ := parseTraceparent(a.Traceparent)
+	s := d.tracer.startSpan(parent, "webhook delivery", spanKindClient)
+	s.setAttribute("http.method", "POST")
+	s.setAttribute("webhook.id", a.WebhookID)
+	s.setAttribute("webhook.delivery_id", a.ID)
+	s.setAttribute("webhook.event", a.Event)
+	defer func() {
+		if status != 0 {
+			s.setAttribute("http.status_code", status)
+		}
+		if err != nil {
+			s.setError(err.Error())
+		}
+		s.finish()
+	}()
+
+	req, err := http.NewRequest("POST", a.URL, bytes.NewReader(a.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", gin.MIMEJSON)
+	req.Header.Set("User-Agent", webhookUserAgent)
+	req.Header.Set("X-Webhook-ID", strconv.Itoa(a.WebhookID))
+	req.Header.Set("X-Webhook-Delivery", strconv.Itoa(a.ID))
+	req.Header.Set("X-Webhook-Event", a.Event)
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookSignatureHeader, "sha256="+signWebhookPayload(a.Secret, timestamp, a.Payload))
+	req.Header.Set(traceparentHeader, s.context.traceparent())
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxWebhookResponseSize)); err != nil {
+		return resp.StatusCode, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+func (d *webhookDispatcher) stop(ctx context.Context) {
+	close(d.quit)
+	select {
+	case <-d.done:
+	case <-ctx.Done():
+	}
+}
+
+// webhookRetryDelay doubles the backoff after every failed attempt.
+func webhookRetryDelay(backoff time.Duration, attempts int) time.Duration {
+	delay := backoff
+	for i := 1; i < attempts && delay < maxWebhookRetryBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxWebhookRetryBackoff {
+		delay = maxWebhookRetryBackoff
+	}
+	return delay
+}
+
+// signWebhookPayload signs the timestamp and the payload, joined by a dot,
+// so that a receiver can reject replayed deliveries.
+func signWebhookPayload(secret string, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newWebhookSecret() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
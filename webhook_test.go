@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	null "gopkg.in/guregu/null.v3"
+)
+
+// webhookTestDatastore keeps the deliveries in memory, the way the
+// PostgreSQL datastore queues and claims them.
+type webhookTestDatastore struct {
+	*mockDatastore
+	mu         sync.Mutex
+	deliveries []*webhookAttempt
+	// failures is the number of claims that fail before the others succeed.
+	failures int
+}
+
+func (d *webhookTestDatastore) claimWebhookDeliveries(heldUntil time.Time, limit int) []*webhookAttempt {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.failures > 0 {
+		d.failures--
+		panic("connection refused")
+	}
+	res := make([]*webhookAttempt, 0)
+	for _, a := range d.deliveries {
+		if len(res) < limit && a.Status == webhookDeliveryPending && !a.NextAttemptAt.Time.After(time.Now()) {
+			a.NextAttemptAt = null.TimeFrom(heldUntil)
+			claimed := *a
+			res = append(res, &claimed)
+		}
+	}
+	return res
+}
+
+func (d *webhookTestDatastore) withContext(ctx context.Context) datastore {
+	return d
+}
+
+func (d *webhookTestDatastore) recordWebhookDeliveryAttempt(w *WebhookDelivery) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, a := range d.deliveries {
+		if a.ID == w.ID {
+			a.WebhookDelivery = *w
+		}
+	}
+}
+
+func (d *webhookTestDatastore) delivery(id int) WebhookDelivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, a := range d.deliveries {
+		if a.ID == id {
+			return a.WebhookDelivery
+		}
+	}
+	return WebhookDelivery{}
+}
+
+func newWebhookTestDatastore(url string) *webhookTestDatastore {
+	return &webhookTestDatastore{
+		mockDatastore: &mockDatastore{},
+		deliveries: []*webhookAttempt{
+			{
+				WebhookDelivery: WebhookDelivery{
+					ID:            1,
+					WebhookID:     7,
+					Event:         recipeCreatedEvent,
+					Payload:       []byte(`{"type":"recipe.created","recipe_id":32}`),
+					Status:        webhookDeliveryPending,
+					NextAttemptAt: null.TimeFrom(time.Now()),
+				},
+				URL:    url,
+				Secret: "secret",
+			},
+		},
+	}
+}
+
+// newLoopbackWebhookDispatcher is a dispatcher that delivers to the
+// receivers of the tests, which listen on the loopback address.
+func newLoopbackWebhookDispatcher(ds datastore, maxAttempts int) *webhookDispatcher {
+	d := newWebhookDispatcher(ds, maxAttempts, time.Minute)
+	d.allowAddress = func(net.IP) bool { return true }
+	return d
+}
+
+var _ = Describe("Delivering webhooks", func() {
+	It("POSTs a signed delivery to the receiver", func() {
+		received := make(chan *http.Request, 1)
+		bodies := make(chan []byte, 1)
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			received <- r
+			bodies <- body
+		}))
+		defer receiver.Close()
+
+		ds := newWebhookTestDatastore(receiver.URL)
+		dispatcher := newLoopbackWebhookDispatcher(ds, defaultWebhookAttempts)
+		go dispatcher.run()
+
+		var r *http.Request
+		var body []byte
+		Eventually(received).Should(Receive(&r))
+		Eventually(bodies).Should(Receive(&body))
+		Expect(r.Method).To(Equal("POST"))
+		Expect(r.Header.Get("X-Webhook-Event")).To(Equal(recipeCreatedEvent))
+		Expect(r.Header.Get("X-Webhook-Delivery")).To(Equal("1"))
+		Expect(string(body)).To(Equal(`{"type":"recipe.created","recipe_id":32}`))
+		timestamp := r.Header.Get(webhookTimestampHeader)
+		Expect(r.Header.Get(webhookSignatureHeader)).To(Equal("sha256=" + signWebhookPayload("secret", timestamp, body)))
+
+		Eventually(func() string { return ds.delivery(1).Status }).Should(Equal(webhookDeliveryDelivered))
+		delivery := ds.delivery(1)
+		Expect(delivery.Attempts).To(Equal(1))
+		Expect(delivery.ResponseStatus).To(Equal(null.IntFrom(http.StatusOK)))
+		Expect(delivery.DeliveredAt.Valid).To(BeTrue())
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		dispatcher.stop(ctx)
+		Expect(ctx.Err()).To(BeNil())
+	})
+	It("retries a failed delivery with a backoff until it is dead", func() {
+		calls := 0
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer receiver.Close()
+
+		ds := newWebhookTestDatastore(receiver.URL)
+		dispatcher := newLoopbackWebhookDispatcher(ds, 3)
+		dispatcher.retryBackoff = time.Hour
+
+		before := time.Now()
+		dispatcher.deliverDue()
+		delivery := ds.delivery(1)
+		Expect(delivery.Status).To(Equal(webhookDeliveryPending))
+		Expect(delivery.Attempts).To(Equal(1))
+		Expect(delivery.ResponseStatus).To(Equal(null.IntFrom(http.StatusInternalServerError)))
+		Expect(delivery.Error.String).To(ContainSubstring("500"))
+		Expect(delivery.NextAttemptAt.Time).To(BeTemporally(">=", before.Add(time.Hour)))
+
+		dispatcher.deliverDue()
+		Expect(calls).To(Equal(1))
+
+		for i := 0; i < 2; i++ {
+			ds.deliveries[0].NextAttemptAt = null.TimeFrom(time.Now())
+			dispatcher.deliverDue()
+		}
+		delivery = ds.delivery(1)
+		Expect(calls).To(Equal(3))
+		Expect(delivery.Status).To(Equal(webhookDeliveryDead))
+		Expect(delivery.Attempts).To(Equal(3))
+		Expect(delivery.NextAttemptAt.Valid).To(BeFalse())
+	})
+	It("doesn't follow redirects of the receiver", func() {
+		receiver := httptest.NewServer(http.RedirectHandler("http://localhost:1/", http.StatusFound))
+		defer receiver.Close()
+
+		ds := newWebhookTestDatastore(receiver.URL)
+		newLoopbackWebhookDispatcher(ds, 3).deliverDue()
+		Expect(ds.delivery(1).ResponseStatus).To(Equal(null.IntFrom(http.StatusFound)))
+		Expect(ds.delivery(1).Status).To(Equal(webhookDeliveryPending))
+	})
+	It("doesn't connect to the network of the service", func() {
+		calls := 0
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+		}))
+		defer receiver.Close()
+
+		ds := newWebhookTestDatastore(receiver.URL)
+		newWebhookDispatcher(ds, 3, time.Minute).deliverDue()
+		Expect(calls).To(Equal(0))
+		Expect(ds.delivery(1).Status).To(Equal(webhookDeliveryPending))
+		Expect(ds.delivery(1).Error.String).To(ContainSubstring("127.0.0.1 is not a public address"))
+
+		Expect(isPublicIP(net.ParseIP("93.184.216.34"))).To(BeTrue())
+		for _, ip := range []string{"127.0.0.1", "::1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254", "fe80::1", "fd00::1", "0.0.0.0", "224.0.0.1"} {
+			Expect(isPublicIP(net.ParseIP(ip))).To(BeFalse(), ip)
+		}
+	})
+	It("keeps delivering after a failure", func() {
+		received := make(chan *http.Request, 1)
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received <- r
+		}))
+		defer receiver.Close()
+
+		ds := newWebhookTestDatastore(receiver.URL)
+		ds.failures = 1
+		dispatcher := newWebhookDispatcher(ds, defaultWebhookAttempts, 10*time.Millisecond)
+		dispatcher.allowAddress = func(net.IP) bool { return true }
+		go dispatcher.run()
+
+		Eventually(received).Should(Receive())
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		dispatcher.stop(ctx)
+		Expect(ctx.Err()).To(BeNil())
+	})
+	It("doubles the backoff after every failed attempt", func() {
+		Expect(webhookRetryDelay(time.Minute, 1)).To(Equal(time.Minute))
+		Expect(webhookRetryDelay(time.Minute, 2)).To(Equal(2 * time.Minute))
+		Expect(webhookRetryDelay(time.Minute, 4)).To(Equal(8 * time.Minute))
+		Expect(webhookRetryDelay(time.Minute, 100)).To(Equal(maxWebhookRetryBackoff))
+	})
+})
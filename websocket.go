@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// websocketGUID is the magic string of RFC 6455 that the accept key of the
+// handshake is derived with.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// The opcodes of the WebSocket frames.
+const (
+	websocketContinuation = 0x0
+	websocketText         = 0x1
+	websocketBinary       = 0x2
+	websocketClose        = 0x8
+	websocketPing         = 0x9
+	websocketPong         = 0xa
+)
+
+// The status codes of the close frames in use.
+const (
+	websocketCloseNormal          = 1000
+	websocketCloseGoingAway       = 1001
+	websocketCloseProtocolError   = 1002
+	websocketCloseUnsupportedData = 1003
+	websocketClosePolicyViolation = 1008
+	websocketCloseTooBig          = 1009
+)
+
+const websocketWriteTimeout = 10 * time.Second
+
+var errWebSocketClosed = errors.New("websocket is closed")
+
+// websocketCloseError is returned by readMessage once the peer closes the
+// connection, or the connection has to be closed because of the peer.
+type websocketCloseError struct {
+	code   int
+	reason string
+}
+
+func (e *websocketCloseError) Error() string {
+	return fmt.Sprintf("websocket closed with %d %s", e.code, e.reason)
+}
+
+// isWebSocketUpgrade tells if a request asks for a WebSocket handshake of
+// the version the server speaks.
+func isWebSocketUpgrade(r *http.Request) bool {
+	if r.Method != http.MethodGet || !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+	upgrade := false
+	for _, token := range strings.Split(r.Header.Get("Connection"), ",") {
+		if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+			upgrade = true
+		}
+	}
+	key, err := base64.StdEncoding.DecodeString(r.Header.Get("Sec-WebSocket-Key"))
+	return upgrade && err == nil && len(key) == 16 && r.Header.Get("Sec-WebSocket-Version") == "13"
+}
+
+func websocketAcceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// websocketConn is a server side WebSocket connection. Messages can be
+// written from any goroutine, but only one goroutine may read them.
+type websocketConn struct {
+	conn           net.Conn
+	reader         *bufio.Reader
+	maxMessageSize int
+	readTimeout    time.Duration
+	mu             sync.Mutex
+	closed         bool
+}
+
+// upgradeWebSocket completes the handshake of a request that
+// isWebSocketUpgrade, and takes the connection over from the HTTP server.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request, maxMessageSize int) (*websocketConn, error) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, errors.New("the connection cannot be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	conn.SetWriteDeadline(time.Now().Add(websocketWriteTimeout))
+	if _, err := fmt.Fprintf(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		websocketAcceptKey(r.Header.Get("Sec-WebSocket-Key"))); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &websocketConn{
+		conn:           conn,
+		reader:         rw.Reader,
+		maxMessageSize: maxMessageSize,
+	}, nil
+}
+
+// readMessage reads the next text or binary message, joining its fragments.
+// Pings are answered in between, and a close frame is answered and returned
+// as a *websocketCloseError.
+func (c *websocketConn) readMessage() (int, []byte, error) {
+	opcode := -1
+	message := make([]byte, 0)
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch op {
+		case websocketPing:
+			if err := c.writeFrame(websocketPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case websocketPong:
+			continue
+		case websocketClose:
+			closeErr := &websocketCloseError{code: websocketCloseNormal}
+			if len(payload) >= 2 {
+				closeErr.code = int(binary.BigEndian.Uint16(payload))
+				closeErr.reason = string(payload[2:])
+			}
+			c.close(closeErr.code, "")
+			return 0, nil, closeErr
+		case websocketText, websocketBinary:
+			if opcode != -1 {
+				return 0, nil, c.fail(websocketCloseProtocolError, "expected a continuation frame")
+			}
+			opcode = op
+		case websocketContinuation:
+			if opcode == -1 {
+				return 0, nil, c.fail(websocketCloseProtocolError, "unexpected continuation frame")
+			}
+		default:
+			return 0, nil, c.fail(websocketCloseProtocolError, fmt.Sprintf("unknown opcode %d", op))
+		}
+		if len(message)+len(payload) > c.maxMessageSize {
+			return 0, nil, c.fail(websocketCloseTooBig, "message too big")
+		}
+		message = append(message, payload...)
+		if fin {
+			return opcode, message, nil
+		}
+	}
+}
+
+func (c *websocketConn) readFrame() (bool, int, []byte, error) {
+	if c.readTimeout > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(c.readTimeout))
+	}
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin := header[0]&0x80 != 0
+	opcode := int(header[0] & 0x0f)
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, c.fail(websocketCloseProtocolError, "reserved bits are set")
+	}
+	if header[1]&0x80 == 0 {
+		return false, 0, nil, c.fail(websocketCloseProtocolError, "frames of a client must be masked")
+	}
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if opcode >= websocketClose && (!fin || length > 125) {
+		return false, 0, nil, c.fail(websocketCloseProtocolError, "malformed control frame")
+	}
+	if length > uint64(c.maxMessageSize) {
+		return false, 0, nil, c.fail(websocketCloseTooBig, "message too big")
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+func (c *websocketConn) writeMessage(payload []byte) error {
+	return c.writeFrame(websocketText, payload)
+}
+
+func (c *websocketConn) ping() error {
+	return c.writeFrame(websocketPing, nil)
+}
+
+// writeFrame writes an unfragmented frame, which the server doesn't mask.
+func (c *websocketConn) writeFrame(opcode int, payload []byte) error {
+	frame := make([]byte, 0, len(payload)+10)
+	frame = append(frame, 0x80|byte(opcode))
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, 126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(len(payload)))
+	default:
+		frame = append(frame, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(len(payload)))
+	}
+	frame = append(frame, payload...)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return errWebSocketClosed
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(websocketWriteTimeout))
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// close sends a close frame with the status code and closes the
+// connection. It can be called more than once.
+func (c *websocketConn) close(code int, reason string) {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+	c.writeFrame(websocketClose, payload)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.closed = true
+		c.conn.Close()
+	}
+}
+
+func (c *websocketConn) fail(code int, reason string) error {
+	c.close(code, reason)
+	return &websocketCloseError{code: code, reason: reason}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+	"time"
+)
+
+const maxWorkerBackoff = 5 * time.Minute
+
+// runWorkerStep runs a step of a background worker. A step that panics, say
+// because the database is down, is logged instead of taking the service down
+// with it, so that the worker can try again later. It reports if the step
+// succeeded.
+func runWorkerStep(worker string, step func()) (ok bool) {
+	defer func() {
+		if p := recover(); p != nil {
+			logger.error("background worker failed", logFields{"worker": worker, "panic": fmt.Sprint(p), "stack": string(debug.Stack())})
+		}
+	}()
+	step()
+	return true
+}
+
+// workerBackoff is how long a worker waits before its next step. It waits
+// the interval after a step that succeeds, and twice as long after every
+// further failure in a row, up to maxWorkerBackoff or the interval if that
+// is longer.
+type workerBackoff struct {
+	failures uint
+}
+
+func (b *workerBackoff) next(ok bool, interval time.Duration) time.Duration {
+	if ok {
+		b.failures = 0
+		return interval
+	}
+	limit := maxWorkerBackoff
+	if interval > limit {
+		limit = interval
+	}
+	wait := interval << b.failures
+	if wait <= 0 || wait > limit {
+		return limit
+	}
+	b.failures++
+	return wait
+}
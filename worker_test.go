@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkerBackoff(t *testing.T) {
+	var b workerBackoff
+	assert.Equal(t, time.Second, b.next(true, time.Second))
+	assert.Equal(t, time.Second, b.next(false, time.Second))
+	assert.Equal(t, 2*time.Second, b.next(false, time.Second))
+	assert.Equal(t, 4*time.Second, b.next(false, time.Second))
+	assert.Equal(t, time.Second, b.next(true, time.Second))
+	for i := 0; i < 100; i++ {
+		b.next(false, time.Minute)
+	}
+	assert.Equal(t, maxWorkerBackoff, b.next(false, time.Minute))
+	assert.Equal(t, time.Minute, b.next(true, time.Minute))
+	assert.Equal(t, time.Hour, b.next(false, time.Hour))
+	assert.Equal(t, time.Hour, b.next(false, time.Hour))
+	assert.True(t, runWorkerStep("test", func() {}))
+	assert.False(t, runWorkerStep("test", func() { panic("connection refused") }))
+}